@@ -0,0 +1,83 @@
+// Package audit contains the audit trail of Funnel API actions, such as task
+// creation, cancelation and node changes.
+package audit
+
+import (
+	"context"
+	"time"
+)
+
+// Outcome of a successful action. Failed actions record the gRPC status code
+// name, e.g. "PermissionDenied".
+const OutcomeOK = "OK"
+
+// Record describes a single audited API action.
+type Record struct {
+	Time time.Time `json:"time"`
+	// Username of the caller. Empty for public or unauthenticated callers.
+	User string `json:"user"`
+	// Address of the client which sent the request.
+	SourceIP string `json:"source_ip"`
+	// Short name of the API method, e.g. "CreateTask".
+	Action string `json:"action"`
+	TaskID string `json:"task_id,omitempty"`
+	NodeID string `json:"node_id,omitempty"`
+	// Outcome is OutcomeOK or the name of the gRPC status code returned.
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+	// Decision of the authorization plugin, if one is configured.
+	Plugin string `json:"plugin,omitempty"`
+}
+
+// Writer writes records to an append-only audit trail.
+type Writer interface {
+	WriteAudit(context.Context, *Record) error
+}
+
+// Reader provides queries of the audit trail.
+type Reader interface {
+	ListAudit(context.Context, *Filter) ([]*Record, error)
+}
+
+// Filter selects records from the audit trail. Empty fields match all records.
+type Filter struct {
+	User   string
+	TaskID string
+	Action string
+	Since  time.Time
+	Until  time.Time
+	// Maximum number of records returned, most recent first.
+	Limit int
+}
+
+// Match reports whether the record is selected by the filter.
+func (f *Filter) Match(r *Record) bool {
+	switch {
+	case f.User != "" && f.User != r.User:
+		return false
+	case f.TaskID != "" && f.TaskID != r.TaskID:
+		return false
+	case f.Action != "" && f.Action != r.Action:
+		return false
+	case !f.Since.IsZero() && r.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && r.Time.After(f.Until):
+		return false
+	}
+	return true
+}
+
+// Select filters records given in chronological order. Matches are returned
+// most recent first, truncated to the filter's limit.
+func (f *Filter) Select(records []*Record) []*Record {
+	var out []*Record
+	for i := len(records) - 1; i >= 0; i-- {
+		if f.Limit > 0 && len(out) >= f.Limit {
+			break
+		}
+		if f.Match(records[i]) {
+			out = append(out, records[i])
+		}
+	}
+	return out
+}
@@ -0,0 +1,35 @@
+package audit
+
+import (
+	"context"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/events"
+)
+
+// EventWriter writes audit records as system log events, at the
+// events.AuditLevel level, to an event writer such as a Kafka topic.
+type EventWriter struct {
+	Writer events.Writer
+}
+
+// WriteAudit writes the record as a system log event.
+func (e *EventWriter) WriteAudit(ctx context.Context, r *Record) error {
+	fields := map[string]string{
+		"user":      r.User,
+		"source_ip": r.SourceIP,
+		"outcome":   r.Outcome,
+	}
+	if r.NodeID != "" {
+		fields["node_id"] = r.NodeID
+	}
+	if r.Error != "" {
+		fields["error"] = r.Error
+	}
+	if r.Plugin != "" {
+		fields["plugin"] = r.Plugin
+	}
+	ev := events.NewSystemLog(r.TaskID, 0, 0, events.AuditLevel, r.Action, fields)
+	ev.Timestamp = r.Time.Format(time.RFC3339Nano)
+	return e.Writer.WriteEvent(ctx, ev)
+}
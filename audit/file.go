@@ -0,0 +1,75 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/ohsu-comp-bio/funnel/util/fsutil"
+)
+
+// FileLog writes audit records as JSON lines to an append-only file.
+type FileLog struct {
+	path string
+	mtx  sync.Mutex
+	file *os.File
+}
+
+// NewFileLog opens, or creates, the audit log at the given path.
+func NewFileLog(path string) (*FileLog, error) {
+	if err := fsutil.EnsurePath(path); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileLog{path: path, file: f}, nil
+}
+
+// WriteAudit appends the record to the log and syncs it to disk.
+func (l *FileLog) WriteAudit(ctx context.Context, r *Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if _, err := l.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+// ListAudit scans the log for records matching the filter.
+func (l *FileLog) ListAudit(ctx context.Context, f *Filter) ([]*Record, error) {
+	file, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []*Record
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		r := &Record{}
+		if err := json.Unmarshal(scanner.Bytes(), r); err != nil {
+			return nil, err
+		}
+		if f.Match(r) {
+			records = append(records, r)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return f.Select(records), nil
+}
+
+// Close closes the log file.
+func (l *FileLog) Close() error {
+	return l.file.Close()
+}
@@ -0,0 +1,53 @@
+package audit
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileLog(t *testing.T) {
+	ctx := context.Background()
+	l, err := NewFileLog(filepath.Join(t.TempDir(), "audit", "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	start := time.Now().UTC()
+	records := []*Record{
+		{Time: start, User: "alice", Action: "CreateTask", TaskID: "task-1", Outcome: OutcomeOK},
+		{Time: start.Add(time.Second), User: "bob", Action: "CancelTask", TaskID: "task-1", Outcome: "PermissionDenied"},
+		{Time: start.Add(2 * time.Second), User: "alice", Action: "CancelTask", TaskID: "task-1", Outcome: OutcomeOK},
+	}
+	for _, r := range records {
+		if err := l.WriteAudit(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := l.ListAudit(ctx, &Filter{User: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Action != "CancelTask" || got[1].Action != "CreateTask" {
+		t.Errorf("expected alice's records, most recent first: %+v", got)
+	}
+
+	got, err = l.ListAudit(ctx, &Filter{TaskID: "task-1", Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].User != "alice" || got[0].Outcome != OutcomeOK {
+		t.Errorf("expected the most recent record: %+v", got)
+	}
+
+	got, err = l.ListAudit(ctx, &Filter{Since: start.Add(time.Second), Until: start.Add(time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].User != "bob" {
+		t.Errorf("expected bob's record: %+v", got)
+	}
+}
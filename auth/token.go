@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TokenPrefix identifies Funnel-issued API tokens, which are sent as
+// "Authorization: Bearer funnel_<id>_<secret>".
+const TokenPrefix = "funnel_"
+
+// Scopes which restrict what an API token may be used for. A token without
+// scopes has all the permissions of its user.
+const (
+	// ScopeRead allows getting and listing tasks.
+	ScopeRead = "read"
+	// ScopeWrite allows creating and canceling tasks.
+	ScopeWrite = "write"
+	// ScopeAdmin allows node, event, audit and token management.
+	ScopeAdmin = "admin"
+)
+
+var (
+	// ErrTokenNotFound is returned by a TokenStore for unknown token IDs.
+	ErrTokenNotFound = errors.New("token not found")
+	errInvalidToken  = errors.New("invalid token")
+	errRevokedToken  = errors.New("token has been revoked")
+	errExpiredToken  = errors.New("token has expired")
+)
+
+// Token describes a Funnel-issued API token, bound to a user.
+// Only a hash of the token secret is stored.
+type Token struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	// User on behalf of whom the token acts.
+	User string `json:"user"`
+	// Admin, Groups and Roles are granted to service accounts by the
+	// administrator creating the token.
+	Admin  bool     `json:"admin,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+	// Basic is set for the tokens of users with Basic credentials, which
+	// are rejected once the credentials are removed from the config.
+	Basic   bool      `json:"basic,omitempty"`
+	Hash    string    `json:"hash,omitempty"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires,omitzero"`
+	Revoked bool      `json:"revoked,omitempty"`
+}
+
+// TokenStore stores API tokens, e.g. in the database backend.
+type TokenStore interface {
+	PutToken(context.Context, *Token) error
+	// GetToken returns ErrTokenNotFound if the token doesn't exist.
+	GetToken(ctx context.Context, id string) (*Token, error)
+	ListTokens(context.Context) ([]*Token, error)
+}
+
+// NewToken generates a new token for the given user. The returned string is
+// the bearer token, which is shown once to the user and never stored.
+func NewToken(user string) (*Token, string, error) {
+	id := make([]byte, 8)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return nil, "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	t := &Token{
+		ID:      hex.EncodeToString(id),
+		User:    user,
+		Created: time.Now().UTC(),
+	}
+	s := base64.RawURLEncoding.EncodeToString(secret)
+	t.Hash = hashSecret(s)
+	return t, TokenPrefix + t.ID + "_" + s, nil
+}
+
+// ParseToken splits a bearer token into its ID and secret.
+func ParseToken(bearer string) (id, secret string, err error) {
+	rest, ok := strings.CutPrefix(bearer, TokenPrefix)
+	if !ok {
+		return "", "", errInvalidToken
+	}
+	id, secret, ok = strings.Cut(rest, "_")
+	if !ok || id == "" || secret == "" {
+		return "", "", errInvalidToken
+	}
+	return id, secret, nil
+}
+
+// Verify checks the secret against the stored hash, and that the token is
+// neither revoked nor expired.
+func (t *Token) Verify(secret string, now time.Time) error {
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(t.Hash)) != 1 {
+		return errInvalidToken
+	}
+	if t.Revoked {
+		return errRevokedToken
+	}
+	if !t.Expires.IsZero() && now.After(t.Expires) {
+		return errExpiredToken
+	}
+	return nil
+}
+
+// ValidateScopes returns an error for unknown scopes.
+func ValidateScopes(scopes []string) error {
+	for _, s := range scopes {
+		switch s {
+		case ScopeRead, ScopeWrite, ScopeAdmin:
+		default:
+			return fmt.Errorf("unknown scope %q. Expected 'read', 'write', or 'admin'", s)
+		}
+	}
+	return nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestToken(t *testing.T) {
+	tok, bearer, err := NewToken("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(bearer, TokenPrefix+tok.ID+"_") || strings.Contains(tok.Hash, bearer) {
+		t.Fatal("unexpected token", bearer, tok.Hash)
+	}
+
+	id, secret, err := ParseToken(bearer)
+	if err != nil || id != tok.ID {
+		t.Fatal("failed to parse token", err)
+	}
+	now := time.Now()
+	if err := tok.Verify(secret, now); err != nil {
+		t.Error("expected valid token", err)
+	}
+	if err := tok.Verify(secret+"x", now); err == nil {
+		t.Error("expected invalid secret")
+	}
+
+	tok.Expires = now.Add(-time.Minute)
+	if err := tok.Verify(secret, now); err == nil {
+		t.Error("expected expired token")
+	}
+	tok.Expires = time.Time{}
+	tok.Revoked = true
+	if err := tok.Verify(secret, now); err == nil {
+		t.Error("expected revoked token")
+	}
+
+	for _, s := range []string{"", "funnel_", "funnel_abc", "Basic abc", "funnel__secret"} {
+		if _, _, err := ParseToken(s); err == nil {
+			t.Error("expected parse error for", s)
+		}
+	}
+	if ValidateScopes([]string{ScopeRead, ScopeWrite}) != nil || ValidateScopes([]string{"delete"}) == nil {
+		t.Error("unexpected scope validation")
+	}
+}
@@ -0,0 +1,103 @@
+// Package auth contains the "funnel auth" CLI commands.
+package auth
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ohsu-comp-bio/funnel/cmd/util"
+	"github.com/spf13/cobra"
+)
+
+// NewCommand returns the "auth" subcommands.
+func NewCommand() *cobra.Command {
+	cmd, _ := newCommandHooks()
+	return cmd
+}
+
+type hooks struct {
+	Create func(server string, req *CreateRequest, w io.Writer) error
+	List   func(server string, w io.Writer) error
+	Revoke func(server string, ids []string, w io.Writer) error
+}
+
+func newCommandHooks() (*cobra.Command, *hooks) {
+
+	h := &hooks{
+		Create: Create,
+		List:   List,
+		Revoke: Revoke,
+	}
+
+	var (
+		defaultServer = "http://localhost:8000"
+		server        string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "auth",
+		Short: "Manage Funnel API tokens.",
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			if server == "" {
+				if val := os.Getenv("FUNNEL_SERVER"); val != "" {
+					server = val
+				} else {
+					server = defaultServer
+				}
+			}
+		},
+	}
+	cmd.SetGlobalNormalizationFunc(util.NormalizeFlags)
+	f := cmd.PersistentFlags()
+	f.StringVarP(&server, "server", "S", server, fmt.Sprintf("(default \"%s\")", defaultServer))
+
+	token := &cobra.Command{
+		Use:     "token",
+		Aliases: []string{"tokens"},
+		Short:   "Create, list and revoke API tokens.",
+		Long: `API tokens are sent as bearer tokens, e.g. by setting FUNNEL_SERVER_TOKEN.
+Requests to the server are authenticated with FUNNEL_SERVER_TOKEN, or
+FUNNEL_SERVER_USER and FUNNEL_SERVER_PASSWORD.`,
+	}
+
+	req := &CreateRequest{}
+	create := &cobra.Command{
+		Use:   "create",
+		Short: "Create an API token. The token is printed once and can't be retrieved later.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return h.Create(server, req, cmd.OutOrStdout())
+		},
+	}
+	cf := create.Flags()
+	cf.StringVar(&req.Name, "name", req.Name, "Token description")
+	cf.StringVar(&req.User, "user", req.User, "User (service account) bound to the token. Defaults to the current user. Requires admin")
+	cf.StringSliceVar(&req.Scopes, "scope", req.Scopes, "Restrict the token to a scope: read, write or admin. May be used multiple times")
+	cf.StringVar(&req.ExpiresIn, "expires", req.ExpiresIn, "Token lifetime, e.g. 720h. The token doesn't expire by default")
+	cf.BoolVar(&req.Admin, "admin", req.Admin, "Grant admin status to the service account. Requires admin")
+	cf.StringSliceVar(&req.Groups, "group", req.Groups, "Group of the service account. May be used multiple times. Requires admin")
+	cf.StringSliceVar(&req.Roles, "role", req.Roles, "Role of the service account. May be used multiple times. Requires admin")
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List API tokens.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return h.List(server, cmd.OutOrStdout())
+		},
+	}
+
+	revoke := &cobra.Command{
+		Use:   "revoke [tokenID ...]",
+		Short: "Revoke one or more API tokens by ID.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return h.Revoke(server, args, cmd.OutOrStdout())
+		},
+	}
+
+	token.AddCommand(create, list, revoke)
+	cmd.AddCommand(token)
+	return cmd, h
+}
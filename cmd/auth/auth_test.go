@@ -0,0 +1,25 @@
+package auth
+
+import (
+	"io"
+	"testing"
+)
+
+func TestCreateFlags(t *testing.T) {
+	cmd, h := newCommandHooks()
+
+	h.Create = func(server string, req *CreateRequest, w io.Writer) error {
+		if server != "http://localhost:8000" {
+			t.Errorf("unexpected server: %s", server)
+		}
+		if req.User != "ci-bot" || req.ExpiresIn != "720h" || len(req.Scopes) != 2 || req.Scopes[1] != "write" {
+			t.Errorf("unexpected request: %+v", req)
+		}
+		return nil
+	}
+
+	cmd.SetArgs([]string{"token", "create", "--user", "ci-bot", "--scope", "read", "--scope", "write", "--expires", "720h"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+}
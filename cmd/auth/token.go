@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/util"
+)
+
+// CreateRequest describes the API token to create.
+type CreateRequest struct {
+	Name      string   `json:"name,omitempty"`
+	User      string   `json:"user,omitempty"`
+	Admin     bool     `json:"admin,omitempty"`
+	Groups    []string `json:"groups,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+	Scopes    []string `json:"scopes,omitempty"`
+	ExpiresIn string   `json:"expires_in,omitempty"`
+}
+
+// Create runs the "auth token create" CLI command.
+func Create(server string, req *CreateRequest, writer io.Writer) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return call(server, http.MethodPost, "/v1/tokens", body, writer)
+}
+
+// List runs the "auth token list" CLI command.
+func List(server string, writer io.Writer) error {
+	return call(server, http.MethodGet, "/v1/tokens", nil, writer)
+}
+
+// Revoke runs the "auth token revoke" CLI command.
+func Revoke(server string, ids []string, writer io.Writer) error {
+	for _, id := range ids {
+		if err := call(server, http.MethodDelete, "/v1/tokens/"+id, nil, writer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// call sends a request to the server, authenticated by the FUNNEL_SERVER_*
+// environment variables, and writes the indented JSON response.
+func call(server, method, path string, body []byte, writer io.Writer) error {
+	if !strings.Contains(server, "://") {
+		server = "http://" + server
+	}
+	hreq, err := http.NewRequest(method, strings.TrimSuffix(server, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	if token := os.Getenv("FUNNEL_SERVER_TOKEN"); token != "" {
+		hreq.Header.Set("Authorization", "Bearer "+token)
+	} else {
+		hreq.SetBasicAuth(os.Getenv("FUNNEL_SERVER_USER"), os.Getenv("FUNNEL_SERVER_PASSWORD"))
+	}
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := util.CheckHTTPResponse(client.Do(hreq))
+	if err != nil {
+		return err
+	}
+
+	out := &bytes.Buffer{}
+	if err := json.Indent(out, resp, "", "  "); err != nil {
+		return err
+	}
+	fmt.Fprintln(writer, strings.TrimSpace(out.String()))
+	return nil
+}
@@ -0,0 +1,135 @@
+// Package db contains the "funnel db" CLI commands, which manage the
+// schema migrations of the database, and move its tasks to another database.
+package db
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	cmdutil "github.com/ohsu-comp-bio/funnel/cmd/util"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/database/migrate"
+	"github.com/ohsu-comp-bio/funnel/database/mongodb"
+	"github.com/ohsu-comp-bio/funnel/database/postgres"
+	"github.com/ohsu-comp-bio/funnel/database/sqlite"
+	"github.com/spf13/cobra"
+)
+
+// migrator is a database with schema migrations.
+type migrator interface {
+	migrate.Migrator
+	Close()
+}
+
+// newMigrator returns the database of the config, replaced in tests.
+var newMigrator = func(conf *config.Config) (migrator, error) {
+	switch strings.ToLower(conf.Database) {
+	case "postgres", "psql":
+		return postgres.NewPostgres(conf.Postgres)
+	case "mongodb":
+		return mongodb.NewMongoDB(conf.MongoDB)
+	case "sqlite":
+		return sqlite.NewSQLite(conf.SQLite)
+	}
+	return nil, fmt.Errorf("the %s database doesn't have schema migrations", conf.Database)
+}
+
+// NewCommand returns the "db" subcommands.
+func NewCommand() *cobra.Command {
+
+	configFile := ""
+	flagConf := config.EmptyConfig()
+	var conf *config.Config
+
+	cmd := &cobra.Command{
+		Use:   "db",
+		Short: "Manage the database.",
+		Long: `The PostgreSQL and MongoDB databases have versioned schema migrations,
+embedded in Funnel. The server applies the pending migrations when it starts,
+unless ManualMigrations is set in the database config: then it refuses to
+start until "funnel db migrate" applies them.
+
+The tasks and nodes of any database may be exported to a file, and imported
+into another database, or copied directly.`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			conf, err = cmdutil.MergeConfigFileWithFlags(configFile, flagConf)
+			if err != nil {
+				return fmt.Errorf("processing config: %v", err)
+			}
+			return nil
+		},
+	}
+	cmd.SetGlobalNormalizationFunc(cmdutil.NormalizeFlags)
+	f := cmd.PersistentFlags()
+	f.AddFlagSet(cmdutil.ServerFlags(flagConf, &configFile))
+
+	status := &cobra.Command{
+		Use:   "status",
+		Short: "List the schema migrations, applied or pending.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, err := newMigrator(conf)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			migrations, err := db.Migrations(context.Background())
+			if err != nil {
+				return err
+			}
+			printStatus(cmd.OutOrStdout(), migrations)
+			return nil
+		},
+	}
+
+	migrateCmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Apply the pending schema migrations.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, err := newMigrator(conf)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			migrated, err := db.Migrate(context.Background())
+			for _, m := range migrated {
+				fmt.Fprintf(cmd.OutOrStdout(), "applied %s\n", m)
+			}
+			if err != nil {
+				return err
+			}
+			if len(migrated) == 0 {
+				fmt.Fprintln(cmd.OutOrStdout(), "the schema is up to date")
+			}
+			return nil
+		},
+	}
+
+	cmd.AddCommand(status, migrateCmd)
+	cmd.AddCommand(newTransferCommands(&conf)...)
+	return cmd
+}
+
+func printStatus(w io.Writer, migrations []*migrate.Migration) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+	for _, m := range migrations {
+		applied := m.Applied.Format(time.RFC3339)
+		switch {
+		case m.Pending():
+			applied = "pending"
+		case m.Unknown:
+			applied += " (newer Funnel version)"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", m.Version, m.Name, applied)
+	}
+	tw.Flush()
+}
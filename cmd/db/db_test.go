@@ -0,0 +1,148 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/database/boltdb"
+	"github.com/ohsu-comp-bio/funnel/database/migrate"
+	"github.com/ohsu-comp-bio/funnel/events"
+	"github.com/ohsu-comp-bio/funnel/server"
+	"github.com/ohsu-comp-bio/funnel/tes"
+)
+
+type fakeMigrator struct {
+	migrations []*migrate.Migration
+	closed     bool
+}
+
+func (f *fakeMigrator) Migrations(ctx context.Context) ([]*migrate.Migration, error) {
+	return f.migrations, nil
+}
+
+func (f *fakeMigrator) Migrate(ctx context.Context) ([]*migrate.Migration, error) {
+	pending := migrate.Pending(f.migrations)
+	for _, m := range pending {
+		m.Applied = time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	}
+	return pending, nil
+}
+
+func (f *fakeMigrator) Close() {
+	f.closed = true
+}
+
+func TestMigrate(t *testing.T) {
+	fake := &fakeMigrator{migrations: []*migrate.Migration{
+		{Version: 1, Name: "initial", Applied: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Version: 2, Name: "indexes"},
+	}}
+	newMigrator = func(conf *config.Config) (migrator, error) {
+		if conf.Database != "postgres" {
+			t.Errorf("unexpected database: %s", conf.Database)
+		}
+		return fake, nil
+	}
+
+	run := func(args ...string) string {
+		cmd := NewCommand()
+		out := &bytes.Buffer{}
+		cmd.SetOut(out)
+		cmd.SetArgs(append(args, "--Database", "postgres"))
+		if err := cmd.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	out := run("status")
+	expected := `VERSION  NAME     APPLIED
+1        initial  2024-01-01T00:00:00Z
+2        indexes  pending
+`
+	if out != expected {
+		t.Errorf("unexpected status:\n%s", out)
+	}
+
+	if out := run("migrate"); out != "applied 2_indexes\n" {
+		t.Errorf("unexpected output: %q", out)
+	}
+	if out := run("migrate"); out != "the schema is up to date\n" {
+		t.Errorf("unexpected output: %q", out)
+	}
+	if !strings.Contains(run("status"), "2024-01-02T00:00:00Z") || !fake.closed {
+		t.Error("expected the applied migration, and the database to be closed")
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.db")
+	target := filepath.Join(dir, "target.db")
+	file := filepath.Join(dir, "tasks.ndjson")
+	checkpoint := filepath.Join(dir, "checkpoint.json")
+
+	src, err := boltdb.NewBoltDB(&config.BoltDB{Path: source})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.Init()
+	ctx := context.WithValue(context.Background(), server.UserInfoKey, &server.UserInfo{Username: "alice"})
+	for _, id := range []string{"task-1", "task-2"} {
+		task := &tes.Task{Id: id, CreationTime: "2024-01-01T00:00:00Z"}
+		if err := src.WriteEvent(ctx, events.NewTaskCreated(task)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src.Close()
+
+	run := func(args ...string) string {
+		cmd := NewCommand()
+		out := &bytes.Buffer{}
+		cmd.SetOut(io.Discard)
+		cmd.SetErr(out)
+		cmd.SetArgs(append(args, "--Database", "boltdb"))
+		if err := cmd.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	if out := run("export", file, "--checkpoint", checkpoint, "--BoltDB.Path", source); out != "exported 2 tasks and 0 nodes\n" {
+		t.Errorf("unexpected output: %q", out)
+	}
+	// The finished export is resumed without writing the tasks again.
+	run("export", file, "--checkpoint", checkpoint, "--BoltDB.Path", source)
+	b, _ := os.ReadFile(file)
+	if n := strings.Count(string(b), "\n"); n != 2 {
+		t.Errorf("expected 2 records, got %d:\n%s", n, b)
+	}
+
+	if out := run("import", file, "--BoltDB.Path", target); out != "imported 2 tasks and 0 nodes (0 already in the database)\n" {
+		t.Errorf("unexpected output: %q", out)
+	}
+	if out := run("import", file, "--BoltDB.Path", target); out != "imported 2 tasks and 0 nodes (2 already in the database)\n" {
+		t.Errorf("unexpected output: %q", out)
+	}
+
+	dst, err := boltdb.NewBoltDB(&config.BoltDB{Path: target})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	task, err := dst.GetTask(context.Background(), &tes.GetTaskRequest{Id: "task-2", View: tes.View_FULL.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner, _ := dst.TaskOwner(context.Background(), "task-2")
+	if task.CreationTime != "2024-01-01T00:00:00Z" || owner != "alice" {
+		t.Errorf("unexpected task: %v, owner %q", task, owner)
+	}
+}
@@ -0,0 +1,280 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	cmdutil "github.com/ohsu-comp-bio/funnel/cmd/util"
+	"github.com/ohsu-comp-bio/funnel/compute/scheduler"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/database/badger"
+	"github.com/ohsu-comp-bio/funnel/database/boltdb"
+	"github.com/ohsu-comp-bio/funnel/database/datastore"
+	"github.com/ohsu-comp-bio/funnel/database/dynamodb"
+	"github.com/ohsu-comp-bio/funnel/database/elastic"
+	"github.com/ohsu-comp-bio/funnel/database/mongodb"
+	"github.com/ohsu-comp-bio/funnel/database/postgres"
+	"github.com/ohsu-comp-bio/funnel/database/sqlite"
+	"github.com/ohsu-comp-bio/funnel/database/transfer"
+	"github.com/ohsu-comp-bio/funnel/events"
+	"github.com/ohsu-comp-bio/funnel/tes"
+	"github.com/spf13/cobra"
+)
+
+// database is a database to export the tasks from, or to import them into.
+type database interface {
+	tes.ReadOnlyServer
+	events.Writer
+	Init() error
+}
+
+// newDatabase returns the database of the config.
+var newDatabase = func(conf *config.Config) (database, error) {
+	switch strings.ToLower(conf.Database) {
+	case "boltdb":
+		return boltdb.NewBoltDB(conf.BoltDB)
+	case "badger":
+		return badger.NewBadger(conf.Badger)
+	case "datastore":
+		return datastore.NewDatastore(conf.Datastore)
+	case "dynamodb":
+		return dynamodb.NewDynamoDB(conf.DynamoDB)
+	case "elastic":
+		return elastic.NewElastic(conf.Elastic)
+	case "mongodb":
+		return mongodb.NewMongoDB(conf.MongoDB)
+	case "postgres", "psql":
+		return postgres.NewPostgres(conf.Postgres)
+	case "sqlite":
+		return sqlite.NewSQLite(conf.SQLite)
+	}
+	return nil, fmt.Errorf("unknown database: '%s'", conf.Database)
+}
+
+func openDatabase(conf *config.Config) (database, error) {
+	db, err := newDatabase(conf)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Init(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("initializing the %s database: %v", conf.Database, err)
+	}
+	return db, nil
+}
+
+func newImporter(db database) *transfer.Importer {
+	imp := &transfer.Importer{Events: db, Tasks: db}
+	if nodes, ok := db.(scheduler.SchedulerServiceServer); ok {
+		imp.Nodes = nodes
+	}
+	return imp
+}
+
+// newTransferCommands returns the "export", "import" and "copy" commands,
+// which use the config of the "db" command.
+func newTransferCommands(conf **config.Config) []*cobra.Command {
+	checkpoint := ""
+
+	export := &cobra.Command{
+		Use:   "export [file]",
+		Short: "Export the tasks and nodes of the database.",
+		Long: `Writes the full tasks of the database, with their owners, then its nodes,
+as newline-delimited JSON, to the file or to stdout.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := ""
+			if len(args) == 1 && args[0] != "-" {
+				path = args[0]
+			}
+			if checkpoint != "" && path == "" {
+				return fmt.Errorf("--checkpoint requires an output file")
+			}
+
+			db, err := openDatabase(*conf)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			cp, err := exportFile(context.Background(), db, path, checkpoint, cmd.OutOrStdout())
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.ErrOrStderr(), "exported %d tasks and %d nodes\n", cp.Tasks, cp.Nodes)
+			return nil
+		},
+	}
+	export.Flags().StringVar(&checkpoint, "checkpoint", checkpoint, "Checkpoint file, to resume an interrupted export")
+
+	importCmd := &cobra.Command{
+		Use:   "import [file]",
+		Short: "Import exported tasks and nodes into the database.",
+		Long: `Replays the exported tasks as events into the database, keeping their
+owners and creation times, and puts the nodes. The tasks and nodes already
+in the database are skipped.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := ""
+			if len(args) == 1 && args[0] != "-" {
+				path = args[0]
+			}
+			if checkpoint != "" && path == "" {
+				return fmt.Errorf("--checkpoint requires an input file")
+			}
+
+			db, err := openDatabase(*conf)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			imp := newImporter(db)
+			cp, err := importFile(context.Background(), imp, path, checkpoint, cmd.InOrStdin())
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.ErrOrStderr(), "imported %d tasks and %d nodes (%d already in the database)\n",
+				cp.Tasks, cp.Nodes, imp.Skipped)
+			return nil
+		},
+	}
+	importCmd.Flags().StringVar(&checkpoint, "checkpoint", checkpoint, "Checkpoint file, to resume an interrupted import")
+
+	targetFile := ""
+	copyCmd := &cobra.Command{
+		Use:   "copy",
+		Short: "Copy the tasks and nodes of the database to another database.",
+		Long: `Exports the tasks and nodes of the database of --config, and imports
+them into the database of --to.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			targetConf, err := cmdutil.MergeConfigFileWithFlags(targetFile, config.EmptyConfig())
+			if err != nil {
+				return fmt.Errorf("processing target config: %v", err)
+			}
+
+			src, err := openDatabase(*conf)
+			if err != nil {
+				return err
+			}
+			defer src.Close()
+
+			dst, err := openDatabase(targetConf)
+			if err != nil {
+				return err
+			}
+			defer dst.Close()
+
+			cp := &transfer.Checkpoint{}
+			if checkpoint != "" {
+				if cp, err = transfer.ReadCheckpoint(checkpoint); err != nil {
+					return err
+				}
+			}
+			imp := newImporter(dst)
+			err = transfer.Export(context.Background(), src, imp, cp, saveCheckpoint(checkpoint, nil))
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.ErrOrStderr(), "copied %d tasks and %d nodes (%d already in the database)\n",
+				cp.Tasks, cp.Nodes, imp.Skipped)
+			return nil
+		},
+	}
+	copyCmd.Flags().StringVar(&targetFile, "to", targetFile, "Config file of the target database")
+	copyCmd.Flags().StringVar(&checkpoint, "checkpoint", checkpoint, "Checkpoint file, to resume an interrupted copy")
+	copyCmd.MarkFlagRequired("to")
+
+	return []*cobra.Command{export, importCmd, copyCmd}
+}
+
+// saveCheckpoint returns the function saving the checkpoint to the file, if
+// any, after flushing the encoder, if any.
+func saveCheckpoint(path string, enc *transfer.Encoder) func(*transfer.Checkpoint) error {
+	return func(cp *transfer.Checkpoint) error {
+		if enc != nil {
+			if err := enc.Flush(); err != nil {
+				return err
+			}
+			cp.Offset = enc.Offset()
+		}
+		if path == "" {
+			return nil
+		}
+		return cp.Save(path)
+	}
+}
+
+// exportFile exports the database to the file, or to stdout if path is
+// empty. An export resumed from a checkpoint truncates the records written
+// after it.
+func exportFile(ctx context.Context, db database, path, checkpoint string, stdout io.Writer) (*transfer.Checkpoint, error) {
+	cp := &transfer.Checkpoint{}
+	if checkpoint != "" {
+		var err error
+		if cp, err = transfer.ReadCheckpoint(checkpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	out := stdout
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if err := f.Truncate(cp.Offset); err != nil {
+			return nil, err
+		}
+		if _, err := f.Seek(cp.Offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		out = f
+	}
+
+	enc := transfer.NewEncoder(out, cp.Offset)
+	if err := transfer.Export(ctx, db, enc, cp, saveCheckpoint(checkpoint, enc)); err != nil {
+		enc.Flush()
+		return nil, err
+	}
+	return cp, nil
+}
+
+// importFile imports the file, or stdin if path is empty, resuming after
+// the last record imported of the checkpoint.
+func importFile(ctx context.Context, imp *transfer.Importer, path, checkpoint string, stdin io.Reader) (*transfer.Checkpoint, error) {
+	cp := &transfer.Checkpoint{}
+	if checkpoint != "" {
+		var err error
+		if cp, err = transfer.ReadCheckpoint(checkpoint); err != nil {
+			return nil, err
+		}
+	}
+	if cp.Done {
+		return cp, nil
+	}
+
+	in := stdin
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if _, err := f.Seek(cp.Offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		in = f
+	}
+
+	dec := transfer.NewDecoder(in, cp.Offset)
+	if err := transfer.Import(ctx, dec, imp, cp, saveCheckpoint(checkpoint, nil)); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
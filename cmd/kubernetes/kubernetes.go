@@ -18,6 +18,7 @@ import (
 	"github.com/ohsu-comp-bio/funnel/database/elastic"
 	"github.com/ohsu-comp-bio/funnel/database/mongodb"
 	"github.com/ohsu-comp-bio/funnel/database/postgres"
+	"github.com/ohsu-comp-bio/funnel/database/sqlite"
 	"github.com/ohsu-comp-bio/funnel/events"
 	"github.com/ohsu-comp-bio/funnel/logger"
 	"github.com/ohsu-comp-bio/funnel/tes"
@@ -108,6 +109,8 @@ func openReader(ctx context.Context, conf *config.Config) (tes.ReadOnlyServer, e
 		return mongodb.NewMongoDB(conf.MongoDB)
 	case "postgres", "psql":
 		return postgres.NewPostgres(conf.Postgres)
+	case "sqlite":
+		return sqlite.NewSQLite(conf.SQLite)
 	default:
 		return nil, fmt.Errorf("unknown database: '%s'", conf.Database)
 	}
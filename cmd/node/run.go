@@ -11,12 +11,30 @@ import (
 	"github.com/ohsu-comp-bio/funnel/compute/scheduler"
 	"github.com/ohsu-comp-bio/funnel/config"
 	"github.com/ohsu-comp-bio/funnel/logger"
+	"github.com/ohsu-comp-bio/funnel/tracing"
 	"github.com/ohsu-comp-bio/funnel/util"
 )
 
 // Run runs a node with the given config, blocking until the node exits.
 func Run(ctx context.Context, conf *config.Config, log *logger.Logger) error {
-	conf.Node.ID = scheduler.GenNodeID()
+	// The ID is kept across restarts, unless it's set by the config.
+	if conf.Node.ID == "" {
+		id, err := scheduler.LoadNodeID(conf.Worker.WorkDir)
+		if err != nil {
+			return err
+		}
+		conf.Node.ID = id
+	}
+
+	shutdown, err := tracing.Setup(ctx, conf.Tracing, "funnel-node")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := shutdown(context.Background()); err != nil {
+			log.Error("flushing the spans", "error", err)
+		}
+	}()
 
 	factory := func(ctx context.Context, taskID string) error {
 		w, err := workerCmd.NewWorker(ctx, conf, log, &workerCmd.Options{
@@ -25,6 +43,7 @@ func Run(ctx context.Context, conf *config.Config, log *logger.Logger) error {
 		if err != nil {
 			return err
 		}
+		w.Resumable = true
 		w.Run(ctx)
 		return nil
 	}
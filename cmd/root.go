@@ -2,12 +2,15 @@
 package cmd
 
 import (
+	"github.com/ohsu-comp-bio/funnel/cmd/auth"
 	"github.com/ohsu-comp-bio/funnel/cmd/aws"
+	"github.com/ohsu-comp-bio/funnel/cmd/db"
 	"github.com/ohsu-comp-bio/funnel/cmd/examples"
 	"github.com/ohsu-comp-bio/funnel/cmd/gce"
 	"github.com/ohsu-comp-bio/funnel/cmd/kubernetes"
 	"github.com/ohsu-comp-bio/funnel/cmd/node"
 	"github.com/ohsu-comp-bio/funnel/cmd/run"
+	"github.com/ohsu-comp-bio/funnel/cmd/secrets"
 	"github.com/ohsu-comp-bio/funnel/cmd/server"
 	"github.com/ohsu-comp-bio/funnel/cmd/storage"
 	"github.com/ohsu-comp-bio/funnel/cmd/task"
@@ -25,7 +28,9 @@ var RootCmd = &cobra.Command{
 }
 
 func init() {
+	RootCmd.AddCommand(auth.NewCommand())
 	RootCmd.AddCommand(aws.Cmd)
+	RootCmd.AddCommand(db.NewCommand())
 	RootCmd.AddCommand(examples.Cmd)
 	RootCmd.AddCommand(gce.Cmd)
 	RootCmd.AddCommand(kubernetes.Cmd)
@@ -33,6 +38,7 @@ func init() {
 	RootCmd.AddCommand(genMarkdownCmd)
 	RootCmd.AddCommand(node.NewCommand())
 	RootCmd.AddCommand(run.Cmd)
+	RootCmd.AddCommand(secrets.NewCommand())
 	RootCmd.AddCommand(server.NewCommand())
 	RootCmd.AddCommand(storage.NewCommand())
 	RootCmd.AddCommand(task.NewCommand())
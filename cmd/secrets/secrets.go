@@ -0,0 +1,107 @@
+// Package secrets contains the "funnel secrets" CLI commands, which manage
+// the secrets of the "encrypted" secrets backend.
+package secrets
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	cmdutil "github.com/ohsu-comp-bio/funnel/cmd/util"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/secrets"
+	"github.com/spf13/cobra"
+)
+
+// NewCommand returns the "secrets" subcommands.
+func NewCommand() *cobra.Command {
+
+	configFile := ""
+	flagConf := &config.Config{}
+	var store *secrets.EncryptedStore
+
+	cmd := &cobra.Command{
+		Use:     "secrets",
+		Aliases: []string{"secret"},
+		Short:   "Manage the secrets of the encrypted secrets backend.",
+		Long: `Tasks reference secrets by name, e.g. an executor env value
+"secret://db-password", or an input URL "secret://tls-key". Workers resolve
+them when the task runs, from the Secrets.Backend of the config.
+
+These commands manage the secrets of the "encrypted" backend, stored in
+Secrets.Path and encrypted with the key in Secrets.KeyFile.`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			conf, err := cmdutil.MergeConfigFileWithFlags(configFile, flagConf)
+			if err != nil {
+				return fmt.Errorf("processing config: %v", err)
+			}
+			if b := conf.GetSecrets().GetBackend(); !strings.EqualFold(b, "encrypted") {
+				return fmt.Errorf("Secrets.Backend is %q: these commands require the encrypted backend", b)
+			}
+			store, err = secrets.NewEncryptedStore(conf.Secrets.Path, conf.Secrets.KeyFile)
+			return err
+		},
+	}
+	cmd.SetGlobalNormalizationFunc(cmdutil.NormalizeFlags)
+	f := cmd.PersistentFlags()
+	f.StringVarP(&configFile, "config", "c", configFile, "Config File")
+
+	var valueFile string
+	put := &cobra.Command{
+		Use:   "put [name]",
+		Short: "Create or replace a secret. The value is read from stdin, or --file.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var r io.Reader = cmd.InOrStdin()
+			if valueFile != "" {
+				f, err := os.Open(valueFile)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				r = f
+			}
+			value, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			return store.Put(args[0], value)
+		},
+	}
+	put.Flags().StringVar(&valueFile, "file", valueFile, "Read the value of the secret from a file")
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the names of the secrets.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			names, err := store.List()
+			if err != nil {
+				return err
+			}
+			for _, name := range names {
+				fmt.Fprintln(cmd.OutOrStdout(), name)
+			}
+			return nil
+		},
+	}
+
+	rm := &cobra.Command{
+		Use:     "rm [name ...]",
+		Aliases: []string{"delete"},
+		Short:   "Delete one or more secrets.",
+		Args:    cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, name := range args {
+				if err := store.Delete(name); err != nil {
+					return fmt.Errorf("deleting secret %q: %v", name, err)
+				}
+			}
+			return nil
+		},
+	}
+
+	cmd.AddCommand(put, list, rm)
+	return cmd
+}
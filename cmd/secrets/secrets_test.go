@@ -0,0 +1,76 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ohsu-comp-bio/funnel/secrets"
+)
+
+func run(stdin string, args ...string) (string, error) {
+	cmd := NewCommand()
+	out := &bytes.Buffer{}
+	cmd.SetIn(strings.NewReader(stdin))
+	cmd.SetOut(out)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func TestSecrets(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))), 0600)
+	path := filepath.Join(dir, "secrets.json")
+	conf := filepath.Join(dir, "config.yaml")
+	os.WriteFile(conf, []byte(fmt.Sprintf("Secrets:\n  Backend: encrypted\n  Path: %s\n  KeyFile: %s\n", path, keyFile)), 0600)
+
+	if _, err := run("hunter22", "put", "db-password", "-c", conf); err != nil {
+		t.Fatal(err)
+	}
+	valueFile := filepath.Join(dir, "tls.key")
+	os.WriteFile(valueFile, []byte("key"), 0600)
+	if _, err := run("", "put", "lab/tls-key", "--file", valueFile, "-c", conf); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := run("", "list", "-c", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "db-password\nlab/tls-key\n" {
+		t.Errorf("unexpected list: %q", out)
+	}
+
+	store, err := secrets.NewEncryptedStore(path, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := store.Get(context.Background(), "db-password"); err != nil || string(v) != "hunter22" {
+		t.Errorf("unexpected value: %q %v", v, err)
+	}
+
+	if _, err := run("", "rm", "db-password", "-c", conf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run("", "rm", "db-password", "-c", conf); err == nil {
+		t.Error("expected error deleting an unknown secret")
+	}
+	if out, _ := run("", "list", "-c", conf); out != "lab/tls-key\n" {
+		t.Errorf("unexpected list: %q", out)
+	}
+}
+
+func TestSecretsBackend(t *testing.T) {
+	conf := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(conf, []byte("Secrets:\n  Backend: vault\n"), 0600)
+	if _, err := run("", "list", "-c", conf); err == nil {
+		t.Error("expected error for a backend other than encrypted")
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/ohsu-comp-bio/funnel/audit"
+	"github.com/ohsu-comp-bio/funnel/auth"
 	"github.com/ohsu-comp-bio/funnel/compute/aws_batch"
 	"github.com/ohsu-comp-bio/funnel/compute/gcp_batch"
 	"github.com/ohsu-comp-bio/funnel/compute/gridengine"
@@ -23,16 +25,30 @@ import (
 	"github.com/ohsu-comp-bio/funnel/database/elastic"
 	"github.com/ohsu-comp-bio/funnel/database/mongodb"
 	"github.com/ohsu-comp-bio/funnel/database/postgres"
+	"github.com/ohsu-comp-bio/funnel/database/sqlite"
 	"github.com/ohsu-comp-bio/funnel/events"
 	"github.com/ohsu-comp-bio/funnel/logger"
+	"github.com/ohsu-comp-bio/funnel/logs"
 	"github.com/ohsu-comp-bio/funnel/metrics"
 	"github.com/ohsu-comp-bio/funnel/plugins/shared"
 	"github.com/ohsu-comp-bio/funnel/server"
+	"github.com/ohsu-comp-bio/funnel/storage"
 	"github.com/ohsu-comp-bio/funnel/tes"
+	"github.com/ohsu-comp-bio/funnel/tracing"
 )
 
 // Run runs the "server run" command.
 func Run(ctx context.Context, conf *config.Config, log *logger.Logger) error {
+	shutdown, err := tracing.Setup(ctx, conf.Tracing, "funnel-server")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := shutdown(context.Background()); err != nil {
+			log.Error("flushing the spans", "error", err)
+		}
+	}()
+
 	s, err := NewServer(ctx, conf, log)
 	if err != nil {
 		return err
@@ -138,6 +154,17 @@ func NewServer(ctx context.Context, conf *config.Config, log *logger.Logger) (*S
 		queue = p
 		writers = append(writers, p)
 
+	case "sqlite":
+		s, err := sqlite.NewSQLite(conf.SQLite)
+		if err != nil {
+			return nil, dberr(err)
+		}
+		database = s
+		reader = s
+		nodes = s
+		queue = s
+		writers = append(writers, s)
+
 	default:
 		return nil, fmt.Errorf("unknown database: '%s'", conf.Database)
 	}
@@ -179,10 +206,22 @@ func NewServer(ctx context.Context, conf *config.Config, log *logger.Logger) (*S
 			writer, err = events.NewKafkaWriter(ctx, conf.Kafka)
 		case "pubsub":
 			writer, err = events.NewPubSubWriter(ctx, conf.PubSub)
+		case "nats":
+			writer, err = events.NewNATSWriter(ctx, conf.NATS)
+		case "redis":
+			writer, err = events.NewRedisWriter(ctx, conf.Redis)
+		case "amqp":
+			writer, err = events.NewAMQPWriter(ctx, conf.AMQP)
+		case "webhook":
+			writer, err = events.NewWebhookWriter(ctx, conf.Webhook, reader)
+		case "cloudevents":
+			writer, err = events.NewCloudEventsWriter(ctx, conf.CloudEvents)
 		case "mongodb":
 			writer, err = mongodb.NewMongoDB(conf.MongoDB)
 		case "postgres", "psql":
 			writer, err = postgres.NewPostgres(conf.Postgres)
+		case "sqlite":
+			writer, err = sqlite.NewSQLite(conf.SQLite)
 		default:
 			return nil, fmt.Errorf("unknown event writer: '%s'", e)
 		}
@@ -194,6 +233,12 @@ func NewServer(ctx context.Context, conf *config.Config, log *logger.Logger) (*S
 		}
 	}
 
+	// The events retried by the workers, written again after an error of
+	// another writer, or read from a transport after an RPC, are written once
+	// to each writer. The first writer is the database.
+	for i, w := range writers {
+		writers[i] = events.NewDedupWriter(w, 10000)
+	}
 	writer = &events.SystemLogFilter{Writer: &writers, Level: conf.Logger.Level}
 
 	// Compute
@@ -277,6 +322,20 @@ func NewServer(ctx context.Context, conf *config.Config, log *logger.Logger) (*S
 
 	writer = &events.ErrLogger{Writer: writer, Log: log}
 
+	auditLog, err := newAuditLog(ctx, conf, database)
+	if err != nil {
+		return nil, fmt.Errorf("error occurred while initializing the audit log: %v", err)
+	}
+
+	if err := startEventReaders(ctx, conf, writers[0]); err != nil {
+		return nil, err
+	}
+
+	logStore, err := newLogStore(conf, database)
+	if err != nil {
+		return nil, fmt.Errorf("error occurred while initializing the log archive: %v", err)
+	}
+
 	if c, ok := reader.(metrics.TaskStateCounter); ok {
 		go metrics.WatchTaskStates(ctx, c)
 	}
@@ -293,6 +352,9 @@ func NewServer(ctx context.Context, conf *config.Config, log *logger.Logger) (*S
 			OidcAuth:         conf.Server.OidcAuth,
 			DisableHTTPCache: conf.Server.DisableHTTPCache,
 			TaskAccess:       conf.Server.TaskAccess,
+			RoleBindings:     conf.Server.RoleBindings,
+			ProjectTag:       conf.Server.ProjectTag,
+			TLS:              conf.Server.TLS,
 			Log:              log,
 			Tasks: &server.TaskService{
 				Name:    conf.Server.ServiceName,
@@ -305,10 +367,26 @@ func NewServer(ctx context.Context, conf *config.Config, log *logger.Logger) (*S
 			Events:  &events.Service{Writer: writer},
 			Nodes:   nodes,
 			Plugins: conf.Plugins,
+			Audit:   auditLog,
 		},
 		Scheduler: sched,
 	}
 
+	serverConf.Server.Attach = &server.AttachService{
+		Tasks: serverConf.Server.Tasks.(*server.TaskService),
+		Log:   log,
+	}
+
+	serverConf.Server.Logs = &server.LogService{
+		Tasks: serverConf.Server.Tasks.(*server.TaskService),
+		Store: logStore,
+		Log:   log,
+	}
+
+	if t, ok := database.(auth.TokenStore); ok {
+		serverConf.Server.Tokens = t
+	}
+
 	if conf.Plugins != nil {
 		if conf.Plugins.Path == "" {
 			return nil, fmt.Errorf("Plugin config is set but required plugin field 'Path' is not found")
@@ -331,6 +409,18 @@ func NewServer(ctx context.Context, conf *config.Config, log *logger.Logger) (*S
 	return serverConf, nil
 }
 
+// skipCreated drops the TASK_CREATED events.
+type skipCreated struct {
+	events.Writer
+}
+
+func (s *skipCreated) WriteEvent(ctx context.Context, ev *events.Event) error {
+	if ev.Type == events.Type_TASK_CREATED {
+		return nil
+	}
+	return s.Writer.WriteEvent(ctx, ev)
+}
+
 // Run runs a default Funnel server.
 // This opens a database, and starts an API server, scheduler and task logger.
 // This blocks indefinitely.
@@ -354,6 +444,108 @@ func (s *Server) Run(ctx context.Context) error {
 	return <-errch
 }
 
+// newAuditLog returns the audit trail sink selected by conf.Audit.Sink,
+// or nil if the audit trail is disabled.
+func newAuditLog(ctx context.Context, conf *config.Config, database Database) (audit.Writer, error) {
+	switch strings.ToLower(conf.Audit.GetSink()) {
+	case "":
+		return nil, nil
+	case "file":
+		return audit.NewFileLog(conf.Audit.Path)
+	case "database":
+		w, ok := database.(audit.Writer)
+		if !ok {
+			return nil, fmt.Errorf("database %s does not support audit logs", conf.Database)
+		}
+		return w, nil
+	case "kafka":
+		w, err := events.NewKafkaWriter(ctx, &config.Kafka{
+			Servers: conf.Kafka.Servers,
+			Topic:   conf.Audit.Topic,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &audit.EventWriter{Writer: w}, nil
+	case "pubsub":
+		w, err := events.NewPubSubWriter(ctx, &config.PubSub{
+			Project:         conf.PubSub.Project,
+			CredentialsFile: conf.PubSub.CredentialsFile,
+			Topic:           conf.Audit.Topic,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &audit.EventWriter{Writer: w}, nil
+	default:
+		return nil, fmt.Errorf("unknown audit sink: '%s'", conf.Audit.Sink)
+	}
+}
+
+// newLogStore returns the store of the archived executor logs, which the
+// server reads, or nil if the log archive is disabled.
+func newLogStore(conf *config.Config, database Database) (logs.Store, error) {
+	switch strings.ToLower(conf.LogArchive.GetSink()) {
+	case "":
+		return nil, nil
+	case "storage":
+		if conf.LogArchive.URL == "" {
+			return nil, fmt.Errorf("LogArchive.URL is required by the storage sink")
+		}
+		store, err := storage.NewMux(conf)
+		if err != nil {
+			return nil, err
+		}
+		return &logs.StorageStore{Storage: store, URL: conf.LogArchive.URL}, nil
+	case "database":
+		s, ok := database.(logs.Store)
+		if !ok {
+			return nil, fmt.Errorf("database %s does not support the log archive", conf.Database)
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unknown log archive sink: '%s'", conf.LogArchive.Sink)
+	}
+}
+
+// startEventReaders reads the events which the workers write to Kafka,
+// NATS, Redis or AMQP into the database. The tasks are created by the
+// server, so their TASK_CREATED events are skipped.
+func startEventReaders(ctx context.Context, conf *config.Config, database events.Writer) error {
+	readers := map[string]func(events.Writer) error{}
+	if conf.Kafka.ConsumerGroup != "" {
+		readers["Kafka"] = func(w events.Writer) error {
+			_, err := events.NewKafkaReader(ctx, conf.Kafka, w)
+			return err
+		}
+	}
+	if conf.NATS.Consumer != "" {
+		readers["NATS"] = func(w events.Writer) error {
+			_, err := events.NewNATSReader(ctx, conf.NATS, w)
+			return err
+		}
+	}
+	if conf.Redis.ConsumerGroup != "" {
+		readers["Redis"] = func(w events.Writer) error {
+			_, err := events.NewRedisReader(ctx, conf.Redis, w)
+			return err
+		}
+	}
+	if conf.AMQP.Consume {
+		readers["AMQP"] = func(w events.Writer) error {
+			_, err := events.NewAMQPReader(ctx, conf.AMQP, w)
+			return err
+		}
+	}
+
+	for name, start := range readers {
+		if err := start(&skipCreated{Writer: database}); err != nil {
+			return fmt.Errorf("error occurred while initializing the %s event reader: %v", name, err)
+		}
+	}
+	return nil
+}
+
 func dberr(err error) error {
 	return fmt.Errorf("error occurred while connecting to or creating the database: %v", err)
 }
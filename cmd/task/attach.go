@@ -0,0 +1,201 @@
+package task
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/ohsu-comp-bio/funnel/attach"
+	"github.com/ohsu-comp-bio/funnel/util/rpc"
+	"golang.org/x/net/context"
+	"golang.org/x/term"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Attach runs the "task attach" CLI command, which streams the live
+// stdout/stderr of an executor of a running task, and returns the exit code
+// of the executor.
+func Attach(server, rpcAddress, id string, executor int32, stdout, stderr io.Writer) (int, error) {
+	conn, err := dialAttach(server, rpcAddress)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	stream, err := attach.NewAttachServiceClient(conn).Attach(context.Background(), &attach.AttachRequest{
+		Id:       id,
+		Executor: executor,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return receiveOutput(stream, stdout, stderr)
+}
+
+// Exec runs the "task exec" CLI command, which runs a command in an executor
+// of a running task, and returns the exit code of the command.
+func Exec(server, rpcAddress, id string, executor int32, command []string, interactive, tty bool, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	conn, err := dialAttach(server, rpcAddress)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := attach.NewAttachServiceClient(conn).Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// gRPC streams don't support concurrent sends.
+	var mtx sync.Mutex
+	send := func(f *attach.Frame) error {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return stream.Send(f)
+	}
+
+	err = send(&attach.Frame{Data: &attach.Frame_Exec{Exec: &attach.ExecRequest{
+		Id:       id,
+		Executor: executor,
+		Command:  command,
+		Tty:      tty,
+	}}})
+	if err != nil {
+		return 0, err
+	}
+
+	if tty {
+		in, out := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+		if !term.IsTerminal(in) {
+			return 0, fmt.Errorf("--tty requires the stdin to be a terminal")
+		}
+		state, err := term.MakeRaw(in)
+		if err != nil {
+			return 0, fmt.Errorf("setting the terminal to raw mode: %v", err)
+		}
+		defer term.Restore(in, state)
+
+		// Forward the terminal size, and its changes.
+		resize := func() {
+			if w, h, err := term.GetSize(out); err == nil {
+				send(&attach.Frame{Data: &attach.Frame_Resize{Resize: &attach.Resize{
+					Width:  uint32(w),
+					Height: uint32(h),
+				}}})
+			}
+		}
+		resize()
+		sigwinch := make(chan os.Signal, 1)
+		signal.Notify(sigwinch, syscall.SIGWINCH)
+		defer signal.Stop(sigwinch)
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-sigwinch:
+					resize()
+				}
+			}
+		}()
+		// In raw mode, the terminal writes "\r\n" itself.
+		stderr = stdout
+	}
+
+	if interactive || tty {
+		go func() {
+			buf := make([]byte, 32*1024)
+			for {
+				n, err := stdin.Read(buf)
+				if n > 0 {
+					data := append([]byte(nil), buf[:n]...)
+					if send(&attach.Frame{Data: &attach.Frame_Stdin{Stdin: data}}) != nil {
+						return
+					}
+				}
+				if err != nil {
+					// Closing the stream closes the stdin of the command.
+					mtx.Lock()
+					stream.CloseSend()
+					mtx.Unlock()
+					return
+				}
+			}
+		}()
+	} else {
+		mtx.Lock()
+		stream.CloseSend()
+		mtx.Unlock()
+	}
+
+	return receiveOutput(stream, stdout, stderr)
+}
+
+// receiveOutput writes the output frames of an attach or exec session, until
+// the exit frame.
+func receiveOutput(stream interface{ Recv() (*attach.Frame, error) }, stdout, stderr io.Writer) (int, error) {
+	for {
+		f, err := stream.Recv()
+		if err == io.EOF {
+			return 0, fmt.Errorf("the session ended without an exit code")
+		}
+		if err != nil {
+			return 0, err
+		}
+		switch d := f.Data.(type) {
+		case *attach.Frame_Stdout:
+			stdout.Write(d.Stdout)
+		case *attach.Frame_Stderr:
+			stderr.Write(d.Stderr)
+		case *attach.Frame_Exit:
+			if d.Exit.Error != "" {
+				return 0, fmt.Errorf("%s", d.Exit.Error)
+			}
+			return int(d.Exit.ExitCode), nil
+		}
+	}
+}
+
+// dialAttach connects to the gRPC API of the server. The attach and exec
+// sessions are streamed, so they aren't available from the HTTP API.
+//
+// The address defaults to port 9090 of the host of the server URL, and
+// TLS is used if the server URL is https. The credentials are taken from
+// FUNNEL_SERVER_TOKEN, or FUNNEL_SERVER_USER and FUNNEL_SERVER_PASSWORD.
+func dialAttach(server, rpcAddress string) (*grpc.ClientConn, error) {
+	u, err := url.Parse(server)
+	if err != nil || u.Host == "" {
+		// e.g. "localhost:8000"
+		u, err = url.Parse("http://" + server)
+		if err != nil {
+			return nil, fmt.Errorf("parsing the server URL: %v", err)
+		}
+	}
+	if rpcAddress == "" {
+		rpcAddress = net.JoinHostPort(u.Hostname(), "9090")
+	}
+
+	creds := insecure.NewCredentials()
+	if strings.EqualFold(u.Scheme, "https") {
+		creds = credentials.NewTLS(&tls.Config{})
+	}
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+
+	if token := os.Getenv("FUNNEL_SERVER_TOKEN"); token != "" {
+		opts = append(opts, rpc.PerRPCToken(token))
+	} else if user := os.Getenv("FUNNEL_SERVER_USER"); user != "" {
+		opts = append(opts, rpc.PerRPCPassword(user, os.Getenv("FUNNEL_SERVER_PASSWORD")))
+	}
+	return grpc.NewClient(rpcAddress, opts...)
+}
@@ -3,16 +3,63 @@ package task
 import (
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 
 	"github.com/ohsu-comp-bio/funnel/tes"
 	"golang.org/x/net/context"
 )
 
+// listFilter builds the task filter of the "task list" flags.
+type listFilter struct {
+	Filter        string
+	Owners        []string
+	Images        []string
+	CreatedAfter  string
+	CreatedBefore string
+	StartedAfter  string
+	StartedBefore string
+	EndedAfter    string
+	EndedBefore   string
+	Sort          string
+}
+
+func (f *listFilter) String() string {
+	terms := []string{}
+	if f.Filter != "" {
+		terms = append(terms, f.Filter)
+	}
+	add := func(field, op, value string) {
+		if value != "" {
+			terms = append(terms, filterTerm(field, op, value))
+		}
+	}
+	add("owner", "=", strings.Join(f.Owners, ","))
+	for _, image := range f.Images {
+		add("image", "^=", image)
+	}
+	add("created", ">=", f.CreatedAfter)
+	add("created", "<", f.CreatedBefore)
+	add("started", ">=", f.StartedAfter)
+	add("started", "<", f.StartedBefore)
+	add("ended", ">=", f.EndedAfter)
+	add("ended", "<", f.EndedBefore)
+	add("sort", "=", f.Sort)
+	return strings.Join(terms, " ")
+}
+
+// filterTerm returns a term of the task filter, quoting the value if needed.
+func filterTerm(field, op, value string) string {
+	if strings.ContainsAny(value, " \t\n\"") {
+		value = strconv.Quote(value)
+	}
+	return field + op + value
+}
+
 // List runs the "task list" CLI command, which connects to the server,
 // calls ListTasks() and requests the given task view.
 // Output is written to the given writer.
-func List(server, taskView, pageToken, stateFilter string, tagsFilter []string, namePrefix string, pageSize int32, all bool, writer io.Writer) error {
+func List(server, taskView, pageToken, stateFilter string, tagsFilter []string, namePrefix, filter string, pageSize int32, all bool, writer io.Writer) error {
 	cli, err := tes.NewClient(server)
 	if err != nil {
 		return err
@@ -26,6 +73,17 @@ func List(server, taskView, pageToken, stateFilter string, tagsFilter []string,
 
 	output := &tes.ListTasksResponse{}
 
+	// Multiple states, and tag prefixes and regular expressions, are only
+	// supported by the task filter.
+	terms := []string{}
+	if filter != "" {
+		terms = append(terms, filter)
+	}
+	if strings.Contains(stateFilter, ",") {
+		terms = append(terms, filterTerm("state", "=", stateFilter))
+		stateFilter = ""
+	}
+
 	state, err := getTaskState(stateFilter)
 	if err != nil {
 		return err
@@ -34,9 +92,13 @@ func List(server, taskView, pageToken, stateFilter string, tagsFilter []string,
 	tagKeys := []string{}
 	tagVals := []string{}
 	for _, v := range tagsFilter {
+		if i := strings.IndexAny(v, "^~="); i > 0 && (strings.HasPrefix(v[i:], "^=") || strings.HasPrefix(v[i:], "~=")) {
+			terms = append(terms, filterTerm("tag."+v[:i], v[i:i+2], v[i+2:]))
+			continue
+		}
 		parts := strings.Split(v, "=")
 		if len(parts) != 2 {
-			return fmt.Errorf("tags must be of the form: KEY=VALUE")
+			return fmt.Errorf("tags must be of the form: KEY=VALUE, KEY^=PREFIX or KEY~=REGEX")
 		}
 		tagKeys = append(tagKeys, parts[0])
 		tagVals = append(tagVals, parts[1])
@@ -53,7 +115,7 @@ func List(server, taskView, pageToken, stateFilter string, tagsFilter []string,
 			NamePrefix: namePrefix,
 		}
 
-		resp, err := cli.ListTasks(context.Background(), req)
+		resp, err := cli.ListTasksFilter(context.Background(), req, strings.Join(terms, " "))
 		if err != nil {
 			return err
 		}
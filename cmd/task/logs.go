@@ -0,0 +1,74 @@
+package task
+
+import (
+	"io"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/logs"
+	"github.com/ohsu-comp-bio/funnel/tes"
+	"golang.org/x/net/context"
+)
+
+// Time between the reads of the logs followed by "task logs --follow".
+var logsPollInterval = time.Second
+
+// Logs runs the "task logs" CLI command, which writes the archived stdout or
+// stderr of an executor, from the offset of the request, and up to its limit
+// if positive. A negative offset is relative to the end of the log. With
+// follow, the new output is written until the task ends.
+func Logs(server, rpcAddress string, req *logs.ReadLogsRequest, follow bool, w io.Writer) error {
+	conn, err := dialAttach(server, rpcAddress)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	client := logs.NewLogServiceClient(conn)
+
+	cli, err := tes.NewClient(server)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	remaining := req.Limit
+	done := false
+	for {
+		if remaining > 0 && remaining < logs.MaxReadSize {
+			req.Limit = remaining
+		} else {
+			req.Limit = logs.MaxReadSize
+		}
+		resp, err := client.ReadLogs(ctx, req)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(resp.Data); err != nil {
+			return err
+		}
+		req.Offset = resp.NextOffset
+
+		if remaining > 0 {
+			remaining -= int64(len(resp.Data))
+			if remaining <= 0 {
+				return nil
+			}
+		}
+		if len(resp.Data) > 0 && resp.NextOffset < resp.Size {
+			continue
+		}
+		if !follow || done {
+			return nil
+		}
+
+		// The workers write the last chunks of the logs before the task ends,
+		// so a read after the end of the task gets the rest of the logs.
+		task, err := cli.GetTask(ctx, &tes.GetTaskRequest{Id: req.Id, View: tes.View_MINIMAL.String()})
+		if err != nil {
+			return err
+		}
+		done = tes.TerminalState(task.State)
+		if !done {
+			time.Sleep(logsPollInterval)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/ohsu-comp-bio/funnel/provenance"
+	"github.com/ohsu-comp-bio/funnel/tes"
+	"golang.org/x/net/context"
+)
+
+// Provenance runs the "task provenance" CLI command, which connects to the
+// server, gets the full task, and writes its provenance as an RO-Crate
+// metadata document (ro-crate-metadata.json) to the given writer.
+func Provenance(server string, id string, w io.Writer) error {
+	cli, err := tes.NewClient(server)
+	if err != nil {
+		return err
+	}
+
+	task, err := cli.GetTask(context.Background(), &tes.GetTaskRequest{
+		Id:   id,
+		View: tes.View_FULL.String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(provenance.ROCrate(task), "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, string(b))
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/ohsu-comp-bio/funnel/cmd/util"
+	"github.com/ohsu-comp-bio/funnel/logs"
 	"github.com/ohsu-comp-bio/funnel/tes"
 	"github.com/spf13/cobra"
 )
@@ -20,11 +21,15 @@ func NewCommand() *cobra.Command {
 func newCommandHooks() (*cobra.Command, *hooks) {
 
 	h := &hooks{
-		Create: Create,
-		Get:    Get,
-		List:   List,
-		Cancel: Cancel,
-		Wait:   Wait,
+		Create:     Create,
+		Get:        Get,
+		List:       List,
+		Cancel:     Cancel,
+		Wait:       Wait,
+		Provenance: Provenance,
+		Attach:     Attach,
+		Exec:       Exec,
+		Logs:       Logs,
 	}
 
 	var (
@@ -67,22 +72,38 @@ func newCommandHooks() (*cobra.Command, *hooks) {
 		stateFilter string
 		tagsFilter  []string
 		namePrefix  string
+		filter      listFilter
 	)
 
 	list := &cobra.Command{
 		Use:   "list",
 		Short: "List all tasks.",
+		Long: `List all tasks.
+
+The tasks may be filtered with the Funnel task filter, e.g.
+  --filter 'state=QUEUED,RUNNING created>=2024-01-01 tag.project^=cohort- image~=^ubuntu'
+which the other filter flags add terms to.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return h.List(tesServer, listView, pageToken, stateFilter, tagsFilter, namePrefix, pageSize, listAll, cmd.OutOrStdout())
+			return h.List(tesServer, listView, pageToken, stateFilter, tagsFilter, namePrefix, filter.String(), pageSize, listAll, cmd.OutOrStdout())
 		},
 	}
 
 	lf := list.Flags()
 	lf.StringVarP(&listView, "view", "v", "basic", "Task view")
 	lf.StringVarP(&pageToken, "page-token", "p", pageToken, "Page token")
-	lf.StringVar(&stateFilter, "state", stateFilter, "State filter")
-	lf.StringSliceVar(&tagsFilter, "tag", tagsFilter, "Tag filter. May be used multiple times to specify more than one tag")
+	lf.StringVar(&stateFilter, "state", stateFilter, "State filter. Multiple states may be separated by commas")
+	lf.StringSliceVar(&tagsFilter, "tag", tagsFilter, "Tag filter, KEY=VALUE, KEY^=PREFIX or KEY~=REGEX. May be used multiple times to specify more than one tag")
 	lf.StringVar(&namePrefix, "name-prefix", namePrefix, "Name prefix")
+	lf.StringVar(&filter.Filter, "filter", "", "Task filter")
+	lf.StringSliceVar(&filter.Owners, "owner", nil, "Owner filter")
+	lf.StringSliceVar(&filter.Images, "image", nil, "Executor image prefix filter")
+	lf.StringVar(&filter.CreatedAfter, "created-after", "", "Tasks created at or after the time (RFC 3339 or YYYY-MM-DD)")
+	lf.StringVar(&filter.CreatedBefore, "created-before", "", "Tasks created before the time")
+	lf.StringVar(&filter.StartedAfter, "started-after", "", "Tasks started at or after the time")
+	lf.StringVar(&filter.StartedBefore, "started-before", "", "Tasks started before the time")
+	lf.StringVar(&filter.EndedAfter, "ended-after", "", "Tasks ended at or after the time")
+	lf.StringVar(&filter.EndedBefore, "ended-before", "", "Tasks ended before the time")
+	lf.StringVar(&filter.Sort, "sort", "", "Sort order: created (oldest first) or -created (default)")
 	lf.Int32VarP(&pageSize, "page-size", "s", pageSize, "Page size")
 	lf.BoolVar(&listAll, "all", listAll, "List all tasks")
 
@@ -117,16 +138,121 @@ func newCommandHooks() (*cobra.Command, *hooks) {
 		},
 	}
 
-	cmd.AddCommand(create, get, list, cancel, wait)
+	provenance := &cobra.Command{
+		Use:   "provenance [taskID]",
+		Short: "Get the provenance of a task as an RO-Crate.",
+		Long: `Writes the RO-Crate metadata (ro-crate-metadata.json) of the task: its
+inputs and outputs, and for each executor, the command line, the image digest,
+the container runtime and the resources.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return h.Provenance(tesServer, args[0], cmd.OutOrStdout())
+		},
+	}
+
+	var (
+		rpcAddress  string
+		executor    int32
+		interactive bool
+		tty         bool
+	)
+
+	rpcAddressFlag := func(cmd *cobra.Command) {
+		cmd.Flags().StringVar(&rpcAddress, "rpc-address", os.Getenv("FUNNEL_RPC_ADDRESS"),
+			"Address of the gRPC API of the server (default: port 9090 of the server host)")
+	}
+
+	attach := &cobra.Command{
+		Use:   "attach [taskID]",
+		Short: "Stream the live stdout/stderr of a running task.",
+		Long: `Streams the stdout/stderr of the running executor of the task, or of the
+executor given by --executor, until it ends. Exits with the exit code of the
+executor.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			code, err := h.Attach(tesServer, rpcAddress, args[0], executor, cmd.OutOrStdout(), cmd.ErrOrStderr())
+			if err != nil {
+				return err
+			}
+			if code != 0 {
+				os.Exit(code)
+			}
+			return nil
+		},
+	}
+	rpcAddressFlag(attach)
+	attach.Flags().Int32VarP(&executor, "executor", "e", -1, "Index of the executor (default: the running executor)")
+
+	exec := &cobra.Command{
+		Use:   "exec [taskID] -- [command ...]",
+		Short: "Run a command in the executor container of a running task.",
+		Long: `Runs a command in the container of the running executor of the task, or of
+the executor given by --executor, e.g. "funnel task exec -it <id> -- sh".
+Only the administrators and the owner of the task may run commands. Exits with
+the exit code of the command.`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			code, err := h.Exec(tesServer, rpcAddress, args[0], executor, args[1:], interactive, tty, cmd.InOrStdin(), cmd.OutOrStdout(), cmd.ErrOrStderr())
+			if err != nil {
+				return err
+			}
+			if code != 0 {
+				os.Exit(code)
+			}
+			return nil
+		},
+	}
+	rpcAddressFlag(exec)
+	ef := exec.Flags()
+	ef.Int32VarP(&executor, "executor", "e", -1, "Index of the executor (default: the running executor)")
+	ef.BoolVarP(&interactive, "interactive", "i", false, "Pass the stdin to the command")
+	ef.BoolVarP(&tty, "tty", "t", false, "Allocate a terminal")
+
+	var (
+		logsReq    logs.ReadLogsRequest
+		logsStderr bool
+		follow     bool
+	)
+	logsCmd := &cobra.Command{
+		Use:   "logs [taskID]",
+		Short: "Get the complete stdout/stderr of an executor.",
+		Long: `Writes the stdout, or the stderr with --stderr, of the executor of the task
+given by --executor, from the log archive of the server (LogArchive). A
+negative --offset is relative to the end of the log, e.g. "--offset -1000"
+writes its last 1000 bytes. With --follow, the new output is written until the
+task ends.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logsReq.Id = args[0]
+			if logsStderr {
+				logsReq.Stream = logs.Stream_STDERR
+			}
+			return h.Logs(tesServer, rpcAddress, &logsReq, follow, cmd.OutOrStdout())
+		},
+	}
+	rpcAddressFlag(logsCmd)
+	lgf := logsCmd.Flags()
+	lgf.Uint32VarP(&logsReq.Executor, "executor", "e", 0, "Index of the executor")
+	lgf.Uint32Var(&logsReq.Attempt, "attempt", 0, "Attempt of the task")
+	lgf.BoolVar(&logsStderr, "stderr", false, "Write the stderr instead of the stdout")
+	lgf.Int64Var(&logsReq.Offset, "offset", 0, "Offset in bytes of the start of the output, relative to the end if negative")
+	lgf.Int64Var(&logsReq.Limit, "limit", 0, "Maximum number of bytes to write (default: no limit)")
+	lgf.BoolVarP(&follow, "follow", "f", false, "Write the new output until the task ends")
+
+	cmd.AddCommand(create, get, list, cancel, wait, provenance, attach, exec, logsCmd)
 	return cmd, h
 }
 
 type hooks struct {
-	Create func(server string, messages []string, r io.Reader, w io.Writer) error
-	Get    func(server string, ids []string, view string, w io.Writer) error
-	List   func(server, view, pageToken, stateFilter string, tagsFilter []string, namePrefix string, pageSize int32, all bool, w io.Writer) error
-	Cancel func(server string, ids []string, w io.Writer) error
-	Wait   func(server string, ids []string) error
+	Create     func(server string, messages []string, r io.Reader, w io.Writer) error
+	Get        func(server string, ids []string, view string, w io.Writer) error
+	List       func(server, view, pageToken, stateFilter string, tagsFilter []string, namePrefix, filter string, pageSize int32, all bool, w io.Writer) error
+	Cancel     func(server string, ids []string, w io.Writer) error
+	Wait       func(server string, ids []string) error
+	Provenance func(server string, id string, w io.Writer) error
+	Attach     func(server, rpcAddress, id string, executor int32, stdout, stderr io.Writer) (int, error)
+	Exec       func(server, rpcAddress, id string, executor int32, command []string, interactive, tty bool, stdin io.Reader, stdout, stderr io.Writer) (int, error)
+	Logs       func(server, rpcAddress string, req *logs.ReadLogsRequest, follow bool, w io.Writer) error
 }
 
 func getTaskState(str string) (tes.State, error) {
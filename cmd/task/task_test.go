@@ -4,6 +4,8 @@ import (
 	"io"
 	"os"
 	"testing"
+
+	"github.com/ohsu-comp-bio/funnel/logs"
 )
 
 func TestGet(t *testing.T) {
@@ -38,10 +40,29 @@ func TestGetDefaultView(t *testing.T) {
 	cmd.Execute()
 }
 
+func TestProvenance(t *testing.T) {
+	cmd, h := newCommandHooks()
+
+	called := false
+	h.Provenance = func(server string, id string, w io.Writer) error {
+		called = true
+		if id != "1" {
+			t.Errorf("unexpected id: %s", id)
+		}
+		return nil
+	}
+
+	cmd.SetArgs([]string{"provenance", "1"})
+	cmd.Execute()
+	if !called {
+		t.Error("expected the provenance hook to be called")
+	}
+}
+
 func TestList(t *testing.T) {
 	cmd, h := newCommandHooks()
 
-	h.List = func(server, view, page, state string, tags []string, namePrefix string, size int32, all bool, w io.Writer) error {
+	h.List = func(server, view, page, state string, tags []string, namePrefix, filter string, size int32, all bool, w io.Writer) error {
 		if view != "FULL" {
 			t.Errorf("expected FULL view, got '%s'", view)
 		}
@@ -52,6 +73,27 @@ func TestList(t *testing.T) {
 	cmd.Execute()
 }
 
+func TestListFilter(t *testing.T) {
+	cmd, h := newCommandHooks()
+
+	called := false
+	h.List = func(server, view, page, state string, tags []string, namePrefix, filter string, size int32, all bool, w io.Writer) error {
+		called = true
+		expected := `tag.project^=cohort- owner=alice,bob image^=ubuntu created>=2024-01-01 ended<"2024-02-01 10:00" sort=created`
+		if filter != expected {
+			t.Errorf("unexpected filter: %s", filter)
+		}
+		return nil
+	}
+
+	cmd.SetArgs([]string{"list", "--filter", "tag.project^=cohort-", "--owner", "alice,bob", "--image", "ubuntu",
+		"--created-after", "2024-01-01", "--ended-before", "2024-02-01 10:00", "--sort", "created"})
+	cmd.Execute()
+	if !called {
+		t.Error("expected the list hook to be called")
+	}
+}
+
 // Test that the server URL defaults to localhost:8000
 func TestServerDefault(t *testing.T) {
 	cmd, h := newCommandHooks()
@@ -62,7 +104,7 @@ func TestServerDefault(t *testing.T) {
 		}
 		return nil
 	}
-	h.List = func(server, view, page, state string, tags []string, namePrefix string, size int32, all bool, w io.Writer) error {
+	h.List = func(server, view, page, state string, tags []string, namePrefix, filter string, size int32, all bool, w io.Writer) error {
 		if server != "http://localhost:8000" {
 			t.Errorf("expected localhost default, got '%s'", server)
 		}
@@ -116,7 +158,7 @@ func TestServerEnv(t *testing.T) {
 		}
 		return nil
 	}
-	h.List = func(server, view, page, state string, tags []string, namePrefix string, size int32, all bool, w io.Writer) error {
+	h.List = func(server, view, page, state string, tags []string, namePrefix, filter string, size int32, all bool, w io.Writer) error {
 		if server != "foobar" {
 			t.Error("expected foobar")
 		}
@@ -170,7 +212,7 @@ func TestServerFlagOverride(t *testing.T) {
 		}
 		return nil
 	}
-	h.List = func(server, view, page, state string, tags []string, namePrefix string, size int32, all bool, w io.Writer) error {
+	h.List = func(server, view, page, state string, tags []string, namePrefix, filter string, size int32, all bool, w io.Writer) error {
 		if server != "flagval" {
 			t.Error("expected flagval")
 		}
@@ -210,3 +252,44 @@ func TestServerFlagOverride(t *testing.T) {
 	cmd.SetArgs([]string{"wait", "-S", srv, "1"})
 	cmd.Execute()
 }
+
+func TestExec(t *testing.T) {
+	cmd, h := newCommandHooks()
+
+	called := false
+	h.Exec = func(server, rpcAddress, id string, executor int32, command []string, interactive, tty bool, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+		called = true
+		if id != "1" || executor != 2 || !interactive || !tty {
+			t.Errorf("unexpected args: %s %d %v %v", id, executor, interactive, tty)
+		}
+		if len(command) != 3 || command[0] != "sh" || command[1] != "-c" {
+			t.Errorf("unexpected command: %#v", command)
+		}
+		return 0, nil
+	}
+
+	cmd.SetArgs([]string{"exec", "-it", "--executor", "2", "1", "--", "sh", "-c", "ls"})
+	cmd.Execute()
+	if !called {
+		t.Error("expected the exec hook to be called")
+	}
+}
+
+func TestLogs(t *testing.T) {
+	cmd, h := newCommandHooks()
+
+	called := false
+	h.Logs = func(server, rpcAddress string, req *logs.ReadLogsRequest, follow bool, w io.Writer) error {
+		called = true
+		if req.Id != "1" || req.Executor != 2 || req.Stream != logs.Stream_STDERR || req.Offset != -100 || !follow {
+			t.Errorf("unexpected args: %v %v", req, follow)
+		}
+		return nil
+	}
+
+	cmd.SetArgs([]string{"logs", "-f", "-e", "2", "--stderr", "--offset", "-100", "1"})
+	cmd.Execute()
+	if !called {
+		t.Error("expected the logs hook to be called")
+	}
+}
@@ -102,6 +102,7 @@ func workerFlags(flagConf *config.Config) *pflag.FlagSet {
 	f.StringVar(&flagConf.Worker.ScratchPath, "Worker.ScratchPath", flagConf.Worker.ScratchPath, "Scratch directory")
 	f.BoolVar(&flagConf.Worker.LeaveWorkDir, "Worker.LeaveWorkDir", flagConf.Worker.LeaveWorkDir, "Leave working directory after execution")
 	f.StringVar(&flagConf.Worker.DriverCommand, "Worker.DriverCommand", flagConf.Worker.DriverCommand, "Overrides the default command used to run containers.")
+	f.StringVar(&flagConf.Worker.Executor, "Worker.Executor", flagConf.Worker.Executor, "Executor backend (docker, apptainer or process)")
 
 	return f
 }
@@ -135,6 +136,9 @@ func dbFlags(flagConf *config.Config) *pflag.FlagSet {
 	// boltdb
 	f.StringVar(&flagConf.BoltDB.Path, "BoltDB.Path", flagConf.BoltDB.Path, "Path to BoltDB database")
 
+	// sqlite
+	f.StringVar(&flagConf.SQLite.Path, "SQLite.Path", flagConf.SQLite.Path, "Path to SQLite database")
+
 	// dynamodb
 	f.StringVar(&flagConf.DynamoDB.AWSConfig.Region, "DynamoDB.Region", flagConf.DynamoDB.AWSConfig.Region, "AWS region of DynamoDB tables")
 	f.StringVar(&flagConf.DynamoDB.TableBasename, "DynamoDB.TableBasename", flagConf.DynamoDB.TableBasename, "Basename of DynamoDB tables")
@@ -150,6 +154,34 @@ func dbFlags(flagConf *config.Config) *pflag.FlagSet {
 	// kafka
 	f.StringSliceVar(&flagConf.Kafka.Servers, "Kafka.Servers", flagConf.Kafka.Servers, "Address of a Kafka server. This flag can be used multiple times")
 	f.StringVar(&flagConf.Kafka.Topic, "Kafka.Topic", flagConf.Kafka.Topic, "Kafka topic to write events to")
+	f.StringVar(&flagConf.Kafka.ConsumerGroup, "Kafka.ConsumerGroup", flagConf.Kafka.ConsumerGroup, "Kafka consumer group of the server, which reads the events of the topic into its database")
+	f.BoolVar(&flagConf.Kafka.IncludeLogs, "Kafka.IncludeLogs", flagConf.Kafka.IncludeLogs, "Write the stdout, stderr and system log events to Kafka")
+	f.StringVar(&flagConf.Kafka.DeadLetterTopic, "Kafka.DeadLetterTopic", flagConf.Kafka.DeadLetterTopic, "Kafka topic receiving the events which can't be read")
+
+	// nats
+	f.StringVar(&flagConf.NATS.URL, "NATS.URL", flagConf.NATS.URL, "NATS server URL")
+	f.StringVar(&flagConf.NATS.Subject, "NATS.Subject", flagConf.NATS.Subject, "NATS subject to write events to")
+	f.StringVar(&flagConf.NATS.Consumer, "NATS.Consumer", flagConf.NATS.Consumer, "Durable NATS consumer of the server, which reads the events of the stream into its database")
+	f.BoolVar(&flagConf.NATS.IncludeLogs, "NATS.IncludeLogs", flagConf.NATS.IncludeLogs, "Write the stdout, stderr and system log events to NATS")
+
+	// redis
+	f.StringVar(&flagConf.Redis.Addr, "Redis.Addr", flagConf.Redis.Addr, "Address of Redis server")
+	f.StringVar(&flagConf.Redis.Stream, "Redis.Stream", flagConf.Redis.Stream, "Redis stream to write events to")
+	f.StringVar(&flagConf.Redis.ConsumerGroup, "Redis.ConsumerGroup", flagConf.Redis.ConsumerGroup, "Redis consumer group of the server, which reads the events of the stream into its database")
+	f.BoolVar(&flagConf.Redis.IncludeLogs, "Redis.IncludeLogs", flagConf.Redis.IncludeLogs, "Write the stdout, stderr and system log events to Redis")
+
+	// amqp
+	f.StringVar(&flagConf.AMQP.URL, "AMQP.URL", flagConf.AMQP.URL, "AMQP server URL")
+	f.StringVar(&flagConf.AMQP.Queue, "AMQP.Queue", flagConf.AMQP.Queue, "AMQP queue to write events to")
+	f.BoolVar(&flagConf.AMQP.Consume, "AMQP.Consume", flagConf.AMQP.Consume, "Read the events of the AMQP queue into the database of the server")
+	f.BoolVar(&flagConf.AMQP.IncludeLogs, "AMQP.IncludeLogs", flagConf.AMQP.IncludeLogs, "Write the stdout, stderr and system log events to AMQP")
+
+	// cloudevents
+	f.StringVar(&flagConf.CloudEvents.URL, "CloudEvents.URL", flagConf.CloudEvents.URL, "HTTP endpoint receiving the events as CloudEvents")
+	f.StringVar(&flagConf.CloudEvents.Mode, "CloudEvents.Mode", flagConf.CloudEvents.Mode, "Content mode of the CloudEvents: structured or binary")
+
+	// tracing
+	f.StringVar(&flagConf.Tracing.Endpoint, "Tracing.Endpoint", flagConf.Tracing.Endpoint, "OTLP/HTTP endpoint receiving the spans of the tasks, e.g. http://localhost:4318/v1/traces")
 
 	// mongodb
 	f.StringSliceVar(&flagConf.MongoDB.Addrs, "MongoDB.Addrs", flagConf.MongoDB.Addrs, "Address of a MongoDB seed server. This flag can be used multiple times")
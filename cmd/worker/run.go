@@ -3,8 +3,10 @@ package worker
 import (
 	"context"
 	"fmt"
+	"io"
 	"strings"
 
+	"github.com/ohsu-comp-bio/funnel/attach"
 	"github.com/ohsu-comp-bio/funnel/config"
 	"github.com/ohsu-comp-bio/funnel/database/datastore"
 	"github.com/ohsu-comp-bio/funnel/database/dynamodb"
@@ -13,14 +15,28 @@ import (
 	"github.com/ohsu-comp-bio/funnel/database/postgres"
 	"github.com/ohsu-comp-bio/funnel/events"
 	"github.com/ohsu-comp-bio/funnel/logger"
+	"github.com/ohsu-comp-bio/funnel/logs"
+	"github.com/ohsu-comp-bio/funnel/secrets"
 	"github.com/ohsu-comp-bio/funnel/storage"
 	"github.com/ohsu-comp-bio/funnel/tes"
+	"github.com/ohsu-comp-bio/funnel/tracing"
 	"github.com/ohsu-comp-bio/funnel/util"
+	"github.com/ohsu-comp-bio/funnel/util/rpc"
 	"github.com/ohsu-comp-bio/funnel/worker"
 )
 
 // Run runs the "worker run" command.
 func Run(ctx context.Context, conf *config.Config, log *logger.Logger, opts *Options) error {
+	shutdown, err := tracing.Setup(ctx, conf.Tracing, "funnel-worker")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := shutdown(context.Background()); err != nil {
+			log.Error("flushing the spans", "error", err)
+		}
+	}()
+
 	w, err := NewWorker(ctx, conf, log, opts)
 	if err != nil {
 		return err
@@ -71,11 +87,26 @@ func NewWorker(ctx context.Context, conf *config.Config, log *logger.Logger, opt
 	}
 	store.AttachLogger(log)
 
-	// The executor always defaults to docker, unless explicitly set to kubernetes.
+	// Store of the secrets referenced by tasks.
+	secretStore, err := secrets.NewStore(conf.Secrets)
+	if err != nil {
+		return nil, fmt.Errorf("creating secret store: %v", err)
+	}
+
+	// The executor defaults to docker, unless set to apptainer or process by
+	// Worker.Executor, or to kubernetes by the compute backend.
 	var executor = worker.Executor{
 		Backend: "docker",
 	}
 
+	switch conf.Worker.Executor {
+	case "", "docker":
+	case "apptainer", "process":
+		executor.Backend = conf.Worker.Executor
+	default:
+		return nil, fmt.Errorf("unknown Worker.Executor %q. Expected 'docker', 'apptainer' or 'process'", conf.Worker.Executor)
+	}
+
 	if conf.Compute == "kubernetes" {
 		executor.Backend = "kubernetes"
 		executor.Template = conf.Kubernetes.ExecutorTemplate
@@ -85,17 +116,70 @@ func NewWorker(ctx context.Context, conf *config.Config, log *logger.Logger, opt
 		executor.Resources = conf.Kubernetes.Resources
 		executor.NodeSelector = conf.Kubernetes.NodeSelector
 		executor.Tolerations = convertK8sTolerations(conf.Kubernetes.Tolerations)
+		executor.ImagePullSecrets = conf.Kubernetes.ImagePullSecrets
 	}
 
-	return &worker.DefaultWorker{
+	w := &worker.DefaultWorker{
 		Executor:    executor,
 		Conf:        conf.Worker,
 		Store:       store,
 		TaskReader:  reader,
 		EventWriter: writer,
+		Secrets:     secretStore,
+	}
+
+	// Serve the attach and exec sessions of the task through the server.
+	// Tasks from a file or string have no server.
+	if !conf.Worker.DisableAttach && conf.RPCClient.GetServerAddress() != "" &&
+		opts.TaskFile == "" && opts.TaskBase64 == "" {
+		conn, err := rpc.Dial(ctx, conf.RPCClient)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to the server for attach: %v", err)
+		}
+		w.Attach = &attachClient{attach.NewAttachServiceClient(conn), conn}
+	}
+
+	w.LogArchive, err = newLogArchive(ctx, conf, store)
+	if err != nil {
+		return nil, fmt.Errorf("creating the log archive: %v", err)
+	}
+	return w, nil
+}
+
+// newLogArchive returns the archive of the executor logs configured by
+// LogArchive, or nil if it is disabled.
+func newLogArchive(ctx context.Context, conf *config.Config, store storage.Storage) (*worker.LogArchive, error) {
+	var out logs.ChunkWriter
+	switch strings.ToLower(conf.LogArchive.GetSink()) {
+	case "":
+		return nil, nil
+	case "storage":
+		if conf.LogArchive.URL == "" {
+			return nil, fmt.Errorf("LogArchive.URL is required by the storage sink")
+		}
+		out = &logs.StorageStore{Storage: store, URL: conf.LogArchive.URL}
+	case "database":
+		w, err := logs.NewRPCWriter(ctx, conf.RPCClient)
+		if err != nil {
+			return nil, err
+		}
+		out = w
+	default:
+		return nil, fmt.Errorf("unknown log archive sink: '%s'", conf.LogArchive.Sink)
+	}
+	return &worker.LogArchive{
+		Writer:        out,
+		ChunkSize:     conf.LogArchive.ChunkSize,
+		FlushInterval: conf.LogArchive.GetFlushInterval().AsDuration(),
 	}, nil
 }
 
+// attachClient closes its connection when the worker is closed.
+type attachClient struct {
+	attach.AttachServiceClient
+	io.Closer
+}
+
 // newTaskReader finds a TaskReader implementation that matches the config
 // and commandline options.
 func newTaskReader(ctx context.Context, conf *config.Config, opts *Options) (worker.TaskReader, error) {
@@ -132,7 +216,7 @@ func newTaskReader(ctx context.Context, conf *config.Config, opts *Options) (wor
 		return newDatabaseTaskReader(opts.TaskID, db, err)
 
 	// These readers connect via RPC (because the database is embedded in the server).
-	// case "boltdb", "badger":
+	// case "boltdb", "badger", "sqlite":
 	// Default to asking the server for the task.
 
 	default:
@@ -186,7 +270,10 @@ func (e *eventWriterBuilder) Add(ctx context.Context, name string, conf *config.
 	switch strings.ToLower(name) {
 	case "log":
 		writer = &events.Logger{Log: log}
-	case "boltdb", "badger", "grpc", "rpc":
+	case "webhook":
+		// The webhooks are sent by the server, which reads the tasks.
+		return
+	case "boltdb", "badger", "sqlite", "grpc", "rpc":
 		writer, err = events.NewRPCWriter(ctx, conf.RPCClient)
 	case "dynamodb":
 		writer, err = dynamodb.NewDynamoDB(conf.DynamoDB)
@@ -198,6 +285,14 @@ func (e *eventWriterBuilder) Add(ctx context.Context, name string, conf *config.
 		writer, err = events.NewKafkaWriter(ctx, conf.Kafka)
 	case "pubsub":
 		writer, err = events.NewPubSubWriter(ctx, conf.PubSub)
+	case "nats":
+		writer, err = events.NewNATSWriter(ctx, conf.NATS)
+	case "redis":
+		writer, err = events.NewRedisWriter(ctx, conf.Redis)
+	case "amqp":
+		writer, err = events.NewAMQPWriter(ctx, conf.AMQP)
+	case "cloudevents":
+		writer, err = events.NewCloudEventsWriter(ctx, conf.CloudEvents)
 	case "mongodb":
 		writer, err = mongodb.NewMongoDB(conf.MongoDB)
 	case "postgres", "psql":
@@ -209,7 +304,7 @@ func (e *eventWriterBuilder) Add(ctx context.Context, name string, conf *config.
 	if err != nil {
 		e.errors = append(e.errors, err)
 	} else {
-		e.writers = append(e.writers, writer)
+		e.writers = append(e.writers, events.NewDedupWriter(writer, 1000))
 	}
 }
 
@@ -218,7 +313,9 @@ func validateConfig(conf *config.Config, opts *Options) error {
 	// only a subset of event writers are supported.
 	if opts.TaskFile != "" || opts.TaskBase64 != "" {
 		for _, e := range conf.EventWriters {
-			if strings.ToLower(e) != "log" && strings.ToLower(e) != "kafka" && strings.ToLower(e) != "pubsub" {
+			switch strings.ToLower(e) {
+			case "log", "kafka", "pubsub", "nats", "redis", "amqp", "cloudevents":
+			default:
 				return fmt.Errorf("event writer %q is not supported with a task file/string reader", e)
 			}
 		}
@@ -171,7 +171,15 @@ func (b *Backend) createResources(ctx context.Context, task *tes.Task, config *c
 		defer cancel()
 	}
 
-	// Create Worker Job first so its UID can be used as an owner reference on
+	// Create the NetworkPolicy of the executor pods before the Worker Job
+	// creates them, so that they never run with more network access than the
+	// task policy. It's deleted by cleanResources.
+	err := b.createNetworkPolicy(timeoutCtx, task, config)
+	if err != nil {
+		return err
+	}
+
+	// Then create the Worker Job, so its UID can be used as an owner reference on
 	// all subordinate namespaced resources, enabling automatic K8s GC cleanup.
 	b.log.Debug("creating Worker Job", "taskID", task.Id)
 	job, err := resources.CreateJob(timeoutCtx, task, config, b.client, b.log)
@@ -285,6 +293,21 @@ func (b *Backend) createResources(ctx context.Context, task *tes.Task, config *c
 	return nil
 }
 
+// createNetworkPolicy creates the NetworkPolicy of the executor pods of a
+// task, if its network policy needs one.
+func (b *Backend) createNetworkPolicy(ctx context.Context, task *tes.Task, conf *config.Config) error {
+	network, err := config.TaskNetwork(conf.Worker, task)
+	if err != nil {
+		return fmt.Errorf("network policy: %w", err)
+	}
+	b.log.Debug("creating executor NetworkPolicy", "taskID", task.Id, "policy", network.Policy)
+	err = resources.CreateNetworkPolicy(ctx, task.Id, network, conf.Kubernetes.JobsNamespace, b.client, b.log, nil)
+	if err != nil {
+		return fmt.Errorf("creating executor NetworkPolicy: %w", err)
+	}
+	return nil
+}
+
 // cleanResources deletes the resources created for a task.
 func (b *Backend) cleanResources(ctx context.Context, taskId string) error {
 	var errs error
@@ -346,6 +369,13 @@ func (b *Backend) cleanResources(ctx context.Context, taskId string) error {
 		b.log.Error("deleting Worker Role", "error", err)
 	}
 
+	// Delete the NetworkPolicy of the executors
+	err = resources.DeleteNetworkPolicy(ctx, taskId, b.conf.Kubernetes.JobsNamespace, b.client, b.log)
+	if err != nil {
+		errs = multierror.Append(errs, err)
+		b.log.Error("deleting executor NetworkPolicy", "error", err)
+	}
+
 	return errs
 }
 
@@ -0,0 +1,164 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/logger"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+)
+
+// lookupIP resolves the allowed host names, replaced in tests.
+var lookupIP = net.LookupIP
+
+// CreateNetworkPolicy creates the NetworkPolicy of the executor pods of a
+// task, which are labeled by the worker. The "none" policy denies all the
+// traffic of the pods; the "allowlist" policy only allows egress to the
+// allowed hosts, and to the DNS servers. Other policies don't need a
+// NetworkPolicy.
+func CreateNetworkPolicy(ctx context.Context, taskID string, network *config.Network, namespace string, client kubernetes.Interface, log *logger.Logger, ownerRef *metav1.OwnerReference) error {
+	policy := network.GetPolicy()
+	if policy != config.NetworkNone && policy != config.NetworkAllowlist {
+		return nil
+	}
+
+	np := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("funnel-executor-%s", taskID),
+			Namespace: namespace,
+			Labels: map[string]string{
+				"app":    "funnel",
+				"taskId": taskID,
+			},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					"app":    "funnel-executor",
+					"taskId": taskID,
+				},
+			},
+			PolicyTypes: []networkingv1.PolicyType{
+				networkingv1.PolicyTypeIngress,
+				networkingv1.PolicyTypeEgress,
+			},
+		},
+	}
+
+	if policy == config.NetworkAllowlist {
+		var peers []networkingv1.NetworkPolicyPeer
+		for _, host := range network.GetAllowedHosts() {
+			cidrs, err := hostCIDRs(host)
+			if err != nil {
+				return err
+			}
+			for _, cidr := range cidrs {
+				peers = append(peers, networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: cidr}})
+			}
+		}
+		// DNS is only allowed to the DNS servers: DNS queries to any server
+		// could carry data out.
+		servers, err := dnsPeers(network.GetDNSServers())
+		if err != nil {
+			return err
+		}
+		udp, tcp := corev1.ProtocolUDP, corev1.ProtocolTCP
+		dns := intstr.FromInt32(53)
+		np.Spec.Egress = []networkingv1.NetworkPolicyEgressRule{
+			{To: peers},
+			{To: servers, Ports: []networkingv1.NetworkPolicyPort{{Protocol: &udp, Port: &dns}, {Protocol: &tcp, Port: &dns}}},
+		}
+	}
+
+	if ownerRef != nil {
+		np.OwnerReferences = []metav1.OwnerReference{*ownerRef}
+	}
+
+	log.Debug("creating executor NetworkPolicy", "name", np.Name, "policy", policy, "taskID", taskID)
+	_, err := client.NetworkingV1().NetworkPolicies(namespace).Create(ctx, np, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create NetworkPolicy: %v", err)
+	}
+	return nil
+}
+
+// dnsPeers returns the peers of the DNS servers: the configured IP addresses
+// or CIDR blocks, or else the pods of the cluster DNS.
+func dnsPeers(servers []string) ([]networkingv1.NetworkPolicyPeer, error) {
+	if len(servers) == 0 {
+		return []networkingv1.NetworkPolicyPeer{{
+			NamespaceSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"kubernetes.io/metadata.name": "kube-system"},
+			},
+			PodSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"k8s-app": "kube-dns"},
+			},
+		}}, nil
+	}
+	var peers []networkingv1.NetworkPolicyPeer
+	for _, server := range servers {
+		cidr := server
+		if _, _, err := net.ParseCIDR(server); err != nil {
+			ip := net.ParseIP(server)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid DNS server %q: expected an IP address or a CIDR block", server)
+			}
+			cidr = ipCIDR(ip)
+		}
+		peers = append(peers, networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: cidr}})
+	}
+	return peers, nil
+}
+
+// hostCIDRs returns the CIDR blocks of an allowed host: an IP address, a CIDR
+// block, or a host name resolved now. The addresses of a host name may change
+// while the task runs.
+func hostCIDRs(host string) ([]string, error) {
+	if _, block, err := net.ParseCIDR(host); err == nil {
+		return []string{block.String()}, nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{ipCIDR(ip)}, nil
+	}
+	ips, err := lookupIP(strings.TrimSuffix(host, "."))
+	if err != nil {
+		return nil, fmt.Errorf("resolving allowed host %s: %v", host, err)
+	}
+	var cidrs []string
+	for _, ip := range ips {
+		cidrs = append(cidrs, ipCIDR(ip))
+	}
+	return cidrs, nil
+}
+
+func ipCIDR(ip net.IP) string {
+	if ip.To4() != nil {
+		return ip.String() + "/32"
+	}
+	return ip.String() + "/128"
+}
+
+// DeleteNetworkPolicy deletes the NetworkPolicy created for a task, if any.
+func DeleteNetworkPolicy(ctx context.Context, taskID string, namespace string, client kubernetes.Interface, log *logger.Logger) error {
+	policies, err := client.NetworkingV1().NetworkPolicies(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("app=funnel,taskId=%s", taskID),
+	})
+	if err != nil {
+		return fmt.Errorf("listing NetworkPolicies for task %s: %v", taskID, err)
+	}
+	for _, np := range policies.Items {
+		log.Debug("deleting executor NetworkPolicy", "name", np.Name, "taskID", taskID)
+		if err := client.NetworkingV1().NetworkPolicies(namespace).Delete(ctx, np.Name, metav1.DeleteOptions{}); err != nil {
+			return fmt.Errorf("deleting NetworkPolicy %s: %v", np.Name, err)
+		}
+	}
+	return nil
+}
@@ -2,6 +2,8 @@ package resources
 
 import (
 	"context"
+	"net"
+	"strings"
 	"testing"
 
 	"github.com/ohsu-comp-bio/funnel/config"
@@ -497,3 +499,88 @@ func TestDeleteRoleBinding(t *testing.T) {
 		t.Errorf("DeleteRoleBinding failed: %v", err)
 	}
 }
+
+func TestCreateNetworkPolicy(t *testing.T) {
+	lookupIP = func(host string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("192.0.2.10"), net.ParseIP("2001:db8::1")}, nil
+	}
+	defer func() { lookupIP = net.LookupIP }()
+	fakeClient := fake.NewSimpleClientset()
+
+	// No NetworkPolicy for the default network.
+	err := CreateNetworkPolicy(ctx, testTaskID, &config.Network{Policy: config.NetworkBridge}, jobsNamespace, fakeClient, l, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, _ := fakeClient.NetworkingV1().NetworkPolicies(jobsNamespace).List(ctx, metav1.ListOptions{})
+	if len(list.Items) != 0 {
+		t.Fatalf("unexpected NetworkPolicy: %v", list.Items)
+	}
+
+	// Deny all the traffic.
+	err = CreateNetworkPolicy(ctx, testTaskID, &config.Network{Policy: config.NetworkNone}, jobsNamespace, fakeClient, l, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	np, err := fakeClient.NetworkingV1().NetworkPolicies(jobsNamespace).Get(ctx, "funnel-executor-"+testTaskID, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if np.Spec.PodSelector.MatchLabels["taskId"] != testTaskID || len(np.Spec.PolicyTypes) != 2 ||
+		len(np.Spec.Ingress) != 0 || len(np.Spec.Egress) != 0 {
+		t.Errorf("unexpected NetworkPolicy: %v", np.Spec)
+	}
+
+	err = DeleteNetworkPolicy(ctx, testTaskID, jobsNamespace, fakeClient, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Egress to the allowed hosts, and DNS.
+	network := &config.Network{Policy: config.NetworkAllowlist, AllowedHosts: []string{"10.1.0.0/16", "10.2.0.1", "pypi.org"}}
+	err = CreateNetworkPolicy(ctx, testTaskID, network, jobsNamespace, fakeClient, l, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	np, err = fakeClient.NetworkingV1().NetworkPolicies(jobsNamespace).Get(ctx, "funnel-executor-"+testTaskID, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(np.Spec.Egress) != 2 {
+		t.Fatalf("unexpected egress rules: %v", np.Spec.Egress)
+	}
+	var cidrs []string
+	for _, peer := range np.Spec.Egress[0].To {
+		cidrs = append(cidrs, peer.IPBlock.CIDR)
+	}
+	expected := []string{"10.1.0.0/16", "10.2.0.1/32", "192.0.2.10/32", "2001:db8::1/128"}
+	if strings.Join(cidrs, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected egress to %v, got %v", expected, cidrs)
+	}
+	dns := np.Spec.Egress[1]
+	if len(dns.Ports) != 2 || dns.Ports[0].Port.IntValue() != 53 {
+		t.Errorf("expected DNS egress, got %v", dns)
+	}
+	// DNS is only allowed to the cluster DNS.
+	if len(dns.To) != 1 || dns.To[0].PodSelector.MatchLabels["k8s-app"] != "kube-dns" ||
+		dns.To[0].NamespaceSelector.MatchLabels["kubernetes.io/metadata.name"] != "kube-system" {
+		t.Errorf("expected DNS egress to the cluster DNS, got %v", dns.To)
+	}
+
+	// Or to the configured DNS servers.
+	if err := DeleteNetworkPolicy(ctx, testTaskID, jobsNamespace, fakeClient, l); err != nil {
+		t.Fatal(err)
+	}
+	network.DNSServers = []string{"10.96.0.10"}
+	err = CreateNetworkPolicy(ctx, testTaskID, network, jobsNamespace, fakeClient, l, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	np, err = fakeClient.NetworkingV1().NetworkPolicies(jobsNamespace).Get(ctx, "funnel-executor-"+testTaskID, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if to := np.Spec.Egress[1].To; len(to) != 1 || to[0].IPBlock.CIDR != "10.96.0.10/32" {
+		t.Errorf("expected DNS egress to 10.96.0.10/32, got %v", to)
+	}
+}
@@ -65,8 +65,10 @@ type NodeProcess struct {
 
 // Run runs a node with the given config. This is responsible for communication
 // with the server and starting task workers
-func (n *NodeProcess) Run(ctx context.Context) error {
-	ctx, cancel := context.WithCancel(ctx)
+func (n *NodeProcess) Run(pctx context.Context) error {
+	// The workers are stopped once the node has decided how to report its
+	// shutdown, which depends on whether they're still running.
+	ctx, cancel := context.WithCancel(context.WithoutCancel(pctx))
 	defer cancel()
 
 	n.log.Info("Starting node")
@@ -80,26 +82,13 @@ func (n *NodeProcess) Run(ctx context.Context) error {
 	for {
 		select {
 		case <-n.timeout.Done():
-			cancel()
+			return n.stop(cancel)
 
 		case <-n.drained:
-			cancel()
+			return n.stop(cancel)
 
-		case <-ctx.Done():
-			n.timeout.Stop()
-
-			// The node gets 10 seconds to do a final sync with the scheduler.
-			stopCtx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-			defer cancel()
-
-			n.state = NodeState_GONE
-			n.sync(stopCtx)
-			// close grpc client connection
-			n.client.Close()
-
-			// The workers get 10 seconds to finish up.
-			n.workers.Wait(time.Second * 10)
-			return nil
+		case <-pctx.Done():
+			return n.stop(cancel)
 
 		case <-ticker.C:
 			n.sync(ctx)
@@ -109,6 +98,32 @@ func (n *NodeProcess) Run(ctx context.Context) error {
 	}
 }
 
+// stop stops the workers and does a final sync with the scheduler.
+func (n *NodeProcess) stop(stopWorkers context.CancelFunc) error {
+	n.timeout.Stop()
+
+	// A node stopped with running tasks, e.g. to restart, is reported dead
+	// rather than gone: its tasks stay assigned to it, and resume if it
+	// restarts before it's marked gone (Scheduler.NodeDeadTimeout).
+	if n.workers.Count() > 0 {
+		n.state = NodeState_DEAD
+	} else {
+		n.state = NodeState_GONE
+	}
+	stopWorkers()
+
+	// The node gets 10 seconds to do a final sync with the scheduler.
+	stopCtx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	n.sync(stopCtx)
+	// close grpc client connection
+	n.client.Close()
+
+	// The workers get 10 seconds to finish up.
+	n.workers.Wait(time.Second * 10)
+	return nil
+}
+
 func (n *NodeProcess) checkConnection(ctx context.Context) {
 	_, err := n.client.GetNode(ctx, &GetNodeRequest{Id: n.conf.Node.ID})
 
@@ -146,8 +161,10 @@ func (n *NodeProcess) sync(ctx context.Context) {
 	// Start task workers. runSet will track task IDs
 	// to ensure there's only one worker per ID, so it's ok
 	// to call this multiple times with the same task ID.
+	// A stopped node doesn't start new workers.
+	stopped := n.state == NodeState_DEAD || n.state == NodeState_GONE
 	for _, id := range r.TaskIds {
-		if n.workers.Add(id) {
+		if !stopped && n.workers.Add(id) {
 			go n.runTask(ctx, id)
 		}
 	}
@@ -181,3 +181,41 @@ func TestFinishedTaskRunsetCount(t *testing.T) {
 		t.Fatalf("Unexpected worker count: %d", n.workers.Count())
 	}
 }
+
+// Test that a node stopped with running tasks, e.g. to restart, is reported
+// dead rather than gone, so that its tasks aren't failed.
+func TestStopNodeWithTasks(t *testing.T) {
+	conf := config.DefaultConfig()
+	conf.Node.UpdateRate = durationpb.New(time.Millisecond * 2)
+	n := newTestNode(conf, t)
+
+	started := make(chan struct{})
+	n.workerRun = func(ctx context.Context, id string) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	n.Client.On("GetNode", mock.Anything, mock.Anything, mock.Anything).
+		Return(&Node{TaskIds: []string{"task-1"}}, nil)
+
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		n.Run(ctx)
+		close(done)
+	}()
+	<-started
+	stop()
+	<-done
+
+	var last *Node
+	for _, c := range n.Client.Calls {
+		if c.Method == "PutNode" {
+			last = c.Arguments.Get(1).(*Node)
+		}
+	}
+	if last.GetState() != NodeState_DEAD {
+		t.Errorf("unexpected final node state: %s", last.GetState())
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"github.com/ohsu-comp-bio/funnel/events"
 	"github.com/ohsu-comp-bio/funnel/logger"
 	"github.com/ohsu-comp-bio/funnel/tes"
+	"github.com/ohsu-comp-bio/funnel/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/net/context"
 )
 
@@ -95,6 +97,10 @@ func (s *Scheduler) Schedule(ctx context.Context) error {
 	for _, task := range s.Queue.ReadQueue(int(s.Conf.ScheduleChunk)) {
 		offer := s.GetOffer(task)
 		if offer != nil {
+			ctx, span := tracing.Start(tracing.TaskContext(ctx, task.Id), "AssignTask",
+				tracing.TaskIDKey.String(task.Id),
+				attribute.String("funnel.node.id", offer.Node.Id),
+			)
 			s.Log.Info("Assigning task to node",
 				"taskID", task.Id,
 				"nodeID", offer.Node.Id,
@@ -121,6 +127,7 @@ func (s *Scheduler) Schedule(ctx context.Context) error {
 						"error":  err.Error(),
 						"nodeID": offer.Node.Id,
 					}))
+				tracing.End(span, err)
 				continue
 			}
 
@@ -132,6 +139,7 @@ func (s *Scheduler) Schedule(ctx context.Context) error {
 					"nodeID", offer.Node.Id,
 				)
 			}
+			tracing.End(span, err)
 		} else {
 			s.Log.Debug("Scheduling failed for task", "taskID", task.Id)
 		}
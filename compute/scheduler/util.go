@@ -3,6 +3,9 @@ package scheduler
 import (
 	"fmt"
 	"math"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/ohsu-comp-bio/funnel/config"
@@ -17,9 +20,31 @@ func GenNodeID() string {
 	return u.String()
 }
 
+// LoadNodeID returns the ID of the node saved in its work directory, or
+// saves a new ID there. A restarted node keeps its ID, and so the tasks
+// assigned to it, which resume from their checkpoints instead of failing
+// when the node is considered gone.
+func LoadNodeID(workDir string) (string, error) {
+	path := filepath.Join(workDir, "funnel-node-id")
+	if b, err := os.ReadFile(path); err == nil {
+		if id := strings.TrimSpace(string(b)); id != "" {
+			return id, nil
+		}
+	}
+	id := GenNodeID()
+	if err := os.MkdirAll(workDir, 0775); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(id+"\n"), 0644); err != nil {
+		return "", fmt.Errorf("saving node ID: %v", err)
+	}
+	return id, nil
+}
+
 // detectResources helps determine the amount of resources to report.
 // Resources are determined by inspecting the host, but they
-// can be overridden by config.
+// can be overridden by config. Host reservations and oversubscription
+// ratios from config are applied to the result.
 //
 // Upon error, detectResources will return the resources given by the config
 // with the error.
@@ -32,15 +57,15 @@ func detectResources(conf *config.Node, workdir string) (*Resources, error) {
 
 	cpuinfo, err := pscpu.Info()
 	if err != nil {
-		return res, fmt.Errorf("Error detecting cpu cores: %s", err)
+		return schedulableResources(res, conf), fmt.Errorf("Error detecting cpu cores: %s", err)
 	}
 	vmeminfo, err := psmem.VirtualMemory()
 	if err != nil {
-		return res, fmt.Errorf("Error detecting memory: %s", err)
+		return schedulableResources(res, conf), fmt.Errorf("Error detecting memory: %s", err)
 	}
 	diskinfo, err := psdisk.Usage(workdir)
 	if err != nil {
-		return res, fmt.Errorf("Error detecting available disk: %s", err)
+		return schedulableResources(res, conf), fmt.Errorf("Error detecting available disk: %s", err)
 	}
 
 	if conf.Resources.Cpus == 0 {
@@ -61,5 +86,33 @@ func detectResources(conf *config.Node, workdir string) (*Resources, error) {
 		res.DiskGb = float64(diskinfo.Free) / float64(gb)
 	}
 
-	return res, nil
+	return schedulableResources(res, conf), nil
+}
+
+// schedulableResources subtracts the host reservations from the total
+// resources and applies the oversubscription ratios to what remains.
+// A ratio of zero is treated as 1.0, i.e. no oversubscription.
+func schedulableResources(total *Resources, conf *config.Node) *Resources {
+	reserved := conf.GetReserved()
+	ratios := conf.GetOversubscription()
+
+	cpus := total.GetCpus()
+	if reserved.GetCpus() >= cpus {
+		cpus = 0
+	} else {
+		cpus -= reserved.GetCpus()
+	}
+
+	return &Resources{
+		Cpus:   uint32(math.Floor(float64(cpus) * ratio(ratios.GetCpus()))),
+		RamGb:  math.Max(total.GetRamGb()-reserved.GetRamGb(), 0) * ratio(ratios.GetRamGb()),
+		DiskGb: math.Max(total.GetDiskGb()-reserved.GetDiskGb(), 0) * ratio(ratios.GetDiskGb()),
+	}
+}
+
+func ratio(r float64) float64 {
+	if r <= 0 {
+		return 1.0
+	}
+	return r
 }
@@ -0,0 +1,54 @@
+package scheduler
+
+import (
+	"testing"
+
+	"github.com/ohsu-comp-bio/funnel/config"
+)
+
+func TestSchedulableResources(t *testing.T) {
+	total := &Resources{Cpus: 8, RamGb: 16.0, DiskGb: 100.0}
+
+	res := schedulableResources(total, &config.Node{})
+	if res.Cpus != 8 || res.RamGb != 16.0 || res.DiskGb != 100.0 {
+		t.Error("expected resources to be unchanged without reservations", res)
+	}
+
+	res = schedulableResources(total, &config.Node{
+		Reserved: &config.Resources{Cpus: 1, RamGb: 2.0},
+	})
+	if res.Cpus != 7 || res.RamGb != 14.0 || res.DiskGb != 100.0 {
+		t.Error("unexpected resources after reservations", res)
+	}
+
+	res = schedulableResources(total, &config.Node{
+		Reserved:         &config.Resources{Cpus: 2, RamGb: 4.0},
+		Oversubscription: &config.ResourceRatios{Cpus: 1.5, RamGb: 1.0},
+	})
+	if res.Cpus != 9 || res.RamGb != 12.0 || res.DiskGb != 100.0 {
+		t.Error("unexpected resources after oversubscription", res)
+	}
+
+	res = schedulableResources(total, &config.Node{
+		Reserved: &config.Resources{Cpus: 10, RamGb: 20.0, DiskGb: 200.0},
+	})
+	if res.Cpus != 0 || res.RamGb != 0 || res.DiskGb != 0 {
+		t.Error("expected reservations larger than the node to clamp to zero", res)
+	}
+}
+
+func TestLoadNodeID(t *testing.T) {
+	dir := t.TempDir()
+	id, err := LoadNodeID(dir)
+	if err != nil || id == "" {
+		t.Fatalf("unexpected node ID %q: %v", id, err)
+	}
+	// A restarted node keeps its ID.
+	again, err := LoadNodeID(dir)
+	if err != nil || again != id {
+		t.Errorf("expected node ID %q, got %q %v", id, again, err)
+	}
+	if other, _ := LoadNodeID(t.TempDir()); other == id {
+		t.Error("expected another node ID in another work directory")
+	}
+}
@@ -10,15 +10,23 @@ func EmptyConfig() *Config {
 		RPCClient:     &RPCClient{Credential: &BasicCredential{}, Timeout: &TimeoutConfig{}},
 		Scheduler:     &Scheduler{ScheduleRate: &durationpb.Duration{}, NodePingTimeout: &TimeoutConfig{}, NodeInitTimeout: &TimeoutConfig{}, NodeDeadTimeout: &TimeoutConfig{}},
 		Node:          &Node{Resources: &Resources{}, Timeout: &TimeoutConfig{}, Metadata: map[string]string{}},
-		Worker:        &Worker{Container: &ContainerConfig{}, PollingRate: &durationpb.Duration{}, LogUpdateRate: &durationpb.Duration{}},
+		Worker:        &Worker{Container: &ContainerConfig{}, Apptainer: &Apptainer{StopTimeout: &durationpb.Duration{}}, Process: &Process{StopTimeout: &durationpb.Duration{}}, PollingRate: &durationpb.Duration{}, LogUpdateRate: &durationpb.Duration{}},
 		Logger:        &logger.LoggerConfig{JsonFormat: &logger.JSONFormatConfig{}, TextFormat: &logger.TextFormatConfig{}},
 		BoltDB:        &BoltDB{},
 		Badger:        &Badger{},
+		SQLite:        &SQLite{},
 		DynamoDB:      &DynamoDB{AWSConfig: &AWSConfig{}},
 		Elastic:       &Elastic{},
 		MongoDB:       &MongoDB{Timeout: &TimeoutConfig{}},
 		Postgres:      &Postgres{Timeout: &TimeoutConfig{}},
 		Kafka:         &Kafka{},
+		NATS:          &NATS{},
+		Redis:         &Redis{},
+		AMQP:          &AMQP{},
+		Webhook:       &Webhook{Timeout: &durationpb.Duration{}},
+		CloudEvents:   &CloudEvents{Timeout: &durationpb.Duration{}},
+		Tracing:       &Tracing{},
+		LogArchive:    &LogArchive{FlushInterval: &durationpb.Duration{}},
 		LocalStorage:  &LocalStorage{},
 		HTTPStorage:   &HTTPStorage{Timeout: &TimeoutConfig{}},
 		FTPStorage:    &FTPStorage{Timeout: &TimeoutConfig{}},
@@ -36,6 +44,7 @@ func EmptyConfig() *Config {
 		Datastore:     &Datastore{},
 		GenericS3:     []*GenericS3Storage{},
 		Server:        &Server{BasicAuth: []*BasicCredential{}, OidcAuth: &OidcAuth{}},
+		Secrets:       &Secrets{Vault: &Vault{}},
 		EventWriters:  []string{},
 	}
 }
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/ohsu-comp-bio/funnel/tes"
+	"google.golang.org/protobuf/proto"
+)
+
+// Task tags which tighten the network policy of a task.
+const (
+	// NetworkPolicyTag sets the network policy of the executors of a task:
+	// "none", "allowlist", "bridge" or "host". It may only be stricter than
+	// Worker.Network.Policy.
+	NetworkPolicyTag = "_NETWORK_POLICY"
+	// NetworkAllowedHostsTag lists the hosts reachable by the executors of
+	// a task with the "allowlist" policy, e.g. "10.0.0.0/8,pypi.org". They
+	// must be allowed by Worker.Network.AllowedHosts, if it's set.
+	NetworkAllowedHostsTag = "_NETWORK_ALLOWED_HOSTS"
+)
+
+// Network policies, from the strictest.
+const (
+	NetworkNone      = "none"
+	NetworkAllowlist = "allowlist"
+	NetworkBridge    = "bridge"
+	NetworkHost      = "host"
+)
+
+var networkPolicies = []string{NetworkNone, NetworkAllowlist, NetworkBridge, NetworkHost}
+
+// ParseNetworkPolicy returns the canonical name of a network policy. The
+// empty policy is "bridge".
+func ParseNetworkPolicy(s string) (string, error) {
+	if s == "" {
+		return NetworkBridge, nil
+	}
+	for _, p := range networkPolicies {
+		if strings.EqualFold(s, p) {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("unknown network policy %q. Expected 'none', 'allowlist', 'bridge' or 'host'", s)
+}
+
+func networkLevel(policy string) int {
+	for i, p := range networkPolicies {
+		if p == policy {
+			return i
+		}
+	}
+	return len(networkPolicies)
+}
+
+// TaskNetwork returns the network policy of the executors of a task, from
+// the worker config and the task tags. Tasks may only restrict the network
+// access given by the config.
+func TaskNetwork(conf *Worker, task *tes.Task) (*Network, error) {
+	global := conf.GetNetwork()
+	policy, err := ParseNetworkPolicy(global.GetPolicy())
+	if err != nil {
+		return nil, err
+	}
+	network := &Network{Policy: policy}
+	if global != nil {
+		network = proto.Clone(global).(*Network)
+		network.Policy = policy
+	}
+
+	if v, ok := task.GetTags()[NetworkPolicyTag]; ok {
+		p, err := ParseNetworkPolicy(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s tag: %v", NetworkPolicyTag, err)
+		}
+		if networkLevel(p) > networkLevel(policy) {
+			return nil, fmt.Errorf("invalid %s tag %q: the network policy of the server is %q", NetworkPolicyTag, v, policy)
+		}
+		network.Policy = p
+	}
+
+	if v := strings.TrimSpace(task.GetTags()[NetworkAllowedHostsTag]); v != "" {
+		var hosts []string
+		for _, h := range strings.Split(v, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				hosts = append(hosts, h)
+			}
+		}
+		// The hosts allowed by the config only bound the hosts of the task
+		// if the config restricts the network.
+		if policy == NetworkAllowlist {
+			for _, h := range hosts {
+				if !hostAllowed(h, global.GetAllowedHosts()) {
+					return nil, fmt.Errorf("invalid %s tag: host %q isn't allowed by the server", NetworkAllowedHostsTag, h)
+				}
+			}
+		}
+		network.AllowedHosts = hosts
+	}
+
+	if network.Policy != NetworkAllowlist {
+		network.AllowedHosts = nil
+	} else if len(network.AllowedHosts) == 0 {
+		return nil, fmt.Errorf("the %q network policy requires allowed hosts", NetworkAllowlist)
+	}
+	return network, nil
+}
+
+// hostAllowed returns whether a host, or all the addresses of a CIDR block,
+// are in the allowed hosts.
+func hostAllowed(host string, allowed []string) bool {
+	for _, a := range allowed {
+		if strings.EqualFold(host, a) {
+			return true
+		}
+		_, block, err := net.ParseCIDR(a)
+		if err != nil {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil && block.Contains(ip) {
+			return true
+		}
+		if _, sub, err := net.ParseCIDR(host); err == nil {
+			ones, _ := sub.Mask.Size()
+			blockOnes, _ := block.Mask.Size()
+			if block.Contains(sub.IP) && ones >= blockOnes {
+				return true
+			}
+		}
+	}
+	return false
+}
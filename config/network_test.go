@@ -0,0 +1,63 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/ohsu-comp-bio/funnel/tes"
+)
+
+func TestTaskNetwork(t *testing.T) {
+	conf := &Worker{}
+	task := &tes.Task{}
+	network, err := TaskNetwork(conf, task)
+	if err != nil || network.Policy != NetworkBridge {
+		t.Errorf("expected the bridge policy by default, got %v %v", network, err)
+	}
+
+	// Tasks may restrict the network.
+	task.Tags = map[string]string{NetworkPolicyTag: "None"}
+	network, err = TaskNetwork(conf, task)
+	if err != nil || network.Policy != NetworkNone {
+		t.Errorf("expected the none policy, got %v %v", network, err)
+	}
+	task.Tags = map[string]string{NetworkPolicyTag: "allowlist", NetworkAllowedHostsTag: "pypi.org, 10.0.0.0/8"}
+	network, err = TaskNetwork(conf, task)
+	if err != nil || network.Policy != NetworkAllowlist || len(network.AllowedHosts) != 2 {
+		t.Errorf("expected the task allowlist, got %v %v", network, err)
+	}
+	task.Tags = map[string]string{NetworkPolicyTag: "allowlist"}
+	if _, err := TaskNetwork(conf, task); err == nil {
+		t.Error("expected an error for an allowlist without hosts")
+	}
+	task.Tags = map[string]string{NetworkPolicyTag: "vpn"}
+	if _, err := TaskNetwork(conf, task); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+
+	// But not extend it.
+	conf.Network = &Network{Policy: "allowlist", AllowedHosts: []string{"10.0.0.0/8", "pypi.org"}, AllowlistNetwork: "egress"}
+	for _, tags := range []map[string]string{
+		{NetworkPolicyTag: "bridge"},
+		{NetworkPolicyTag: "host"},
+		{NetworkAllowedHostsTag: "example.org"},
+		{NetworkAllowedHostsTag: "10.0.0.0/7"},
+	} {
+		task.Tags = tags
+		if _, err := TaskNetwork(conf, task); err == nil {
+			t.Errorf("expected an error for %v", tags)
+		}
+	}
+	task.Tags = map[string]string{NetworkAllowedHostsTag: "10.1.2.3,10.2.0.0/16,PyPI.org"}
+	network, err = TaskNetwork(conf, task)
+	if err != nil || network.Policy != NetworkAllowlist || len(network.AllowedHosts) != 3 || network.AllowlistNetwork != "egress" {
+		t.Errorf("expected the task allowlist, got %v %v", network, err)
+	}
+	task.Tags = nil
+	network, err = TaskNetwork(conf, task)
+	if err != nil || len(network.AllowedHosts) != 2 {
+		t.Errorf("expected the allowlist of the config, got %v %v", network, err)
+	}
+	if conf.Network.Policy != "allowlist" {
+		t.Error("expected the config to be unchanged")
+	}
+}
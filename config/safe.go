@@ -1,6 +1,10 @@
 package config
 
-import "google.golang.org/protobuf/proto"
+import (
+	"net/url"
+
+	"google.golang.org/protobuf/proto"
+)
 
 // Safe returns a copy of the config with sensitive fields redacted
 func (c *Config) Safe() *Config {
@@ -28,6 +32,32 @@ func (c *Config) Safe() *Config {
 		safe.Elastic.ServiceToken = redact(safe.Elastic.ServiceToken)
 	}
 
+	// Event transport credentials
+	if safe.Redis != nil {
+		safe.Redis.Password = redact(safe.Redis.Password)
+	}
+
+	if safe.NATS != nil {
+		safe.NATS.URL = redactURL(safe.NATS.URL)
+	}
+
+	if safe.AMQP != nil {
+		safe.AMQP.URL = redactURL(safe.AMQP.URL)
+	}
+
+	if safe.Webhook != nil {
+		safe.Webhook.CallbackSecret = redact(safe.Webhook.CallbackSecret)
+		for _, e := range safe.Webhook.Endpoints {
+			e.Secret = redact(e.Secret)
+		}
+	}
+
+	if safe.Tracing != nil {
+		for k, v := range safe.Tracing.Headers {
+			safe.Tracing.Headers[k] = redact(v)
+		}
+	}
+
 	// Cloud provider credentials
 	if safe.AWSBatch != nil && safe.AWSBatch.AWSConfig != nil {
 		safe.AWSBatch.AWSConfig.Key = redact(safe.AWSBatch.AWSConfig.Key)
@@ -82,6 +112,19 @@ func (c *Config) Safe() *Config {
 		safe.RPCClient.Credential.Password = redact(safe.RPCClient.Credential.Password)
 	}
 
+	if safe.Worker != nil {
+		for _, cred := range safe.Worker.RegistryCredentials {
+			if cred == nil {
+				continue
+			}
+			cred.Password = redact(cred.Password)
+		}
+	}
+
+	if safe.Secrets != nil && safe.Secrets.Vault != nil {
+		safe.Secrets.Vault.Token = redact(safe.Secrets.Vault.Token)
+	}
+
 	// Storage credentials
 	if safe.Swift != nil {
 		safe.Swift.Password = redact(safe.Swift.Password)
@@ -106,3 +149,12 @@ func redact(str string) string {
 	}
 	return "***"
 }
+
+// redactURL redacts the password of a URL.
+func redactURL(str string) string {
+	u, err := url.Parse(str)
+	if err != nil {
+		return redact(str)
+	}
+	return u.Redacted()
+}
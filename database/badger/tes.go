@@ -6,6 +6,7 @@ import (
 	"fmt"
 
 	badger "github.com/dgraph-io/badger/v2"
+	"github.com/ohsu-comp-bio/funnel/query"
 	"github.com/ohsu-comp-bio/funnel/server"
 	"github.com/ohsu-comp-bio/funnel/tes"
 	"google.golang.org/protobuf/proto"
@@ -33,17 +34,33 @@ func (db *Badger) GetTask(ctx context.Context, req *tes.GetTaskRequest) (*tes.Ta
 	return task, nil
 }
 
+// TaskOwner returns the owner of a task.
+func (db *Badger) TaskOwner(ctx context.Context, id string) (string, error) {
+	var owner string
+	err := db.db.View(func(txn *badger.Txn) error {
+		if _, err := txn.Get(taskKey(id)); err == badger.ErrKeyNotFound {
+			return tes.ErrNotFound
+		} else if err != nil {
+			return err
+		}
+		owner = getTaskOwner(txn, ownerKey(id))
+		return nil
+	})
+	return owner, err
+}
+
 // ListTasks returns a list of tasks.
 func (db *Badger) ListTasks(ctx context.Context, req *tes.ListTasksRequest) (*tes.ListTasksResponse, error) {
 	var tasks []*tes.Task
 	pageSize := tes.GetPageSize(req.GetPageSize())
+	q := query.FromContext(ctx)
 
 	err := db.db.View(func(txn *badger.Txn) error {
 
 		it := txn.NewIterator(badger.IteratorOptions{
 			// Keys (task IDs) are in ascending order, and we want the first page
 			// to be the most recent task, so that's at the end of the list.
-			Reverse:        true,
+			Reverse:        !q.Ascending(),
 			PrefetchValues: true,
 			PrefetchSize:   pageSize,
 		})
@@ -55,7 +72,11 @@ func (db *Badger) ListTasks(ctx context.Context, req *tes.ListTasksRequest) (*te
 		if req.PageToken != "" {
 			it.Seek(taskKey(req.PageToken))
 			// Seek moves to the key, but the start of the page is the next key.
-			it.Next()
+			if it.Valid() && bytes.Equal(it.Item().Key(), taskKey(req.PageToken)) {
+				it.Next()
+			}
+		} else if q.Ascending() {
+			it.Seek(taskKeyPrefix)
 		} else {
 			it.Rewind()
 		}
@@ -103,6 +124,10 @@ func (db *Badger) ListTasks(ctx context.Context, req *tes.ListTasksRequest) (*te
 				continue taskLoop
 			}
 
+			if !q.Match(task, taskOwner) {
+				continue taskLoop
+			}
+
 			switch req.View {
 			case tes.View_MINIMAL.String():
 				task = task.GetMinimalView()
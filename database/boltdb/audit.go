@@ -0,0 +1,50 @@
+package boltdb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/boltdb/bolt"
+	"github.com/ohsu-comp-bio/funnel/audit"
+	"golang.org/x/net/context"
+)
+
+// WriteAudit appends a record to the audit log.
+func (taskBolt *BoltDB) WriteAudit(ctx context.Context, r *audit.Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return taskBolt.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(AuditLog)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		return bucket.Put(key, b)
+	})
+}
+
+// ListAudit returns audit records matching the filter, most recent first.
+func (taskBolt *BoltDB) ListAudit(ctx context.Context, f *audit.Filter) ([]*audit.Record, error) {
+	var out []*audit.Record
+	err := taskBolt.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(AuditLog).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			if f.Limit > 0 && len(out) >= f.Limit {
+				break
+			}
+			r := &audit.Record{}
+			if err := json.Unmarshal(v, r); err != nil {
+				return err
+			}
+			if f.Match(r) {
+				out = append(out, r)
+			}
+		}
+		return nil
+	})
+	return out, err
+}
@@ -25,8 +25,46 @@ const (
 	Canceled      = tes.State_CANCELED
 )
 
-// WriteEvent creates an event for the server to handle.
+// WriteEvent creates an event for the server to handle. The events written
+// again, e.g. retried by a worker, are dropped: the IDs of the events written
+// are recorded.
 func (taskBolt *BoltDB) WriteEvent(ctx context.Context, req *events.Event) error {
+	if req.EventId == "" {
+		return taskBolt.writeEvent(ctx, req)
+	}
+	added, err := taskBolt.addEventID(req)
+	if err != nil || !added {
+		return err
+	}
+	if err := taskBolt.writeEvent(ctx, req); err != nil {
+		taskBolt.removeEventID(req)
+		return err
+	}
+	return nil
+}
+
+// addEventID records the ID of the event, and returns false if it was
+// already recorded.
+func (taskBolt *BoltDB) addEventID(req *events.Event) (bool, error) {
+	added := false
+	err := taskBolt.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(EventIDs)
+		if b.Get([]byte(req.EventId)) != nil {
+			return nil
+		}
+		added = true
+		return b.Put([]byte(req.EventId), []byte(req.Id))
+	})
+	return added, err
+}
+
+func (taskBolt *BoltDB) removeEventID(req *events.Event) {
+	taskBolt.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(EventIDs).Delete([]byte(req.EventId))
+	})
+}
+
+func (taskBolt *BoltDB) writeEvent(ctx context.Context, req *events.Event) error {
 	var err error
 
 	if req.Type == events.Type_TASK_CREATED {
@@ -0,0 +1,109 @@
+package boltdb
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/boltdb/bolt"
+	"github.com/ohsu-comp-bio/funnel/logs"
+	"golang.org/x/net/context"
+)
+
+// The chunks of a log are keyed by the path of the log and their offset,
+// padded so that the keys sort by offset.
+func logPrefix(key logs.Key) []byte {
+	return []byte(key.Path() + "/")
+}
+
+func logChunkKey(key logs.Key, offset int64) []byte {
+	return []byte(fmt.Sprintf("%s/%020d", key.Path(), offset))
+}
+
+func logChunkOffset(prefix, k []byte) (int64, bool) {
+	if !bytes.HasPrefix(k, prefix) {
+		return 0, false
+	}
+	offset, err := strconv.ParseInt(string(k[len(prefix):]), 10, 64)
+	return offset, err == nil
+}
+
+// WriteChunk stores a chunk of an executor log.
+func (taskBolt *BoltDB) WriteChunk(ctx context.Context, c *logs.Chunk) error {
+	return taskBolt.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(LogChunks).Put(logChunkKey(c.Key(), c.Offset), c.Data)
+	})
+}
+
+// ReadChunks returns the chunks of a log which overlap the range of size
+// bytes at offset.
+func (taskBolt *BoltDB) ReadChunks(ctx context.Context, key logs.Key, offset, size int64) ([]*logs.Chunk, error) {
+	prefix := logPrefix(key)
+	var chunks []*logs.Chunk
+	err := taskBolt.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(LogChunks).Cursor()
+
+		// Start at the chunk containing the offset, which may begin before it.
+		k, v := c.Seek(logChunkKey(key, offset))
+		if start, ok := logChunkOffset(prefix, k); !ok || start > offset {
+			if k == nil {
+				k, v = c.Last()
+			} else if pk, pv := c.Prev(); pk != nil {
+				k, v = pk, pv
+			} else {
+				k, v = c.First()
+			}
+		}
+
+		for ; k != nil; k, v = c.Next() {
+			start, ok := logChunkOffset(prefix, k)
+			if !ok {
+				if bytes.Compare(k, prefix) > 0 {
+					break
+				}
+				continue
+			}
+			if start >= offset+size {
+				break
+			}
+			if start+int64(len(v)) <= offset {
+				continue
+			}
+			chunks = append(chunks, &logs.Chunk{
+				Id:       key.ID,
+				Attempt:  key.Attempt,
+				Executor: key.Executor,
+				Stream:   key.Stream,
+				Offset:   start,
+				// The values are only valid during the transaction.
+				Data: append([]byte(nil), v...),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return chunks, nil
+}
+
+// Size returns the end of the last chunk of a log.
+func (taskBolt *BoltDB) Size(ctx context.Context, key logs.Key) (int64, error) {
+	prefix := logPrefix(key)
+	var size int64
+	err := taskBolt.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(LogChunks).Cursor()
+		// The offsets are digits, which sort before ":".
+		k, v := c.Seek(append(append([]byte(nil), prefix...), ':'))
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		if start, ok := logChunkOffset(prefix, k); ok {
+			size = start + int64(len(v))
+		}
+		return nil
+	})
+	return size, err
+}
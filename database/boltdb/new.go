@@ -46,6 +46,18 @@ var Nodes = []byte("nodes")
 // task ID -> tes.TaskLog.SystemLogs
 var SysLogs = []byte("system-logs")
 
+// AuditLog maps sequence number -> audit.Record JSON
+var AuditLog = []byte("audit-log")
+
+// APITokens maps token ID -> auth.Token JSON
+var APITokens = []byte("api-tokens")
+
+// LogChunks maps (log path + offset) -> chunk of an archived executor log
+var LogChunks = []byte("log-chunks")
+
+// EventIDs maps event ID -> task ID, of the events written
+var EventIDs = []byte("event-ids")
+
 // BoltDB provides handlers for gRPC endpoints.
 // Data is stored/retrieved from the BoltDB key-value database.
 type BoltDB struct {
@@ -100,6 +112,18 @@ func (taskBolt *BoltDB) Init() error {
 		if tx.Bucket(SysLogs) == nil {
 			tx.CreateBucket(SysLogs)
 		}
+		if tx.Bucket(AuditLog) == nil {
+			tx.CreateBucket(AuditLog)
+		}
+		if tx.Bucket(APITokens) == nil {
+			tx.CreateBucket(APITokens)
+		}
+		if tx.Bucket(LogChunks) == nil {
+			tx.CreateBucket(LogChunks)
+		}
+		if tx.Bucket(EventIDs) == nil {
+			tx.CreateBucket(EventIDs)
+		}
 		return nil
 	})
 }
@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/boltdb/bolt"
+	"github.com/ohsu-comp-bio/funnel/query"
 	"github.com/ohsu-comp-bio/funnel/server"
 	"github.com/ohsu-comp-bio/funnel/tes"
 	"golang.org/x/net/context"
@@ -158,6 +159,19 @@ func getTaskView(tx *bolt.Tx, id string, view tes.View, ctx context.Context) (*t
 	return task, err
 }
 
+// TaskOwner returns the owner of a task.
+func (taskBolt *BoltDB) TaskOwner(ctx context.Context, id string) (string, error) {
+	var owner string
+	err := taskBolt.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(TaskBucket).Get([]byte(id)) == nil {
+			return tes.ErrNotFound
+		}
+		owner = string(tx.Bucket(TaskOwner).Get([]byte(id)))
+		return nil
+	})
+	return owner, err
+}
+
 // ListTasks returns a list of taskIDs
 func (taskBolt *BoltDB) ListTasks(ctx context.Context, req *tes.ListTasksRequest) (*tes.ListTasksResponse, error) {
 	var tasks []*tes.Task
@@ -169,6 +183,8 @@ func (taskBolt *BoltDB) ListTasks(ctx context.Context, req *tes.ListTasksRequest
 	viewMode := tes.View(tes.View_value[view])
 	pageSize := tes.GetPageSize(req.GetPageSize())
 
+	q := query.FromContext(ctx)
+
 	taskBolt.db.View(func(tx *bolt.Tx) error {
 		c := tx.Bucket(TaskBucket).Cursor()
 
@@ -176,7 +192,18 @@ func (taskBolt *BoltDB) ListTasks(ctx context.Context, req *tes.ListTasksRequest
 
 		// For pagination, figure out the starting key.
 		var k []byte
-		if req.PageToken != "" {
+		next := c.Prev
+		if q.Ascending() {
+			next = c.Next
+			if req.PageToken != "" {
+				k, _ = c.Seek([]byte(req.PageToken))
+				if string(k) == req.PageToken {
+					k, _ = c.Next()
+				}
+			} else {
+				k, _ = c.First()
+			}
+		} else if req.PageToken != "" {
 			// Seek moves to the key, but the start of the page is the next key.
 			c.Seek([]byte(req.PageToken))
 			k, _ = c.Prev()
@@ -188,7 +215,7 @@ func (taskBolt *BoltDB) ListTasks(ctx context.Context, req *tes.ListTasksRequest
 		}
 
 	taskLoop:
-		for ; k != nil && i < pageSize; k, _ = c.Prev() {
+		for ; k != nil && i < pageSize; k, _ = next() {
 			taskId := string(k)
 
 			task, err := getTaskView(tx, taskId, tes.View_BASIC, ctx)
@@ -211,6 +238,10 @@ func (taskBolt *BoltDB) ListTasks(ctx context.Context, req *tes.ListTasksRequest
 				}
 			}
 
+			if !q.Match(task, string(tx.Bucket(TaskOwner).Get(k))) {
+				continue taskLoop
+			}
+
 			if viewMode != tes.View_BASIC {
 				task, _ = getTaskView(tx, taskId, viewMode, ctx)
 			}
@@ -0,0 +1,52 @@
+package boltdb
+
+import (
+	"encoding/json"
+
+	"github.com/boltdb/bolt"
+	"github.com/ohsu-comp-bio/funnel/auth"
+	"golang.org/x/net/context"
+)
+
+// PutToken creates or updates an API token.
+func (taskBolt *BoltDB) PutToken(ctx context.Context, t *auth.Token) error {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return taskBolt.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(APITokens).Put([]byte(t.ID), b)
+	})
+}
+
+// GetToken returns the API token with the given ID.
+func (taskBolt *BoltDB) GetToken(ctx context.Context, id string) (*auth.Token, error) {
+	t := &auth.Token{}
+	err := taskBolt.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(APITokens).Get([]byte(id))
+		if b == nil {
+			return auth.ErrTokenNotFound
+		}
+		return json.Unmarshal(b, t)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ListTokens returns all API tokens.
+func (taskBolt *BoltDB) ListTokens(ctx context.Context) ([]*auth.Token, error) {
+	var out []*auth.Token
+	err := taskBolt.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(APITokens).ForEach(func(k, v []byte) error {
+			t := &auth.Token{}
+			if err := json.Unmarshal(v, t); err != nil {
+				return err
+			}
+			out = append(out, t)
+			return nil
+		})
+	})
+	return out, err
+}
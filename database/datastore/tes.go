@@ -2,6 +2,7 @@ package datastore
 
 import (
 	"cloud.google.com/go/datastore"
+	"github.com/ohsu-comp-bio/funnel/query"
 	"github.com/ohsu-comp-bio/funnel/server"
 	"github.com/ohsu-comp-bio/funnel/tes"
 	"golang.org/x/net/context"
@@ -70,8 +71,24 @@ func (d *Datastore) getFullView(ctx context.Context, keys []*datastore.Key, task
 	return nil
 }
 
+// TaskOwner returns the owner of a task.
+func (d *Datastore) TaskOwner(ctx context.Context, id string) (string, error) {
+	entity := &task{}
+	err := d.client.Get(ctx, taskKey(id), entity)
+	if err == datastore.ErrNoSuchEntity {
+		return "", tes.ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return entity.Owner, nil
+}
+
 // ListTasks implements the TES ListTasks interface.
 func (d *Datastore) ListTasks(ctx context.Context, req *tes.ListTasksRequest) (*tes.ListTasksResponse, error) {
+	if query.FromContext(ctx) != nil {
+		return nil, query.ErrUnsupported
+	}
 
 	page := req.PageToken
 	size := tes.GetPageSize(req.GetPageSize())
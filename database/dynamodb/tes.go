@@ -7,6 +7,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/ohsu-comp-bio/funnel/query"
 	"github.com/ohsu-comp-bio/funnel/server"
 	"github.com/ohsu-comp-bio/funnel/tes"
 	"golang.org/x/net/context"
@@ -44,8 +45,27 @@ func (db *DynamoDB) GetTask(ctx context.Context, req *tes.GetTaskRequest) (*tes.
 	return task, nil
 }
 
+// TaskOwner returns the owner of a task.
+func (db *DynamoDB) TaskOwner(ctx context.Context, id string) (string, error) {
+	response, err := db.getMinimalView(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if response.Item == nil {
+		return "", tes.ErrNotFound
+	}
+	owner := ""
+	if attrValue, ok := response.Item["owner"]; ok {
+		owner = aws.StringValue(attrValue.S)
+	}
+	return owner, nil
+}
+
 // ListTasks returns a list of taskIDs
 func (db *DynamoDB) ListTasks(ctx context.Context, req *tes.ListTasksRequest) (*tes.ListTasksResponse, error) {
+	if query.FromContext(ctx) != nil {
+		return nil, query.ErrUnsupported
+	}
 
 	filters := []expression.ConditionBuilder{}
 
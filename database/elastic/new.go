@@ -73,9 +73,13 @@ func (es *Elastic) Init() error {
 		"state":  types.KeywordProperty{},
 		"owner":  types.KeywordProperty{},
 		"inputs": types.NestedProperty{},
+		// The times are compared by the task filters.
+		"creation_time": types.DateProperty{},
 		"logs": types.NestedProperty{
 			Properties: map[string]types.Property{
-				"logs": types.NestedProperty{},
+				"logs":       types.NestedProperty{},
+				"start_time": types.DateProperty{},
+				"end_time":   types.DateProperty{},
 			},
 		},
 	}
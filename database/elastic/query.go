@@ -0,0 +1,124 @@
+package elastic
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/ohsu-comp-bio/funnel/query"
+)
+
+// queryFilters translates the task filter to filters of the tasks index.
+func queryFilters(q *query.Query) []types.Query {
+	if q == nil {
+		return nil
+	}
+	var filters []types.Query
+
+	if len(q.States) > 0 {
+		var states []types.FieldValue
+		for _, s := range q.States {
+			states = append(states, s.String())
+		}
+		filters = append(filters, types.Query{
+			Terms: &types.TermsQuery{TermsQuery: map[string]types.TermsQueryField{"state": states}},
+		})
+	}
+
+	if len(q.Owners) > 0 {
+		var owners []types.FieldValue
+		for _, o := range q.Owners {
+			owners = append(owners, o)
+		}
+		filters = append(filters, types.Query{
+			Terms: &types.TermsQuery{TermsQuery: map[string]types.TermsQueryField{"owner": owners}},
+		})
+	}
+
+	if !q.Created.Empty() {
+		filters = append(filters, rangeQuery("creation_time", q.Created))
+	}
+
+	// The logs are nested: a single attempt must match the range.
+	for _, log := range []struct {
+		field string
+		r     query.Range
+	}{{"logs.start_time", q.Started}, {"logs.end_time", q.Ended}} {
+		if log.r.Empty() {
+			continue
+		}
+		filters = append(filters, types.Query{
+			Nested: &types.NestedQuery{Path: "logs", Query: rangeQuery(log.field, log.r)},
+		})
+	}
+
+	for _, m := range q.Tags {
+		filters = append(filters, matchQuery(fmt.Sprintf("tags.%s.keyword", m.Key), m))
+	}
+
+	for _, m := range q.Images {
+		filters = append(filters, matchQuery("executors.image.keyword", m))
+	}
+
+	if q.Scope != nil {
+		scope := []types.Query{{
+			Term: map[string]types.TermQuery{"owner": {Value: q.Scope.Owner}},
+		}}
+		for _, m := range q.Scope.Tags {
+			scope = append(scope, matchQuery(fmt.Sprintf("tags.%s.keyword", m.Key), m))
+		}
+		filters = append(filters, types.Query{
+			Bool: &types.BoolQuery{Should: scope, MinimumShouldMatch: 1},
+		})
+	}
+
+	return filters
+}
+
+func rangeQuery(field string, r query.Range) types.Query {
+	rq := types.DateRangeQuery{}
+	if r.Min != nil {
+		t := r.Min.Time.Format(time.RFC3339Nano)
+		if r.Min.Inclusive {
+			rq.Gte = &t
+		} else {
+			rq.Gt = &t
+		}
+	}
+	if r.Max != nil {
+		t := r.Max.Time.Format(time.RFC3339Nano)
+		if r.Max.Inclusive {
+			rq.Lte = &t
+		} else {
+			rq.Lt = &t
+		}
+	}
+	return types.Query{Range: map[string]types.RangeQuery{field: rq}}
+}
+
+func matchQuery(field string, m *query.Match) types.Query {
+	switch m.Op {
+	case query.Prefix:
+		return types.Query{Prefix: map[string]types.PrefixQuery{field: {Value: m.Value}}}
+	case query.Regex:
+		return types.Query{Regexp: map[string]types.RegexpQuery{field: {Value: luceneRegexp(m.Value)}}}
+	}
+	return types.Query{Term: map[string]types.TermQuery{field: {Value: m.Value}}}
+}
+
+// luceneRegexp converts a regular expression matching anywhere in the value
+// to the Lucene syntax, which matches the whole value, without anchors.
+func luceneRegexp(re string) string {
+	if strings.HasPrefix(re, "^") {
+		re = re[1:]
+	} else {
+		re = ".*" + re
+	}
+	if strings.HasSuffix(re, "$") && !strings.HasSuffix(re, `\$`) {
+		re = re[:len(re)-1]
+	} else {
+		re += ".*"
+	}
+	return re
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
 	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/sortorder"
+	"github.com/ohsu-comp-bio/funnel/query"
 	"github.com/ohsu-comp-bio/funnel/server"
 	"github.com/ohsu-comp-bio/funnel/tes"
 	"golang.org/x/net/context"
@@ -69,6 +70,22 @@ func (es *Elastic) GetTask(ctx context.Context, req *tes.GetTaskRequest) (*tes.T
 	return task, err
 }
 
+// TaskOwner returns the owner of a task.
+func (es *Elastic) TaskOwner(ctx context.Context, id string) (string, error) {
+	res, err := es.client.Get(es.taskIndex, id).SourceIncludes_("owner").Do(ctx)
+	if err != nil {
+		return "", err
+	}
+	if !res.Found {
+		return "", tes.ErrNotFound
+	}
+	owner := TaskOwner{}
+	if err := json.Unmarshal(res.Source_, &owner); err != nil {
+		return "", err
+	}
+	return owner.Owner, nil
+}
+
 // ListTasks lists tasks, duh.
 func (es *Elastic) ListTasks(ctx context.Context, req *tes.ListTasksRequest) (*tes.ListTasksResponse, error) {
 	pageSize := tes.GetPageSize(req.GetPageSize())
@@ -87,29 +104,37 @@ func (es *Elastic) ListTasks(ctx context.Context, req *tes.ListTasksRequest) (*t
 		filters[field] = v
 	}
 
+	q := query.FromContext(ctx)
+	order := sortorder.Desc
+	if q.Ascending() {
+		order = sortorder.Asc
+	}
+
 	sort := types.SortOptions{
 		SortOptions: map[string]types.FieldSort{
-			"id": {Order: &sortorder.Desc},
+			"id": {Order: &order},
 		},
 	}
 
-	query := types.Query{
+	taskQuery := types.Query{
 		Bool: &types.BoolQuery{
 			Filter: []types.Query{},
 		},
 	}
 
 	for key, value := range filters {
-		query.Bool.Filter = append(query.Bool.Filter, types.Query{
+		taskQuery.Bool.Filter = append(taskQuery.Bool.Filter, types.Query{
 			Term: map[string]types.TermQuery{
 				key: {Value: value},
 			},
 		})
 	}
 
+	taskQuery.Bool.Filter = append(taskQuery.Bool.Filter, queryFilters(q)...)
+
 	search := es.client.Search().
 		Index(es.taskIndex).
-		Query(&query).
+		Query(&taskQuery).
 		Size(pageSize).
 		Sort(sort).
 		ErrorTrace(true)
@@ -0,0 +1,138 @@
+// Package migrate contains the common code of the versioned schema
+// migrations of the databases.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrPending is returned at start by the databases configured with
+// ManualMigrations while some migrations are pending.
+var ErrPending = errors.New("pending schema migrations")
+
+// Migration is a versioned change of the schema of a database.
+type Migration struct {
+	Version int
+	Name    string
+	// When the migration was applied, zero while pending.
+	Applied time.Time
+	// The migration was applied by a newer version of Funnel.
+	Unknown bool
+}
+
+// Pending returns whether the migration wasn't applied.
+func (m *Migration) Pending() bool {
+	return m.Applied.IsZero()
+}
+
+func (m *Migration) String() string {
+	return fmt.Sprintf("%d_%s", m.Version, m.Name)
+}
+
+// Migrator is implemented by the databases with a versioned schema.
+type Migrator interface {
+	// Migrations returns the migrations of the database, applied or pending,
+	// ordered by version.
+	Migrations(ctx context.Context) ([]*Migration, error)
+	// Migrate applies the pending migrations, and returns them.
+	Migrate(ctx context.Context) ([]*Migration, error)
+}
+
+// Pending returns the pending migrations.
+func Pending(migrations []*Migration) []*Migration {
+	var pending []*Migration
+	for _, m := range migrations {
+		if m.Pending() {
+			pending = append(pending, m)
+		}
+	}
+	return pending
+}
+
+// Check returns ErrPending if some migrations are pending.
+func Check(migrations []*Migration) error {
+	pending := Pending(migrations)
+	if len(pending) == 0 {
+		return nil
+	}
+	var names []string
+	for _, m := range pending {
+		names = append(names, m.String())
+	}
+	return fmt.Errorf("%w: %s; run \"funnel db migrate\"", ErrPending, strings.Join(names, ", "))
+}
+
+// Merge returns the known migrations, with the time they were applied, and
+// the applied migrations unknown to this version of Funnel.
+func Merge(known []*Migration, applied []*Migration) []*Migration {
+	byVersion := map[int]*Migration{}
+	var out []*Migration
+	for _, m := range known {
+		c := *m
+		byVersion[m.Version] = &c
+		out = append(out, &c)
+	}
+	for _, a := range applied {
+		if m, ok := byVersion[a.Version]; ok {
+			m.Applied = a.Applied
+			continue
+		}
+		c := *a
+		c.Unknown = true
+		out = append(out, &c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out
+}
+
+// File is a migration of a SQL file.
+type File struct {
+	Migration
+	SQL string
+}
+
+// ReadFiles returns the migrations of the SQL files of a directory, named
+// <version>_<name>.sql, ordered by version.
+func ReadFiles(fsys fs.FS, dir string) ([]*File, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*File
+	versions := map[int]bool{}
+	for _, e := range entries {
+		if e.IsDir() || path.Ext(e.Name()) != ".sql" {
+			continue
+		}
+		base := strings.TrimSuffix(e.Name(), ".sql")
+		v, name, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(v)
+		if !ok || err != nil || version <= 0 || name == "" {
+			return nil, fmt.Errorf("invalid migration file name %s: expected <version>_<name>.sql", e.Name())
+		}
+		if versions[version] {
+			return nil, fmt.Errorf("duplicate migration version %d", version)
+		}
+		versions[version] = true
+
+		b, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, &File{
+			Migration: Migration{Version: version, Name: name},
+			SQL:       string(b),
+		})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Version < files[j].Version })
+	return files, nil
+}
@@ -0,0 +1,60 @@
+package migrate
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestReadFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0002_tasks_name.sql": {Data: []byte("CREATE INDEX b;")},
+		"migrations/0001_initial.sql":    {Data: []byte("CREATE TABLE a;")},
+		"migrations/README.md":           {Data: []byte("docs")},
+	}
+	files, err := ReadFiles(fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].String() != "1_initial" || files[1].String() != "2_tasks_name" || files[1].SQL != "CREATE INDEX b;" {
+		t.Errorf("unexpected migrations: %v", files)
+	}
+
+	for _, name := range []string{"migrations/initial.sql", "migrations/0_initial.sql", "migrations/0001_.sql"} {
+		if _, err := ReadFiles(fstest.MapFS{name: {}}, "migrations"); err == nil {
+			t.Errorf("expected an error for %s", name)
+		}
+	}
+	dup := fstest.MapFS{"migrations/1_a.sql": {}, "migrations/01_b.sql": {}}
+	if _, err := ReadFiles(dup, "migrations"); err == nil {
+		t.Error("expected an error for duplicate versions")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	now := time.Now()
+	known := []*Migration{{Version: 1, Name: "initial"}, {Version: 2, Name: "indexes"}}
+
+	migrations := Merge(known, []*Migration{{Version: 1, Name: "initial", Applied: now}})
+	if len(migrations) != 2 || migrations[0].Pending() || !migrations[1].Pending() {
+		t.Errorf("unexpected migrations: %v", migrations)
+	}
+	if !known[0].Pending() {
+		t.Error("unexpected change of the known migrations")
+	}
+	err := Check(migrations)
+	if !errors.Is(err, ErrPending) || err.Error() != `pending schema migrations: 2_indexes; run "funnel db migrate"` {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// A newer Funnel applied a migration.
+	applied := []*Migration{{Version: 1, Applied: now}, {Version: 2, Applied: now}, {Version: 3, Name: "columns", Applied: now}}
+	migrations = Merge(known, applied)
+	if len(migrations) != 3 || !migrations[2].Unknown || migrations[2].Name != "columns" {
+		t.Errorf("unexpected migrations: %v", migrations)
+	}
+	if err := Check(migrations); err != nil {
+		t.Error(err)
+	}
+}
@@ -15,8 +15,45 @@ import (
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
-// WriteEvent creates an event for the server to handle.
+// WriteEvent creates an event for the server to handle. The events written
+// again, e.g. retried by a worker, are dropped: the IDs of the events written
+// are recorded.
 func (db *MongoDB) WriteEvent(ctx context.Context, req *events.Event) error {
+	if req.EventId == "" {
+		return db.writeEvent(ctx, req)
+	}
+	added, err := db.addEventID(ctx, req)
+	if err != nil || !added {
+		return err
+	}
+	if err := db.writeEvent(ctx, req); err != nil {
+		db.removeEventID(ctx, req)
+		return err
+	}
+	return nil
+}
+
+// addEventID records the ID of the event, and returns false if it was
+// already recorded.
+func (db *MongoDB) addEventID(ctx context.Context, req *events.Event) (bool, error) {
+	mctx, cancel := db.wrap(ctx)
+	defer cancel()
+
+	_, err := db.eventIDs().InsertOne(mctx, bson.M{"_id": req.EventId, "task_id": req.Id})
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (db *MongoDB) removeEventID(ctx context.Context, req *events.Event) {
+	mctx, cancel := db.wrap(ctx)
+	defer cancel()
+
+	db.eventIDs().DeleteOne(mctx, bson.M{"_id": req.EventId})
+}
+
+func (db *MongoDB) writeEvent(ctx context.Context, req *events.Event) error {
 	logger := logger.NewLogger("mongodb", logger.DefaultConfig())
 	tasks := db.tasks()
 
@@ -0,0 +1,140 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/database/migrate"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// migration is an up-migration of the collections and indexes, applied in
+// the order of the versions. Servers starting together may apply the same
+// migration: they must be idempotent. A released migration must never
+// change: add a new one instead.
+type migration struct {
+	migrate.Migration
+	up func(ctx context.Context, db *MongoDB) error
+}
+
+var migrations = []*migration{
+	{
+		// The collections of the deployments created before the migrations.
+		Migration: migrate.Migration{Version: 1, Name: "initial"},
+		up: func(ctx context.Context, db *MongoDB) error {
+			found, err := db.findCollections("tasks", "nodes")
+			if err != nil {
+				return err
+			}
+			if !found["tasks"] {
+				indexKeys := &bson.D{
+					{Key: "-id", Value: -1},
+					{Key: "-creationtime", Value: -1},
+				}
+				if err := db.createCollection("tasks", indexKeys); err != nil {
+					return err
+				}
+			}
+			if !found["nodes"] {
+				indexKeys := &bson.D{
+					{Key: "-id", Value: -1},
+				}
+				if err := db.createCollection("nodes", indexKeys); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// The indexes of the IDs, and of the task list filters.
+		Migration: migrate.Migration{Version: 2, Name: "task_indexes"},
+		up: func(ctx context.Context, db *MongoDB) error {
+			_, err := db.tasks().Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+				{Keys: bson.D{{Key: "creationtime", Value: -1}, {Key: "id", Value: -1}}},
+				{Keys: bson.D{{Key: "state", Value: 1}}},
+				{Keys: bson.D{{Key: "owner", Value: 1}}},
+			})
+			if err != nil {
+				return err
+			}
+			_, err = db.nodes().Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "id", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			})
+			return err
+		},
+	},
+}
+
+type appliedMigration struct {
+	Version int       `bson:"_id"`
+	Name    string    `bson:"name"`
+	Applied time.Time `bson:"applied_at"`
+}
+
+func (db *MongoDB) migrations() *mongo.Collection {
+	return db.collection("schema_migrations")
+}
+
+// Migrations returns the schema migrations, applied or pending.
+func (db *MongoDB) Migrations(ctx context.Context) ([]*migrate.Migration, error) {
+	applied, err := db.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var known []*migrate.Migration
+	for _, m := range migrations {
+		known = append(known, &m.Migration)
+	}
+	return migrate.Merge(known, applied), nil
+}
+
+// Migrate applies the pending schema migrations.
+func (db *MongoDB) Migrate(ctx context.Context) ([]*migrate.Migration, error) {
+	applied, err := db.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	done := map[int]bool{}
+	for _, m := range applied {
+		done[m.Version] = true
+	}
+
+	var migrated []*migrate.Migration
+	for _, m := range migrations {
+		if done[m.Version] {
+			continue
+		}
+		if err := m.up(ctx, db); err != nil {
+			return migrated, fmt.Errorf("applying schema migration %s: %v", m.String(), err)
+		}
+		a := appliedMigration{Version: m.Version, Name: m.Name, Applied: time.Now()}
+		_, err := db.migrations().InsertOne(ctx, a)
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return migrated, fmt.Errorf("recording schema migration %s: %v", m.String(), err)
+		}
+		migrated = append(migrated, &migrate.Migration{Version: a.Version, Name: a.Name, Applied: a.Applied})
+	}
+	return migrated, nil
+}
+
+func (db *MongoDB) appliedMigrations(ctx context.Context) ([]*migrate.Migration, error) {
+	cursor, err := db.migrations().Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var records []appliedMigration
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	var applied []*migrate.Migration
+	for _, r := range records {
+		applied = append(applied, &migrate.Migration{Version: r.Version, Name: r.Name, Applied: r.Applied})
+	}
+	return applied, nil
+}
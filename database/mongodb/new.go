@@ -6,6 +6,7 @@ import (
 
 	"github.com/ohsu-comp-bio/funnel/compute/scheduler"
 	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/database/migrate"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
@@ -66,6 +67,11 @@ func (db *MongoDB) tasks() *mongo.Collection {
 	return db.collection("tasks")
 }
 
+// eventIDs are the IDs of the events written.
+func (db *MongoDB) eventIDs() *mongo.Collection {
+	return db.collection("event_ids")
+}
+
 func (db *MongoDB) createCollection(name string, indexKeys *bson.D) error {
 	ctx, cancel := db.context()
 	defer cancel()
@@ -106,33 +112,20 @@ func (db *MongoDB) findCollections(names ...string) (map[string]bool, error) {
 	return result, nil
 }
 
-// Init creates tables in MongoDB.
+// Init creates or upgrades the collections and indexes in MongoDB.
 func (db *MongoDB) Init() error {
-	found, err := db.findCollections("tasks", "nodes")
-	if err != nil {
-		return err
-	}
-
-	if !found["tasks"] {
-		indexKeys := &bson.D{
-			{Key: "-id", Value: -1},
-			{Key: "-creationtime", Value: -1},
-		}
-		if err := db.createCollection("tasks", indexKeys); err != nil {
-			return err
-		}
-	}
+	// Index builds of large collections may exceed the timeout.
+	ctx := context.Background()
 
-	if !found["nodes"] {
-		indexKeys := &bson.D{
-			{Key: "-id", Value: -1},
-		}
-		if err := db.createCollection("nodes", indexKeys); err != nil {
+	if db.conf.GetManualMigrations() {
+		migrations, err := db.Migrations(ctx)
+		if err != nil {
 			return err
 		}
+		return migrate.Check(migrations)
 	}
-
-	return nil
+	_, err := db.Migrate(ctx)
+	return err
 }
 
 // Close closes the database session.
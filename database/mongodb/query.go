@@ -0,0 +1,100 @@
+package mongodb
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/ohsu-comp-bio/funnel/query"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// queryConditions translates the task filter to conditions of the tasks
+// collection, which all must match.
+func queryConditions(q *query.Query) []bson.M {
+	if q == nil {
+		return nil
+	}
+	var conds []bson.M
+
+	if len(q.States) > 0 {
+		conds = append(conds, bson.M{"state": bson.M{"$in": q.States}})
+	}
+
+	if len(q.Owners) > 0 {
+		conds = append(conds, bson.M{"owner": bson.M{"$in": q.Owners}})
+	}
+
+	// The times are stored as strings, with the time zone of the server:
+	// they are parsed to be compared.
+	var exprs []bson.M
+	if !q.Created.Empty() {
+		exprs = append(exprs, rangeExpr(parseDate("$creationtime"), q.Created))
+	}
+	for _, log := range []struct {
+		field string
+		r     query.Range
+	}{{"$$l.starttime", q.Started}, {"$$l.endtime", q.Ended}} {
+		if log.r.Empty() {
+			continue
+		}
+		exprs = append(exprs, bson.M{"$anyElementTrue": bson.A{bson.M{"$map": bson.M{
+			"input": bson.M{"$ifNull": bson.A{"$logs", bson.A{}}},
+			"as":    "l",
+			"in":    rangeExpr(parseDate(log.field), log.r),
+		}}}})
+	}
+	if len(exprs) > 0 {
+		conds = append(conds, bson.M{"$expr": bson.M{"$and": exprs}})
+	}
+
+	for _, m := range q.Tags {
+		conds = append(conds, bson.M{fmt.Sprintf("tags.%s", m.Key): matchCondition(m)})
+	}
+
+	for _, m := range q.Images {
+		conds = append(conds, bson.M{"executors.image": matchCondition(m)})
+	}
+
+	if q.Scope != nil {
+		scope := bson.A{bson.M{"owner": bson.M{"$eq": q.Scope.Owner}}}
+		for _, m := range q.Scope.Tags {
+			scope = append(scope, bson.M{fmt.Sprintf("tags.%s", m.Key): matchCondition(m)})
+		}
+		conds = append(conds, bson.M{"$or": scope})
+	}
+
+	return conds
+}
+
+func parseDate(field string) bson.M {
+	return bson.M{"$dateFromString": bson.M{"dateString": field, "onError": nil, "onNull": nil}}
+}
+
+func rangeExpr(date bson.M, r query.Range) bson.M {
+	exprs := bson.A{bson.M{"$ne": bson.A{date, nil}}}
+	if r.Min != nil {
+		op := "$gt"
+		if r.Min.Inclusive {
+			op = "$gte"
+		}
+		exprs = append(exprs, bson.M{op: bson.A{date, r.Min.Time}})
+	}
+	if r.Max != nil {
+		op := "$lt"
+		if r.Max.Inclusive {
+			op = "$lte"
+		}
+		exprs = append(exprs, bson.M{op: bson.A{date, r.Max.Time}})
+	}
+	return bson.M{"$and": exprs}
+}
+
+func matchCondition(m *query.Match) bson.M {
+	switch m.Op {
+	case query.Prefix:
+		return bson.M{"$regex": "^" + regexp.QuoteMeta(m.Value)}
+	case query.Regex:
+		return bson.M{"$regex": m.Value}
+	}
+	return bson.M{"$eq": m.Value}
+}
@@ -3,9 +3,11 @@ package mongodb
 import (
 	"fmt"
 
+	"github.com/ohsu-comp-bio/funnel/query"
 	"github.com/ohsu-comp-bio/funnel/server"
 	"github.com/ohsu-comp-bio/funnel/tes"
 	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
 	"golang.org/x/net/context"
 )
@@ -54,37 +56,68 @@ func (db *MongoDB) GetTask(ctx context.Context, req *tes.GetTaskRequest) (*tes.T
 	return &task, nil
 }
 
+// TaskOwner returns the owner of a task.
+func (db *MongoDB) TaskOwner(ctx context.Context, id string) (string, error) {
+	mctx, cancel := db.wrap(ctx)
+	defer cancel()
+
+	opts := options.FindOne().SetProjection(bson.M{"owner": 1})
+	result := db.tasks().FindOne(mctx, bson.M{"id": id}, opts)
+	if result.Err() == mongo.ErrNoDocuments {
+		return "", tes.ErrNotFound
+	}
+	var owner TaskOwner
+	if err := result.Decode(&owner); err != nil {
+		return "", err
+	}
+	return owner.Owner, nil
+}
+
 // ListTasks returns a list of taskIDs
 func (db *MongoDB) ListTasks(ctx context.Context, req *tes.ListTasksRequest) (*tes.ListTasksResponse, error) {
 	pageSize := tes.GetPageSize(req.GetPageSize())
 
-	var query = bson.M{}
+	q := query.FromContext(ctx)
+
+	var filter = bson.M{}
 	var err error
 	if req.PageToken != "" {
-		query["id"] = bson.M{"$lt": req.PageToken}
+		if q.Ascending() {
+			filter["id"] = bson.M{"$gt": req.PageToken}
+		} else {
+			filter["id"] = bson.M{"$lt": req.PageToken}
+		}
 	}
 
 	if req.State != tes.Unknown {
-		query["state"] = bson.M{"$eq": req.State}
+		filter["state"] = bson.M{"$eq": req.State}
 	}
 
 	if req.NamePrefix != "" {
-		query["name"] = bson.M{"$regex": fmt.Sprintf("^%s", req.NamePrefix)}
+		filter["name"] = bson.M{"$regex": fmt.Sprintf("^%s", req.NamePrefix)}
 	}
 
 	if userInfo := server.GetUser(ctx); !userInfo.CanSeeAllTasks() {
-		query["owner"] = bson.M{"$eq": userInfo.Username}
+		filter["owner"] = bson.M{"$eq": userInfo.Username}
 	}
 
 	for k, v := range req.GetTags() {
 		if v == "" {
-			query[fmt.Sprintf("tags.%s", k)] = bson.M{"$exists": true}
+			filter[fmt.Sprintf("tags.%s", k)] = bson.M{"$exists": true}
 		} else {
-			query[fmt.Sprintf("tags.%s", k)] = bson.M{"$eq": v}
+			filter[fmt.Sprintf("tags.%s", k)] = bson.M{"$eq": v}
 		}
 	}
 
-	var opts = options.Find().SetSort(bson.M{"creationtime": -1}).SetLimit(int64(pageSize))
+	if conds := queryConditions(q); len(conds) > 0 {
+		filter["$and"] = conds
+	}
+
+	order := -1
+	if q.Ascending() {
+		order = 1
+	}
+	var opts = options.Find().SetSort(bson.D{{Key: "creationtime", Value: order}, {Key: "id", Value: order}}).SetLimit(int64(pageSize))
 
 	switch req.View {
 	case tes.View_BASIC.String():
@@ -96,7 +129,7 @@ func (db *MongoDB) ListTasks(ctx context.Context, req *tes.ListTasksRequest) (*t
 	mctx, cancel := db.wrap(ctx)
 	defer cancel()
 
-	cursor, err := db.tasks().Find(mctx, query, opts)
+	cursor, err := db.tasks().Find(mctx, filter, opts)
 	if err != nil {
 		return nil, err
 	}
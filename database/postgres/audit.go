@@ -0,0 +1,79 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ohsu-comp-bio/funnel/audit"
+)
+
+// WriteAudit appends a record to the audit log.
+func (db *Postgres) WriteAudit(ctx context.Context, r *audit.Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit record: %w", err)
+	}
+
+	insertSQL := `INSERT INTO audit_log (time, username, action, task_id, data) VALUES ($1, $2, $3, $4, $5)`
+	_, err = db.client.Exec(ctx, insertSQL, r.Time, r.User, r.Action, r.TaskID, data)
+	if err != nil {
+		return fmt.Errorf("failed to insert audit record: %w", err)
+	}
+	return nil
+}
+
+// ListAudit returns audit records matching the filter, most recent first.
+func (db *Postgres) ListAudit(ctx context.Context, f *audit.Filter) ([]*audit.Record, error) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if f.User != "" {
+		add("username = $%d", f.User)
+	}
+	if f.TaskID != "" {
+		add("task_id = $%d", f.TaskID)
+	}
+	if f.Action != "" {
+		add("action = $%d", f.Action)
+	}
+	if !f.Since.IsZero() {
+		add("time >= $%d", f.Since)
+	}
+	if !f.Until.IsZero() {
+		add("time <= $%d", f.Until)
+	}
+
+	selectSQL := "SELECT data FROM audit_log"
+	if len(where) > 0 {
+		selectSQL += " WHERE " + strings.Join(where, " AND ")
+	}
+	selectSQL += " ORDER BY id DESC"
+	if f.Limit > 0 {
+		selectSQL += fmt.Sprintf(" LIMIT %d", f.Limit)
+	}
+
+	rows, err := db.client.Query(ctx, selectSQL, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var out []*audit.Record
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to scan audit record: %w", err)
+		}
+		r := &audit.Record{}
+		if err := json.Unmarshal(data, r); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal audit record: %w", err)
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
@@ -16,8 +16,47 @@ import (
 	"google.golang.org/grpc/status"
 )
 
-// WriteEvent creates an event for the server to handle.
+// WriteEvent creates an event for the server to handle. The events written
+// again, e.g. retried by a worker, are dropped: the IDs of the events written
+// are recorded.
 func (db *Postgres) WriteEvent(ctx context.Context, req *events.Event) error {
+	if req.EventId == "" {
+		return db.writeEvent(ctx, req)
+	}
+	added, err := db.addEventID(req)
+	if err != nil || !added {
+		return err
+	}
+	if err := db.writeEvent(ctx, req); err != nil {
+		db.removeEventID(req)
+		return err
+	}
+	return nil
+}
+
+// addEventID records the ID of the event, and returns false if it was
+// already recorded.
+func (db *Postgres) addEventID(req *events.Event) (bool, error) {
+	ctx, cancel := db.context()
+	defer cancel()
+
+	tag, err := db.client.Exec(ctx,
+		`INSERT INTO event_ids (event_id, task_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+		req.EventId, req.Id)
+	if err != nil {
+		return false, fmt.Errorf("failed to record event ID: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (db *Postgres) removeEventID(req *events.Event) {
+	ctx, cancel := db.context()
+	defer cancel()
+
+	db.client.Exec(ctx, `DELETE FROM event_ids WHERE event_id = $1`, req.EventId)
+}
+
+func (db *Postgres) writeEvent(ctx context.Context, req *events.Event) error {
 	logger := logger.NewLogger("postgres", logger.DefaultConfig())
 
 	ctx, cancel := db.context()
@@ -242,7 +281,10 @@ func (db *Postgres) WriteEvent(ctx context.Context, req *events.Event) error {
 }
 
 func (db *Postgres) insertTask(ctx context.Context, task *tes.Task, owner string) error {
-	task.CreationTime = time.Now().Format(time.RFC3339Nano)
+	// Imported tasks keep their creation time.
+	if task.CreationTime == "" {
+		task.CreationTime = time.Now().Format(time.RFC3339Nano)
+	}
 	task.State = tes.State_QUEUED
 
 	taskJSON, err := json.Marshal(task)
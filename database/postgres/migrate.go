@@ -0,0 +1,149 @@
+package postgres
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/ohsu-comp-bio/funnel/database/migrate"
+)
+
+// The up-migrations of the schema, applied in the order of their versions.
+// A released migration must never change: add a new one instead.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLock is the key of the advisory lock held while migrating, so that
+// servers starting together don't apply the same migrations.
+const migrationLock = 0x66756e6e656c // "funnel"
+
+const createMigrationsTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	applied_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL
+);
+`
+
+// Migrations returns the schema migrations, applied or pending.
+func (db *Postgres) Migrations(ctx context.Context) ([]*migrate.Migration, error) {
+	files, err := migrate.ReadFiles(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(ctx, db.client)
+	if err != nil {
+		return nil, err
+	}
+	var known []*migrate.Migration
+	for _, f := range files {
+		known = append(known, &f.Migration)
+	}
+	return migrate.Merge(known, applied), nil
+}
+
+// Migrate creates the database if needed, and applies the pending schema
+// migrations, each in a transaction.
+func (db *Postgres) Migrate(ctx context.Context) ([]*migrate.Migration, error) {
+	if err := ensureDatabaseExists(ctx, db.conf); err != nil {
+		return nil, err
+	}
+	return db.migrate(ctx)
+}
+
+func (db *Postgres) migrate(ctx context.Context) ([]*migrate.Migration, error) {
+	files, err := migrate.ReadFiles(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := db.client.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLock); err != nil {
+		return nil, fmt.Errorf("locking the schema migrations: %w", err)
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLock)
+
+	if _, err := conn.Exec(ctx, createMigrationsTable); err != nil {
+		return nil, fmt.Errorf("failed to create 'schema_migrations' table: %w", err)
+	}
+
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	done := map[int]bool{}
+	for _, m := range applied {
+		done[m.Version] = true
+	}
+
+	var migrated []*migrate.Migration
+	for _, f := range files {
+		if done[f.Version] {
+			continue
+		}
+		err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, f.SQL); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", f.Version, f.Name)
+			return err
+		})
+		if err != nil {
+			return migrated, fmt.Errorf("applying schema migration %s: %w", f.String(), err)
+		}
+		f.Applied = time.Now()
+		migrated = append(migrated, &f.Migration)
+	}
+	return migrated, nil
+}
+
+// checkMigrations returns migrate.ErrPending if some migrations are pending.
+func (db *Postgres) checkMigrations(ctx context.Context) error {
+	migrations, err := db.Migrations(ctx)
+	if err != nil {
+		return err
+	}
+	return migrate.Check(migrations)
+}
+
+// querier is a connection, or the pool.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// appliedMigrations returns the applied migrations, none if the migrations
+// table doesn't exist yet.
+func appliedMigrations(ctx context.Context, q querier) ([]*migrate.Migration, error) {
+	var exists bool
+	if err := q.QueryRow(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	rows, err := q.Query(ctx, "SELECT version, name, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var applied []*migrate.Migration
+	for rows.Next() {
+		m := &migrate.Migration{}
+		if err := rows.Scan(&m.Version, &m.Name, &m.Applied); err != nil {
+			return nil, err
+		}
+		applied = append(applied, m)
+	}
+	return applied, rows.Err()
+}
@@ -0,0 +1,43 @@
+-- The schema of the deployments created before the migrations, which is
+-- kept as is when they upgrade.
+
+CREATE TABLE IF NOT EXISTS tasks (
+	id VARCHAR(255) PRIMARY KEY,
+	state VARCHAR(50) NOT NULL,
+	owner VARCHAR(255),
+	creation_time TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+	version BIGINT DEFAULT 0 NOT NULL,
+	data JSONB
+);
+
+CREATE TABLE IF NOT EXISTS nodes (
+	id VARCHAR(255) PRIMARY KEY,
+	state VARCHAR(50) NOT NULL,
+	owner VARCHAR(255),
+	version BIGINT DEFAULT 0 NOT NULL,
+	last_heartbeat TIMESTAMP WITH TIME ZONE,
+	data JSONB
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+	id BIGSERIAL PRIMARY KEY,
+	time TIMESTAMP WITH TIME ZONE NOT NULL,
+	username VARCHAR(255),
+	action VARCHAR(255) NOT NULL,
+	task_id VARCHAR(255),
+	data JSONB
+);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id VARCHAR(255) PRIMARY KEY,
+	username VARCHAR(255) NOT NULL,
+	data JSONB
+);
+
+CREATE INDEX IF NOT EXISTS idx_tasks_state ON tasks (state);
+CREATE INDEX IF NOT EXISTS idx_tasks_owner ON tasks (owner);
+CREATE INDEX IF NOT EXISTS idx_tasks_creation_time ON tasks (creation_time DESC);
+CREATE INDEX IF NOT EXISTS idx_nodes_state ON nodes (state);
+CREATE INDEX IF NOT EXISTS idx_nodes_owner ON nodes (owner);
+CREATE INDEX IF NOT EXISTS idx_audit_log_time ON audit_log (time DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_log_task_id ON audit_log (task_id);
@@ -0,0 +1,2 @@
+-- Index of the name prefix filter of ListTasks.
+CREATE INDEX IF NOT EXISTS idx_tasks_name ON tasks ((data ->> 'name') text_pattern_ops);
@@ -0,0 +1,12 @@
+-- The chunks of the archived executor logs, keyed by their log and their
+-- offset in it.
+
+CREATE TABLE IF NOT EXISTS log_chunks (
+	task_id TEXT NOT NULL,
+	attempt INTEGER NOT NULL,
+	executor INTEGER NOT NULL,
+	stream TEXT NOT NULL,
+	start BIGINT NOT NULL,
+	data BYTEA NOT NULL,
+	PRIMARY KEY (task_id, attempt, executor, stream, start)
+);
@@ -0,0 +1,7 @@
+-- The IDs of the events written, to drop the events written again, e.g.
+-- retried by a worker.
+
+CREATE TABLE IF NOT EXISTS event_ids (
+	event_id TEXT PRIMARY KEY,
+	task_id TEXT NOT NULL
+);
@@ -2,6 +2,7 @@ package postgres
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/url"
 	"time"
@@ -10,6 +11,7 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/ohsu-comp-bio/funnel/compute/scheduler"
 	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/database/migrate"
 	"github.com/ohsu-comp-bio/funnel/util"
 )
 
@@ -42,6 +44,9 @@ func (db *Postgres) Init() error {
 
 	retrier := util.NewRetrier()
 	retrier.MaxElapsedTime = time.Second * 300
+	retrier.ShouldRetry = func(err error) bool {
+		return !errors.Is(err, migrate.ErrPending)
+	}
 
 	return retrier.Retry(ctx, func() error {
 		// Check/create resources (Roles/DBs)
@@ -49,8 +54,12 @@ func (db *Postgres) Init() error {
 			return err
 		}
 
-		// Create Tables and Indices
-		return db.createTables(ctx)
+		// Create or upgrade the tables and indices
+		if db.conf.GetManualMigrations() {
+			return db.checkMigrations(ctx)
+		}
+		_, err := db.migrate(ctx)
+		return err
 	})
 }
 
@@ -143,56 +152,6 @@ func createResources(ctx context.Context, conf *config.Postgres) error {
 	return nil
 }
 
-func (db *Postgres) createTables(ctx context.Context) error {
-	// Tasks
-	tasks := `
-    CREATE TABLE IF NOT EXISTS tasks (
-		id VARCHAR(255) PRIMARY KEY,
-		state VARCHAR(50) NOT NULL,
-		owner VARCHAR(255),
-		creation_time TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
-		version BIGINT DEFAULT 0 NOT NULL,
-		data JSONB
-    );
-    `
-
-	if _, err := db.client.Exec(ctx, tasks); err != nil {
-		return fmt.Errorf("failed to create 'tasks' table: %w", err)
-	}
-
-	// Nodes
-	nodes := `
-    CREATE TABLE IF NOT EXISTS nodes (
-		id VARCHAR(255) PRIMARY KEY,
-		state VARCHAR(50) NOT NULL,
-		owner VARCHAR(255),
-		version BIGINT DEFAULT 0 NOT NULL,
-		last_heartbeat TIMESTAMP WITH TIME ZONE,
-		data JSONB
-    );
-    `
-
-	if _, err := db.client.Exec(ctx, nodes); err != nil {
-		return fmt.Errorf("failed to create 'nodes' table: %w", err)
-	}
-
-	indices := []string{
-		"CREATE INDEX IF NOT EXISTS idx_tasks_state ON tasks (state);",
-		"CREATE INDEX IF NOT EXISTS idx_tasks_owner ON tasks (owner);",
-		"CREATE INDEX IF NOT EXISTS idx_tasks_creation_time ON tasks (creation_time DESC);",
-		"CREATE INDEX IF NOT EXISTS idx_nodes_state ON nodes (state);",
-		"CREATE INDEX IF NOT EXISTS idx_nodes_owner ON nodes (owner);",
-	}
-
-	for _, query := range indices {
-		if _, err := db.client.Exec(ctx, query); err != nil {
-			return fmt.Errorf("failed to create index with query '%s': %w", query, err)
-		}
-	}
-
-	return nil
-}
-
 // Close closes the database session.
 func (db *Postgres) Close() {
 	if db.active {
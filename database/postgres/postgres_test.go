@@ -4,11 +4,14 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/database/migrate"
 	"github.com/ohsu-comp-bio/funnel/events"
+	"github.com/ohsu-comp-bio/funnel/query"
 	"github.com/ohsu-comp-bio/funnel/server"
 	"github.com/ohsu-comp-bio/funnel/tes"
 	"github.com/testcontainers/testcontainers-go"
@@ -189,3 +192,41 @@ func TestPostgresOperations(t *testing.T) {
 		}
 	})
 }
+
+func TestQueryClauses(t *testing.T) {
+	q, err := query.Parse(`state=QUEUED,RUNNING started>=2024-01-01 tag.project^=cohort_1 image~=^ubuntu`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var args []interface{}
+	clauses := queryClauses(q, func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	})
+
+	expected := []string{
+		"state = ANY($1)",
+		"EXISTS (SELECT 1 FROM jsonb_array_elements(COALESCE(data -> 'logs', '[]'::jsonb)) AS l WHERE NULLIF(l ->> 'start_time', '')::timestamptz >= $2::timestamptz)",
+		"data -> 'tags' ->> $3 LIKE $4",
+		"EXISTS (SELECT 1 FROM jsonb_array_elements(COALESCE(data -> 'executors', '[]'::jsonb)) AS e WHERE e ->> 'image' ~ $5)",
+	}
+	if strings.Join(clauses, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected clauses:\n%s", strings.Join(clauses, "\n"))
+	}
+	if fmt.Sprint(args) != "[[QUEUED RUNNING] 2024-01-01T00:00:00Z project cohort\\_1% ^ubuntu]" {
+		t.Errorf("unexpected arguments: %v", args)
+	}
+}
+
+func TestMigrationFiles(t *testing.T) {
+	files, err := migrate.ReadFiles(migrationFiles, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range files {
+		if f.Version != i+1 {
+			t.Errorf("expected consecutive versions, got %s", f)
+		}
+	}
+}
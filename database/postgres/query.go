@@ -0,0 +1,105 @@
+package postgres
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/query"
+)
+
+// queryClauses translates the task filter to the WHERE clauses of the tasks
+// table. arg adds a parameter of the statement, and returns its placeholder.
+func queryClauses(q *query.Query, arg func(interface{}) string) []string {
+	if q == nil {
+		return nil
+	}
+	var clauses []string
+
+	if len(q.States) > 0 {
+		var states []string
+		for _, s := range q.States {
+			states = append(states, s.String())
+		}
+		clauses = append(clauses, fmt.Sprintf("state = ANY(%s)", arg(states)))
+	}
+
+	if len(q.Owners) > 0 {
+		clauses = append(clauses, fmt.Sprintf("owner = ANY(%s)", arg(q.Owners)))
+	}
+
+	if c := rangeClause("creation_time", q.Created, arg); c != "" {
+		clauses = append(clauses, c)
+	}
+
+	// The times of the attempts are only in the task logs.
+	for _, log := range []struct {
+		field string
+		r     query.Range
+	}{{"start_time", q.Started}, {"end_time", q.Ended}} {
+		if log.r.Empty() {
+			continue
+		}
+		c := rangeClause(fmt.Sprintf("NULLIF(l ->> '%s', '')::timestamptz", log.field), log.r, arg)
+		clauses = append(clauses, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM jsonb_array_elements(COALESCE(data -> 'logs', '[]'::jsonb)) AS l WHERE %s)", c))
+	}
+
+	for _, m := range q.Tags {
+		clauses = append(clauses, matchClause(tagExpr(m, arg), m, arg))
+	}
+
+	if q.Scope != nil {
+		conds := []string{fmt.Sprintf("owner = %s", arg(q.Scope.Owner))}
+		for _, m := range q.Scope.Tags {
+			conds = append(conds, matchClause(tagExpr(m, arg), m, arg))
+		}
+		clauses = append(clauses, fmt.Sprintf("(%s)", strings.Join(conds, " OR ")))
+	}
+
+	for _, m := range q.Images {
+		clauses = append(clauses, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM jsonb_array_elements(COALESCE(data -> 'executors', '[]'::jsonb)) AS e WHERE %s)",
+			matchClause("e ->> 'image'", m, arg)))
+	}
+
+	return clauses
+}
+
+func tagExpr(m *query.Match, arg func(interface{}) string) string {
+	return fmt.Sprintf("data -> 'tags' ->> %s", arg(m.Key))
+}
+
+func rangeClause(expr string, r query.Range, arg func(interface{}) string) string {
+	var conds []string
+	if r.Min != nil {
+		op := ">"
+		if r.Min.Inclusive {
+			op = ">="
+		}
+		conds = append(conds, fmt.Sprintf("%s %s %s::timestamptz", expr, op, arg(r.Min.Time.Format(time.RFC3339Nano))))
+	}
+	if r.Max != nil {
+		op := "<"
+		if r.Max.Inclusive {
+			op = "<="
+		}
+		conds = append(conds, fmt.Sprintf("%s %s %s::timestamptz", expr, op, arg(r.Max.Time.Format(time.RFC3339Nano))))
+	}
+	return strings.Join(conds, " AND ")
+}
+
+func matchClause(expr string, m *query.Match, arg func(interface{}) string) string {
+	switch m.Op {
+	case query.Prefix:
+		return fmt.Sprintf("%s LIKE %s", expr, arg(escapeLike(m.Value)+"%"))
+	case query.Regex:
+		return fmt.Sprintf("%s ~ %s", expr, arg(m.Value))
+	}
+	return fmt.Sprintf("%s = %s", expr, arg(m.Value))
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -3,9 +3,12 @@ package postgres
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/ohsu-comp-bio/funnel/query"
 	"github.com/ohsu-comp-bio/funnel/server"
 	"github.com/ohsu-comp-bio/funnel/tes"
 )
@@ -23,6 +26,8 @@ var minimalView = "id, owner, state, data -> 'creation_time'" // Can't easily co
 
 // GetTask gets a task.
 func (db *Postgres) GetTask(ctx context.Context, req *tes.GetTaskRequest) (*tes.Task, error) {
+	userInfo := server.GetUser(ctx)
+
 	ctx, cancel := db.context()
 	defer cancel()
 
@@ -45,7 +50,7 @@ func (db *Postgres) GetTask(ctx context.Context, req *tes.GetTaskRequest) (*tes.
 	}
 
 	// Authorization Check
-	if !server.GetUser(ctx).IsAccessible(core.Owner) {
+	if !userInfo.IsAccessible(core.Owner) {
 		return nil, tes.ErrNotPermitted
 	}
 
@@ -71,8 +76,24 @@ func (db *Postgres) GetTask(ctx context.Context, req *tes.GetTaskRequest) (*tes.
 	return &task, nil
 }
 
+// TaskOwner returns the owner of a task.
+func (db *Postgres) TaskOwner(ctx context.Context, id string) (string, error) {
+	ctx, cancel := db.context()
+	defer cancel()
+
+	var owner string
+	err := db.client.QueryRow(ctx, "SELECT owner FROM tasks WHERE id = $1", id).Scan(&owner)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", tes.ErrNotFound
+	}
+	return owner, err
+}
+
 // ListTasks returns a list of tasks.
 func (db *Postgres) ListTasks(ctx context.Context, req *tes.ListTasksRequest) (*tes.ListTasksResponse, error) {
+	userInfo := server.GetUser(ctx)
+	q := query.FromContext(ctx)
+
 	ctx, cancel := db.context()
 	defer cancel()
 
@@ -80,42 +101,50 @@ func (db *Postgres) ListTasks(ctx context.Context, req *tes.ListTasksRequest) (*
 
 	var args []interface{}
 	var whereClauses []string
-	paramCount := 1
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
 
 	// Name prefix filter
 	if req.NamePrefix != "" {
-		whereClauses = append(whereClauses, fmt.Sprintf("data ->> 'name' LIKE $%d", paramCount))
-		args = append(args, req.NamePrefix+"%") // PostgreSQL LIKE operator needs % for prefix match
-		paramCount++
+		// PostgreSQL LIKE operator needs % for prefix match
+		whereClauses = append(whereClauses, fmt.Sprintf("data ->> 'name' LIKE %s", arg(escapeLike(req.NamePrefix)+"%")))
+	}
+
+	// State filter
+	if req.State != tes.Unknown {
+		whereClauses = append(whereClauses, fmt.Sprintf("state = %s", arg(req.State.String())))
 	}
 
 	// Authorization filter
-	if userInfo := server.GetUser(ctx); !userInfo.CanSeeAllTasks() {
-		whereClauses = append(whereClauses, fmt.Sprintf("owner = $%d", paramCount))
-		args = append(args, userInfo.Username)
-		paramCount++
+	if !userInfo.CanSeeAllTasks() {
+		whereClauses = append(whereClauses, fmt.Sprintf("owner = %s", arg(userInfo.Username)))
 	}
 
 	// Tags Filter
 	for k, v := range req.GetTags() {
 		if v == "" {
 			// Check if tag key exists: `data -> 'tags' ? 'key'`
-			whereClauses = append(whereClauses, fmt.Sprintf("data -> 'tags' ? $%d", paramCount))
-			args = append(args, k)
+			whereClauses = append(whereClauses, fmt.Sprintf("data -> 'tags' ? %s", arg(k)))
 		} else {
 			// Check if tag value equals: `data -> 'tags' ->> 'key' = 'value'`
-			whereClauses = append(whereClauses, fmt.Sprintf("data -> 'tags' ->> $%d = $%d", paramCount, paramCount+1))
-			args = append(args, k, v)
-			paramCount++
+			whereClauses = append(whereClauses, fmt.Sprintf("data -> 'tags' ->> %s = %s", arg(k), arg(v)))
 		}
-		paramCount++
 	}
 
+	// Funnel task filter
+	whereClauses = append(whereClauses, queryClauses(q, arg)...)
+
 	// Page Token
+	orderByClause := "ORDER BY creation_time DESC, id DESC"
+	tokenOp := "<"
+	if q.Ascending() {
+		orderByClause = "ORDER BY creation_time ASC, id ASC"
+		tokenOp = ">"
+	}
 	if req.PageToken != "" {
-		whereClauses = append(whereClauses, fmt.Sprintf("id < $%d", paramCount))
-		args = append(args, req.PageToken)
-		paramCount++
+		whereClauses = append(whereClauses, fmt.Sprintf("id %s %s", tokenOp, arg(req.PageToken)))
 	}
 
 	whereClause := ""
@@ -123,9 +152,7 @@ func (db *Postgres) ListTasks(ctx context.Context, req *tes.ListTasksRequest) (*
 		whereClause = "WHERE " + strings.Join(whereClauses, " AND ")
 	}
 
-	orderByClause := "ORDER BY creation_time DESC, id DESC"
-	limitClause := fmt.Sprintf("LIMIT $%d", paramCount)
-	args = append(args, pageSize)
+	limitClause := fmt.Sprintf("LIMIT %s", arg(pageSize))
 
 	selectSQL := fmt.Sprintf("SELECT data FROM tasks %s %s %s", whereClause, orderByClause, limitClause)
 
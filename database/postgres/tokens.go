@@ -0,0 +1,67 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/ohsu-comp-bio/funnel/auth"
+)
+
+// PutToken creates or updates an API token.
+func (db *Postgres) PutToken(ctx context.Context, t *auth.Token) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("failed to marshal token: %w", err)
+	}
+
+	upsertSQL := `
+		INSERT INTO api_tokens (id, username, data) VALUES ($1, $2, $3)
+		ON CONFLICT (id) DO UPDATE SET username = EXCLUDED.username, data = EXCLUDED.data`
+	if _, err := db.client.Exec(ctx, upsertSQL, t.ID, t.User, data); err != nil {
+		return fmt.Errorf("failed to store token: %w", err)
+	}
+	return nil
+}
+
+// GetToken returns the API token with the given ID.
+func (db *Postgres) GetToken(ctx context.Context, id string) (*auth.Token, error) {
+	var data []byte
+	err := db.client.QueryRow(ctx, "SELECT data FROM api_tokens WHERE id = $1", id).Scan(&data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, auth.ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token: %w", err)
+	}
+	t := &auth.Token{}
+	if err := json.Unmarshal(data, t); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal token: %w", err)
+	}
+	return t, nil
+}
+
+// ListTokens returns all API tokens.
+func (db *Postgres) ListTokens(ctx context.Context) ([]*auth.Token, error) {
+	rows, err := db.client.Query(ctx, "SELECT data FROM api_tokens ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var out []*auth.Token
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to scan token: %w", err)
+		}
+		t := &auth.Token{}
+		if err := json.Unmarshal(data, t); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal token: %w", err)
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
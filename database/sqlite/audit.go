@@ -0,0 +1,79 @@
+package sqlite
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ohsu-comp-bio/funnel/audit"
+)
+
+// WriteAudit appends a record to the audit log.
+func (db *SQLite) WriteAudit(ctx context.Context, r *audit.Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit record: %w", err)
+	}
+
+	insertSQL := `INSERT INTO audit_log (time, username, action, task_id, data) VALUES (?, ?, ?, ?, ?)`
+	_, err = db.db.ExecContext(ctx, insertSQL, r.Time.UnixNano(), r.User, r.Action, r.TaskID, string(data))
+	if err != nil {
+		return fmt.Errorf("failed to insert audit record: %w", err)
+	}
+	return nil
+}
+
+// ListAudit returns audit records matching the filter, most recent first.
+func (db *SQLite) ListAudit(ctx context.Context, f *audit.Filter) ([]*audit.Record, error) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, cond)
+	}
+	if f.User != "" {
+		add("username = ?", f.User)
+	}
+	if f.TaskID != "" {
+		add("task_id = ?", f.TaskID)
+	}
+	if f.Action != "" {
+		add("action = ?", f.Action)
+	}
+	if !f.Since.IsZero() {
+		add("time >= ?", f.Since.UnixNano())
+	}
+	if !f.Until.IsZero() {
+		add("time <= ?", f.Until.UnixNano())
+	}
+
+	selectSQL := "SELECT data FROM audit_log"
+	if len(where) > 0 {
+		selectSQL += " WHERE " + strings.Join(where, " AND ")
+	}
+	selectSQL += " ORDER BY id DESC"
+	if f.Limit > 0 {
+		selectSQL += fmt.Sprintf(" LIMIT %d", f.Limit)
+	}
+
+	rows, err := db.db.QueryContext(ctx, selectSQL, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var out []*audit.Record
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to scan audit record: %w", err)
+		}
+		r := &audit.Record{}
+		if err := json.Unmarshal([]byte(data), r); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal audit record: %w", err)
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
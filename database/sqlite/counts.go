@@ -0,0 +1,25 @@
+package sqlite
+
+import (
+	"context"
+)
+
+// TaskStateCounts returns the number of tasks in each state.
+func (db *SQLite) TaskStateCounts(ctx context.Context) (map[string]int32, error) {
+	rows, err := db.db.QueryContext(ctx, "SELECT state, COUNT(*) FROM tasks GROUP BY state")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int32{}
+	for rows.Next() {
+		var state string
+		var count int32
+		if err := rows.Scan(&state, &count); err != nil {
+			return nil, err
+		}
+		counts[state] = count
+	}
+	return counts, rows.Err()
+}
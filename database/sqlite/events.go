@@ -0,0 +1,105 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/events"
+	"github.com/ohsu-comp-bio/funnel/server"
+	"github.com/ohsu-comp-bio/funnel/tes"
+)
+
+// WriteEvent creates an event for the server to handle. The events written
+// again, e.g. retried by a worker, are dropped: the IDs of the events written
+// are recorded with the update of their task.
+func (db *SQLite) WriteEvent(ctx context.Context, req *events.Event) error {
+	if req.Type == events.Type_TASK_CREATED {
+		return db.insertTask(ctx, req.GetTask(), server.GetUsername(ctx))
+	}
+
+	return db.transaction(ctx, func(tx *sql.Tx) error {
+		// The events written again, e.g. retried by a worker, are dropped.
+		if req.EventId != "" {
+			res, err := tx.ExecContext(ctx,
+				"INSERT INTO event_ids (event_id, task_id) VALUES (?, ?) ON CONFLICT DO NOTHING",
+				req.EventId, req.Id)
+			if err != nil {
+				return err
+			}
+			if n, err := res.RowsAffected(); err != nil || n == 0 {
+				return err
+			}
+		}
+
+		var owner, state, data string
+		err := tx.QueryRowContext(ctx, "SELECT owner, state, data FROM tasks WHERE id = ?", req.Id).
+			Scan(&owner, &state, &data)
+		if err == sql.ErrNoRows {
+			return tes.ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		task, err := unmarshalTask(data, state)
+		if err != nil {
+			return err
+		}
+
+		switch req.Type {
+		case events.Type_TASK_STATE, events.Type_TASK_RESOURCES:
+			if !server.GetUser(ctx).IsAccessible(owner) {
+				return tes.ErrNotPermitted
+			}
+		}
+
+		if req.Type == events.Type_TASK_RESOURCES {
+			r := req.GetResources()
+			if task.Resources == nil {
+				task.Resources = &tes.Resources{}
+			}
+			task.Resources.CpuCores = r.CpuCores
+			task.Resources.RamGb = r.RamGb
+			task.Resources.DiskGb = r.DiskGb
+			task.Resources.Preemptible = r.Preemptible
+			task.Resources.BackendParameters = r.BackendParameters
+			task.Resources.Zones = r.Zones
+		} else if err := (events.TaskBuilder{Task: task}).WriteEvent(ctx, req); err != nil {
+			return err
+		}
+
+		b, err := marshaler.Marshal(task)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE tasks SET state = ?, version = ?, data = ? WHERE id = ?",
+			task.State.String(), time.Now().UnixNano(), string(b), req.Id)
+		return err
+	})
+}
+
+func (db *SQLite) insertTask(ctx context.Context, task *tes.Task, owner string) error {
+	// Imported tasks keep their creation time.
+	created := time.Now()
+	if task.CreationTime == "" {
+		task.CreationTime = created.Format(time.RFC3339Nano)
+	} else if t, err := time.Parse(time.RFC3339Nano, task.CreationTime); err == nil {
+		created = t
+	}
+	task.State = tes.State_QUEUED
+
+	b, err := marshaler.Marshal(task)
+	if err != nil {
+		return fmt.Errorf("marshaling task: %v", err)
+	}
+
+	_, err = db.db.ExecContext(ctx,
+		"INSERT INTO tasks (id, state, owner, creation_time, version, data) VALUES (?, ?, ?, ?, ?, ?)",
+		task.Id, task.State.String(), owner, created.UnixNano(), time.Now().UnixNano(), string(b))
+	if err != nil {
+		return fmt.Errorf("storing task: %v", err)
+	}
+	return nil
+}
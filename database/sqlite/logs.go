@@ -0,0 +1,58 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ohsu-comp-bio/funnel/logs"
+)
+
+// WriteChunk stores a chunk of an executor log.
+func (db *SQLite) WriteChunk(ctx context.Context, c *logs.Chunk) error {
+	upsertSQL := `
+		INSERT INTO log_chunks (task_id, attempt, executor, stream, start, data) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (task_id, attempt, executor, stream, start) DO UPDATE SET data = excluded.data`
+	_, err := db.db.ExecContext(ctx, upsertSQL, c.Id, c.Attempt, c.Executor, c.Stream.String(), c.Offset, c.Data)
+	if err != nil {
+		return fmt.Errorf("failed to store log chunk: %w", err)
+	}
+	return nil
+}
+
+// ReadChunks returns the chunks of a log which overlap the range of size
+// bytes at offset.
+func (db *SQLite) ReadChunks(ctx context.Context, key logs.Key, offset, size int64) ([]*logs.Chunk, error) {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT start, data FROM log_chunks
+		WHERE task_id = ? AND attempt = ? AND executor = ? AND stream = ?
+			AND start < ? AND start + length(data) > ?
+		ORDER BY start`,
+		key.ID, key.Attempt, key.Executor, key.Stream.String(), offset+size, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read log chunks: %w", err)
+	}
+	defer rows.Close()
+
+	var chunks []*logs.Chunk
+	for rows.Next() {
+		c := &logs.Chunk{Id: key.ID, Attempt: key.Attempt, Executor: key.Executor, Stream: key.Stream}
+		if err := rows.Scan(&c.Offset, &c.Data); err != nil {
+			return nil, fmt.Errorf("failed to read log chunk: %w", err)
+		}
+		chunks = append(chunks, c)
+	}
+	return chunks, rows.Err()
+}
+
+// Size returns the end of the last chunk of a log.
+func (db *SQLite) Size(ctx context.Context, key logs.Key) (int64, error) {
+	var size int64
+	err := db.db.QueryRowContext(ctx, `
+		SELECT COALESCE(MAX(start + length(data)), 0) FROM log_chunks
+		WHERE task_id = ? AND attempt = ? AND executor = ? AND stream = ?`,
+		key.ID, key.Attempt, key.Executor, key.Stream.String()).Scan(&size)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get log size: %w", err)
+	}
+	return size, nil
+}
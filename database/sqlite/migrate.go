@@ -0,0 +1,123 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/database/migrate"
+)
+
+// The up-migrations of the schema, applied in the order of their versions.
+// A released migration must never change: add a new one instead.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+const createMigrationsTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at INTEGER NOT NULL
+);
+`
+
+// Migrations returns the schema migrations, applied or pending.
+func (db *SQLite) Migrations(ctx context.Context) ([]*migrate.Migration, error) {
+	files, err := migrate.ReadFiles(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(ctx, db.db)
+	if err != nil {
+		return nil, err
+	}
+	var known []*migrate.Migration
+	for _, f := range files {
+		known = append(known, &f.Migration)
+	}
+	return migrate.Merge(known, applied), nil
+}
+
+// Migrate applies the pending schema migrations, each in a transaction. The
+// lock of the file keeps the servers starting together from applying them
+// twice.
+func (db *SQLite) Migrate(ctx context.Context) ([]*migrate.Migration, error) {
+	files, err := migrate.ReadFiles(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.db.ExecContext(ctx, createMigrationsTable); err != nil {
+		return nil, fmt.Errorf("creating the schema_migrations table: %v", err)
+	}
+
+	var migrated []*migrate.Migration
+	for _, f := range files {
+		applied := false
+		err := db.transaction(ctx, func(tx *sql.Tx) error {
+			var n int
+			err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM schema_migrations WHERE version = ?", f.Version).Scan(&n)
+			if err != nil || n > 0 {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, f.SQL); err != nil {
+				return err
+			}
+			f.Applied = time.Now()
+			_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+				f.Version, f.Name, f.Applied.UnixNano())
+			applied = err == nil
+			return err
+		})
+		if err != nil {
+			return migrated, fmt.Errorf("applying schema migration %s: %v", f.String(), err)
+		}
+		if applied {
+			migrated = append(migrated, &f.Migration)
+		}
+	}
+	return migrated, nil
+}
+
+// appliedMigrations returns the applied migrations, none if the migrations
+// table doesn't exist yet.
+func appliedMigrations(ctx context.Context, q *sql.DB) ([]*migrate.Migration, error) {
+	var exists int
+	err := q.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'").Scan(&exists)
+	if err != nil || exists == 0 {
+		return nil, err
+	}
+
+	rows, err := q.QueryContext(ctx, "SELECT version, name, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var applied []*migrate.Migration
+	for rows.Next() {
+		m := &migrate.Migration{}
+		var at int64
+		if err := rows.Scan(&m.Version, &m.Name, &at); err != nil {
+			return nil, err
+		}
+		m.Applied = time.Unix(0, at)
+		applied = append(applied, m)
+	}
+	return applied, rows.Err()
+}
+
+// transaction runs f in a transaction, committed if f succeeds.
+func (db *SQLite) transaction(ctx context.Context, f func(tx *sql.Tx) error) error {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,40 @@
+-- The tables of the Postgres schema, in SQLite. The times are Unix nanoseconds,
+-- and the data columns are the protojson of the tasks and nodes.
+
+CREATE TABLE IF NOT EXISTS tasks (
+	id TEXT PRIMARY KEY,
+	state TEXT NOT NULL,
+	owner TEXT NOT NULL DEFAULT '',
+	creation_time INTEGER NOT NULL,
+	version INTEGER NOT NULL DEFAULT 0,
+	data TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS nodes (
+	id TEXT PRIMARY KEY,
+	state TEXT NOT NULL,
+	version INTEGER NOT NULL DEFAULT 0,
+	data TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	time INTEGER NOT NULL,
+	username TEXT,
+	action TEXT NOT NULL,
+	task_id TEXT,
+	data TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id TEXT PRIMARY KEY,
+	username TEXT NOT NULL,
+	data TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_tasks_state ON tasks (state);
+CREATE INDEX IF NOT EXISTS idx_tasks_owner ON tasks (owner);
+CREATE INDEX IF NOT EXISTS idx_tasks_creation_time ON tasks (creation_time DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_tasks_name ON tasks (json_extract(data, '$.name'));
+CREATE INDEX IF NOT EXISTS idx_audit_log_time ON audit_log (time DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_log_task_id ON audit_log (task_id);
@@ -0,0 +1,111 @@
+// Package sqlite contains the SQLite database backend, a single file for the
+// deployments on a single host.
+package sqlite
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sync"
+
+	"github.com/ohsu-comp-bio/funnel/compute/scheduler"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/util/fsutil"
+	"modernc.org/sqlite"
+)
+
+// SQLite provides a task database based on an SQLite file.
+type SQLite struct {
+	scheduler.UnimplementedSchedulerServiceServer
+	db   *sql.DB
+	conf *config.SQLite
+}
+
+// The connections wait for the writer holding the lock of the file, and
+// the writes run in IMMEDIATE transactions, so that a transaction reading
+// before writing doesn't fail to upgrade its lock.
+var connParams = url.Values{
+	"_pragma": {"busy_timeout(10000)", "journal_mode(WAL)", "synchronous(NORMAL)"},
+	"_txlock": {"immediate"},
+}
+
+// NewSQLite opens the database file.
+func NewSQLite(conf *config.SQLite) (*SQLite, error) {
+	if err := fsutil.EnsurePath(conf.Path); err != nil {
+		return nil, fmt.Errorf("creating database directory: %v", err)
+	}
+	db, err := sql.Open("sqlite", conf.Path+"?"+connParams.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("opening database: %v", err)
+	}
+	return &SQLite{db: db, conf: conf}, nil
+}
+
+// Init creates or upgrades the tables.
+func (db *SQLite) Init() error {
+	_, err := db.Migrate(context.Background())
+	return err
+}
+
+// Close closes the database file.
+func (db *SQLite) Close() {
+	db.db.Close()
+}
+
+// The regular expressions of the task filter use the Go syntax, like those
+// of the embedded databases. The SQL function is called for each row, so the
+// last patterns are kept compiled.
+var regexps = newRegexpCache(64)
+
+func init() {
+	sqlite.MustRegisterDeterministicScalarFunction("regexp", 2, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		pattern, _ := args[0].(string)
+		value, ok := args[1].(string)
+		if !ok {
+			return false, nil
+		}
+		re, err := regexps.get(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString(value), nil
+	})
+}
+
+// regexpCache keeps the compiled regular expressions of the last patterns
+// used.
+type regexpCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List // of *regexp.Regexp, the most recently used first
+	byPat map[string]*list.Element
+}
+
+func newRegexpCache(size int) *regexpCache {
+	return &regexpCache{size: size, order: list.New(), byPat: map[string]*list.Element{}}
+}
+
+// get returns the compiled pattern, evicting the least recently used one
+// when the cache is full.
+func (c *regexpCache) get(pattern string) (*regexp.Regexp, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.byPat[pattern]; ok {
+		c.order.MoveToFront(e)
+		return e.Value.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	c.byPat[pattern] = c.order.PushFront(re)
+	if c.order.Len() > c.size {
+		last := c.order.Remove(c.order.Back()).(*regexp.Regexp)
+		delete(c.byPat, last.String())
+	}
+	return re, nil
+}
@@ -0,0 +1,118 @@
+package sqlite
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/query"
+)
+
+// queryClauses translates the task filter to the WHERE clauses of the tasks
+// table. arg adds a parameter of the statement, and returns its placeholder.
+func queryClauses(q *query.Query, arg func(interface{}) string) []string {
+	if q == nil {
+		return nil
+	}
+	var clauses []string
+
+	if len(q.States) > 0 {
+		var states []string
+		for _, s := range q.States {
+			states = append(states, arg(s.String()))
+		}
+		clauses = append(clauses, fmt.Sprintf("state IN (%s)", strings.Join(states, ", ")))
+	}
+
+	if len(q.Owners) > 0 {
+		var owners []string
+		for _, o := range q.Owners {
+			owners = append(owners, arg(o))
+		}
+		clauses = append(clauses, fmt.Sprintf("owner IN (%s)", strings.Join(owners, ", ")))
+	}
+
+	// The creation times are stored as Unix nanoseconds.
+	if c := rangeClause("creation_time", q.Created, func(t time.Time) string {
+		return arg(t.UnixNano())
+	}); c != "" {
+		clauses = append(clauses, c)
+	}
+
+	// The times of the attempts are only in the task logs.
+	for _, log := range []struct {
+		field string
+		r     query.Range
+	}{{"start_time", q.Started}, {"end_time", q.Ended}} {
+		if log.r.Empty() {
+			continue
+		}
+		c := rangeClause(fmt.Sprintf("julianday(NULLIF(json_extract(l.value, '$.%s'), ''))", log.field), log.r,
+			func(t time.Time) string {
+				return fmt.Sprintf("julianday(%s)", arg(t.UTC().Format(time.RFC3339Nano)))
+			})
+		clauses = append(clauses, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM json_each(data, '$.logs') AS l WHERE %s)", c))
+	}
+
+	for _, m := range q.Tags {
+		clauses = append(clauses, matchClause(tagExpr(m, arg), m, arg))
+	}
+
+	if q.Scope != nil {
+		conds := []string{fmt.Sprintf("owner = %s", arg(q.Scope.Owner))}
+		for _, m := range q.Scope.Tags {
+			conds = append(conds, matchClause(tagExpr(m, arg), m, arg))
+		}
+		clauses = append(clauses, fmt.Sprintf("(%s)", strings.Join(conds, " OR ")))
+	}
+
+	for _, m := range q.Images {
+		clauses = append(clauses, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM json_each(data, '$.executors') AS e WHERE %s)",
+			matchClause("json_extract(e.value, '$.image')", m, arg)))
+	}
+
+	return clauses
+}
+
+func tagExpr(m *query.Match, arg func(interface{}) string) string {
+	return fmt.Sprintf("json_extract(data, '$.tags.' || json_quote(%s))", arg(m.Key))
+}
+
+// rangeClause compares expr to the bounds of the range, which value returns
+// as SQL expressions.
+func rangeClause(expr string, r query.Range, value func(time.Time) string) string {
+	var conds []string
+	if r.Min != nil {
+		op := ">"
+		if r.Min.Inclusive {
+			op = ">="
+		}
+		conds = append(conds, fmt.Sprintf("%s %s %s", expr, op, value(r.Min.Time)))
+	}
+	if r.Max != nil {
+		op := "<"
+		if r.Max.Inclusive {
+			op = "<="
+		}
+		conds = append(conds, fmt.Sprintf("%s %s %s", expr, op, value(r.Max.Time)))
+	}
+	return strings.Join(conds, " AND ")
+}
+
+func matchClause(expr string, m *query.Match, arg func(interface{}) string) string {
+	switch m.Op {
+	case query.Prefix:
+		return fmt.Sprintf("%s GLOB %s", expr, arg(escapeGlob(m.Value)+"*"))
+	case query.Regex:
+		return fmt.Sprintf("%s REGEXP %s", expr, arg(m.Value))
+	}
+	return fmt.Sprintf("%s = %s", expr, arg(m.Value))
+}
+
+// escapeGlob escapes the wildcards of a GLOB pattern, which is case
+// sensitive, unlike LIKE.
+func escapeGlob(s string) string {
+	return strings.NewReplacer(`*`, `[*]`, `?`, `[?]`, `[`, `[[]`).Replace(s)
+}
@@ -0,0 +1,140 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/ohsu-comp-bio/funnel/compute/scheduler"
+	"github.com/ohsu-comp-bio/funnel/logger"
+	"github.com/ohsu-comp-bio/funnel/tes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ReadQueue returns a slice of queued Tasks. Up to "n" tasks are returned.
+func (db *SQLite) ReadQueue(n int) []*tes.Task {
+	log := logger.NewLogger("sqlite", logger.DefaultConfig())
+
+	rows, err := db.db.Query("SELECT state, data FROM tasks WHERE state = ? ORDER BY creation_time ASC, id ASC LIMIT ?",
+		tes.State_QUEUED.String(), n)
+	if err != nil {
+		log.Error("reading queue", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var tasks []*tes.Task
+	for rows.Next() {
+		var state, data string
+		if err := rows.Scan(&state, &data); err != nil {
+			log.Error("reading queue", err)
+			continue
+		}
+		task, err := unmarshalTask(data, state)
+		if err != nil {
+			log.Error("reading queue", err)
+			continue
+		}
+		tasks = append(tasks, task)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error("reading queue", err)
+	}
+	return tasks
+}
+
+// PutNode put a node object into the database.
+//
+// For optimisic locking, if the node already exists and node.Version
+// doesn't match the version in the database, an error is returned.
+func (db *SQLite) PutNode(ctx context.Context, node *scheduler.Node) (*scheduler.PutNodeResponse, error) {
+	err := db.transaction(ctx, func(tx *sql.Tx) error {
+		existing := &scheduler.Node{}
+		var data string
+		err := tx.QueryRowContext(ctx, "SELECT data FROM nodes WHERE id = ?", node.Id).Scan(&data)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if err == nil {
+			if err := unmarshaler.Unmarshal([]byte(data), existing); err != nil {
+				return fmt.Errorf("unmarshaling node: %v", err)
+			}
+		}
+
+		if existing.GetVersion() != 0 && node.Version != existing.GetVersion() {
+			return fmt.Errorf("Version outdated")
+		}
+
+		if err := scheduler.UpdateNode(ctx, db, node, existing); err != nil {
+			return err
+		}
+
+		b, err := marshaler.Marshal(node)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO nodes (id, state, version, data) VALUES (?, ?, ?, ?)
+			ON CONFLICT (id) DO UPDATE SET state = excluded.state, version = excluded.version, data = excluded.data`,
+			node.Id, node.State.String(), node.Version, string(b))
+		return err
+	})
+	return &scheduler.PutNodeResponse{}, err
+}
+
+// GetNode gets a node
+func (db *SQLite) GetNode(ctx context.Context, req *scheduler.GetNodeRequest) (*scheduler.Node, error) {
+	var data string
+	err := db.db.QueryRowContext(ctx, "SELECT data FROM nodes WHERE id = ?", req.Id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, status.Errorf(codes.NotFound, "nodeID: %s not found", req.Id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	node := &scheduler.Node{}
+	if err := unmarshaler.Unmarshal([]byte(data), node); err != nil {
+		return nil, fmt.Errorf("unmarshaling node: %v", err)
+	}
+	return node, nil
+}
+
+// DeleteNode deletes a node
+func (db *SQLite) DeleteNode(ctx context.Context, req *scheduler.Node) (*scheduler.DeleteNodeResponse, error) {
+	res, err := db.db.ExecContext(ctx, "DELETE FROM nodes WHERE id = ?", req.Id)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return nil, status.Errorf(codes.NotFound, "nodeID: %s not found", req.Id)
+	}
+	return &scheduler.DeleteNodeResponse{}, nil
+}
+
+// ListNodes is an API endpoint that returns a list of nodes.
+func (db *SQLite) ListNodes(ctx context.Context, req *scheduler.ListNodesRequest) (*scheduler.ListNodesResponse, error) {
+	rows, err := db.db.QueryContext(ctx, "SELECT data FROM nodes ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var nodes []*scheduler.Node
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		node := &scheduler.Node{}
+		if err := unmarshaler.Unmarshal([]byte(data), node); err != nil {
+			return nil, fmt.Errorf("unmarshaling node: %v", err)
+		}
+		nodes = append(nodes, node)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &scheduler.ListNodesResponse{Nodes: nodes}, nil
+}
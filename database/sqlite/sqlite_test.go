@@ -0,0 +1,338 @@
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/compute/scheduler"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/events"
+	"github.com/ohsu-comp-bio/funnel/logs"
+	"github.com/ohsu-comp-bio/funnel/query"
+	"github.com/ohsu-comp-bio/funnel/server"
+	"github.com/ohsu-comp-bio/funnel/tes"
+)
+
+func getTestSQLite(t *testing.T) *SQLite {
+	db, err := NewSQLite(&config.SQLite{Path: filepath.Join(t.TempDir(), "funnel.sqlite")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func userContext(username string) context.Context {
+	return context.WithValue(context.Background(), server.UserInfoKey, &server.UserInfo{Username: username})
+}
+
+func newTestTask(id, name string, created time.Time, tags map[string]string) *tes.Task {
+	return &tes.Task{
+		Id:           id,
+		Name:         name,
+		CreationTime: created.Format(time.RFC3339Nano),
+		Tags:         tags,
+		Executors:    []*tes.Executor{{Image: "alpine:" + id, Command: []string{"echo", "hello"}}},
+	}
+}
+
+func TestTasks(t *testing.T) {
+	server.NewAuthentication(nil, nil, server.AccessOwner, nil, "")
+	defer server.NewAuthentication(nil, nil, server.AccessAll, nil, "")
+
+	db := getTestSQLite(t)
+	alice := userContext("alice")
+	bob := userContext("bob")
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, task := range []*tes.Task{
+		newTestTask("task-1", "align-1", created, map[string]string{"project": "a"}),
+		newTestTask("task-2", "align-2", created.Add(time.Hour), map[string]string{"project": "b"}),
+		newTestTask("task-3", "call*", created.Add(2*time.Hour), nil),
+	} {
+		ctx := alice
+		if i == 2 {
+			ctx = bob
+		}
+		if err := db.WriteEvent(ctx, events.NewTaskCreated(task)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, ev := range []*events.Event{
+		events.NewState("task-1", tes.Running),
+		events.NewStartTime("task-1", 0, created.Add(time.Minute)),
+		events.NewExecutorStartTime("task-1", 0, 0, created.Add(time.Minute)),
+		events.NewStdout("task-1", 0, 0, "hello\n"),
+		events.NewExitCode("task-1", 0, 0, 0),
+		events.NewSystemLog("task-1", 0, 0, "info", "executor done", nil),
+		events.NewState("task-1", tes.Complete),
+	} {
+		if err := db.WriteEvent(alice, ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.WriteEvent(alice, events.NewState("task-1", tes.Running)); err == nil {
+		t.Error("expected an error for the transition from COMPLETE to RUNNING")
+	}
+	if err := db.WriteEvent(bob, events.NewState("task-2", tes.Canceled)); err != tes.ErrNotPermitted {
+		t.Errorf("expected ErrNotPermitted, got %v", err)
+	}
+	if err := db.WriteEvent(alice, events.NewState("missing", tes.Canceled)); err != tes.ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	task, err := db.GetTask(alice, &tes.GetTaskRequest{Id: "task-1", View: tes.View_FULL.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.State != tes.Complete || task.Logs[0].Logs[0].Stdout != "hello\n" || len(task.Logs[0].SystemLogs) != 1 {
+		t.Errorf("unexpected task: %v", task)
+	}
+	if task.CreationTime != created.Format(time.RFC3339Nano) {
+		t.Errorf("unexpected creation time: %s", task.CreationTime)
+	}
+	if _, err := db.GetTask(bob, &tes.GetTaskRequest{Id: "task-1"}); err != tes.ErrNotPermitted {
+		t.Errorf("expected ErrNotPermitted, got %v", err)
+	}
+	if owner, _ := db.TaskOwner(alice, "task-3"); owner != "bob" {
+		t.Errorf("unexpected owner: %q", owner)
+	}
+
+	list := func(ctx context.Context, req *tes.ListTasksRequest, filter string) []string {
+		t.Helper()
+		if filter != "" {
+			q, err := query.Parse(filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx = query.NewContext(ctx, q)
+		}
+		resp, err := db.ListTasks(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, task := range resp.Tasks {
+			ids = append(ids, task.Id)
+		}
+		return ids
+	}
+	admin := context.Background()
+
+	for _, tc := range []struct {
+		ctx      context.Context
+		req      *tes.ListTasksRequest
+		filter   string
+		expected []string
+	}{
+		{admin, &tes.ListTasksRequest{}, "", []string{"task-3", "task-2", "task-1"}},
+		{alice, &tes.ListTasksRequest{}, "", []string{"task-2", "task-1"}},
+		{admin, &tes.ListTasksRequest{NamePrefix: "align"}, "", []string{"task-2", "task-1"}},
+		{admin, &tes.ListTasksRequest{NamePrefix: "call*"}, "", []string{"task-3"}},
+		{admin, &tes.ListTasksRequest{NamePrefix: "al*"}, "", nil},
+		{admin, &tes.ListTasksRequest{State: tes.Queued}, "", []string{"task-3", "task-2"}},
+		{admin, &tes.ListTasksRequest{TagKey: []string{"project"}, TagValue: []string{"a"}}, "", []string{"task-1"}},
+		{admin, &tes.ListTasksRequest{TagKey: []string{"project"}}, "", []string{"task-2", "task-1"}},
+		{admin, &tes.ListTasksRequest{}, "owner=bob", []string{"task-3"}},
+		{admin, &tes.ListTasksRequest{}, "state=COMPLETE,CANCELED", []string{"task-1"}},
+		{admin, &tes.ListTasksRequest{}, "created>=2024-01-01T01:00:00Z", []string{"task-3", "task-2"}},
+		{admin, &tes.ListTasksRequest{}, "started<2024-01-01T00:30:00Z", []string{"task-1"}},
+		{admin, &tes.ListTasksRequest{}, "tag.project~=^[ab]$ sort=created", []string{"task-1", "task-2"}},
+		{admin, &tes.ListTasksRequest{}, "image=alpine:task-2", []string{"task-2"}},
+	} {
+		got := list(tc.ctx, tc.req, tc.filter)
+		if len(got) != len(tc.expected) {
+			t.Errorf("%+v %q: expected %v, got %v", tc.req, tc.filter, tc.expected, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.expected[i] {
+				t.Errorf("%+v %q: expected %v, got %v", tc.req, tc.filter, tc.expected, got)
+				break
+			}
+		}
+	}
+
+	// The scope of the users with project-scoped roles: a full page of
+	// the tasks of bob, or of the project "a".
+	scoped := query.NewContext(admin, &query.Query{Scope: &query.Scope{
+		Owner: "bob",
+		Tags:  []*query.Match{{Key: "project", Op: query.Prefix, Value: "a"}},
+	}})
+	resp, err := db.ListTasks(scoped, &tes.ListTasksRequest{PageSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Tasks) != 2 || resp.Tasks[0].Id != "task-3" || resp.Tasks[1].Id != "task-1" {
+		t.Errorf("unexpected scoped tasks: %v", resp.Tasks)
+	}
+
+	// Paging
+	resp, err = db.ListTasks(admin, &tes.ListTasksRequest{PageSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Tasks) != 2 || resp.NextPageToken != "task-2" {
+		t.Fatalf("unexpected first page: %v", resp)
+	}
+	resp, err = db.ListTasks(admin, &tes.ListTasksRequest{PageSize: 2, PageToken: resp.NextPageToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Tasks) != 1 || resp.Tasks[0].Id != "task-1" || resp.NextPageToken != "" {
+		t.Errorf("unexpected second page: %v", resp)
+	}
+
+	queued := db.ReadQueue(10)
+	if len(queued) != 2 || queued[0].Id != "task-2" || queued[1].Id != "task-3" {
+		t.Errorf("unexpected queue: %v", queued)
+	}
+
+	counts, err := db.TaskStateCounts(admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts["QUEUED"] != 2 || counts["COMPLETE"] != 1 {
+		t.Errorf("unexpected counts: %v", counts)
+	}
+}
+
+func TestEventDedup(t *testing.T) {
+	server.NewAuthentication(nil, nil, server.AccessOwner, nil, "")
+	defer server.NewAuthentication(nil, nil, server.AccessAll, nil, "")
+
+	alice, bob := userContext("alice"), userContext("bob")
+	db := getTestSQLite(t)
+	if err := db.WriteEvent(alice, events.NewTaskCreated(newTestTask("task-1", "", time.Now(), nil))); err != nil {
+		t.Fatal(err)
+	}
+
+	// A retried event is written once.
+	syslog := events.NewSystemLog("task-1", 0, 0, "info", "retried", nil)
+	for i := 0; i < 2; i++ {
+		if err := db.WriteEvent(alice, syslog); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A failed event is written when it's retried.
+	running := events.NewState("task-1", tes.Running)
+	if err := db.WriteEvent(bob, running); err != tes.ErrNotPermitted {
+		t.Errorf("expected ErrNotPermitted, got %v", err)
+	}
+	if err := db.WriteEvent(alice, running); err != nil {
+		t.Fatal(err)
+	}
+
+	task, err := db.GetTask(alice, &tes.GetTaskRequest{Id: "task-1", View: tes.View_FULL.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.State != tes.Running || len(task.Logs[0].SystemLogs) != 1 {
+		t.Errorf("unexpected task: %v", task)
+	}
+}
+
+func TestNodes(t *testing.T) {
+	db := getTestSQLite(t)
+	ctx := context.Background()
+
+	node := &scheduler.Node{
+		Id:        "node-1",
+		Resources: &scheduler.Resources{Cpus: 4, RamGb: 8},
+		Metadata:  map[string]string{"zone": "a"},
+	}
+	if _, err := db.PutNode(ctx, node); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.GetNode(ctx, &scheduler.GetNodeRequest{Id: "node-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Available.GetCpus() != 4 || got.LastPing == 0 {
+		t.Errorf("unexpected node: %v", got)
+	}
+
+	got.Version = 2
+	got.Metadata = map[string]string{"rack": "1"}
+	if _, err := db.PutNode(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = db.GetNode(ctx, &scheduler.GetNodeRequest{Id: "node-1"})
+	if got.Metadata["zone"] != "a" || got.Metadata["rack"] != "1" {
+		t.Errorf("expected the metadata to be merged: %v", got.Metadata)
+	}
+
+	got.Version = 1
+	if _, err := db.PutNode(ctx, got); err == nil {
+		t.Error("expected an error for an outdated version")
+	}
+
+	resp, err := db.ListNodes(ctx, &scheduler.ListNodesRequest{})
+	if err != nil || len(resp.Nodes) != 1 {
+		t.Errorf("unexpected nodes: %v %v", resp, err)
+	}
+
+	if _, err := db.DeleteNode(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetNode(ctx, &scheduler.GetNodeRequest{Id: "node-1"}); err == nil {
+		t.Error("expected the node to be deleted")
+	}
+}
+
+func TestLogChunks(t *testing.T) {
+	ctx := context.Background()
+	db := getTestSQLite(t)
+	key := logs.Key{ID: "task-1", Executor: 1, Stream: logs.Stream_STDERR}
+
+	for i, data := range []string{"hello ", "world", "!"} {
+		c := &logs.Chunk{Id: "task-1", Executor: 1, Stream: logs.Stream_STDERR, Offset: []int64{0, 6, 11}[i], Data: []byte(data)}
+		if err := db.WriteChunk(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A retried write replaces the chunk.
+	if err := db.WriteChunk(ctx, &logs.Chunk{Id: "task-1", Executor: 1, Stream: logs.Stream_STDERR, Offset: 6, Data: []byte("world")}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := logs.Read(ctx, db, &logs.ReadLogsRequest{Id: "task-1", Executor: 1, Stream: logs.Stream_STDERR, Offset: 3, Limit: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Data) != "lo wor" || resp.NextOffset != 9 || resp.Size != 12 {
+		t.Errorf("unexpected response: %q %d %d", resp.Data, resp.NextOffset, resp.Size)
+	}
+
+	if size, err := db.Size(ctx, logs.Key{ID: "task-1", Executor: 1}); err != nil || size != 0 {
+		t.Errorf("expected an empty stdout, got %d %v", size, err)
+	}
+	chunks, err := db.ReadChunks(ctx, key, 11, 100)
+	if err != nil || len(chunks) != 1 || string(chunks[0].Data) != "!" {
+		t.Errorf("unexpected chunks: %v %v", chunks, err)
+	}
+}
+
+func TestRegexpCache(t *testing.T) {
+	c := newRegexpCache(2)
+	for _, pattern := range []string{"^a", "^b", "^a", "^c"} {
+		if _, err := c.get(pattern); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// "^b" is the least recently used pattern.
+	if _, ok := c.byPat["^b"]; ok || len(c.byPat) != 2 || c.order.Len() != 2 {
+		t.Errorf("unexpected cached patterns: %v", c.byPat)
+	}
+	if _, err := c.get("("); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
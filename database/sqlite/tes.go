@@ -0,0 +1,156 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/ohsu-comp-bio/funnel/query"
+	"github.com/ohsu-comp-bio/funnel/server"
+	"github.com/ohsu-comp-bio/funnel/tes"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// The data columns use the field names of the protos, like the JSON of the
+// Postgres columns.
+var (
+	marshaler   = protojson.MarshalOptions{UseProtoNames: true}
+	unmarshaler = protojson.UnmarshalOptions{DiscardUnknown: true}
+)
+
+func unmarshalTask(data string, state string) (*tes.Task, error) {
+	task := &tes.Task{}
+	if err := unmarshaler.Unmarshal([]byte(data), task); err != nil {
+		return nil, fmt.Errorf("unmarshaling task: %v", err)
+	}
+	task.State = tes.State(tes.State_value[state])
+	return task, nil
+}
+
+func taskView(task *tes.Task, view string) *tes.Task {
+	switch view {
+	case tes.View_BASIC.String():
+		return task.GetBasicView()
+	case tes.View_FULL.String():
+		return task
+	}
+	return task.GetMinimalView()
+}
+
+// GetTask gets a task.
+func (db *SQLite) GetTask(ctx context.Context, req *tes.GetTaskRequest) (*tes.Task, error) {
+	var owner, state, data string
+	err := db.db.QueryRowContext(ctx, "SELECT owner, state, data FROM tasks WHERE id = ?", req.Id).
+		Scan(&owner, &state, &data)
+	if err == sql.ErrNoRows {
+		return nil, tes.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !server.GetUser(ctx).IsAccessible(owner) {
+		return nil, tes.ErrNotPermitted
+	}
+
+	task, err := unmarshalTask(data, state)
+	if err != nil {
+		return nil, err
+	}
+	return taskView(task, req.View), nil
+}
+
+// TaskOwner returns the owner of a task.
+func (db *SQLite) TaskOwner(ctx context.Context, id string) (string, error) {
+	var owner string
+	err := db.db.QueryRowContext(ctx, "SELECT owner FROM tasks WHERE id = ?", id).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return "", tes.ErrNotFound
+	}
+	return owner, err
+}
+
+// ListTasks returns a list of tasks.
+func (db *SQLite) ListTasks(ctx context.Context, req *tes.ListTasksRequest) (*tes.ListTasksResponse, error) {
+	userInfo := server.GetUser(ctx)
+	q := query.FromContext(ctx)
+	pageSize := tes.GetPageSize(req.GetPageSize())
+
+	var args []interface{}
+	var whereClauses []string
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("?%d", len(args))
+	}
+
+	if req.NamePrefix != "" {
+		whereClauses = append(whereClauses, fmt.Sprintf("json_extract(data, '$.name') GLOB %s", arg(escapeGlob(req.NamePrefix)+"*")))
+	}
+
+	if req.State != tes.Unknown {
+		whereClauses = append(whereClauses, fmt.Sprintf("state = %s", arg(req.State.String())))
+	}
+
+	if !userInfo.CanSeeAllTasks() {
+		whereClauses = append(whereClauses, fmt.Sprintf("owner = %s", arg(userInfo.Username)))
+	}
+
+	for k, v := range req.GetTags() {
+		tag := fmt.Sprintf("json_extract(data, '$.tags.' || json_quote(%s))", arg(k))
+		if v == "" {
+			whereClauses = append(whereClauses, fmt.Sprintf("%s IS NOT NULL", tag))
+		} else {
+			whereClauses = append(whereClauses, fmt.Sprintf("%s = %s", tag, arg(v)))
+		}
+	}
+
+	whereClauses = append(whereClauses, queryClauses(q, arg)...)
+
+	// The page token is the ID of the last task of the previous page.
+	order := "DESC"
+	tokenOp := "<"
+	if q.Ascending() {
+		order = "ASC"
+		tokenOp = ">"
+	}
+	if req.PageToken != "" {
+		whereClauses = append(whereClauses, fmt.Sprintf(
+			"(creation_time, id) %s (SELECT creation_time, id FROM tasks WHERE id = %s)", tokenOp, arg(req.PageToken)))
+	}
+
+	whereClause := ""
+	if len(whereClauses) > 0 {
+		whereClause = "WHERE " + strings.Join(whereClauses, " AND ")
+	}
+
+	selectSQL := fmt.Sprintf("SELECT state, data FROM tasks %s ORDER BY creation_time %s, id %s LIMIT %s",
+		whereClause, order, order, arg(pageSize))
+
+	rows, err := db.db.QueryContext(ctx, selectSQL, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []*tes.Task
+	for rows.Next() {
+		var state, data string
+		if err := rows.Scan(&state, &data); err != nil {
+			return nil, err
+		}
+		task, err := unmarshalTask(data, state)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, taskView(task, req.View))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	out := &tes.ListTasksResponse{Tasks: tasks}
+	if len(tasks) == pageSize {
+		out.NextPageToken = tasks[len(tasks)-1].Id
+	}
+	return out, nil
+}
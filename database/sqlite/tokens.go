@@ -0,0 +1,67 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/ohsu-comp-bio/funnel/auth"
+)
+
+// PutToken creates or updates an API token.
+func (db *SQLite) PutToken(ctx context.Context, t *auth.Token) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("failed to marshal token: %w", err)
+	}
+
+	upsertSQL := `
+		INSERT INTO api_tokens (id, username, data) VALUES (?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET username = excluded.username, data = excluded.data`
+	if _, err := db.db.ExecContext(ctx, upsertSQL, t.ID, t.User, string(data)); err != nil {
+		return fmt.Errorf("failed to store token: %w", err)
+	}
+	return nil
+}
+
+// GetToken returns the API token with the given ID.
+func (db *SQLite) GetToken(ctx context.Context, id string) (*auth.Token, error) {
+	var data string
+	err := db.db.QueryRowContext(ctx, "SELECT data FROM api_tokens WHERE id = ?", id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, auth.ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token: %w", err)
+	}
+	t := &auth.Token{}
+	if err := json.Unmarshal([]byte(data), t); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal token: %w", err)
+	}
+	return t, nil
+}
+
+// ListTokens returns all API tokens.
+func (db *SQLite) ListTokens(ctx context.Context) ([]*auth.Token, error) {
+	rows, err := db.db.QueryContext(ctx, "SELECT data FROM api_tokens ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var out []*auth.Token
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to scan token: %w", err)
+		}
+		t := &auth.Token{}
+		if err := json.Unmarshal([]byte(data), t); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal token: %w", err)
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,77 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ohsu-comp-bio/funnel/compute/scheduler"
+	"github.com/ohsu-comp-bio/funnel/tes"
+)
+
+// The number of tasks read at a time, and between checkpoints.
+const pageSize = 100
+
+// OwnerReader is implemented by the databases which record the owners of the
+// tasks. The tasks of the other databases are exported without owners.
+type OwnerReader interface {
+	TaskOwner(ctx context.Context, id string) (string, error)
+}
+
+// Export reads the full tasks, then the nodes, of the database and writes
+// them to w, starting from the checkpoint. save is called with the updated
+// checkpoint after each page of tasks, and after the nodes.
+func Export(ctx context.Context, db tes.ReadOnlyServer, w Writer, cp *Checkpoint, save func(*Checkpoint) error) error {
+	owners, _ := db.(OwnerReader)
+
+	for !cp.TasksDone {
+		resp, err := db.ListTasks(ctx, &tes.ListTasksRequest{
+			View:      tes.View_FULL.String(),
+			PageSize:  pageSize,
+			PageToken: cp.PageToken,
+		})
+		if err != nil {
+			return fmt.Errorf("listing tasks: %v", err)
+		}
+
+		for _, task := range resp.Tasks {
+			r := &Record{Task: task}
+			if owners != nil {
+				r.Owner, err = owners.TaskOwner(ctx, task.Id)
+				if err != nil {
+					return fmt.Errorf("getting the owner of task %s: %v", task.Id, err)
+				}
+			}
+			if err := w.WriteRecord(ctx, r); err != nil {
+				return fmt.Errorf("writing task %s: %v", task.Id, err)
+			}
+			cp.Tasks++
+		}
+
+		cp.PageToken = resp.NextPageToken
+		cp.TasksDone = resp.NextPageToken == ""
+		if err := save(cp); err != nil {
+			return fmt.Errorf("saving checkpoint: %v", err)
+		}
+	}
+
+	if cp.Done {
+		return nil
+	}
+	if nodes, ok := db.(scheduler.SchedulerServiceServer); ok {
+		resp, err := nodes.ListNodes(ctx, &scheduler.ListNodesRequest{})
+		if err != nil {
+			return fmt.Errorf("listing nodes: %v", err)
+		}
+		for _, node := range resp.Nodes {
+			if err := w.WriteRecord(ctx, &Record{Node: node}); err != nil {
+				return fmt.Errorf("writing node %s: %v", node.Id, err)
+			}
+			cp.Nodes++
+		}
+	}
+	cp.Done = true
+	if err := save(cp); err != nil {
+		return fmt.Errorf("saving checkpoint: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/compute/scheduler"
+	"github.com/ohsu-comp-bio/funnel/events"
+	"github.com/ohsu-comp-bio/funnel/server"
+	"github.com/ohsu-comp-bio/funnel/tes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Importer writes records to a database: the tasks are replayed as events,
+// the nodes are put. The tasks and nodes already in the database are
+// skipped, and the tasks partially written are completed, so that an
+// interrupted import may run again.
+type Importer struct {
+	// The events writer of the database.
+	Events events.Writer
+	// The tasks of the database.
+	Tasks tes.ReadOnlyServer
+	// The nodes of the database, nil if it doesn't store nodes.
+	Nodes scheduler.SchedulerServiceServer
+	// The number of tasks and nodes skipped.
+	Skipped int
+}
+
+// WriteRecord writes a task or a node to the database.
+func (imp *Importer) WriteRecord(ctx context.Context, r *Record) error {
+	if r.Node != nil {
+		return imp.putNode(ctx, r.Node)
+	}
+
+	task := r.Task
+	evs := TaskEvents(task)
+	existing, err := imp.Tasks.GetTask(ctx, &tes.GetTaskRequest{Id: task.Id, View: tes.View_FULL.String()})
+	if err == nil {
+		// The import of the task may have been interrupted.
+		evs = missingEvents(existing, evs)
+		if len(evs) == 0 {
+			imp.Skipped++
+			return nil
+		}
+	} else if !isNotFound(err) {
+		return fmt.Errorf("getting task %s: %v", task.Id, err)
+	}
+
+	// The writers record the user of the TASK_CREATED event as the owner.
+	ctx = context.WithValue(ctx, server.UserInfoKey, &server.UserInfo{Username: r.Owner, IsAdmin: true})
+	for _, ev := range evs {
+		if err := imp.Events.WriteEvent(ctx, ev); err != nil {
+			return fmt.Errorf("writing %s event of task %s: %v", ev.Type, task.Id, err)
+		}
+	}
+	return nil
+}
+
+// missingEvents returns the events of a task which an interrupted import
+// didn't write to the database. The events are written in order, the state
+// last: the task was imported if it's in its final state. Otherwise, the
+// events following its creation are written again. They set the logs, so
+// writing them twice doesn't change the task, except the system logs, which
+// are appended: those already written are skipped.
+func missingEvents(existing *tes.Task, evs []*events.Event) []*events.Event {
+	if last := evs[len(evs)-1]; last.Type == events.Type_TASK_STATE && existing.GetState() == last.GetState() {
+		return nil
+	}
+	written := map[uint32]int{}
+	for a, tl := range existing.GetLogs() {
+		written[uint32(a)] = len(tl.GetSystemLogs())
+	}
+	var missing []*events.Event
+	for _, ev := range evs[1:] {
+		if ev.Type == events.Type_SYSTEM_LOG && written[ev.Attempt] > 0 {
+			written[ev.Attempt]--
+			continue
+		}
+		missing = append(missing, ev)
+	}
+	return missing
+}
+
+func (imp *Importer) putNode(ctx context.Context, node *scheduler.Node) error {
+	if imp.Nodes == nil {
+		imp.Skipped++
+		return nil
+	}
+	_, err := imp.Nodes.GetNode(ctx, &scheduler.GetNodeRequest{Id: node.Id})
+	if err == nil {
+		imp.Skipped++
+		return nil
+	}
+	if !isNotFound(err) {
+		return fmt.Errorf("getting node %s: %v", node.Id, err)
+	}
+	node = proto.Clone(node).(*scheduler.Node)
+	node.Version = 0
+	if _, err := imp.Nodes.PutNode(ctx, node); err != nil {
+		return fmt.Errorf("putting node %s: %v", node.Id, err)
+	}
+	return nil
+}
+
+// Import reads the records of the decoder and writes them to w. save is
+// called with the updated checkpoint after each record.
+func Import(ctx context.Context, dec *Decoder, w Writer, cp *Checkpoint, save func(*Checkpoint) error) error {
+	for {
+		r, err := dec.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := w.WriteRecord(ctx, r); err != nil {
+			return err
+		}
+
+		if r.Task != nil {
+			cp.Tasks++
+		} else {
+			cp.Nodes++
+		}
+		cp.Offset = dec.Offset()
+		if err := save(cp); err != nil {
+			return fmt.Errorf("saving checkpoint: %v", err)
+		}
+	}
+	cp.Done = true
+	if err := save(cp); err != nil {
+		return fmt.Errorf("saving checkpoint: %v", err)
+	}
+	return nil
+}
+
+// TaskEvents returns the events which write the task to a database: the
+// creation of the queued task, its logs, and its state.
+func TaskEvents(task *tes.Task) []*events.Event {
+	created := proto.Clone(task).(*tes.Task)
+	created.State = tes.Queued
+	created.Logs = nil
+	evs := []*events.Event{events.NewTaskCreated(created)}
+
+	add := func(typ events.Type, attempt, index int) *events.Event {
+		ev := &events.Event{
+			Id:        task.Id,
+			Timestamp: time.Now().Format(time.RFC3339Nano),
+			EventId:   events.NewEventID(),
+			Type:      typ,
+			Attempt:   uint32(attempt),
+			Index:     uint32(index),
+		}
+		evs = append(evs, ev)
+		return ev
+	}
+
+	for a, tl := range task.Logs {
+		if tl.StartTime != "" {
+			add(events.Type_TASK_START_TIME, a, 0).Data = &events.Event_StartTime{StartTime: tl.StartTime}
+		}
+		if tl.EndTime != "" {
+			add(events.Type_TASK_END_TIME, a, 0).Data = &events.Event_EndTime{EndTime: tl.EndTime}
+		}
+		if len(tl.Outputs) > 0 {
+			add(events.Type_TASK_OUTPUTS, a, 0).Data = &events.Event_Outputs{Outputs: &events.Outputs{Value: tl.Outputs}}
+		}
+		if len(tl.Metadata) > 0 {
+			add(events.Type_TASK_METADATA, a, 0).Data = &events.Event_Metadata{Metadata: &events.Metadata{Value: tl.Metadata}}
+		}
+
+		for i, el := range tl.Logs {
+			if el.StartTime != "" {
+				add(events.Type_EXECUTOR_START_TIME, a, i).Data = &events.Event_StartTime{StartTime: el.StartTime}
+			}
+			if el.EndTime != "" {
+				add(events.Type_EXECUTOR_END_TIME, a, i).Data = &events.Event_EndTime{EndTime: el.EndTime}
+			}
+			if el.EndTime != "" || el.ExitCode != 0 {
+				add(events.Type_EXECUTOR_EXIT_CODE, a, i).Data = &events.Event_ExitCode{ExitCode: el.ExitCode}
+			}
+			if el.Stdout != "" {
+				add(events.Type_EXECUTOR_STDOUT, a, i).Data = &events.Event_Stdout{Stdout: el.Stdout}
+			}
+			if el.Stderr != "" {
+				add(events.Type_EXECUTOR_STDERR, a, i).Data = &events.Event_Stderr{Stderr: el.Stderr}
+			}
+		}
+
+		for _, s := range tl.SystemLogs {
+			ev, ok := events.ParseSysLog(task.Id, s)
+			if !ok {
+				ev = events.NewSystemLog(task.Id, uint32(a), 0, "info", s, nil)
+			}
+			evs = append(evs, ev)
+		}
+	}
+
+	if task.State != tes.Queued && task.State != tes.Unknown {
+		evs = append(evs, events.NewState(task.Id, task.State))
+	}
+	return evs
+}
+
+func isNotFound(err error) bool {
+	return err == tes.ErrNotFound || status.Code(err) == codes.NotFound
+}
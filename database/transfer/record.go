@@ -0,0 +1,201 @@
+// Package transfer moves the tasks and nodes of a database to another,
+// through a stream of newline-delimited JSON records.
+package transfer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ohsu-comp-bio/funnel/compute/scheduler"
+	"github.com/ohsu-comp-bio/funnel/tes"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Record is a task, with its owner, or a node.
+type Record struct {
+	Owner string
+	Task  *tes.Task
+	Node  *scheduler.Node
+}
+
+// jsonRecord is the line of a record: the task and the node are protojson.
+type jsonRecord struct {
+	Owner string          `json:"owner,omitempty"`
+	Task  json.RawMessage `json:"task,omitempty"`
+	Node  json.RawMessage `json:"node,omitempty"`
+}
+
+// MarshalJSON encodes the record on a single line.
+func (r *Record) MarshalJSON() ([]byte, error) {
+	var j jsonRecord
+	var err error
+	switch {
+	case r.Task != nil:
+		j.Owner = r.Owner
+		j.Task, err = protojson.Marshal(r.Task)
+	case r.Node != nil:
+		j.Node, err = protojson.Marshal(r.Node)
+	default:
+		return nil, fmt.Errorf("empty record")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON decodes a record.
+func (r *Record) UnmarshalJSON(b []byte) error {
+	var j jsonRecord
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	*r = Record{Owner: j.Owner}
+	switch {
+	case j.Task != nil:
+		r.Task = &tes.Task{}
+		return protojson.Unmarshal(j.Task, r.Task)
+	case j.Node != nil:
+		r.Node = &scheduler.Node{}
+		return protojson.Unmarshal(j.Node, r.Node)
+	}
+	return fmt.Errorf("record without a task or a node")
+}
+
+// Writer writes records: to a file, or to a database.
+type Writer interface {
+	WriteRecord(ctx context.Context, r *Record) error
+}
+
+// Encoder writes records as newline-delimited JSON.
+type Encoder struct {
+	w      *bufio.Writer
+	offset int64
+}
+
+// NewEncoder returns an encoder writing to w, which is at the given offset
+// of the file.
+func NewEncoder(w io.Writer, offset int64) *Encoder {
+	return &Encoder{w: bufio.NewWriter(w), offset: offset}
+}
+
+// WriteRecord writes a record on a line.
+func (e *Encoder) WriteRecord(ctx context.Context, r *Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	n, err := e.w.Write(append(b, '\n'))
+	e.offset += int64(n)
+	return err
+}
+
+// Flush writes the buffered records.
+func (e *Encoder) Flush() error {
+	return e.w.Flush()
+}
+
+// Offset returns the offset of the next record in the file.
+func (e *Encoder) Offset() int64 {
+	return e.offset
+}
+
+// Decoder reads records written by an Encoder.
+type Decoder struct {
+	r      *bufio.Reader
+	offset int64
+	line   int
+}
+
+// NewDecoder returns a decoder reading from r, which is at the given offset
+// of the file.
+func NewDecoder(r io.Reader, offset int64) *Decoder {
+	return &Decoder{r: bufio.NewReader(r), offset: offset}
+}
+
+// Read returns the next record, or io.EOF at the end of the stream.
+func (d *Decoder) Read() (*Record, error) {
+	for {
+		b, err := d.r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(b) == 0 {
+			return nil, io.EOF
+		}
+		d.offset += int64(len(b))
+		d.line++
+
+		if len(b) == 1 && b[0] == '\n' {
+			continue
+		}
+		r := &Record{}
+		if err := json.Unmarshal(b, r); err != nil {
+			return nil, fmt.Errorf("line %d: %v", d.line, err)
+		}
+		return r, nil
+	}
+}
+
+// Offset returns the offset of the next record in the file.
+func (d *Decoder) Offset() int64 {
+	return d.offset
+}
+
+// Checkpoint is the progress of an export, an import or a copy, saved to
+// resume it where it stopped.
+type Checkpoint struct {
+	// The page token of the next tasks to read from the database.
+	PageToken string `json:"page_token,omitempty"`
+	// All the tasks were read: only the nodes are left.
+	TasksDone bool `json:"tasks_done,omitempty"`
+	// All the records were read.
+	Done bool `json:"done,omitempty"`
+	// The offset of the next record in the file.
+	Offset int64 `json:"offset,omitempty"`
+	Tasks  int   `json:"tasks"`
+	Nodes  int   `json:"nodes"`
+}
+
+// ReadCheckpoint reads a checkpoint file. It returns an empty checkpoint if
+// the file doesn't exist.
+func ReadCheckpoint(path string) (*Checkpoint, error) {
+	cp := &Checkpoint{}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, cp); err != nil {
+		return nil, fmt.Errorf("reading checkpoint %s: %v", path, err)
+	}
+	return cp, nil
+}
+
+// Save writes the checkpoint file, replacing the previous one atomically.
+func (cp *Checkpoint) Save(path string) error {
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,179 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/compute/scheduler"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/database/badger"
+	"github.com/ohsu-comp-bio/funnel/database/boltdb"
+	"github.com/ohsu-comp-bio/funnel/events"
+	"github.com/ohsu-comp-bio/funnel/server"
+	"github.com/ohsu-comp-bio/funnel/tes"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestParseSysLog(t *testing.T) {
+	ev := events.NewSystemLog("task-1", 2, 1, "error", "can't 'pull' image", map[string]string{"image": "alpine"})
+	s := ev.SysLogString()
+
+	parsed, ok := events.ParseSysLog("task-1", s)
+	if !ok {
+		t.Fatalf("failed to parse %q", s)
+	}
+	if parsed.SysLogString() != s {
+		t.Errorf("unexpected system log:\n%s\n%s", parsed.SysLogString(), s)
+	}
+	if parsed.Attempt != 2 || parsed.Index != 1 {
+		t.Errorf("unexpected attempt and index: %d %d", parsed.Attempt, parsed.Index)
+	}
+
+	for _, s := range []string{"plain message", "level='info' msg='unterminated", "msg='no level'"} {
+		if _, ok := events.ParseSysLog("task-1", s); ok {
+			t.Errorf("expected %q not to parse", s)
+		}
+	}
+}
+
+func TestRecordJSON(t *testing.T) {
+	buf := &bytes.Buffer{}
+	enc := NewEncoder(buf, 0)
+	records := []*Record{
+		{Owner: "alice", Task: &tes.Task{Id: "task-1", CreationTime: "2024-01-01T00:00:00Z"}},
+		{Node: &scheduler.Node{Id: "node-1", Hostname: "worker"}},
+	}
+	for _, r := range records {
+		if err := enc.WriteRecord(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	enc.Flush()
+	if enc.Offset() != int64(buf.Len()) {
+		t.Errorf("unexpected offset: %d", enc.Offset())
+	}
+
+	dec := NewDecoder(bytes.NewReader(buf.Bytes()), 0)
+	for _, r := range records {
+		got, err := dec.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Owner != r.Owner || !proto.Equal(got.Task, r.Task) || !proto.Equal(got.Node, r.Node) {
+			t.Errorf("unexpected record: %+v", got)
+		}
+	}
+	if _, err := dec.Read(); err == nil {
+		t.Error("expected the end of the records")
+	}
+}
+
+func TestCopy(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	src, err := boltdb.NewBoltDB(&config.BoltDB{Path: filepath.Join(dir, "funnel.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	if err := src.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	dst, err := badger.NewBadger(&config.Badger{Path: filepath.Join(dir, "badger")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	task := &tes.Task{
+		Id:           "task-1",
+		Name:         "hello",
+		CreationTime: created.Format(time.RFC3339Nano),
+		Executors:    []*tes.Executor{{Image: "alpine", Command: []string{"echo", "hello"}}},
+	}
+	userCtx := context.WithValue(ctx, server.UserInfoKey, &server.UserInfo{Username: "alice"})
+	for _, ev := range []*events.Event{
+		events.NewTaskCreated(task),
+		events.NewState(task.Id, tes.Running),
+		events.NewStartTime(task.Id, 0, created.Add(time.Minute)),
+		events.NewExecutorStartTime(task.Id, 0, 0, created.Add(time.Minute)),
+		events.NewStdout(task.Id, 0, 0, "hello\n"),
+		events.NewExitCode(task.Id, 0, 0, 0),
+		events.NewExecutorEndTime(task.Id, 0, 0, created.Add(2*time.Minute)),
+		events.NewSystemLog(task.Id, 0, 0, "info", "executor done", map[string]string{"exit_code": "0"}),
+		events.NewEndTime(task.Id, 0, created.Add(2*time.Minute)),
+		events.NewState(task.Id, tes.Complete),
+	} {
+		if err := src.WriteEvent(userCtx, ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// An import interrupted before the state of the task, the system log
+	// being written.
+	failing := &failingWriter{Writer: dst, n: 9}
+	err = Export(ctx, src, &Importer{Events: failing, Tasks: dst}, &Checkpoint{}, func(*Checkpoint) error { return nil })
+	if err == nil {
+		t.Fatal("expected the import to fail")
+	}
+	if partial, err := dst.GetTask(ctx, &tes.GetTaskRequest{Id: task.Id}); err != nil || partial.State != tes.Queued {
+		t.Fatalf("expected a partial task: %v %v", partial, err)
+	}
+
+	// Importing again completes the task.
+	imp := &Importer{Events: dst, Tasks: dst}
+	cp := &Checkpoint{}
+	saves := 0
+	err = Export(ctx, src, imp, cp, func(*Checkpoint) error {
+		saves++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.Tasks != 1 || !cp.Done || saves != 2 {
+		t.Errorf("unexpected checkpoint: %+v, saved %d times", cp, saves)
+	}
+
+	full := &tes.GetTaskRequest{Id: task.Id, View: tes.View_FULL.String()}
+	expected, err := src.GetTask(ctx, full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := dst.GetTask(ctx, full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imp.Skipped != 0 || !proto.Equal(got, expected) {
+		t.Errorf("unexpected task:\n%v\nexpected:\n%v", got, expected)
+	}
+	if owner, _ := dst.TaskOwner(ctx, task.Id); owner != "alice" {
+		t.Errorf("unexpected owner: %q", owner)
+	}
+
+	// Copying again skips the task.
+	err = Export(ctx, src, imp, &Checkpoint{}, func(*Checkpoint) error { return nil })
+	if err != nil || imp.Skipped != 1 {
+		t.Errorf("expected the task to be skipped: %v, %d", err, imp.Skipped)
+	}
+}
+
+// failingWriter fails at the nth event.
+type failingWriter struct {
+	events.Writer
+	n int
+}
+
+func (w *failingWriter) WriteEvent(ctx context.Context, ev *events.Event) error {
+	if w.n--; w.n == 0 {
+		return errors.New("interrupted")
+	}
+	return w.Writer.WriteEvent(ctx, ev)
+}
@@ -0,0 +1,230 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/logger"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// dialAMQP connects to the AMQP server of the config, and declares the
+// durable queue of the events.
+func dialAMQP(conf *config.AMQP) (*amqp.Connection, *amqp.Channel, error) {
+	conn, err := amqp.Dial(conf.URL)
+	if err != nil {
+		return nil, nil, err
+	}
+	ch, err := conn.Channel()
+	if err == nil {
+		_, err = ch.QueueDeclare(conf.Queue, true, false, false, false, nil)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("declaring AMQP queue %s: %v", conf.Queue, err)
+	}
+	return conn, ch, nil
+}
+
+// AMQPWriter writes events to an AMQP queue.
+type AMQPWriter struct {
+	conf *config.AMQP
+	mtx  sync.Mutex
+	conn *amqp.Connection
+	ch   *amqp.Channel
+}
+
+// NewAMQPWriter creates a new event writer for writing events to an AMQP
+// queue, which is declared if it doesn't exist.
+func NewAMQPWriter(ctx context.Context, conf *config.AMQP) (*AMQPWriter, error) {
+	w := &AMQPWriter{conf: conf}
+	if err := w.connect(); err != nil {
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		w.Close()
+	}()
+	return w, nil
+}
+
+// connect opens the connection and its channel, in confirm mode.
+func (a *AMQPWriter) connect() error {
+	conn, ch, err := dialAMQP(a.conf)
+	if err != nil {
+		return err
+	}
+	if err := ch.Confirm(false); err != nil {
+		conn.Close()
+		return err
+	}
+	a.conn, a.ch = conn, ch
+	return nil
+}
+
+// WriteEvent publishes the persistent event, with its message ID, and waits
+// for the server to confirm it. The events are published one at a time, so
+// that they stay in order. Stdout, stderr and system log events, other than
+// audit records, are dropped unless conf.IncludeLogs is set.
+func (a *AMQPWriter) WriteEvent(ctx context.Context, ev *Event) error {
+	ev = filterLogs(ev, a.conf.IncludeLogs, a.conf.MaxLogSize)
+	if ev == nil {
+		return nil
+	}
+
+	s, err := Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if a.ch == nil || a.ch.IsClosed() {
+		if a.conn != nil {
+			a.conn.Close()
+		}
+		if err := a.connect(); err != nil {
+			return err
+		}
+	}
+
+	confirm, err := a.ch.PublishWithDeferredConfirmWithContext(ctx, "", a.conf.Queue, false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    messageID(s),
+		Timestamp:    time.Now(),
+		Body:         []byte(s),
+	})
+	if err != nil {
+		return err
+	}
+	ok, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("AMQP server didn't confirm the event")
+	}
+	return nil
+}
+
+func (a *AMQPWriter) Close() {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if a.conn != nil {
+		a.conn.Close()
+	}
+}
+
+// AMQPReader reads events from an AMQP queue, and writes them to a Writer.
+//
+// The reader is the exclusive consumer of the queue, so that the events are
+// written in order. A message is acknowledged once written, and the
+// messages not acknowledged are delivered again when the reader reconnects.
+// The events which can't be written are dropped.
+type AMQPReader struct {
+	conf   *config.AMQP
+	seen   *recentIDs
+	w      Writer
+	log    *logger.Logger
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewAMQPReader creates a new event reader for reading events from an AMQP
+// queue and writing them to the given Writer. It reads until the context is
+// canceled or Close is called.
+func NewAMQPReader(ctx context.Context, conf *config.AMQP, w Writer) (*AMQPReader, error) {
+	// Check the connection, so that a wrong config is reported.
+	conn, _, err := dialAMQP(conf)
+	if err != nil {
+		return nil, err
+	}
+	conn.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	r := &AMQPReader{
+		conf:   conf,
+		seen:   newRecentIDs(10000),
+		w:      w,
+		log:    logger.NewLogger("amqp", logger.DefaultConfig()),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go r.run(ctx)
+	return r, nil
+}
+
+// run consumes the queue until the context is canceled, and reconnects when
+// the connection is lost or an event fails.
+func (r *AMQPReader) run(ctx context.Context) {
+	defer close(r.done)
+
+	for ctx.Err() == nil {
+		err := r.consume(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.log.Error("reading AMQP events", "error", err)
+			sleep(ctx, time.Second)
+		}
+	}
+}
+
+// consume writes the messages of one connection. Closing the connection
+// returns the messages not acknowledged to the queue.
+func (r *AMQPReader) consume(ctx context.Context) error {
+	conn, ch, err := dialAMQP(r.conf)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := ch.Qos(100, 0, false); err != nil {
+		return err
+	}
+	deliveries, err := ch.Consume(r.conf.Queue, "", false, true, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-deliveries:
+			if !ok {
+				return fmt.Errorf("AMQP channel closed")
+			}
+			if err := r.handle(ctx, msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handle writes the event of the message, and acknowledges it. The messages
+// which can't be written are dropped, and the messages already written are
+// skipped.
+func (r *AMQPReader) handle(ctx context.Context, msg amqp.Delivery) error {
+	if !r.seen.contains(msg.MessageId) {
+		err := writeMessage(ctx, r.w, msg.Body)
+		var rejected *rejectedError
+		if errors.As(err, &rejected) {
+			r.log.Error("dropping AMQP event", "error", err, "message", msg.MessageId)
+		} else if err != nil {
+			return fmt.Errorf("writing AMQP event: %v", err)
+		}
+		r.seen.add(msg.MessageId)
+	}
+	return msg.Ack(false)
+}
+
+// Close stops reading.
+func (r *AMQPReader) Close() {
+	r.cancel()
+	<-r.done
+}
@@ -0,0 +1,144 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// CloudEventsWriter sends events to an HTTP endpoint as CloudEvents 1.0, in
+// the structured or binary content mode of the HTTP binding.
+//
+// The data of a CloudEvent is the Funnel event, as JSON. Its type is
+// "org.ohsu.funnel.<event type>", e.g. "org.ohsu.funnel.task_state", and its
+// subject is the task ID. The traceparent extension holds the trace context
+// of the event, if any.
+type CloudEventsWriter struct {
+	conf   *config.CloudEvents
+	client *http.Client
+}
+
+// NewCloudEventsWriter creates a new event writer for sending events as
+// CloudEvents.
+func NewCloudEventsWriter(ctx context.Context, conf *config.CloudEvents) (*CloudEventsWriter, error) {
+	if !strings.HasPrefix(conf.URL, "http://") && !strings.HasPrefix(conf.URL, "https://") {
+		return nil, fmt.Errorf("invalid CloudEvents URL: %q", conf.URL)
+	}
+	switch conf.Mode {
+	case "", "structured", "binary":
+	default:
+		return nil, fmt.Errorf("unknown CloudEvents mode: %s", conf.Mode)
+	}
+	timeout := 10 * time.Second
+	if conf.Timeout.AsDuration() > 0 {
+		timeout = conf.Timeout.AsDuration()
+	}
+	return &CloudEventsWriter{conf, &http.Client{Timeout: timeout}}, nil
+}
+
+// cloudEvent holds the context attributes of a CloudEvent.
+type cloudEvent struct {
+	SpecVersion     string `json:"specversion"`
+	ID              string `json:"id"`
+	Source          string `json:"source"`
+	Type            string `json:"type"`
+	Subject         string `json:"subject,omitempty"`
+	Time            string `json:"time,omitempty"`
+	DataContentType string `json:"datacontenttype"`
+	Traceparent     string `json:"traceparent,omitempty"`
+	// Data is only set in the structured mode.
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// WriteEvent sends the event. Stdout, stderr and system log events, other
+// than audit records, are dropped unless conf.IncludeLogs is set.
+func (c *CloudEventsWriter) WriteEvent(ctx context.Context, ev *Event) error {
+	ev = filterLogs(ev, c.conf.IncludeLogs, c.conf.MaxLogSize)
+	if ev == nil {
+		return nil
+	}
+
+	s, err := Marshal(ev)
+	if err != nil {
+		return err
+	}
+	source := c.conf.Source
+	if source == "" {
+		source = "funnel"
+	}
+	ce := &cloudEvent{
+		SpecVersion:     "1.0",
+		ID:              messageID(s),
+		Source:          source,
+		Type:            "org.ohsu.funnel." + strings.ToLower(ev.Type.String()),
+		Subject:         ev.Id,
+		Time:            ev.Timestamp,
+		DataContentType: "application/json",
+	}
+	// The span writing the event, or else the root span of the task.
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() && tracing.Enabled() && ev.Id != "" {
+		sc = tracing.TaskSpanContext(ev.Id)
+	}
+	if sc.IsValid() {
+		ce.Traceparent = tracing.Traceparent(sc)
+	}
+
+	var body []byte
+	header := http.Header{}
+	if c.conf.Mode == "binary" {
+		body = []byte(s)
+		header.Set("Content-Type", ce.DataContentType)
+		header.Set("ce-specversion", ce.SpecVersion)
+		header.Set("ce-id", ce.ID)
+		header.Set("ce-source", ce.Source)
+		header.Set("ce-type", ce.Type)
+		if ce.Subject != "" {
+			header.Set("ce-subject", ce.Subject)
+		}
+		if ce.Time != "" {
+			header.Set("ce-time", ce.Time)
+		}
+		if ce.Traceparent != "" {
+			header.Set("ce-traceparent", ce.Traceparent)
+		}
+	} else {
+		ce.Data = json.RawMessage(s)
+		body, err = json.Marshal(ce)
+		if err != nil {
+			return err
+		}
+		header.Set("Content-Type", "application/cloudevents+json; charset=UTF-8")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	req.Header.Set("User-Agent", "funnel")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("CloudEvents endpoint responded %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	return nil
+}
+
+// Close closes the writer.
+func (c *CloudEventsWriter) Close() {}
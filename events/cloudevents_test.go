@@ -0,0 +1,95 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/tes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestCloudEventsWriter(t *testing.T) {
+	var reqs []*http.Request
+	var bodies [][]byte
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		reqs = append(reqs, req)
+		bodies = append(bodies, body)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	ev := NewState("task-1", tes.Complete)
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{2},
+		TraceFlags: trace.FlagsSampled,
+	})
+	traceparent := "00-01000000000000000000000000000000-0200000000000000-01"
+
+	// Structured mode.
+	w, err := NewCloudEventsWriter(ctx, &config.CloudEvents{URL: srv.URL, Source: "funnel-test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteEvent(trace.ContextWithSpanContext(ctx, sc), ev); err != nil {
+		t.Fatal(err)
+	}
+	// The logs are dropped.
+	if err := w.WriteEvent(ctx, NewStdout("task-1", 0, 0, "dropped")); err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(reqs))
+	}
+	if ct := reqs[0].Header.Get("Content-Type"); ct != "application/cloudevents+json; charset=UTF-8" {
+		t.Errorf("unexpected content type: %s", ct)
+	}
+	ce := map[string]json.RawMessage{}
+	if err := json.Unmarshal(bodies[0], &ce); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]string{
+		"specversion": `"1.0"`,
+		"source":      `"funnel-test"`,
+		"type":        `"org.ohsu.funnel.task_state"`,
+		"subject":     `"task-1"`,
+		"traceparent": `"` + traceparent + `"`,
+	} {
+		if string(ce[k]) != v {
+			t.Errorf("%s: expected %s, got %s", k, v, ce[k])
+		}
+	}
+	data := &Event{}
+	if err := Unmarshal(ce["data"], data); err != nil || data.GetState() != tes.Complete {
+		t.Errorf("unexpected data: %s %v", ce["data"], err)
+	}
+
+	// Binary mode.
+	w, err = NewCloudEventsWriter(ctx, &config.CloudEvents{URL: srv.URL, Mode: "binary"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteEvent(trace.ContextWithSpanContext(ctx, sc), ev); err != nil {
+		t.Fatal(err)
+	}
+	h := reqs[1].Header
+	if h.Get("ce-specversion") != "1.0" || h.Get("ce-type") != "org.ohsu.funnel.task_state" ||
+		h.Get("ce-source") != "funnel" || h.Get("ce-traceparent") != traceparent ||
+		h.Get("ce-id") != string(ce["id"][1:len(ce["id"])-1]) {
+		t.Errorf("unexpected headers: %v", h)
+	}
+	data = &Event{}
+	if err := Unmarshal(bodies[1], data); err != nil || data.GetState() != tes.Complete {
+		t.Errorf("unexpected body: %s %v", bodies[1], err)
+	}
+
+	if _, err := NewCloudEventsWriter(ctx, &config.CloudEvents{URL: srv.URL, Mode: "batched"}); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
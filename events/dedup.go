@@ -0,0 +1,42 @@
+package events
+
+import (
+	"context"
+)
+
+// DedupWriter drops the events already written to its writer, e.g. when a
+// worker retries a write which succeeded, or writes an event again after an
+// error of another writer of a MultiWriter. The events are identified by
+// their EventId, and the last size IDs are remembered, in memory: the IDs
+// are lost on restart, and aren't shared between the replicas of a server.
+// The database writers drop the duplicate events themselves. Events without
+// an EventId are always written.
+type DedupWriter struct {
+	Writer Writer
+	seen   *recentIDs
+}
+
+// NewDedupWriter returns a DedupWriter remembering the IDs of the last size
+// events written.
+func NewDedupWriter(w Writer, size int) *DedupWriter {
+	return &DedupWriter{Writer: w, seen: newRecentIDs(size)}
+}
+
+// WriteEvent writes the event, unless it was already written.
+func (d *DedupWriter) WriteEvent(ctx context.Context, ev *Event) error {
+	// The ID is added before the write, so that an event written
+	// concurrently is only written once, and removed if the write fails.
+	if ev.EventId != "" && !d.seen.add(ev.EventId) {
+		return nil
+	}
+	if err := d.Writer.WriteEvent(ctx, ev); err != nil {
+		d.seen.remove(ev.EventId)
+		return err
+	}
+	return nil
+}
+
+// Close closes the writer.
+func (d *DedupWriter) Close() {
+	d.Writer.Close()
+}
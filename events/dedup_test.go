@@ -0,0 +1,56 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ohsu-comp-bio/funnel/tes"
+)
+
+// failWriter fails the writes while fail is positive.
+type failWriter struct {
+	recordWriter
+	fail int
+}
+
+func (w *failWriter) WriteEvent(ctx context.Context, ev *Event) error {
+	if w.fail > 0 {
+		w.fail--
+		return errors.New("unavailable")
+	}
+	return w.recordWriter.WriteEvent(ctx, ev)
+}
+
+func TestDedupWriter(t *testing.T) {
+	ctx := context.Background()
+	db := &recordWriter{}
+	kafka := &failWriter{fail: 1}
+	w := &MultiWriter{NewDedupWriter(db, 2), NewDedupWriter(kafka, 2)}
+
+	running := NewState("task-1", tes.Running)
+	// The write to kafka fails, and is retried: the event is written once
+	// to the database.
+	if err := w.WriteEvent(ctx, running); err == nil {
+		t.Fatal("expected an error")
+	}
+	if err := w.WriteEvent(ctx, running); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.states) != 1 || len(kafka.states) != 1 {
+		t.Errorf("expected the event to be written once, got %v %v", db.states, kafka.states)
+	}
+
+	// The events without an ID are always written.
+	complete := NewState("task-1", tes.Complete)
+	complete.EventId = ""
+	w.WriteEvent(ctx, complete)
+	w.WriteEvent(ctx, complete)
+	if len(db.states) != 3 {
+		t.Errorf("expected the events without an ID to be written, got %v", db.states)
+	}
+
+	if a, b := NewEventID(), NewEventID(); a == b || a > b {
+		t.Errorf("expected increasing event IDs, got %s %s", a, b)
+	}
+}
@@ -2,6 +2,7 @@ package events
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"sync"
 	"sync/atomic"
@@ -64,6 +65,16 @@ func (eg *ExecutorGenerator) Stderr(s string) *Event {
 	return NewStderr(eg.taskID, eg.attempt, eg.index, s)
 }
 
+// Metadata updates the task's metadata log, with keys prefixed by
+// "executor.<index>.", e.g. "executor.0.image_digest".
+func (eg *ExecutorGenerator) Metadata(m map[string]string) *Event {
+	prefixed := make(map[string]string, len(m))
+	for k, v := range m {
+		prefixed[fmt.Sprintf("executor.%d.%s", eg.index, k)] = v
+	}
+	return NewMetadata(eg.taskID, eg.attempt, prefixed)
+}
+
 // Info creates an info level system log message.
 func (eg *ExecutorGenerator) Info(msg string, args ...interface{}) *Event {
 	return eg.sys.Info(msg, args...)
@@ -121,6 +132,11 @@ func (ew *ExecutorWriter) Stderr(s string) error {
 	return ew.out.WriteEvent(context.Background(), ew.gen.Stderr(s))
 }
 
+// Metadata updates the task's metadata log with executor metadata.
+func (ew *ExecutorWriter) Metadata(m map[string]string) error {
+	return ew.out.WriteEvent(context.Background(), ew.gen.Metadata(m))
+}
+
 // Info writes an info level system log message.
 func (ew *ExecutorWriter) Info(msg string, args ...interface{}) error {
 	return ew.sys.Info(msg, args...)
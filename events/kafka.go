@@ -2,11 +2,33 @@ package events
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strconv"
 
 	"github.com/Shopify/sarama"
 	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/logger"
 )
 
+// newKafkaConfig returns the config of the Kafka clients. The messages are
+// keyed by task ID, so that the events of a task are in one partition, in
+// order. The producer is idempotent, so that its retries don't reorder or
+// duplicate them.
+func newKafkaConfig() *sarama.Config {
+	c := sarama.NewConfig()
+	c.ClientID = "funnel"
+	c.Producer.RequiredAcks = sarama.WaitForAll
+	c.Producer.Idempotent = true
+	c.Producer.Return.Successes = true
+	c.Producer.Retry.Max = 10
+	c.Net.MaxOpenRequests = 1
+	// A new consumer group reads the events written before it started.
+	c.Consumer.Offsets.Initial = sarama.OffsetOldest
+	c.Consumer.Return.Errors = true
+	return c
+}
+
 // KafkaWriter writes events to a Kafka topic.
 type KafkaWriter struct {
 	conf     *config.Kafka
@@ -15,7 +37,7 @@ type KafkaWriter struct {
 
 // NewKafkaWriter creates a new event writer for writing events to a Kafka topic.
 func NewKafkaWriter(ctx context.Context, conf *config.Kafka) (*KafkaWriter, error) {
-	producer, err := sarama.NewSyncProducer(conf.Servers, nil)
+	producer, err := sarama.NewSyncProducer(conf.Servers, newKafkaConfig())
 	if err != nil {
 		return nil, err
 	}
@@ -26,12 +48,13 @@ func NewKafkaWriter(ctx context.Context, conf *config.Kafka) (*KafkaWriter, erro
 	return &KafkaWriter{conf, producer}, nil
 }
 
-// WriteEvent writes the event. Events may be sent in batches in the background by the
-// Kafka client library. Currently stdout, stderr, and system log events are dropped.
+// WriteEvent writes the event, keyed by task ID. Stdout, stderr and system
+// log events, other than audit records, are dropped unless
+// conf.IncludeLogs is set, and stdout and stderr keep their last
+// conf.MaxLogSize bytes.
 func (k *KafkaWriter) WriteEvent(ctx context.Context, ev *Event) error {
-
-	switch ev.Type {
-	case Type_EXECUTOR_STDOUT, Type_EXECUTOR_STDERR, Type_SYSTEM_LOG:
+	ev = filterLogs(ev, k.conf.IncludeLogs, k.conf.MaxLogSize)
+	if ev == nil {
 		return nil
 	}
 
@@ -42,7 +65,7 @@ func (k *KafkaWriter) WriteEvent(ctx context.Context, ev *Event) error {
 
 	msg := &sarama.ProducerMessage{
 		Topic: k.conf.Topic,
-		Key:   nil,
+		Key:   sarama.StringEncoder(ev.Id),
 		Value: sarama.StringEncoder(s),
 	}
 	_, _, err = k.producer.SendMessage(msg)
@@ -53,43 +76,156 @@ func (k *KafkaWriter) Close() {
 	k.producer.Close()
 }
 
-// KafkaReader reads events to a Kafka topic and writes them
-// to a Writer.
+// KafkaReader reads events from a Kafka topic, as a member of the consumer
+// group conf.ConsumerGroup, and writes them to a Writer.
+//
+// The partitions of the topic are shared by the members of the group. The
+// offset of an event is committed once written, so that a restarted reader
+// resumes after the last event written. The events which can't be decoded,
+// or which the writer rejects, are sent to conf.DeadLetterTopic.
 type KafkaReader struct {
-	conf *config.Kafka
-	con  sarama.Consumer
-	pcon sarama.PartitionConsumer
+	conf     *config.Kafka
+	group    sarama.ConsumerGroup
+	producer sarama.SyncProducer
+	w        Writer
+	log      *logger.Logger
+	cancel   context.CancelFunc
+	done     chan struct{}
 }
 
 // NewKafkaReader creates a new event reader for reading events from a Kafka topic and writing them to the given Writer.
+// It reads until the context is canceled or Close is called.
 func NewKafkaReader(ctx context.Context, conf *config.Kafka, w Writer) (*KafkaReader, error) {
-	con, err := sarama.NewConsumer(conf.Servers, nil)
-	if err != nil {
-		return nil, err
+	if conf.ConsumerGroup == "" {
+		return nil, fmt.Errorf("reading Kafka events requires a consumer group")
 	}
 
-	// TODO better handling of partition and offset.
-	p, err := con.ConsumePartition(conf.Topic, 0, sarama.OffsetNewest)
+	c := newKafkaConfig()
+	group, err := sarama.NewConsumerGroup(conf.Servers, conf.ConsumerGroup, c)
 	if err != nil {
 		return nil, err
 	}
 
+	var producer sarama.SyncProducer
+	if conf.DeadLetterTopic != "" {
+		producer, err = sarama.NewSyncProducer(conf.Servers, c)
+		if err != nil {
+			group.Close()
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	r := &KafkaReader{
+		conf:     conf,
+		group:    group,
+		producer: producer,
+		w:        w,
+		log:      logger.NewLogger("kafka", logger.DefaultConfig()),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+
 	go func() {
-		for msg := range p.Messages() {
-			ev := &Event{}
-			err := Unmarshal(msg.Value, ev)
-			if err != nil {
-				// TODO
-				continue
-			}
-			w.WriteEvent(context.Background(), ev)
+		for err := range group.Errors() {
+			r.log.Error("reading Kafka events", "error", err)
 		}
 	}()
+	go r.run(ctx)
+	return r, nil
+}
 
-	go func() {
-		<-ctx.Done()
-		con.Close()
-		p.Close()
+// run consumes the topic until the context is canceled. Consume returns
+// when the partitions of the group are rebalanced.
+func (r *KafkaReader) run(ctx context.Context) {
+	defer close(r.done)
+	defer func() {
+		r.group.Close()
+		if r.producer != nil {
+			r.producer.Close()
+		}
 	}()
-	return &KafkaReader{conf, con, p}, nil
+
+	for ctx.Err() == nil {
+		err := r.group.Consume(ctx, []string{r.conf.Topic}, r)
+		if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+			return
+		}
+		if err != nil {
+			r.log.Error("reading Kafka events", "error", err)
+		}
+	}
+}
+
+// Close stops reading, and commits the offsets of the events written.
+func (r *KafkaReader) Close() {
+	r.cancel()
+	<-r.done
+}
+
+// Setup is called at the start of a session of the consumer group.
+func (r *KafkaReader) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// Cleanup is called at the end of a session of the consumer group.
+func (r *KafkaReader) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// ConsumeClaim writes the events of a partition, in order. An event which
+// fails is read again by the next session.
+func (r *KafkaReader) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			if err := r.handle(sess.Context(), msg); err != nil {
+				return err
+			}
+			sess.MarkMessage(msg, "")
+
+		case <-sess.Context().Done():
+			return nil
+		}
+	}
+}
+
+// handle writes the event of the message. The messages which can't be
+// written are sent to the dead letter topic.
+func (r *KafkaReader) handle(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	err := writeMessage(ctx, r.w, msg.Value)
+	var rejected *rejectedError
+	if errors.As(err, &rejected) {
+		return r.deadLetter(msg, err)
+	}
+	return err
+}
+
+// deadLetter sends the message to the dead letter topic, with the error and
+// its origin in headers. It is dropped if there is no dead letter topic.
+func (r *KafkaReader) deadLetter(msg *sarama.ConsumerMessage, cause error) error {
+	r.log.Error("dropping Kafka event", "error", cause,
+		"topic", msg.Topic, "partition", msg.Partition, "offset", msg.Offset)
+	if r.producer == nil {
+		return nil
+	}
+
+	_, _, err := r.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: r.conf.DeadLetterTopic,
+		Key:   sarama.ByteEncoder(msg.Key),
+		Value: sarama.ByteEncoder(msg.Value),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte("error"), Value: []byte(cause.Error())},
+			{Key: []byte("topic"), Value: []byte(msg.Topic)},
+			{Key: []byte("partition"), Value: []byte(strconv.Itoa(int(msg.Partition)))},
+			{Key: []byte("offset"), Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("sending to the dead letter topic: %v", err)
+	}
+	return nil
 }
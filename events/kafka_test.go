@@ -0,0 +1,174 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/logger"
+	"github.com/ohsu-comp-bio/funnel/tes"
+)
+
+func TestKafkaWriter(t *testing.T) {
+	producer := mocks.NewSyncProducer(t, nil)
+	defer producer.Close()
+
+	var sent []*Event
+	check := func(msg *sarama.ProducerMessage) error {
+		key, _ := msg.Key.Encode()
+		value, _ := msg.Value.Encode()
+		ev := &Event{}
+		if err := Unmarshal(value, ev); err != nil {
+			return err
+		}
+		if string(key) != ev.Id {
+			return fmt.Errorf("unexpected key %q for task %q", key, ev.Id)
+		}
+		sent = append(sent, ev)
+		return nil
+	}
+
+	w := &KafkaWriter{conf: &config.Kafka{Topic: "funnel"}, producer: producer}
+	logs := []*Event{
+		NewStdout("task-1", 0, 0, "hello"),
+		NewSystemLog("task-1", 0, 0, "info", "running", nil),
+	}
+
+	// The logs are dropped by default.
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(check)
+	for _, ev := range append([]*Event{NewState("task-1", tes.Running)}, logs...) {
+		if err := w.WriteEvent(context.Background(), ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w.conf.IncludeLogs = true
+	w.conf.MaxLogSize = 4
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(check)
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(check)
+	for _, ev := range logs {
+		if err := w.WriteEvent(context.Background(), ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(sent) != 3 || sent[0].Type != Type_TASK_STATE || sent[2].Type != Type_SYSTEM_LOG {
+		t.Fatalf("unexpected events: %v", sent)
+	}
+	if sent[1].GetStdout() != "ello" {
+		t.Errorf("expected the end of stdout, got %q", sent[1].GetStdout())
+	}
+	if logs[0].GetStdout() != "hello" {
+		t.Error("expected the event not to be modified")
+	}
+}
+
+func TestTruncateLogs(t *testing.T) {
+	ev := truncateLogs(NewStderr("task-1", 0, 0, "héllo"), 4)
+	if ev.GetStderr() != "llo" {
+		t.Errorf("expected a UTF-8 boundary, got %q", ev.GetStderr())
+	}
+}
+
+type testSession struct {
+	sarama.ConsumerGroupSession
+	marked []int64
+}
+
+func (s *testSession) Context() context.Context {
+	return context.Background()
+}
+
+func (s *testSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.marked = append(s.marked, msg.Offset)
+}
+
+type testClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *testClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+type errWriter struct {
+	Noop
+	err error
+}
+
+func (w *errWriter) WriteEvent(ctx context.Context, ev *Event) error {
+	return w.err
+}
+
+func TestKafkaReader(t *testing.T) {
+	task := &tes.Task{State: tes.Complete}
+	producer := mocks.NewSyncProducer(t, nil)
+	defer producer.Close()
+
+	r := &KafkaReader{
+		conf:     &config.Kafka{Topic: "funnel", DeadLetterTopic: "funnel-dead"},
+		producer: producer,
+		w:        TaskBuilder{Task: task},
+		log:      logger.NewLogger("kafka", logger.DefaultConfig()),
+	}
+
+	var dead []string
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		if msg.Topic != "funnel-dead" {
+			return fmt.Errorf("unexpected topic %s", msg.Topic)
+		}
+		for _, h := range msg.Headers {
+			if string(h.Key) == "error" {
+				dead = append(dead, string(h.Value))
+			}
+		}
+		return nil
+	})
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		dead = append(dead, "rejected")
+		return nil
+	})
+
+	claim := &testClaim{messages: make(chan *sarama.ConsumerMessage, 3)}
+	for i, ev := range []string{
+		`not an event`,
+		`{"id": "task-1", "type": "TASK_STATE", "state": "RUNNING"}`,
+		`{"id": "task-1", "type": "EXECUTOR_STDOUT", "stdout": "hello"}`,
+	} {
+		claim.messages <- &sarama.ConsumerMessage{Topic: "funnel", Offset: int64(i), Value: []byte(ev)}
+	}
+	close(claim.messages)
+
+	sess := &testSession{}
+	if err := r.ConsumeClaim(sess, claim); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sess.marked) != 3 {
+		t.Errorf("expected all the messages to be marked, got %v", sess.marked)
+	}
+	if len(dead) != 2 || !strings.HasPrefix(dead[0], "decoding event") {
+		t.Errorf("unexpected dead letters: %v", dead)
+	}
+	if task.State != tes.Complete || task.GetExecLog(0, 0).Stdout != "hello" {
+		t.Errorf("unexpected task: %v", task)
+	}
+
+	// If the dead letter topic can't be written, the claim stops without
+	// marking the message, which is read again.
+	r.producer = mocks.NewSyncProducer(t, nil)
+	r.producer.(*mocks.SyncProducer).ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+	r.w = &errWriter{err: tes.ErrNotFound}
+	claim = &testClaim{messages: make(chan *sarama.ConsumerMessage, 1)}
+	claim.messages <- &sarama.ConsumerMessage{Offset: 3, Value: []byte(`{"id": "task-2", "type": "TASK_STATE", "state": "RUNNING"}`)}
+	close(claim.messages)
+	sess = &testSession{}
+	if err := r.ConsumeClaim(sess, claim); err == nil || len(sess.marked) != 0 {
+		t.Errorf("expected an error without marking the message: %v %v", err, sess.marked)
+	}
+}
@@ -0,0 +1,199 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/logger"
+)
+
+// newJetStream connects to the NATS server of the config, and creates the
+// stream if it doesn't exist. The stream drops the messages published twice
+// with the same ID.
+func newJetStream(ctx context.Context, conf *config.NATS) (*nats.Conn, jetstream.JetStream, error) {
+	nc, err := nats.Connect(conf.URL, nats.Name("funnel"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, nil, err
+	}
+	js, err := jetstream.New(nc)
+	if err != nil {
+		nc.Close()
+		return nil, nil, err
+	}
+
+	_, err = js.Stream(ctx, conf.Stream)
+	if errors.Is(err, jetstream.ErrStreamNotFound) {
+		_, err = js.CreateStream(ctx, jetstream.StreamConfig{
+			Name:       conf.Stream,
+			Subjects:   []string{conf.Subject},
+			Storage:    jetstream.FileStorage,
+			Duplicates: 10 * time.Minute,
+		})
+		if errors.Is(err, jetstream.ErrStreamNameAlreadyInUse) {
+			err = nil
+		}
+	}
+	if err != nil {
+		nc.Close()
+		return nil, nil, fmt.Errorf("creating NATS stream %s: %v", conf.Stream, err)
+	}
+	return nc, js, nil
+}
+
+// NATSWriter writes events to a NATS JetStream stream.
+type NATSWriter struct {
+	conf *config.NATS
+	nc   *nats.Conn
+	js   jetstream.JetStream
+}
+
+// NewNATSWriter creates a new event writer for writing events to a NATS
+// JetStream stream, which is created if it doesn't exist.
+func NewNATSWriter(ctx context.Context, conf *config.NATS) (*NATSWriter, error) {
+	nc, js, err := newJetStream(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		nc.Close()
+	}()
+	return &NATSWriter{conf, nc, js}, nil
+}
+
+// WriteEvent publishes the event, and waits for the stream to store it.
+// Stdout, stderr and system log events, other than audit records, are
+// dropped unless conf.IncludeLogs is set.
+func (n *NATSWriter) WriteEvent(ctx context.Context, ev *Event) error {
+	ev = filterLogs(ev, n.conf.IncludeLogs, n.conf.MaxLogSize)
+	if ev == nil {
+		return nil
+	}
+
+	s, err := Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = n.js.Publish(ctx, n.conf.Subject, []byte(s), jetstream.WithMsgID(messageID(s)))
+	return err
+}
+
+func (n *NATSWriter) Close() {
+	n.nc.Close()
+}
+
+// NATSReader reads events from a NATS JetStream stream, with the durable
+// consumer conf.Consumer, and writes them to a Writer.
+//
+// An event is acknowledged once written, so that a restarted reader resumes
+// after the last event written. The events which can't be written are
+// dropped.
+type NATSReader struct {
+	conf   *config.NATS
+	nc     *nats.Conn
+	cons   jetstream.Consumer
+	w      Writer
+	log    *logger.Logger
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewNATSReader creates a new event reader for reading events from a NATS
+// JetStream stream and writing them to the given Writer. It reads until the
+// context is canceled or Close is called.
+func NewNATSReader(ctx context.Context, conf *config.NATS, w Writer) (*NATSReader, error) {
+	if conf.Consumer == "" {
+		return nil, fmt.Errorf("reading NATS events requires a durable consumer")
+	}
+
+	nc, js, err := newJetStream(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+	cons, err := js.CreateOrUpdateConsumer(ctx, conf.Stream, jetstream.ConsumerConfig{
+		Durable:       conf.Consumer,
+		FilterSubject: conf.Subject,
+		DeliverPolicy: jetstream.DeliverAllPolicy,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       time.Minute,
+	})
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("creating NATS consumer %s: %v", conf.Consumer, err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	r := &NATSReader{
+		conf:   conf,
+		nc:     nc,
+		cons:   cons,
+		w:      w,
+		log:    logger.NewLogger("nats", logger.DefaultConfig()),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go r.run(ctx)
+	return r, nil
+}
+
+// run fetches the events in batches until the context is canceled. When an
+// event fails, it and the rest of its batch are redelivered, in order.
+func (r *NATSReader) run(ctx context.Context) {
+	defer close(r.done)
+	defer r.nc.Close()
+
+	for ctx.Err() == nil {
+		batch, err := r.cons.Fetch(100, jetstream.FetchMaxWait(time.Second))
+		if err != nil {
+			r.log.Error("reading NATS events", "error", err)
+			sleep(ctx, time.Second)
+			continue
+		}
+
+		var failed error
+		for msg := range batch.Messages() {
+			if failed != nil {
+				msg.Nak()
+				continue
+			}
+			err := writeMessage(ctx, r.w, msg.Data())
+			var rejected *rejectedError
+			switch {
+			case errors.As(err, &rejected):
+				r.log.Error("dropping NATS event", "error", err, "subject", msg.Subject())
+				msg.Term()
+			case err != nil:
+				failed = err
+				msg.Nak()
+			default:
+				msg.Ack()
+			}
+		}
+		if err := batch.Error(); err != nil && !errors.Is(err, nats.ErrTimeout) && ctx.Err() == nil {
+			r.log.Error("reading NATS events", "error", err)
+		}
+		if failed != nil && ctx.Err() == nil {
+			r.log.Error("writing NATS event", "error", failed)
+			sleep(ctx, time.Second)
+		}
+	}
+}
+
+// Close stops reading.
+func (r *NATSReader) Close() {
+	r.cancel()
+	<-r.done
+}
+
+// sleep waits for the duration, or until the context is canceled.
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
@@ -0,0 +1,105 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	natsserver "github.com/nats-io/nats-server/v2/server"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/tes"
+)
+
+func runNATSServer(t *testing.T) string {
+	s, err := natsserver.NewServer(&natsserver.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(10 * time.Second) {
+		t.Fatal("NATS server not ready")
+	}
+	t.Cleanup(s.Shutdown)
+	return s.ClientURL()
+}
+
+func TestNATS(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	conf := &config.NATS{
+		URL:      runNATSServer(t),
+		Stream:   "FUNNEL",
+		Subject:  "funnel.events",
+		Consumer: "funnel-test",
+	}
+	w, err := NewNATSWriter(ctx, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	running := NewState("task-1", tes.Running)
+	for _, ev := range []*Event{
+		NewState("task-1", tes.Initializing),
+		running,
+		// The stream drops the event published twice.
+		running,
+		NewStdout("task-1", 0, 0, "dropped"),
+		NewState("task-1", tes.Complete),
+		NewSystemLog("task-1", 0, 0, AuditLevel, "audited", nil),
+	} {
+		if err := w.WriteEvent(ctx, ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := &recordWriter{}
+	r, err := NewNATSReader(ctx, conf, rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	states := rec.wait(t, 4)
+	if states[1] != tes.Running || states[2] != tes.Complete || states[3] != tes.Unknown {
+		t.Errorf("unexpected states: %v", states)
+	}
+}
+
+// recordWriter records the states written. The other events are recorded
+// as UNKNOWN.
+type recordWriter struct {
+	Noop
+	mtx    sync.Mutex
+	states []tes.State
+}
+
+func (w *recordWriter) WriteEvent(ctx context.Context, ev *Event) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.states = append(w.states, ev.GetState())
+	return nil
+}
+
+// wait returns the states, once n are written.
+func (w *recordWriter) wait(t *testing.T, n int) []tes.State {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		w.mtx.Lock()
+		states := append([]tes.State(nil), w.states...)
+		w.mtx.Unlock()
+		if len(states) >= n {
+			return states
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d events", n)
+	return nil
+}
@@ -4,13 +4,21 @@ import (
 	"time"
 
 	"github.com/ohsu-comp-bio/funnel/tes"
+	"github.com/rs/xid"
 )
 
+// NewEventID returns a new unique event ID. The IDs generated by a process
+// increase, and sort as strings.
+func NewEventID() string {
+	return xid.New().String()
+}
+
 // NewTaskCreated creates a state change event.
 func NewTaskCreated(task *tes.Task) *Event {
 	return &Event{
 		Id:        task.Id,
 		Timestamp: time.Now().Format(time.RFC3339Nano),
+		EventId:   NewEventID(),
 		Type:      Type_TASK_CREATED,
 		Data: &Event_Task{
 			Task: task,
@@ -23,6 +31,7 @@ func NewState(taskID string, s tes.State) *Event {
 	return &Event{
 		Id:        taskID,
 		Timestamp: time.Now().Format(time.RFC3339Nano),
+		EventId:   NewEventID(),
 		Type:      Type_TASK_STATE,
 		Data: &Event_State{
 			State: s,
@@ -35,6 +44,7 @@ func NewStartTime(taskID string, attempt uint32, t time.Time) *Event {
 	return &Event{
 		Id:        taskID,
 		Timestamp: time.Now().Format(time.RFC3339Nano),
+		EventId:   NewEventID(),
 		Type:      Type_TASK_START_TIME,
 		Attempt:   attempt,
 		Data: &Event_StartTime{
@@ -48,6 +58,7 @@ func NewEndTime(taskID string, attempt uint32, t time.Time) *Event {
 	return &Event{
 		Id:        taskID,
 		Timestamp: time.Now().Format(time.RFC3339Nano),
+		EventId:   NewEventID(),
 		Type:      Type_TASK_END_TIME,
 		Attempt:   attempt,
 		Data: &Event_EndTime{
@@ -61,6 +72,7 @@ func NewOutputs(taskID string, attempt uint32, f []*tes.OutputFileLog) *Event {
 	return &Event{
 		Id:        taskID,
 		Timestamp: time.Now().Format(time.RFC3339Nano),
+		EventId:   NewEventID(),
 		Type:      Type_TASK_OUTPUTS,
 		Attempt:   attempt,
 		Data: &Event_Outputs{
@@ -76,6 +88,7 @@ func NewMetadata(taskID string, attempt uint32, m map[string]string) *Event {
 	return &Event{
 		Id:        taskID,
 		Timestamp: time.Now().Format(time.RFC3339Nano),
+		EventId:   NewEventID(),
 		Type:      Type_TASK_METADATA,
 		Attempt:   attempt,
 		Data: &Event_Metadata{
@@ -92,6 +105,7 @@ func NewExecutorStartTime(taskID string, attempt uint32, index uint32, t time.Ti
 	return &Event{
 		Id:        taskID,
 		Timestamp: time.Now().Format(time.RFC3339Nano),
+		EventId:   NewEventID(),
 		Type:      Type_EXECUTOR_START_TIME,
 		Attempt:   attempt,
 		Index:     index,
@@ -107,6 +121,7 @@ func NewExecutorEndTime(taskID string, attempt uint32, index uint32, t time.Time
 	return &Event{
 		Id:        taskID,
 		Timestamp: time.Now().Format(time.RFC3339Nano),
+		EventId:   NewEventID(),
 		Type:      Type_EXECUTOR_END_TIME,
 		Attempt:   attempt,
 		Index:     index,
@@ -122,6 +137,7 @@ func NewExitCode(taskID string, attempt uint32, index uint32, x int32) *Event {
 	return &Event{
 		Id:        taskID,
 		Timestamp: time.Now().Format(time.RFC3339Nano),
+		EventId:   NewEventID(),
 		Type:      Type_EXECUTOR_EXIT_CODE,
 		Attempt:   attempt,
 		Index:     index,
@@ -137,6 +153,7 @@ func NewStdout(taskID string, attempt uint32, index uint32, s string) *Event {
 	return &Event{
 		Id:        taskID,
 		Timestamp: time.Now().Format(time.RFC3339Nano),
+		EventId:   NewEventID(),
 		Type:      Type_EXECUTOR_STDOUT,
 		Attempt:   attempt,
 		Index:     index,
@@ -152,6 +169,7 @@ func NewStderr(taskID string, attempt uint32, index uint32, s string) *Event {
 	return &Event{
 		Id:        taskID,
 		Timestamp: time.Now().Format(time.RFC3339Nano),
+		EventId:   NewEventID(),
 		Type:      Type_EXECUTOR_STDERR,
 		Attempt:   attempt,
 		Index:     index,
@@ -161,11 +179,16 @@ func NewStderr(taskID string, attempt uint32, index uint32, s string) *Event {
 	}
 }
 
+// AuditLevel is the system log level of audit records (see the audit package).
+// Unlike other system logs, these are sent by the Kafka and Pub/Sub writers.
+const AuditLevel = "audit"
+
 // NewSystemLog creates an system log event.
 func NewSystemLog(taskID string, attempt uint32, index uint32, lvl string, msg string, fields map[string]string) *Event {
 	return &Event{
 		Id:        taskID,
 		Timestamp: time.Now().Format(time.RFC3339Nano),
+		EventId:   NewEventID(),
 		Type:      Type_SYSTEM_LOG,
 		Attempt:   attempt,
 		Index:     index,
@@ -188,6 +211,7 @@ func NewResources(taskID string, r *tes.Resources) *Event {
 	return &Event{
 		Id:        taskID,
 		Timestamp: time.Now().Format(time.RFC3339Nano),
+		EventId:   NewEventID(),
 		Type:      Type_TASK_RESOURCES,
 		Data: &Event_Resources{
 			Resources: &Resources{
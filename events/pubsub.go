@@ -19,7 +19,7 @@ type PubSubWriter struct {
 // The given context is used to shut down the Pub/Sub client and flush
 // any buffered messages.
 //
-// Stdout, stderr, and system log events are not sent.
+// Stdout, stderr, and system log events, other than audit records, are not sent.
 func NewPubSubWriter(ctx context.Context, conf *config.PubSub) (*PubSubWriter, error) {
 	opts := []option.ClientOption{}
 	if conf.CredentialsFile != "" {
@@ -52,11 +52,15 @@ func NewPubSubWriter(ctx context.Context, conf *config.PubSub) (*PubSubWriter, e
 
 // WriteEvent writes an event to the configured Pub/Sub topic.
 // Events are buffered and sent in batches by a background routine.
-// Stdout, stderr, and system log events are not sent.
+// Stdout, stderr, and system log events, other than audit records, are not sent.
 func (p *PubSubWriter) WriteEvent(ctx context.Context, ev *Event) error {
 	switch ev.Type {
-	case Type_EXECUTOR_STDOUT, Type_EXECUTOR_STDERR, Type_SYSTEM_LOG:
+	case Type_EXECUTOR_STDOUT, Type_EXECUTOR_STDERR:
 		return nil
+	case Type_SYSTEM_LOG:
+		if ev.GetSystemLog().GetLevel() != AuditLevel {
+			return nil
+		}
 	}
 
 	s, err := Marshal(ev)
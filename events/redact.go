@@ -0,0 +1,91 @@
+package events
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Redacted replaces the secret values redacted by RedactWriter.
+const Redacted = "[REDACTED]"
+
+// RedactWriter is an event writer which replaces secret values, e.g. the
+// secrets injected in the executors of a task, with "[REDACTED]" in the
+// system logs, executor stdout/stderr and task metadata it writes. Events
+// are redacted before they reach any underlying writer, e.g. a database or
+// the Logger.
+type RedactWriter struct {
+	Writer Writer
+	mtx    sync.RWMutex
+	values []string
+}
+
+// Redact adds secret values to redact from the events written afterwards.
+func (w *RedactWriter) Redact(values ...string) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	for _, v := range values {
+		if v != "" {
+			w.values = append(w.values, v)
+		}
+	}
+	// Longest first, so that a secret containing another one is redacted
+	// as a whole.
+	sort.SliceStable(w.values, func(i, j int) bool {
+		return len(w.values[i]) > len(w.values[j])
+	})
+}
+
+// WriteEvent writes the redacted event to the underlying writer.
+func (w *RedactWriter) WriteEvent(ctx context.Context, ev *Event) error {
+	w.mtx.RLock()
+	n := len(w.values)
+	w.mtx.RUnlock()
+	if n == 0 {
+		return w.Writer.WriteEvent(ctx, ev)
+	}
+
+	redact := w.RedactString
+	redactMap := func(m map[string]string) {
+		for k, v := range m {
+			m[k] = redact(v)
+		}
+	}
+
+	switch ev.Type {
+	case Type_SYSTEM_LOG:
+		ev = proto.Clone(ev).(*Event)
+		log := ev.GetSystemLog()
+		log.Msg = redact(log.Msg)
+		redactMap(log.Fields)
+	case Type_EXECUTOR_STDOUT:
+		ev = proto.Clone(ev).(*Event)
+		ev.Data = &Event_Stdout{Stdout: redact(ev.GetStdout())}
+	case Type_EXECUTOR_STDERR:
+		ev = proto.Clone(ev).(*Event)
+		ev.Data = &Event_Stderr{Stderr: redact(ev.GetStderr())}
+	case Type_TASK_METADATA:
+		ev = proto.Clone(ev).(*Event)
+		redactMap(ev.GetMetadata().GetValue())
+	}
+	return w.Writer.WriteEvent(ctx, ev)
+}
+
+// RedactString replaces the secret values in s, e.g. the live output of an
+// executor streamed to an attached client.
+func (w *RedactWriter) RedactString(s string) string {
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+	for _, v := range w.values {
+		s = strings.ReplaceAll(s, v, Redacted)
+	}
+	return s
+}
+
+// Close closes the underlying writer.
+func (w *RedactWriter) Close() {
+	w.Writer.Close()
+}
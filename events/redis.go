@@ -0,0 +1,202 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/logger"
+	"github.com/redis/go-redis/v9"
+)
+
+func newRedisClient(conf *config.Redis) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:     conf.Addr,
+		Username: conf.Username,
+		Password: conf.Password,
+		DB:       int(conf.DB),
+	})
+}
+
+// RedisWriter writes events to a Redis stream.
+type RedisWriter struct {
+	conf   *config.Redis
+	client *redis.Client
+}
+
+// NewRedisWriter creates a new event writer for writing events to a Redis
+// stream.
+func NewRedisWriter(ctx context.Context, conf *config.Redis) (*RedisWriter, error) {
+	client := newRedisClient(conf)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("connecting to Redis: %v", err)
+	}
+	go func() {
+		<-ctx.Done()
+		client.Close()
+	}()
+	return &RedisWriter{conf, client}, nil
+}
+
+// WriteEvent adds the event to the stream, with its message ID, which the
+// readers use to drop the events added twice. The stream keeps about
+// conf.MaxLen entries, if set. Stdout, stderr and system log events, other
+// than audit records, are dropped unless conf.IncludeLogs is set.
+func (r *RedisWriter) WriteEvent(ctx context.Context, ev *Event) error {
+	ev = filterLogs(ev, r.conf.IncludeLogs, r.conf.MaxLogSize)
+	if ev == nil {
+		return nil
+	}
+
+	s, err := Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return r.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: r.conf.Stream,
+		MaxLen: r.conf.MaxLen,
+		Approx: r.conf.MaxLen > 0,
+		Values: map[string]interface{}{
+			"id":    messageID(s),
+			"task":  ev.Id,
+			"event": s,
+		},
+	}).Err()
+}
+
+func (r *RedisWriter) Close() {
+	r.client.Close()
+}
+
+// RedisReader reads events from a Redis stream, as a member of the consumer
+// group conf.ConsumerGroup, and writes them to a Writer.
+//
+// An entry is acknowledged once written, so that a restarted reader resumes
+// with its pending entries. The events which can't be written are dropped.
+type RedisReader struct {
+	conf     *config.Redis
+	client   *redis.Client
+	consumer string
+	seen     *recentIDs
+	w        Writer
+	log      *logger.Logger
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// NewRedisReader creates a new event reader for reading events from a Redis
+// stream and writing them to the given Writer. The consumer group is created
+// if it doesn't exist, and reads the stream from the start. It reads until
+// the context is canceled or Close is called.
+func NewRedisReader(ctx context.Context, conf *config.Redis, w Writer) (*RedisReader, error) {
+	if conf.ConsumerGroup == "" {
+		return nil, fmt.Errorf("reading Redis events requires a consumer group")
+	}
+
+	client := newRedisClient(conf)
+	err := client.XGroupCreateMkStream(ctx, conf.Stream, conf.ConsumerGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		client.Close()
+		return nil, fmt.Errorf("creating Redis consumer group %s: %v", conf.ConsumerGroup, err)
+	}
+
+	consumer, err := os.Hostname()
+	if err != nil {
+		consumer = "funnel"
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	r := &RedisReader{
+		conf:     conf,
+		client:   client,
+		consumer: consumer,
+		seen:     newRecentIDs(10000),
+		w:        w,
+		log:      logger.NewLogger("redis", logger.DefaultConfig()),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	go r.run(ctx)
+	return r, nil
+}
+
+// run reads the stream until the context is canceled. The pending entries
+// of the consumer, delivered but not acknowledged, are read first. When an
+// entry fails, the reader starts again from the pending entries, so that
+// the entries are written in order.
+func (r *RedisReader) run(ctx context.Context) {
+	defer close(r.done)
+	defer r.client.Close()
+
+	start := "0"
+	for ctx.Err() == nil {
+		streams, err := r.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    r.conf.ConsumerGroup,
+			Consumer: r.consumer,
+			Streams:  []string{r.conf.Stream, start},
+			Count:    100,
+			Block:    time.Second,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				r.log.Error("reading Redis events", "error", err)
+				sleep(ctx, time.Second)
+			}
+			continue
+		}
+
+		var msgs []redis.XMessage
+		for _, s := range streams {
+			msgs = append(msgs, s.Messages...)
+		}
+		// Once the pending entries are written, read the new entries.
+		if start == "0" && len(msgs) == 0 {
+			start = ">"
+			continue
+		}
+
+		for _, msg := range msgs {
+			if err := r.handle(ctx, msg); err != nil {
+				if ctx.Err() == nil {
+					r.log.Error("writing Redis event", "error", err, "entry", msg.ID)
+					sleep(ctx, time.Second)
+				}
+				start = "0"
+				break
+			}
+		}
+	}
+}
+
+// handle writes the event of the entry, and acknowledges it. The entries
+// which can't be written are dropped, and the entries already written are
+// skipped.
+func (r *RedisReader) handle(ctx context.Context, msg redis.XMessage) error {
+	id, _ := msg.Values["id"].(string)
+	if !r.seen.contains(id) {
+		data, _ := msg.Values["event"].(string)
+		err := writeMessage(ctx, r.w, []byte(data))
+		var rejected *rejectedError
+		if errors.As(err, &rejected) {
+			r.log.Error("dropping Redis event", "error", err, "entry", msg.ID)
+		} else if err != nil {
+			return err
+		}
+		r.seen.add(id)
+	}
+	return r.client.XAck(ctx, r.conf.Stream, r.conf.ConsumerGroup, msg.ID).Err()
+}
+
+// Close stops reading.
+func (r *RedisReader) Close() {
+	r.cancel()
+	<-r.done
+}
@@ -0,0 +1,64 @@
+package events
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/tes"
+)
+
+func TestRedis(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	conf := &config.Redis{
+		Addr:          miniredis.RunT(t).Addr(),
+		Stream:        "funnel:events",
+		ConsumerGroup: "funnel-test",
+	}
+	w, err := NewRedisWriter(ctx, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	running := NewState("task-1", tes.Running)
+	for _, ev := range []*Event{
+		NewState("task-1", tes.Initializing),
+		running,
+		// The reader drops the event added twice.
+		running,
+		NewStdout("task-1", 0, 0, "dropped"),
+		NewState("task-1", tes.Complete),
+	} {
+		if err := w.WriteEvent(ctx, ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := w.client.XLen(ctx, conf.Stream).Val(); n != 4 {
+		t.Errorf("expected 4 entries, got %d", n)
+	}
+
+	rec := &recordWriter{}
+	r, err := NewRedisReader(ctx, conf, rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	states := rec.wait(t, 3)
+	if states[0] != tes.Initializing || states[1] != tes.Running || states[2] != tes.Complete {
+		t.Errorf("unexpected states: %v", states)
+	}
+
+	// The entries are acknowledged once written.
+	for i := 0; w.client.XPending(ctx, conf.Stream, conf.ConsumerGroup).Val().Count != 0; i++ {
+		if i == 100 {
+			t.Fatal("expected no pending entries")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/ohsu-comp-bio/funnel/util"
@@ -93,6 +94,70 @@ func (s *Event) SysLogString() string {
 	return strings.Join(parts, " ")
 }
 
+// ParseSysLog parses a system log of the task flattened by SysLogString, so
+// that writing the returned event stores the same system log again. It
+// returns false if the string isn't a flattened system log.
+func ParseSysLog(taskID string, s string) (*Event, bool) {
+	values := map[string]string{}
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimLeft(s, " ") {
+		eq := strings.Index(s, "='")
+		if eq <= 0 || strings.Contains(s[:eq], " ") {
+			return nil, false
+		}
+		key := s[:eq]
+		s = s[eq+2:]
+
+		var val strings.Builder
+		closed := false
+		for i := 0; i < len(s); i++ {
+			if s[i] == '\\' && i+1 < len(s) && s[i+1] == '\'' {
+				val.WriteByte('\'')
+				i++
+				continue
+			}
+			if s[i] == '\'' {
+				s = s[i+1:]
+				closed = true
+				break
+			}
+			val.WriteByte(s[i])
+		}
+		if !closed {
+			return nil, false
+		}
+		values[key] = val.String()
+	}
+
+	level, hasLevel := values["level"]
+	msg, hasMsg := values["msg"]
+	if !hasLevel || !hasMsg {
+		return nil, false
+	}
+	ev := NewSystemLog(taskID, 0, 0, level, msg, map[string]string{})
+	for k, v := range values {
+		switch k {
+		case "level", "msg":
+		case "timestamp":
+			ev.Timestamp = v
+		case "task_attempt":
+			n, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				return nil, false
+			}
+			ev.Attempt = uint32(n)
+		case "executor_index":
+			n, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				return nil, false
+			}
+			ev.Index = uint32(n)
+		default:
+			ev.GetSystemLog().Fields[k] = v
+		}
+	}
+	return ev, true
+}
+
 func escape(s string) string {
 	return strings.Replace(s, "'", "\\'", -1)
 }
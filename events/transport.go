@@ -0,0 +1,159 @@
+package events
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/ohsu-comp-bio/funnel/tes"
+	"github.com/ohsu-comp-bio/funnel/util"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// The message transports (Kafka, NATS, Redis and AMQP) share these helpers.
+// The messages of a writer are sent in order, and a reader writes them in
+// order. Each message has an ID derived from its event, so that the
+// messages sent twice, by the retries of a writer, are dropped by the
+// broker or by the reader.
+
+// filterLogs returns the event to send, or nil if it is dropped. Stdout,
+// stderr and system log events, other than audit records, are dropped
+// unless includeLogs is set, and stdout and stderr keep their last maxSize
+// bytes.
+func filterLogs(ev *Event, includeLogs bool, maxSize int64) *Event {
+	switch ev.Type {
+	case Type_EXECUTOR_STDOUT, Type_EXECUTOR_STDERR:
+		if !includeLogs {
+			return nil
+		}
+		return truncateLogs(ev, maxSize)
+	case Type_SYSTEM_LOG:
+		if !includeLogs && ev.GetSystemLog().GetLevel() != AuditLevel {
+			return nil
+		}
+	}
+	return ev
+}
+
+// truncateLogs returns the stdout or stderr event with its last max bytes,
+// at a UTF-8 character boundary.
+func truncateLogs(ev *Event, max int64) *Event {
+	if max <= 0 || int64(len(ev.GetStdout())+len(ev.GetStderr())) <= max {
+		return ev
+	}
+	tail := func(s string) string {
+		s = s[int64(len(s))-max:]
+		for len(s) > 0 && !utf8.RuneStart(s[0]) {
+			s = s[1:]
+		}
+		return s
+	}
+
+	ev = proto.Clone(ev).(*Event)
+	switch d := ev.Data.(type) {
+	case *Event_Stdout:
+		d.Stdout = tail(d.Stdout)
+	case *Event_Stderr:
+		d.Stderr = tail(d.Stderr)
+	}
+	return ev
+}
+
+// messageID returns the ID of the message of a marshaled event. The events
+// have a timestamp, so that two events differ.
+func messageID(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:16])
+}
+
+// rejectedError is the error of a message which can't be written: it can't
+// be decoded, or the writer rejects its event.
+type rejectedError struct {
+	err error
+}
+
+func (e *rejectedError) Error() string {
+	return e.err.Error()
+}
+
+// writeMessage decodes the event of a message and writes it. The writes
+// are retried, unless the writer rejects the event. The error is a
+// *rejectedError if the message can't be written.
+func writeMessage(ctx context.Context, w Writer, data []byte) error {
+	ev := &Event{}
+	if err := Unmarshal(data, ev); err != nil {
+		return &rejectedError{fmt.Errorf("decoding event: %v", err)}
+	}
+
+	retrier := util.NewRetrier()
+	retrier.ShouldRetry = isTransient
+	err := retrier.Retry(ctx, func() error {
+		return w.WriteEvent(ctx, ev)
+	})
+	if err != nil && ctx.Err() == nil && !isTransient(err) {
+		return &rejectedError{err}
+	}
+	return err
+}
+
+// isTransient returns false for the errors of the events which can't be
+// written.
+func isTransient(err error) bool {
+	var transition *tes.TransitionError
+	if errors.As(err, &transition) || err == tes.ErrNotFound || err == tes.ErrNotPermitted {
+		return false
+	}
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.NotFound, codes.PermissionDenied:
+		return false
+	}
+	return true
+}
+
+// recentIDs remembers the IDs of the last messages written by a reader, to
+// drop the messages delivered twice.
+type recentIDs struct {
+	mtx  sync.Mutex
+	ids  map[string]bool
+	ring []string
+	next int
+}
+
+func newRecentIDs(size int) *recentIDs {
+	return &recentIDs{ids: map[string]bool{}, ring: make([]string, size)}
+}
+
+func (r *recentIDs) contains(id string) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return id != "" && r.ids[id]
+}
+
+// add adds the ID, and returns false if it was already there.
+func (r *recentIDs) add(id string) bool {
+	if id == "" {
+		return false
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.ids[id] {
+		return false
+	}
+	delete(r.ids, r.ring[r.next])
+	r.ring[r.next] = id
+	r.ids[id] = true
+	r.next = (r.next + 1) % len(r.ring)
+	return true
+}
+
+func (r *recentIDs) remove(id string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	delete(r.ids, id)
+}
@@ -0,0 +1,490 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/logger"
+	"github.com/ohsu-comp-bio/funnel/tes"
+	"github.com/ohsu-comp-bio/funnel/util"
+	"github.com/ohsu-comp-bio/funnel/util/fsutil"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// WebhookWriter sends events to HTTP endpoints, as signed JSON payloads.
+//
+// The events are sent to the endpoints which match them, and the final
+// states of a task to the callback URL in its conf.CallbackTag tag. Each
+// endpoint, and the callbacks, have a queue of deliveries, sent in order.
+// A delivery is retried with backoff, and written to the dead letter log
+// once it fails all its tries.
+type WebhookWriter struct {
+	conf      *config.Webhook
+	tasks     tes.ReadOnlyServer
+	client    *http.Client
+	retry     util.Retrier
+	endpoints []*webhookQueue
+	callbacks *webhookQueue
+	dead      *webhookDeadLetters
+	log       *logger.Logger
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+// webhookQueue is the queue of deliveries of an endpoint, or of the
+// callbacks.
+type webhookQueue struct {
+	endpoint *config.WebhookEndpoint
+	ch       chan *webhookDelivery
+}
+
+// webhookDelivery is the payload of an event sent to a URL.
+type webhookDelivery struct {
+	url     string
+	secret  string
+	id      string
+	taskID  string
+	payload []byte
+}
+
+// NewWebhookWriter creates a new event writer for sending events to
+// webhooks. The tasks are read from the given server, to filter the events
+// on their tags and to find their callbacks. If it is nil, only the
+// TASK_CREATED events have the tags of their task.
+func NewWebhookWriter(ctx context.Context, conf *config.Webhook, tasks tes.ReadOnlyServer) (*WebhookWriter, error) {
+	retry := util.NewRetrier()
+	if conf.MaxTries > 0 {
+		retry.MaxTries = int(conf.MaxTries)
+	}
+	return newWebhookWriter(ctx, conf, tasks, retry)
+}
+
+func newWebhookWriter(ctx context.Context, conf *config.Webhook, tasks tes.ReadOnlyServer, retry *util.Retrier) (*WebhookWriter, error) {
+	for _, e := range conf.Endpoints {
+		if err := checkWebhookEndpoint(e); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range conf.CallbackStates {
+		if _, ok := tes.State_value[strings.ToUpper(s)]; !ok {
+			return nil, fmt.Errorf("unknown task state in webhook callback states: %s", s)
+		}
+	}
+
+	dead, err := newWebhookDeadLetters(conf.DeadLetterPath)
+	if err != nil {
+		return nil, err
+	}
+
+	retry.ShouldRetry = isRetriedDelivery
+	ctx, cancel := context.WithCancel(ctx)
+	w := &WebhookWriter{
+		conf:   conf,
+		tasks:  tasks,
+		client: &http.Client{
+			Timeout: conf.GetTimeout().AsDuration(),
+			// A redirect could send the payload to a host which isn't
+			// allowed, e.g. one of the callback hosts redirecting to an
+			// internal address: the redirects fail the delivery.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		retry:  *retry,
+		dead:   dead,
+		log:    logger.NewLogger("webhook", logger.DefaultConfig()),
+		cancel: cancel,
+	}
+
+	size := int(conf.QueueSize)
+	if size <= 0 {
+		size = 1000
+	}
+	for _, e := range conf.Endpoints {
+		q := &webhookQueue{endpoint: e, ch: make(chan *webhookDelivery, size)}
+		w.endpoints = append(w.endpoints, q)
+		w.start(ctx, q)
+	}
+	if conf.CallbackTag != "" {
+		w.callbacks = &webhookQueue{ch: make(chan *webhookDelivery, size)}
+		w.start(ctx, w.callbacks)
+	}
+	return w, nil
+}
+
+// checkWebhookEndpoint returns an error if the URL or a filter of the
+// endpoint is invalid.
+func checkWebhookEndpoint(e *config.WebhookEndpoint) error {
+	u, err := url.Parse(e.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook URL: %q", e.URL)
+	}
+	for _, t := range e.Types {
+		if _, ok := Type_value[strings.ToUpper(t)]; !ok {
+			return fmt.Errorf("unknown event type in webhook filter: %s", t)
+		}
+	}
+	for _, s := range e.States {
+		if _, ok := tes.State_value[strings.ToUpper(s)]; !ok {
+			return fmt.Errorf("unknown task state in webhook filter: %s", s)
+		}
+	}
+	return nil
+}
+
+// WriteEvent queues the deliveries of the event. It doesn't wait for them,
+// so that a slow endpoint doesn't slow down the tasks.
+func (w *WebhookWriter) WriteEvent(ctx context.Context, ev *Event) error {
+	var task *tes.Task
+	loaded := false
+	getTask := func() *tes.Task {
+		if !loaded {
+			loaded = true
+			task = w.getTask(ev)
+		}
+		return task
+	}
+
+	var payload []byte
+	var id string
+	deliver := func(q *webhookQueue, url, secret string) {
+		if payload == nil {
+			var err error
+			payload, id, err = webhookPayload(ev, getTask())
+			if err != nil {
+				w.log.Error("encoding webhook payload", "error", err, "taskID", ev.Id)
+				return
+			}
+		}
+		d := &webhookDelivery{url: url, secret: secret, id: id, taskID: ev.Id, payload: payload}
+		select {
+		case q.ch <- d:
+		default:
+			w.deadLetter(d, fmt.Errorf("delivery queue is full"))
+		}
+	}
+
+	for _, q := range w.endpoints {
+		e := q.endpoint
+		if !matchWebhookEvent(e, ev) {
+			continue
+		}
+		if len(e.Tags) > 0 && !matchWebhookTags(e.Tags, getTask()) {
+			continue
+		}
+		deliver(q, e.URL, e.Secret)
+	}
+
+	if w.callbacks != nil && ev.Type == Type_TASK_STATE && w.isCallbackState(ev.GetState()) {
+		if u := getTask().GetTags()[w.conf.CallbackTag]; u != "" {
+			if err := w.checkCallback(u); err != nil {
+				w.log.Error("dropping webhook callback", "error", err, "taskID", ev.Id)
+			} else {
+				deliver(w.callbacks, u, w.conf.CallbackSecret)
+			}
+		}
+	}
+	return nil
+}
+
+// getTask returns the task of the event, or nil if it can't be read.
+func (w *WebhookWriter) getTask(ev *Event) *tes.Task {
+	if ev.Type == Type_TASK_CREATED {
+		return ev.GetTask()
+	}
+	if w.tasks == nil {
+		return nil
+	}
+	// The events are written by the system, which can read every task.
+	task, err := w.tasks.GetTask(context.Background(), &tes.GetTaskRequest{
+		Id:   ev.Id,
+		View: tes.View_BASIC.String(),
+	})
+	if err != nil {
+		w.log.Error("reading the task of a webhook event", "error", err, "taskID", ev.Id)
+		return nil
+	}
+	return task
+}
+
+// matchWebhookEvent returns true if the event matches the type and state
+// filters of the endpoint.
+func matchWebhookEvent(e *config.WebhookEndpoint, ev *Event) bool {
+	if len(e.Types) > 0 && !containsFold(e.Types, ev.Type.String()) {
+		return false
+	}
+	if len(e.States) > 0 && (ev.Type != Type_TASK_STATE || !containsFold(e.States, ev.GetState().String())) {
+		return false
+	}
+	return true
+}
+
+// matchWebhookTags returns true if the task has the tags. An empty value
+// matches any value.
+func matchWebhookTags(tags map[string]string, task *tes.Task) bool {
+	if task == nil {
+		return false
+	}
+	for k, v := range tags {
+		tv, ok := task.Tags[k]
+		if !ok || (v != "" && v != tv) {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *WebhookWriter) isCallbackState(s tes.State) bool {
+	return containsFold(w.conf.CallbackStates, s.String())
+}
+
+// checkCallback returns an error unless the callback URL is an HTTP URL of
+// an allowed host.
+func (w *WebhookWriter) checkCallback(s string) error {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid callback URL: %q", s)
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, h := range w.conf.CallbackHosts {
+		h = strings.ToLower(h)
+		if host == h || (strings.HasPrefix(h, "*.") && strings.HasSuffix(host, h[1:])) {
+			return nil
+		}
+	}
+	return fmt.Errorf("callback host %s is not allowed", host)
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookPayload returns the JSON payload of the event and its ID. The
+// payload has the event, and the ID, name, state and tags of its task if
+// known.
+func webhookPayload(ev *Event, task *tes.Task) ([]byte, string, error) {
+	s, err := Marshal(ev)
+	if err != nil {
+		return nil, "", err
+	}
+	p := struct {
+		ID    string          `json:"id"`
+		Event json.RawMessage `json:"event"`
+		Task  json.RawMessage `json:"task,omitempty"`
+	}{ID: messageID(s), Event: json.RawMessage(s)}
+
+	if task != nil {
+		b, err := protojson.Marshal(&tes.Task{
+			Id:           task.Id,
+			Name:         task.Name,
+			State:        task.State,
+			Tags:         task.Tags,
+			CreationTime: task.CreationTime,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		p.Task = b
+	}
+
+	b, err := json.Marshal(p)
+	return b, p.ID, err
+}
+
+// start sends the deliveries of the queue until the writer is closed.
+func (w *WebhookWriter) start(ctx context.Context, q *webhookQueue) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		for {
+			select {
+			case d := <-q.ch:
+				w.send(ctx, d)
+			case <-ctx.Done():
+				// The deliveries left are dead-lettered.
+				for {
+					select {
+					case d := <-q.ch:
+						w.deadLetter(d, fmt.Errorf("webhook writer closed"))
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+// send posts the delivery, with retries. The retrier of a delivery is a
+// copy of the writer's, since a retrier can't be shared.
+func (w *WebhookWriter) send(ctx context.Context, d *webhookDelivery) {
+	retry := w.retry
+	r := &retrier{
+		Retrier: &retry,
+		Writer:  &webhookPoster{client: w.client, d: d},
+	}
+	if err := r.WriteEvent(ctx, nil); err != nil {
+		w.deadLetter(d, err)
+	}
+}
+
+// deadLetter logs the delivery which failed, and writes it to the dead
+// letter log.
+func (w *WebhookWriter) deadLetter(d *webhookDelivery, cause error) {
+	w.log.Error("webhook delivery failed", "error", cause, "url", redactWebhookURL(d.url), "taskID", d.taskID)
+	if err := w.dead.write(d, cause); err != nil {
+		w.log.Error("writing the webhook dead letter log", "error", err)
+	}
+}
+
+// Close stops sending, and dead-letters the deliveries left.
+func (w *WebhookWriter) Close() {
+	w.cancel()
+	w.wg.Wait()
+	w.dead.close()
+}
+
+// webhookPoster posts a delivery. It is the writer wrapped by a retrier,
+// which retries the failed requests.
+type webhookPoster struct {
+	client *http.Client
+	d      *webhookDelivery
+}
+
+// WriteEvent posts the payload of the delivery. The event was encoded in
+// the payload, so the given one is ignored.
+func (p *webhookPoster) WriteEvent(ctx context.Context, _ *Event) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.d.url, bytes.NewReader(p.d.payload))
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "funnel")
+	req.Header.Set("X-Funnel-Delivery", p.d.id)
+	req.Header.Set("X-Funnel-Timestamp", ts)
+	if p.d.secret != "" {
+		req.Header.Set("X-Funnel-Signature", "sha256="+SignWebhook(p.d.secret, ts, p.d.payload))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &webhookStatusError{resp.StatusCode}
+	}
+	return nil
+}
+
+func (p *webhookPoster) Close() {}
+
+// SignWebhook returns the hex HMAC-SHA256 signature of a webhook payload,
+// as sent in the X-Funnel-Signature header. The timestamp of the
+// X-Funnel-Timestamp header is signed with the payload, as
+// "<timestamp>.<payload>", so that the receivers can reject old requests.
+func SignWebhook(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookStatusError is the error of a response which isn't a success.
+type webhookStatusError struct {
+	code int
+}
+
+func (e *webhookStatusError) Error() string {
+	return fmt.Sprintf("webhook responded %d %s", e.code, http.StatusText(e.code))
+}
+
+// isRetriedDelivery returns false for the redirects and the client errors of
+// the endpoint, other than a timeout or too many requests, which fail again.
+func isRetriedDelivery(err error) bool {
+	if e, ok := err.(*webhookStatusError); ok && e.code < 500 {
+		return e.code == http.StatusRequestTimeout || e.code == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// redactWebhookURL returns the URL without its path and query, which may
+// hold a token.
+func redactWebhookURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "<invalid URL>"
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// webhookDeadLetters writes the deliveries which failed as JSON lines to an
+// append-only file. It writes nothing if it has no file.
+type webhookDeadLetters struct {
+	mtx  sync.Mutex
+	file *os.File
+}
+
+func newWebhookDeadLetters(path string) (*webhookDeadLetters, error) {
+	if path == "" {
+		return &webhookDeadLetters{}, nil
+	}
+	if err := fsutil.EnsurePath(path); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &webhookDeadLetters{file: f}, nil
+}
+
+func (l *webhookDeadLetters) write(d *webhookDelivery, cause error) error {
+	if l.file == nil {
+		return nil
+	}
+	b, err := json.Marshal(struct {
+		Time    string          `json:"time"`
+		URL     string          `json:"url"`
+		TaskID  string          `json:"taskID"`
+		Error   string          `json:"error"`
+		Payload json.RawMessage `json:"payload"`
+	}{time.Now().Format(time.RFC3339Nano), d.url, d.taskID, cause.Error(), d.payload})
+	if err != nil {
+		return err
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	_, err = l.file.Write(append(b, '\n'))
+	return err
+}
+
+func (l *webhookDeadLetters) close() {
+	if l.file != nil {
+		l.file.Close()
+	}
+}
@@ -0,0 +1,267 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/tes"
+	"github.com/ohsu-comp-bio/funnel/util"
+)
+
+// webhookServer records the payloads it receives, and fails the requests
+// while fail is positive.
+type webhookServer struct {
+	*httptest.Server
+	mtx      sync.Mutex
+	fail     int
+	payloads []map[string]json.RawMessage
+	sigErr   []string
+}
+
+func newWebhookServer(t *testing.T, secret string) *webhookServer {
+	s := &webhookServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		if s.fail > 0 {
+			s.fail--
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		sig := "sha256=" + SignWebhook(secret, req.Header.Get("X-Funnel-Timestamp"), body)
+		if secret != "" && req.Header.Get("X-Funnel-Signature") != sig {
+			s.sigErr = append(s.sigErr, req.Header.Get("X-Funnel-Signature"))
+		}
+		p := map[string]json.RawMessage{}
+		json.Unmarshal(body, &p)
+		s.payloads = append(s.payloads, p)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// wait returns the payloads, once n are received.
+func (s *webhookServer) wait(t *testing.T, n int) []map[string]json.RawMessage {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		s.mtx.Lock()
+		payloads := append([]map[string]json.RawMessage(nil), s.payloads...)
+		s.mtx.Unlock()
+		if len(payloads) >= n {
+			return payloads
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d payloads", n)
+	return nil
+}
+
+type testTasks struct {
+	tes.ReadOnlyServer
+	tasks map[string]*tes.Task
+}
+
+func (r *testTasks) GetTask(ctx context.Context, req *tes.GetTaskRequest) (*tes.Task, error) {
+	if task, ok := r.tasks[req.Id]; ok {
+		return task, nil
+	}
+	return nil, tes.ErrNotFound
+}
+
+func newTestRetrier() *util.Retrier {
+	r := util.NewRetrier()
+	r.InitialInterval = time.Millisecond
+	r.MaxInterval = time.Millisecond
+	r.MaxTries = 3
+	return r
+}
+
+func TestWebhookWriter(t *testing.T) {
+	ctx := context.Background()
+	all := newWebhookServer(t, "s3cret")
+	done := newWebhookServer(t, "")
+	tagged := newWebhookServer(t, "")
+
+	conf := &config.Webhook{
+		Endpoints: []*config.WebhookEndpoint{
+			{URL: all.URL, Secret: "s3cret"},
+			{URL: done.URL, Types: []string{"TASK_STATE"}, States: []string{"complete"}},
+			{URL: tagged.URL, Types: []string{"TASK_STATE"}, Tags: map[string]string{"project": ""}},
+		},
+	}
+	tasks := &testTasks{tasks: map[string]*tes.Task{
+		"task-1": {Id: "task-1", State: tes.Complete, Tags: map[string]string{"project": "a"}},
+		"task-2": {Id: "task-2", State: tes.Running},
+	}}
+	w, err := newWebhookWriter(ctx, conf, tasks, newTestRetrier())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// The first requests fail, and are retried.
+	all.fail = 2
+	for _, ev := range []*Event{
+		NewState("task-1", tes.Running),
+		NewState("task-1", tes.Complete),
+		NewState("task-2", tes.Running),
+	} {
+		if err := w.WriteEvent(ctx, ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if p := all.wait(t, 3); len(all.sigErr) != 0 {
+		t.Errorf("unexpected signatures: %v", all.sigErr)
+	} else if ev := string(p[2]["event"]); !strings.Contains(ev, "task-2") {
+		t.Errorf("expected the events in order, got %s", ev)
+	}
+
+	p := done.wait(t, 1)
+	if !strings.Contains(string(p[0]["event"]), "COMPLETE") || !strings.Contains(string(p[0]["task"]), `"project":"a"`) {
+		t.Errorf("unexpected payload: %s %s", p[0]["event"], p[0]["task"])
+	}
+	if p := tagged.wait(t, 2); len(p) != 2 {
+		t.Errorf("expected the events of task-1 only, got %d", len(p))
+	}
+}
+
+func TestWebhookCallbacks(t *testing.T) {
+	ctx := context.Background()
+	callback := newWebhookServer(t, "cb")
+	deadPath := filepath.Join(t.TempDir(), "dead.log")
+
+	conf := &config.Webhook{
+		CallbackTag:    "funnel.callback",
+		CallbackHosts:  []string{"127.0.0.1"},
+		CallbackSecret: "cb",
+		CallbackStates: []string{"COMPLETE", "EXECUTOR_ERROR"},
+		DeadLetterPath: deadPath,
+	}
+	tasks := &testTasks{tasks: map[string]*tes.Task{
+		"task-1": {Id: "task-1", Tags: map[string]string{"funnel.callback": callback.URL + "/done"}},
+		"task-2": {Id: "task-2", Tags: map[string]string{"funnel.callback": "http://metadata.internal/"}},
+		"task-3": {Id: "task-3", Tags: map[string]string{"funnel.callback": callback.URL + "/fails"}},
+	}}
+	w, err := newWebhookWriter(ctx, conf, tasks, newTestRetrier())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	callback.fail = 3
+	for _, ev := range []*Event{
+		// task-3 fails its 3 tries.
+		NewState("task-3", tes.ExecutorError),
+		NewState("task-1", tes.Running),
+		NewState("task-1", tes.Complete),
+		// The host of task-2 isn't allowed.
+		NewState("task-2", tes.Complete),
+	} {
+		if err := w.WriteEvent(ctx, ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := callback.wait(t, 1)
+	if len(p) != 1 || !strings.Contains(string(p[0]["event"]), "task-1") || len(callback.sigErr) != 0 {
+		t.Errorf("unexpected callbacks: %v %v", p, callback.sigErr)
+	}
+	w.Close()
+
+	f, err := os.Open(deadPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var dead []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		d := map[string]interface{}{}
+		if err := json.Unmarshal(scanner.Bytes(), &d); err != nil {
+			t.Fatal(err)
+		}
+		dead = append(dead, d)
+	}
+	if len(dead) != 1 || dead[0]["taskID"] != "task-3" || !strings.Contains(dead[0]["error"].(string), "503") {
+		t.Errorf("unexpected dead letters: %v", dead)
+	}
+}
+
+func TestWebhookRedirect(t *testing.T) {
+	ctx := context.Background()
+	internal := newWebhookServer(t, "")
+	redirect := httptest.NewServer(http.RedirectHandler(internal.URL, http.StatusTemporaryRedirect))
+	defer redirect.Close()
+	deadPath := filepath.Join(t.TempDir(), "dead.log")
+
+	conf := &config.Webhook{
+		CallbackTag:    "funnel.callback",
+		CallbackHosts:  []string{"127.0.0.1"},
+		CallbackStates: []string{"COMPLETE"},
+		DeadLetterPath: deadPath,
+	}
+	tasks := &testTasks{tasks: map[string]*tes.Task{
+		"task-1": {Id: "task-1", Tags: map[string]string{"funnel.callback": redirect.URL}},
+	}}
+	w, err := newWebhookWriter(ctx, conf, tasks, newTestRetrier())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := w.WriteEvent(ctx, NewState("task-1", tes.Complete)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The delivery fails without being retried.
+	var b []byte
+	for i := 0; i < 100 && len(b) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		b, _ = os.ReadFile(deadPath)
+	}
+	if !strings.Contains(string(b), "307") {
+		t.Errorf("expected the delivery to be dead-lettered: %s", b)
+	}
+	internal.mtx.Lock()
+	defer internal.mtx.Unlock()
+	if len(internal.payloads) != 0 {
+		t.Error("expected the redirect not to be followed")
+	}
+}
+
+func TestWebhookConfig(t *testing.T) {
+	for _, e := range []*config.WebhookEndpoint{
+		{URL: "ftp://example.com"},
+		{URL: "https://example.com", Types: []string{"TASK_DONE"}},
+		{URL: "https://example.com", States: []string{"FINISHED"}},
+	} {
+		_, err := NewWebhookWriter(context.Background(), &config.Webhook{Endpoints: []*config.WebhookEndpoint{e}}, nil)
+		if err == nil {
+			t.Errorf("expected an error for %v", e)
+		}
+	}
+
+	w := &WebhookWriter{conf: &config.Webhook{CallbackHosts: []string{"hooks.example.com", "*.example.org"}}}
+	for u, ok := range map[string]bool{
+		"https://hooks.example.com/a":  true,
+		"https://a.b.example.org/":     true,
+		"https://example.org/":         false,
+		"https://hooks.example.com.io": false,
+		"file:///etc/passwd":           false,
+	} {
+		if err := w.checkCallback(u); (err == nil) != ok {
+			t.Errorf("%s: unexpected result %v", u, err)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package logs
+
+import (
+	"context"
+
+	"github.com/ohsu-comp-bio/funnel/config"
+	util "github.com/ohsu-comp-bio/funnel/util/rpc"
+	"google.golang.org/grpc"
+)
+
+// RPCWriter writes the chunks of the logs to the database of the server,
+// over gRPC.
+type RPCWriter struct {
+	client LogServiceClient
+	conn   *grpc.ClientConn
+}
+
+// NewRPCWriter returns a new RPCWriter instance.
+func NewRPCWriter(ctx context.Context, conf *config.RPCClient) (*RPCWriter, error) {
+	conn, err := util.Dial(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+	return &RPCWriter{NewLogServiceClient(conn), conn}, nil
+}
+
+// WriteChunk writes the chunk to the server.
+func (r *RPCWriter) WriteChunk(ctx context.Context, c *Chunk) error {
+	_, err := r.client.WriteChunk(ctx, c)
+	return err
+}
+
+// Close closes the connection.
+func (r *RPCWriter) Close() error {
+	return r.conn.Close()
+}
@@ -0,0 +1,136 @@
+package logs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/ohsu-comp-bio/funnel/storage"
+)
+
+// StorageStore stores the chunks of the logs as objects under a storage URL:
+// "<URL>/<task ID>/<attempt>/<executor>/<stdout|stderr>/<offset>", with the
+// offset padded to 20 digits, so that the objects sort by offset.
+type StorageStore struct {
+	Storage storage.Storage
+	URL     string
+}
+
+func (s *StorageStore) dir(key Key) (string, error) {
+	return s.Storage.Join(s.URL, key.Path())
+}
+
+// WriteChunk writes the chunk to an object.
+func (s *StorageStore) WriteChunk(ctx context.Context, c *Chunk) error {
+	dir, err := s.dir(c.Key())
+	if err != nil {
+		return err
+	}
+	url, err := s.Storage.Join(dir, fmt.Sprintf("%020d", c.Offset))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp("", "funnel-log-chunk-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(c.Data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	_, err = s.Storage.Put(ctx, url, f.Name())
+	return err
+}
+
+// storedChunk is a chunk object of a log.
+type storedChunk struct {
+	url    string
+	offset int64
+	size   int64
+}
+
+// list returns the chunk objects of a log, ordered by offset.
+func (s *StorageStore) list(ctx context.Context, key Key) ([]storedChunk, error) {
+	dir, err := s.dir(key)
+	if err != nil {
+		return nil, err
+	}
+	objs, err := s.Storage.List(ctx, dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var chunks []storedChunk
+	for _, o := range objs {
+		offset, err := strconv.ParseInt(path.Base(o.Name), 10, 64)
+		if err != nil {
+			continue
+		}
+		chunks = append(chunks, storedChunk{o.URL, offset, o.Size})
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].offset < chunks[j].offset
+	})
+	return chunks, nil
+}
+
+// ReadChunks downloads the chunks which overlap the range.
+func (s *StorageStore) ReadChunks(ctx context.Context, key Key, offset, size int64) ([]*Chunk, error) {
+	stored, err := s.list(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.MkdirTemp("", "funnel-log-chunks-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	var chunks []*Chunk
+	for i, sc := range stored {
+		if sc.offset+sc.size <= offset || sc.offset >= offset+size {
+			continue
+		}
+		p := filepath.Join(tmp, strconv.Itoa(i))
+		if _, err := s.Storage.Get(ctx, sc.url, p); err != nil {
+			return nil, err
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, &Chunk{
+			Id:       key.ID,
+			Attempt:  key.Attempt,
+			Executor: key.Executor,
+			Stream:   key.Stream,
+			Offset:   sc.offset,
+			Data:     b,
+		})
+	}
+	return chunks, nil
+}
+
+// Size returns the end of the last chunk object.
+func (s *StorageStore) Size(ctx context.Context, key Key) (int64, error) {
+	stored, err := s.list(ctx, key)
+	if err != nil || len(stored) == 0 {
+		return 0, err
+	}
+	last := stored[len(stored)-1]
+	return last.offset + last.size, nil
+}
@@ -0,0 +1,90 @@
+package logs
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/storage"
+)
+
+func TestStorageStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	local, err := storage.NewLocal(&config.LocalStorage{AllowedDirs: []string{dir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &StorageStore{Storage: local, URL: dir + "/logs"}
+	key := Key{ID: "task-1", Executor: 2, Stream: Stream_STDERR}
+
+	size, err := s.Size(ctx, key)
+	if err != nil || size != 0 {
+		t.Fatalf("expected an empty log, got %d %v", size, err)
+	}
+
+	// The writer writes chunks of 4 bytes.
+	w := NewWriter(ctx, s, key, 4, 0)
+	for _, p := range []string{"hello", " ", "world", "!"} {
+		if _, err := w.Write([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("late")); err == nil {
+		t.Error("expected an error for a write after Close")
+	}
+
+	chunks, err := s.ReadChunks(ctx, key, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 3 || chunks[2].Offset != 8 || string(chunks[2].Data) != "rld!" {
+		t.Errorf("unexpected chunks: %v", chunks)
+	}
+
+	resp, err := Read(ctx, s, &ReadLogsRequest{Id: "task-1", Executor: 2, Stream: Stream_STDERR, Offset: 3, Limit: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Data) != "lo wor" || resp.NextOffset != 9 || resp.Size != 12 {
+		t.Errorf("unexpected response: %q %d %d", resp.Data, resp.NextOffset, resp.Size)
+	}
+}
+
+func TestWriterFlushInterval(t *testing.T) {
+	s := &memStore{}
+	key := Key{ID: "task-1"}
+	w := NewWriter(context.Background(), s, key, 1024, 10*time.Millisecond)
+	defer w.Close()
+	w.Write([]byte("partial "))
+
+	// The partial chunk is written at the next flush.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		size, _ := s.Size(context.Background(), key)
+		if size == 8 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the partial chunk to be flushed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	w.Write([]byte("line"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := Read(context.Background(), s, &ReadLogsRequest{Id: "task-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(resp.Data), "partial line") {
+		t.Errorf("unexpected log: %q", resp.Data)
+	}
+}
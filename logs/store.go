@@ -0,0 +1,104 @@
+// Package logs archives the complete stdout and stderr of the executors, in
+// chunks written by the workers, and reads ranges of them.
+package logs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// MaxReadSize is the maximum size in bytes of a range read by ReadLogs.
+const MaxReadSize = 1 << 20
+
+// Key identifies the log of a stream of an executor.
+type Key struct {
+	ID       string
+	Attempt  uint32
+	Executor uint32
+	Stream   Stream
+}
+
+// Key returns the key of the log of the chunk.
+func (c *Chunk) Key() Key {
+	return Key{c.Id, c.Attempt, c.Executor, c.Stream}
+}
+
+// Key returns the key of the log of the request.
+func (r *ReadLogsRequest) Key() Key {
+	return Key{r.Id, r.Attempt, r.Executor, r.Stream}
+}
+
+// Path returns the relative path of the log, e.g. "<task ID>/0/1/stdout".
+func (k Key) Path() string {
+	return fmt.Sprintf("%s/%d/%d/%s", k.ID, k.Attempt, k.Executor, strings.ToLower(k.Stream.String()))
+}
+
+// Store stores the chunks of the logs.
+type Store interface {
+	// WriteChunk stores a chunk. A chunk written again at the same offset,
+	// e.g. by a retried write, replaces the previous one.
+	WriteChunk(ctx context.Context, c *Chunk) error
+	// ReadChunks returns the chunks of a log, ordered by offset, which
+	// overlap the range of size bytes at offset.
+	ReadChunks(ctx context.Context, key Key, offset, size int64) ([]*Chunk, error)
+	// Size returns the size of a log: the end of its last chunk.
+	Size(ctx context.Context, key Key) (int64, error)
+}
+
+// Read reads a range of a log from the store. The range ends at the first
+// missing chunk, if any.
+func Read(ctx context.Context, s Store, req *ReadLogsRequest) (*ReadLogsResponse, error) {
+	key := req.Key()
+	if key.ID == "" {
+		return nil, fmt.Errorf("missing the task ID")
+	}
+	limit := req.Limit
+	if limit <= 0 || limit > MaxReadSize {
+		limit = MaxReadSize
+	}
+
+	size, err := s.Size(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	offset := req.Offset
+	if offset < 0 {
+		offset = size + offset
+		if offset < 0 {
+			offset = 0
+		}
+	}
+	resp := &ReadLogsResponse{Offset: offset, NextOffset: offset, Size: size}
+	if offset >= size {
+		return resp, nil
+	}
+
+	chunks, err := s.ReadChunks(ctx, key, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	for _, c := range chunks {
+		pos := offset + int64(len(data))
+		start := pos - c.Offset
+		if start < 0 {
+			// A missing chunk.
+			break
+		}
+		if start >= int64(len(c.Data)) {
+			continue
+		}
+		end := start + limit - int64(len(data))
+		if end > int64(len(c.Data)) {
+			end = int64(len(c.Data))
+		}
+		data = append(data, c.Data[start:end]...)
+		if int64(len(data)) == limit {
+			break
+		}
+	}
+	resp.Data = data
+	resp.NextOffset = offset + int64(len(data))
+	return resp, nil
+}
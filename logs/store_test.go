@@ -0,0 +1,121 @@
+package logs
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// memStore stores the chunks in memory.
+type memStore struct {
+	mtx    sync.Mutex
+	chunks map[Key]map[int64][]byte
+}
+
+func (m *memStore) WriteChunk(ctx context.Context, c *Chunk) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.chunks == nil {
+		m.chunks = map[Key]map[int64][]byte{}
+	}
+	if m.chunks[c.Key()] == nil {
+		m.chunks[c.Key()] = map[int64][]byte{}
+	}
+	m.chunks[c.Key()][c.Offset] = c.Data
+	return nil
+}
+
+func (m *memStore) ReadChunks(ctx context.Context, key Key, offset, size int64) ([]*Chunk, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	var chunks []*Chunk
+	for o, data := range m.chunks[key] {
+		if o < offset+size && o+int64(len(data)) > offset {
+			chunks = append(chunks, &Chunk{Id: key.ID, Offset: o, Data: data})
+		}
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Offset < chunks[j].Offset })
+	return chunks, nil
+}
+
+func (m *memStore) Size(ctx context.Context, key Key) (int64, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	var size int64
+	for o, data := range m.chunks[key] {
+		if end := o + int64(len(data)); end > size {
+			size = end
+		}
+	}
+	return size, nil
+}
+
+func writeChunks(t *testing.T, s Store, key Key, data ...string) {
+	var offset int64
+	for _, d := range data {
+		c := &Chunk{Id: key.ID, Attempt: key.Attempt, Executor: key.Executor, Stream: key.Stream, Offset: offset, Data: []byte(d)}
+		if err := s.WriteChunk(context.Background(), c); err != nil {
+			t.Fatal(err)
+		}
+		offset += int64(len(d))
+	}
+}
+
+func TestRead(t *testing.T) {
+	ctx := context.Background()
+	s := &memStore{}
+	key := Key{ID: "task-1", Executor: 1}
+	writeChunks(t, s, key, "hello ", "world", "!")
+
+	tests := []struct {
+		offset, limit int64
+		data          string
+		next          int64
+	}{
+		{0, 0, "hello world!", 12},
+		{3, 5, "lo wo", 8},
+		{6, 5, "world", 11},
+		{-6, 0, "world!", 12},
+		{-100, 2, "he", 2},
+		{12, 0, "", 12},
+		{20, 0, "", 20},
+	}
+	for _, tt := range tests {
+		resp, err := Read(ctx, s, &ReadLogsRequest{Id: "task-1", Executor: 1, Offset: tt.offset, Limit: tt.limit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Data) != tt.data || resp.NextOffset != tt.next || resp.Size != 12 {
+			t.Errorf("offset %d, limit %d: unexpected response %q %d %d", tt.offset, tt.limit, resp.Data, resp.NextOffset, resp.Size)
+		}
+	}
+
+	// The other streams have no logs.
+	resp, err := Read(ctx, s, &ReadLogsRequest{Id: "task-1", Executor: 1, Stream: Stream_STDERR})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Data) != 0 || resp.Size != 0 {
+		t.Errorf("expected an empty log, got %v", resp)
+	}
+
+	if _, err := Read(ctx, s, &ReadLogsRequest{}); err == nil {
+		t.Error("expected an error for a missing task ID")
+	}
+}
+
+func TestReadGap(t *testing.T) {
+	s := &memStore{}
+	key := Key{ID: "task-1"}
+	writeChunks(t, s, key, "abc", "def", "ghi")
+	delete(s.chunks[key], 3)
+
+	resp, err := Read(context.Background(), s, &ReadLogsRequest{Id: "task-1", Offset: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Data) != "bc" || resp.NextOffset != 3 || resp.Size != 9 {
+		t.Errorf("expected the range to end at the missing chunk, got %q %d", resp.Data, resp.NextOffset)
+	}
+}
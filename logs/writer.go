@@ -0,0 +1,149 @@
+package logs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/util"
+)
+
+// ChunkWriter writes the chunks of the logs, e.g. a Store.
+type ChunkWriter interface {
+	WriteChunk(ctx context.Context, c *Chunk) error
+}
+
+var errClosed = errors.New("the log writer is closed")
+
+// Writer archives a log in chunks of up to chunkSize bytes. A chunk is
+// written once full, at the next flush interval, or when the writer is
+// closed. The chunks are written in order, in the background: Write only
+// blocks when the chunks are written slower than the log grows.
+type Writer struct {
+	out      ChunkWriter
+	key      Key
+	size     int
+	retrier  *util.Retrier
+	mtx      sync.Mutex
+	buf      []byte
+	offset   int64
+	closed   bool
+	chunks   chan *Chunk
+	stop     chan struct{}
+	finished chan struct{}
+	// The first error of the chunk writes, set before finished is closed.
+	err error
+}
+
+// NewWriter returns a Writer writing the chunks of the log of the key to
+// out. The chunks are also written every interval, if positive.
+func NewWriter(ctx context.Context, out ChunkWriter, key Key, chunkSize int64, interval time.Duration) *Writer {
+	if chunkSize <= 0 {
+		chunkSize = 256 * 1024
+	}
+	retrier := util.NewRetrier()
+	retrier.MaxTries = 3
+	w := &Writer{
+		out:      out,
+		key:      key,
+		size:     int(chunkSize),
+		retrier:  retrier,
+		chunks:   make(chan *Chunk, 16),
+		stop:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	go w.send(ctx)
+	if interval > 0 {
+		go w.tick(interval)
+	}
+	return w
+}
+
+// Write appends p to the log.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.closed {
+		return 0, errClosed
+	}
+	n := len(p)
+	for len(p) > 0 {
+		k := w.size - len(w.buf)
+		if k > len(p) {
+			k = len(p)
+		}
+		w.buf = append(w.buf, p[:k]...)
+		p = p[k:]
+		if len(w.buf) == w.size {
+			w.flush()
+		}
+	}
+	return n, nil
+}
+
+// flush queues the buffered chunk. The lock must be held.
+func (w *Writer) flush() {
+	if len(w.buf) == 0 {
+		return
+	}
+	w.chunks <- &Chunk{
+		Id:       w.key.ID,
+		Attempt:  w.key.Attempt,
+		Executor: w.key.Executor,
+		Stream:   w.key.Stream,
+		Offset:   w.offset,
+		Data:     w.buf,
+	}
+	w.offset += int64(len(w.buf))
+	w.buf = nil
+}
+
+func (w *Writer) tick(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.mtx.Lock()
+			if !w.closed {
+				w.flush()
+			}
+			w.mtx.Unlock()
+		}
+	}
+}
+
+func (w *Writer) send(ctx context.Context) {
+	defer close(w.finished)
+	for c := range w.chunks {
+		err := w.retrier.Retry(ctx, func() error {
+			return w.out.WriteChunk(ctx, c)
+		})
+		if err != nil && w.err == nil {
+			w.err = err
+		}
+	}
+}
+
+// Close writes the last chunk, and waits for the chunks to be written. It
+// returns the first error of the chunk writes, whose chunks are missing from
+// the archive.
+func (w *Writer) Close() error {
+	w.mtx.Lock()
+	if w.closed {
+		w.mtx.Unlock()
+		<-w.finished
+		return w.err
+	}
+	w.flush()
+	w.closed = true
+	close(w.stop)
+	close(w.chunks)
+	w.mtx.Unlock()
+
+	<-w.finished
+	return w.err
+}
@@ -0,0 +1,104 @@
+// Package provenance records how the executors of a task ran, e.g. the
+// resolved image digest and the exact command line, and exports it as an
+// RO-Crate for reproducibility.
+package provenance
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Executor describes how an executor ran. It's recorded in the metadata of
+// the task log, with keys prefixed by "executor.<index>.", e.g.
+// "executor.0.image_digest".
+type Executor struct {
+	// Container runtime, e.g. "docker", "podman", "apptainer", "kubernetes"
+	// or "process".
+	Runtime        string
+	RuntimeVersion string
+	// Image of the task, and its resolved registry digest, e.g. "sha256:...".
+	Image       string
+	ImageDigest string
+	// ID of the image which ran, e.g. the Docker image ID or the digest of
+	// the SIF file.
+	ImageID string
+	// Command line run by the worker, e.g. "docker run ... ubuntu echo hello".
+	CommandLine string
+	// Effective resources, e.g. "cpu_cores": "2" or "memory_limit": "2048m".
+	Resources map[string]string
+}
+
+// Metadata keys of the executor provenance.
+const (
+	runtimeKey        = "runtime"
+	runtimeVersionKey = "runtime_version"
+	imageKey          = "image"
+	imageDigestKey    = "image_digest"
+	imageIDKey        = "image_id"
+	commandLineKey    = "command_line"
+	resourcesPrefix   = "resources."
+)
+
+// Metadata returns the metadata of the executor, without the executor
+// prefix. Empty fields are omitted, so that the provenance may be recorded
+// in several parts, e.g. when the image is pulled, then when it runs.
+func (e *Executor) Metadata() map[string]string {
+	md := map[string]string{}
+	for k, v := range map[string]string{
+		runtimeKey:        e.Runtime,
+		runtimeVersionKey: e.RuntimeVersion,
+		imageKey:          e.Image,
+		imageDigestKey:    e.ImageDigest,
+		imageIDKey:        e.ImageID,
+		commandLineKey:    e.CommandLine,
+	} {
+		if v != "" {
+			md[k] = v
+		}
+	}
+	for k, v := range e.Resources {
+		if v != "" {
+			md[resourcesPrefix+k] = v
+		}
+	}
+	return md
+}
+
+// ParseExecutor returns the provenance of the executor at the given index,
+// from the task log metadata, or nil if none was recorded.
+func ParseExecutor(metadata map[string]string, index int) *Executor {
+	prefix := fmt.Sprintf("executor.%d.", index)
+	e := &Executor{}
+	found := false
+	for k, v := range metadata {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		found = true
+		switch k = strings.TrimPrefix(k, prefix); k {
+		case runtimeKey:
+			e.Runtime = v
+		case runtimeVersionKey:
+			e.RuntimeVersion = v
+		case imageKey:
+			e.Image = v
+		case imageDigestKey:
+			e.ImageDigest = v
+		case imageIDKey:
+			e.ImageID = v
+		case commandLineKey:
+			e.CommandLine = v
+		default:
+			if name := strings.TrimPrefix(k, resourcesPrefix); name != k {
+				if e.Resources == nil {
+					e.Resources = map[string]string{}
+				}
+				e.Resources[name] = v
+			}
+		}
+	}
+	if !found {
+		return nil
+	}
+	return e
+}
@@ -0,0 +1,143 @@
+package provenance
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ohsu-comp-bio/funnel/tes"
+)
+
+func TestExecutorMetadata(t *testing.T) {
+	e := &Executor{
+		Runtime:     "docker",
+		ImageDigest: "sha256:1234",
+		CommandLine: "docker run ubuntu echo hello",
+		Resources:   map[string]string{"cpu_cores": "2"},
+	}
+	md := e.Metadata()
+	expected := map[string]string{
+		"runtime":             "docker",
+		"image_digest":        "sha256:1234",
+		"command_line":        "docker run ubuntu echo hello",
+		"resources.cpu_cores": "2",
+	}
+	if !reflect.DeepEqual(md, expected) {
+		t.Errorf("unexpected metadata: %v", md)
+	}
+
+	prefixed := map[string]string{"other": "x"}
+	for k, v := range md {
+		prefixed["executor.1."+k] = v
+	}
+	if got := ParseExecutor(prefixed, 1); !reflect.DeepEqual(got, e) {
+		t.Errorf("expected %+v, got %+v", e, got)
+	}
+	if got := ParseExecutor(prefixed, 0); got != nil {
+		t.Errorf("expected no provenance, got %+v", got)
+	}
+}
+
+func TestROCrate(t *testing.T) {
+	task := &tes.Task{
+		Id:    "task1",
+		State: tes.ExecutorError,
+		Inputs: []*tes.Input{
+			{Url: "s3://bucket/in.txt", Path: "/data/in.txt"},
+		},
+		Executors: []*tes.Executor{
+			{Image: "ubuntu:24.04", Command: []string{"sort"}, Stdin: "/data/in.txt", Stdout: "/data/out.txt"},
+			{Image: "ghcr.io/org/tool:1.0", Command: []string{"tool"}},
+			{Image: "ubuntu", Command: []string{"never"}},
+		},
+		Logs: []*tes.TaskLog{{
+			StartTime: "2026-01-01T00:00:00Z",
+			EndTime:   "2026-01-01T00:01:00Z",
+			Outputs:   []*tes.OutputFileLog{{Url: "s3://bucket/out.txt", Path: "/data/out.txt", SizeBytes: "42"}},
+			Logs: []*tes.ExecutorLog{
+				{StartTime: "2026-01-01T00:00:01Z", EndTime: "2026-01-01T00:00:30Z"},
+				{StartTime: "2026-01-01T00:00:31Z", EndTime: "2026-01-01T00:00:59Z", ExitCode: 3},
+			},
+			Metadata: map[string]string{
+				"executor.0.runtime":                "docker",
+				"executor.0.runtime_version":        "27.1.1",
+				"executor.0.image":                  "ubuntu:24.04",
+				"executor.0.image_digest":           "sha256:abcd",
+				"executor.0.command_line":           "docker run ubuntu@sha256:abcd sort",
+				"executor.0.resources.cpu_cores":    "2",
+				"executor.1.runtime":                "docker",
+				"executor.1.runtime_version":        "27.1.1",
+				"executor.1.resources.memory_limit": "2048m",
+				"executor.1.image_id":               "sha256:ef01",
+				"executor.1.command_line":           "docker run ghcr.io/org/tool:1.0 tool",
+			},
+		}},
+	}
+
+	crate := ROCrate(task)
+	// The document must be valid JSON.
+	if _, err := json.Marshal(crate); err != nil {
+		t.Fatal(err)
+	}
+	entities := map[string]Entity{}
+	for _, e := range crate.Graph {
+		id := e["@id"].(string)
+		if _, ok := entities[id]; ok {
+			t.Errorf("duplicate entity %s", id)
+		}
+		entities[id] = e
+	}
+
+	root := entities["./"]
+	if root["datePublished"] != "2026-01-01T00:01:00Z" || len(root["hasPart"].([]Entity)) != 2 ||
+		len(root["mentions"].([]Entity)) != 4 {
+		t.Errorf("unexpected root dataset: %v", root)
+	}
+	if a := entities["#task1"]; a["actionStatus"] != "FailedActionStatus" || a["error"] != "EXECUTOR_ERROR" {
+		t.Errorf("unexpected task action: %v", a)
+	}
+
+	a := entities["#task1-executor-0"]
+	if a["actionStatus"] != "CompletedActionStatus" || a["description"] != "docker run ubuntu@sha256:abcd sort" ||
+		a["object"].([]Entity)[0]["@id"] != "s3://bucket/in.txt" || a["result"].([]Entity)[0]["@id"] != "s3://bucket/out.txt" {
+		t.Errorf("unexpected executor action: %v", a)
+	}
+	img := entities["#task1-executor-0-image"]
+	if img["registry"] != "docker.io" || img["name"] != "library/ubuntu" || img["tag"] != "24.04" || img["sha256"] != "abcd" {
+		t.Errorf("unexpected container image: %v", img)
+	}
+	sw := entities["#task1-executor-0-software"]
+	if sw["softwareRequirements"].(Entity)["@id"] != "#runtime-docker-27.1.1" {
+		t.Errorf("unexpected software: %v", sw)
+	}
+	if r := entities["#task1-executor-0-cpu_cores"]; r["value"] != "2" {
+		t.Errorf("unexpected resource usage: %v", r)
+	}
+
+	a = entities["#task1-executor-1"]
+	if a["actionStatus"] != "FailedActionStatus" || a["error"] != "exit code 3" {
+		t.Errorf("unexpected executor action: %v", a)
+	}
+	img = entities["#task1-executor-1-image"]
+	if img["registry"] != "ghcr.io" || img["name"] != "org/tool" || img["identifier"] != "sha256:ef01" {
+		t.Errorf("unexpected container image: %v", img)
+	}
+	if a := entities["#task1-executor-2"]; a["actionStatus"] != "PotentialActionStatus" || a["startTime"] != nil {
+		t.Errorf("unexpected executor action: %v", a)
+	}
+}
+
+func TestParseImage(t *testing.T) {
+	for image, expected := range map[string]string{
+		"ubuntu":                       "docker.io library/ubuntu ",
+		"biocontainers/samtools:1.9":   "docker.io biocontainers/samtools 1.9",
+		"localhost:5000/tool":          "localhost:5000 tool ",
+		"ghcr.io/org/tool:1.0@sha256:": "ghcr.io org/tool 1.0",
+	} {
+		registry, name, tag := parseImage(image)
+		if got := strings.Join([]string{registry, name, tag}, " "); got != expected {
+			t.Errorf("parseImage(%s): expected %q, got %q", image, expected, got)
+		}
+	}
+}
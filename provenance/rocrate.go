@@ -0,0 +1,308 @@
+package provenance
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ohsu-comp-bio/funnel/tes"
+)
+
+// RO-Crate and Workflow Run Crate identifiers.
+// See https://www.researchobject.org/ro-crate/ and
+// https://www.researchobject.org/workflow-run-crate/
+const (
+	roCrateSpec        = "https://w3id.org/ro/crate/1.1"
+	roCrateContext     = "https://w3id.org/ro/crate/1.1/context"
+	workflowRunContext = "https://w3id.org/ro/terms/workflow-run/context"
+	workflowRunTerms   = "https://w3id.org/ro/terms/workflow-run#"
+	processRunCrate    = "https://w3id.org/ro/wfrun/process/0.5"
+)
+
+// Entity is an entity of an RO-Crate graph, e.g. a file or an action.
+type Entity map[string]interface{}
+
+func ref(id string) Entity {
+	return Entity{"@id": id}
+}
+
+// Crate is an RO-Crate metadata document, i.e. ro-crate-metadata.json.
+type Crate struct {
+	Context interface{} `json:"@context"`
+	Graph   []Entity    `json:"@graph"`
+}
+
+// ROCrate returns the RO-Crate of the last attempt of a task, following the
+// Process Run Crate profile. The task is a CreateAction which reads the task
+// inputs and creates the task outputs, and each executor is a CreateAction
+// with its command line, container image, runtime and resources.
+//
+// The task must have the FULL view, which includes the task logs.
+func ROCrate(task *tes.Task) *Crate {
+	log := &tes.TaskLog{}
+	if n := len(task.GetLogs()); n > 0 {
+		log = task.Logs[n-1]
+	}
+
+	c := &Crate{Context: []string{roCrateContext, workflowRunContext}}
+	ids := map[string]bool{}
+	add := func(e Entity) {
+		if id := e["@id"].(string); !ids[id] {
+			ids[id] = true
+			c.Graph = append(c.Graph, e)
+		}
+	}
+
+	name := task.GetName()
+	if name == "" {
+		name = "Task " + task.GetId()
+	}
+	description := task.GetDescription()
+	if description == "" {
+		description = "Provenance of the Funnel task " + task.GetId()
+	}
+	published := log.GetEndTime()
+	if published == "" {
+		published = task.GetCreationTime()
+	}
+	root := Entity{
+		"@id":           "./",
+		"@type":         "Dataset",
+		"name":          name,
+		"description":   description,
+		"identifier":    task.GetId(),
+		"datePublished": published,
+		"conformsTo":    ref(processRunCrate),
+	}
+	add(Entity{
+		"@id":        "ro-crate-metadata.json",
+		"@type":      "CreativeWork",
+		"conformsTo": ref(roCrateSpec),
+		"about":      ref("./"),
+	})
+	add(root)
+	add(Entity{
+		"@id":     processRunCrate,
+		"@type":   "CreativeWork",
+		"name":    "Process Run Crate",
+		"version": "0.5",
+	})
+
+	// Files, keyed by container path.
+	var parts, inputs, outputs []Entity
+	files := map[string]Entity{}
+	for i, in := range task.GetInputs() {
+		f := Entity{"@id": in.GetUrl(), "@type": fileType(in.GetType()), "alternateName": in.GetPath()}
+		if in.GetUrl() == "" {
+			f["@id"] = fmt.Sprintf("#%s-input-%d", task.GetId(), i)
+		}
+		if in.GetName() != "" {
+			f["name"] = in.GetName()
+		}
+		if in.GetDescription() != "" {
+			f["description"] = in.GetDescription()
+		}
+		inputs = append(inputs, ref(f["@id"].(string)))
+		files[in.GetPath()] = f
+		add(f)
+	}
+	for _, out := range log.GetOutputs() {
+		f := Entity{"@id": out.GetUrl(), "@type": "File", "alternateName": out.GetPath()}
+		if out.GetSizeBytes() != "" {
+			f["contentSize"] = out.GetSizeBytes()
+		}
+		outputs = append(outputs, ref(out.GetUrl()))
+		files[out.GetPath()] = f
+		add(f)
+	}
+	parts = append(parts, inputs...)
+	parts = append(parts, outputs...)
+
+	taskAction := Entity{
+		"@id":          "#" + task.GetId(),
+		"@type":        "CreateAction",
+		"name":         name,
+		"actionStatus": taskStatus(task.GetState()),
+	}
+	setTimes(taskAction, log.GetStartTime(), log.GetEndTime())
+	setRefs(taskAction, "object", inputs)
+	setRefs(taskAction, "result", outputs)
+	if status := taskAction["actionStatus"]; status == "FailedActionStatus" {
+		taskAction["error"] = task.GetState().String()
+	}
+	add(taskAction)
+	mentions := []Entity{ref("#" + task.GetId())}
+
+	for i, ex := range task.GetExecutors() {
+		id := fmt.Sprintf("#%s-executor-%d", task.GetId(), i)
+		p := ParseExecutor(log.GetMetadata(), i)
+		if p == nil {
+			p = &Executor{}
+		}
+
+		software := Entity{
+			"@id":   id + "-software",
+			"@type": "SoftwareApplication",
+			"name":  strings.Join(ex.GetCommand(), " "),
+		}
+		action := Entity{
+			"@id":        id,
+			"@type":      "CreateAction",
+			"name":       fmt.Sprintf("Executor %d of task %s", i, task.GetId()),
+			"instrument": ref(id + "-software"),
+		}
+		if p.CommandLine != "" {
+			action["description"] = p.CommandLine
+		}
+
+		if p.Runtime != "" {
+			runtimeID := "#runtime-" + p.Runtime
+			runtime := Entity{"@id": runtimeID, "@type": "SoftwareApplication", "name": p.Runtime}
+			if p.RuntimeVersion != "" {
+				runtimeID += "-" + p.RuntimeVersion
+				runtime["@id"] = runtimeID
+				runtime["softwareVersion"] = p.RuntimeVersion
+			}
+			software["softwareRequirements"] = ref(runtimeID)
+			add(runtime)
+		}
+		add(software)
+
+		image := p.Image
+		if image == "" {
+			image = ex.GetImage()
+		}
+		if image != "" && p.Runtime != "process" {
+			action["containerImage"] = ref(id + "-image")
+			add(containerImage(id+"-image", image, p))
+		}
+
+		var usage []Entity
+		for _, k := range sortedKeys(p.Resources) {
+			usage = append(usage, ref(id+"-"+k))
+			add(Entity{"@id": id + "-" + k, "@type": "PropertyValue", "name": k, "value": p.Resources[k]})
+		}
+		setRefs(action, "resourceUsage", usage)
+
+		// Stdio files of the executor, if they're task inputs or outputs.
+		if f, ok := files[ex.GetStdin()]; ok && ex.GetStdin() != "" {
+			action["object"] = []Entity{ref(f["@id"].(string))}
+		}
+		var results []Entity
+		for _, path := range []string{ex.GetStdout(), ex.GetStderr()} {
+			if f, ok := files[path]; ok && path != "" {
+				results = append(results, ref(f["@id"].(string)))
+			}
+		}
+		setRefs(action, "result", results)
+
+		action["actionStatus"] = "PotentialActionStatus"
+		if logs := log.GetLogs(); i < len(logs) {
+			el := logs[i]
+			setTimes(action, el.GetStartTime(), el.GetEndTime())
+			switch {
+			case el.GetEndTime() == "":
+				action["actionStatus"] = "ActiveActionStatus"
+			case el.GetExitCode() != 0:
+				action["actionStatus"] = "FailedActionStatus"
+				action["error"] = fmt.Sprintf("exit code %d", el.GetExitCode())
+			default:
+				action["actionStatus"] = "CompletedActionStatus"
+			}
+		}
+		add(action)
+		mentions = append(mentions, ref(id))
+	}
+
+	setRefs(root, "hasPart", parts)
+	root["mentions"] = mentions
+	return c
+}
+
+// containerImage returns the ContainerImage entity of an executor image.
+func containerImage(id, image string, p *Executor) Entity {
+	e := Entity{"@id": id, "@type": "ContainerImage"}
+	if strings.HasSuffix(image, ".sif") || strings.HasPrefix(image, "library://") || strings.HasPrefix(image, "oras://") {
+		e["additionalType"] = ref(workflowRunTerms + "SIFImage")
+		e["name"] = image
+	} else {
+		registry, name, tag := parseImage(image)
+		e["additionalType"] = ref(workflowRunTerms + "DockerImage")
+		e["registry"] = registry
+		e["name"] = name
+		if tag != "" {
+			e["tag"] = tag
+		}
+	}
+	digest := p.ImageDigest
+	if _, d, ok := strings.Cut(image, "@"); ok && digest == "" {
+		digest = d
+	}
+	if hex, ok := strings.CutPrefix(digest, "sha256:"); ok {
+		e["sha256"] = hex
+	}
+	if p.ImageID != "" {
+		e["identifier"] = p.ImageID
+	}
+	return e
+}
+
+// parseImage splits a Docker image reference, e.g. "ghcr.io/org/tool:1.0" or
+// "ubuntu", into its registry, repository and tag.
+func parseImage(image string) (registry, name, tag string) {
+	image, _, _ = strings.Cut(strings.TrimPrefix(image, "docker://"), "@")
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image, tag = image[:i], image[i+1:]
+	}
+	registry = "docker.io"
+	if first, rest, ok := strings.Cut(image, "/"); ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		registry, image = first, rest
+	}
+	if registry == "docker.io" && !strings.Contains(image, "/") {
+		image = "library/" + image
+	}
+	return registry, image, tag
+}
+
+func taskStatus(state tes.State) string {
+	switch state {
+	case tes.Unknown, tes.Queued:
+		return "PotentialActionStatus"
+	case tes.Initializing, tes.Running, tes.Paused:
+		return "ActiveActionStatus"
+	case tes.Complete:
+		return "CompletedActionStatus"
+	}
+	return "FailedActionStatus"
+}
+
+func fileType(t tes.FileType) string {
+	if t == tes.Directory {
+		return "Dataset"
+	}
+	return "File"
+}
+
+func setTimes(e Entity, start, end string) {
+	if start != "" {
+		e["startTime"] = start
+	}
+	if end != "" {
+		e["endTime"] = end
+	}
+}
+
+func setRefs(e Entity, key string, refs []Entity) {
+	if len(refs) > 0 {
+		e[key] = refs
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
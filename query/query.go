@@ -0,0 +1,442 @@
+// Package query parses the task filters of ListTasks, a Funnel extension of
+// the TES API, into a query translated by each database.
+//
+// A filter is a list of terms separated by spaces, which all must match:
+//
+//	state=QUEUED,RUNNING owner=alice created>=2024-01-01 tag.project^=cohort- image~=^ubuntu sort=created
+//
+// Values containing spaces are double-quoted, e.g. tag.name="my project".
+package query
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/tes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Param is the HTTP query parameter of the filter of ListTasks.
+const Param = "filter"
+
+// MetadataKey is the gRPC metadata key of the filter of ListTasks.
+const MetadataKey = "funnel-filter"
+
+// ErrUnsupported is returned by the databases which can't filter tasks.
+var ErrUnsupported = status.Error(codes.Unimplemented, "task filters aren't supported by this database")
+
+// Op is the operator of a string match.
+type Op int
+
+const (
+	// Equal matches the whole value.
+	Equal Op = iota
+	// Prefix matches the start of the value.
+	Prefix
+	// Regex matches a regular expression anywhere in the value. The syntax
+	// of the database applies, which is mostly compatible for simple
+	// expressions.
+	Regex
+)
+
+// Match matches a string: a tag value, or an executor image.
+type Match struct {
+	// Key of the tag, empty for images.
+	Key   string
+	Op    Op
+	Value string
+	re    *regexp.Regexp
+}
+
+// Bound is a bound of a time range.
+type Bound struct {
+	Time      time.Time
+	Inclusive bool
+}
+
+// Range is a time range, open if a bound is nil.
+type Range struct {
+	Min *Bound
+	Max *Bound
+}
+
+// Order is the order of the tasks, by creation time.
+type Order int
+
+const (
+	// Descending lists the most recent tasks first, the default.
+	Descending Order = iota
+	// Ascending lists the oldest tasks first.
+	Ascending
+)
+
+// Query is a parsed filter. A task matches if it matches all the fields set.
+type Query struct {
+	// The task is in one of the states.
+	States []tes.State
+	// The task is owned by one of the users.
+	Owners []string
+	// The task was created in the range.
+	Created Range
+	// An attempt of the task started in the range.
+	Started Range
+	// An attempt of the task ended in the range.
+	Ended Range
+	// The tags of the task match all the matches.
+	Tags []*Match
+	// An executor image of the task matches each match.
+	Images []*Match
+	Order  Order
+	// The task is visible in the scope. It isn't parsed from the filter, but
+	// set by the server for the users with project-scoped roles.
+	Scope *Scope
+}
+
+// Scope restricts the tasks to those owned by a user, or with a tag
+// matching one of the matches.
+type Scope struct {
+	Owner string
+	Tags  []*Match
+}
+
+// Parse parses a filter. An empty filter returns a nil query.
+func Parse(filter string) (*Query, error) {
+	terms, err := split(filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	q := &Query{}
+	for _, term := range terms {
+		if err := q.parseTerm(term); err != nil {
+			return nil, fmt.Errorf("invalid filter term %q: %v", term, err)
+		}
+	}
+	return q, nil
+}
+
+// ops are the operators of the terms, longest first.
+var ops = []string{"<=", ">=", "^=", "~=", "=", "<", ">"}
+
+func (q *Query) parseTerm(term string) error {
+	field, op, value := "", "", ""
+	for i := range term {
+		for _, o := range ops {
+			if strings.HasPrefix(term[i:], o) {
+				field, op, value = term[:i], o, term[i+len(o):]
+				break
+			}
+		}
+		if op != "" {
+			break
+		}
+	}
+	if field == "" {
+		return fmt.Errorf("expected <field><operator><value>")
+	}
+	if strings.HasPrefix(value, `"`) {
+		v, err := strconv.Unquote(value)
+		if err != nil {
+			return fmt.Errorf("unquoting value: %v", err)
+		}
+		value = v
+	}
+
+	switch {
+	case field == "state":
+		if op != "=" {
+			return fmt.Errorf("expected state=STATE[,STATE...]")
+		}
+		for _, s := range strings.Split(value, ",") {
+			v, ok := tes.State_value[strings.ToUpper(s)]
+			if !ok {
+				return fmt.Errorf("unknown state %s", s)
+			}
+			q.States = append(q.States, tes.State(v))
+		}
+
+	case field == "owner":
+		if op != "=" || value == "" {
+			return fmt.Errorf("expected owner=USER[,USER...]")
+		}
+		q.Owners = append(q.Owners, strings.Split(value, ",")...)
+
+	case field == "created":
+		return parseBound(&q.Created, op, value)
+	case field == "started":
+		return parseBound(&q.Started, op, value)
+	case field == "ended":
+		return parseBound(&q.Ended, op, value)
+
+	case strings.HasPrefix(field, "tag."):
+		m, err := parseMatch(op, value)
+		if err != nil {
+			return err
+		}
+		m.Key = strings.TrimPrefix(field, "tag.")
+		if m.Key == "" {
+			return fmt.Errorf("missing tag key")
+		}
+		q.Tags = append(q.Tags, m)
+
+	case field == "image":
+		m, err := parseMatch(op, value)
+		if err != nil {
+			return err
+		}
+		q.Images = append(q.Images, m)
+
+	case field == "sort":
+		switch {
+		case op == "=" && value == "created":
+			q.Order = Ascending
+		case op == "=" && value == "-created":
+			q.Order = Descending
+		default:
+			return fmt.Errorf("expected sort=created or sort=-created")
+		}
+
+	default:
+		return fmt.Errorf("unknown field %s", field)
+	}
+	return nil
+}
+
+func parseMatch(op, value string) (*Match, error) {
+	m := &Match{Value: value}
+	switch op {
+	case "=":
+		m.Op = Equal
+	case "^=":
+		m.Op = Prefix
+	case "~=":
+		m.Op = Regex
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, err
+		}
+		m.re = re
+	default:
+		return nil, fmt.Errorf("expected one of =, ^= or ~=")
+	}
+	return m, nil
+}
+
+func parseBound(r *Range, op, value string) error {
+	t, err := parseTime(value)
+	if err != nil {
+		return err
+	}
+	b := &Bound{Time: t, Inclusive: strings.HasSuffix(op, "=")}
+	switch op {
+	case ">", ">=":
+		if r.Min != nil {
+			return fmt.Errorf("duplicate lower bound")
+		}
+		r.Min = b
+	case "<", "<=":
+		if r.Max != nil {
+			return fmt.Errorf("duplicate upper bound")
+		}
+		r.Max = b
+	default:
+		return fmt.Errorf("expected one of <, <=, > or >=")
+	}
+	return nil
+}
+
+// parseTime parses a RFC 3339 time, or a date in UTC.
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return t, fmt.Errorf("expected a RFC 3339 time or a YYYY-MM-DD date")
+	}
+	return t, nil
+}
+
+// split splits a filter on the spaces outside double quotes.
+func split(filter string) ([]string, error) {
+	var terms []string
+	var term strings.Builder
+	quoted, escaped := false, false
+	for _, r := range filter {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			if term.Len() > 0 {
+				terms = append(terms, term.String())
+				term.Reset()
+			}
+			continue
+		}
+		term.WriteRune(r)
+	}
+	if quoted {
+		return nil, fmt.Errorf("invalid filter: unterminated quote")
+	}
+	if term.Len() > 0 {
+		terms = append(terms, term.String())
+	}
+	return terms, nil
+}
+
+// Empty returns whether the range is open on both sides.
+func (r Range) Empty() bool {
+	return r.Min == nil && r.Max == nil
+}
+
+// Contains returns whether the range contains the time.
+func (r Range) Contains(t time.Time) bool {
+	if r.Min != nil && (t.Before(r.Min.Time) || (!r.Min.Inclusive && t.Equal(r.Min.Time))) {
+		return false
+	}
+	if r.Max != nil && (t.After(r.Max.Time) || (!r.Max.Inclusive && t.Equal(r.Max.Time))) {
+		return false
+	}
+	return true
+}
+
+// containsString returns whether the range contains the time formatted as
+// in the tasks. Tasks without time are never in a range.
+func (r Range) containsString(s string) bool {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	return err == nil && r.Contains(t)
+}
+
+// MatchString returns whether the match matches the value.
+func (m *Match) MatchString(value string) bool {
+	switch m.Op {
+	case Prefix:
+		return strings.HasPrefix(value, m.Value)
+	case Regex:
+		return m.re.MatchString(value)
+	}
+	return value == m.Value
+}
+
+// Match returns whether the task, owned by the user, matches the query. It's
+// used by the databases which scan the tasks.
+func (q *Query) Match(task *tes.Task, owner string) bool {
+	if q == nil {
+		return true
+	}
+	if len(q.States) > 0 && !containsState(q.States, task.GetState()) {
+		return false
+	}
+	if len(q.Owners) > 0 && !containsString(q.Owners, owner) {
+		return false
+	}
+	if !q.Created.Empty() && !q.Created.containsString(task.GetCreationTime()) {
+		return false
+	}
+	if !q.Started.Empty() && !anyLog(task, func(l *tes.TaskLog) bool { return q.Started.containsString(l.GetStartTime()) }) {
+		return false
+	}
+	if !q.Ended.Empty() && !anyLog(task, func(l *tes.TaskLog) bool { return q.Ended.containsString(l.GetEndTime()) }) {
+		return false
+	}
+	for _, m := range q.Tags {
+		v, ok := task.GetTags()[m.Key]
+		if !ok || !m.MatchString(v) {
+			return false
+		}
+	}
+	if q.Scope != nil && !q.Scope.match(task, owner) {
+		return false
+	}
+	for _, m := range q.Images {
+		found := false
+		for _, e := range task.GetExecutors() {
+			if m.MatchString(e.GetImage()) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Scope) match(task *tes.Task, owner string) bool {
+	if owner == s.Owner {
+		return true
+	}
+	for _, m := range s.Tags {
+		if v, ok := task.GetTags()[m.Key]; ok && m.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedsLogs returns whether the query matches the logs of the tasks.
+func (q *Query) NeedsLogs() bool {
+	return q != nil && (!q.Started.Empty() || !q.Ended.Empty())
+}
+
+// Ascending returns whether the oldest tasks are listed first.
+func (q *Query) Ascending() bool {
+	return q != nil && q.Order == Ascending
+}
+
+func anyLog(task *tes.Task, f func(*tes.TaskLog) bool) bool {
+	for _, l := range task.GetLogs() {
+		if f(l) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsState(states []tes.State, state tes.State) bool {
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+type queryKey struct{}
+
+// NewContext returns a context carrying the query of ListTasks, parsed by the
+// server, for the database.
+func NewContext(ctx context.Context, q *Query) context.Context {
+	return context.WithValue(ctx, queryKey{}, q)
+}
+
+// FromContext returns the query of ListTasks, or nil.
+func FromContext(ctx context.Context) *Query {
+	if ctx == nil {
+		return nil
+	}
+	q, _ := ctx.Value(queryKey{}).(*Query)
+	return q
+}
@@ -0,0 +1,123 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/tes"
+)
+
+func TestParse(t *testing.T) {
+	q, err := Parse(`state=queued,RUNNING owner=alice created>=2024-01-01 created<2024-02-01T00:00:00Z tag.project^=cohort- tag.name="my project" image~=^ubuntu sort=created`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q.States) != 2 || q.States[0] != tes.Queued || q.States[1] != tes.Running {
+		t.Errorf("unexpected states: %v", q.States)
+	}
+	if len(q.Owners) != 1 || q.Owners[0] != "alice" {
+		t.Errorf("unexpected owners: %v", q.Owners)
+	}
+	min := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if q.Created.Min == nil || !q.Created.Min.Time.Equal(min) || !q.Created.Min.Inclusive {
+		t.Errorf("unexpected lower bound: %v", q.Created.Min)
+	}
+	if q.Created.Max == nil || q.Created.Max.Inclusive {
+		t.Errorf("unexpected upper bound: %v", q.Created.Max)
+	}
+	if len(q.Tags) != 2 || q.Tags[0].Key != "project" || q.Tags[0].Op != Prefix || q.Tags[1].Value != "my project" {
+		t.Errorf("unexpected tags: %v", q.Tags)
+	}
+	if len(q.Images) != 1 || q.Images[0].Op != Regex {
+		t.Errorf("unexpected images: %v", q.Images)
+	}
+	if !q.Ascending() {
+		t.Error("expected ascending order")
+	}
+
+	if q, err := Parse("  "); q != nil || err != nil {
+		t.Errorf("expected no query, got %v %v", q, err)
+	}
+
+	for _, filter := range []string{
+		"state=DONE",
+		"state>QUEUED",
+		"owner=",
+		"created>yesterday",
+		"created>2024-01-01 created>=2024-01-02",
+		"tag.=x",
+		"tag.x<y",
+		"image~=(",
+		"sort=name",
+		"name=x",
+		"created",
+		`tag.x="unterminated`,
+	} {
+		if _, err := Parse(filter); err == nil {
+			t.Errorf("expected an error for %q", filter)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	task := &tes.Task{
+		State:        tes.Complete,
+		CreationTime: "2024-01-15T10:00:00.123456789+01:00",
+		Tags:         map[string]string{"project": "cohort-1"},
+		Executors:    []*tes.Executor{{Image: "alpine"}, {Image: "ubuntu:22.04"}},
+		Logs: []*tes.TaskLog{
+			{StartTime: "2024-01-15T10:01:00Z", EndTime: "2024-01-15T10:02:00Z"},
+			{StartTime: "2024-01-16T10:01:00Z", EndTime: "2024-01-16T10:02:00Z"},
+		},
+	}
+
+	tests := []struct {
+		filter string
+		match  bool
+	}{
+		{"", true},
+		{"state=COMPLETE,RUNNING", true},
+		{"state=RUNNING", false},
+		{"owner=alice", true},
+		{"owner=bob", false},
+		{"created>=2024-01-15 created<2024-01-16", true},
+		{"created>2024-01-15T09:00:00.123456789Z", false},
+		{"created<=2024-01-15T09:00:00.123456789Z", true},
+		{"started>2024-01-16", true},
+		{"started>2024-01-17", false},
+		{"ended<2024-01-15T10:02:00Z", false},
+		{"ended<=2024-01-15T10:02:00Z", true},
+		{"tag.project=cohort-1", true},
+		{"tag.project^=cohort-", true},
+		{"tag.project~=[0-9]$", true},
+		{"tag.project=cohort", false},
+		{"tag.missing~=.*", false},
+		{"image^=ubuntu", true},
+		{"image=ubuntu", false},
+		{"image=alpine image~=22", true},
+	}
+	for _, tt := range tests {
+		q, err := Parse(tt.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.Match(task, "alice") != tt.match {
+			t.Errorf("expected %q to match: %v", tt.filter, tt.match)
+		}
+	}
+
+	for _, tt := range []struct {
+		scope Scope
+		match bool
+	}{
+		{Scope{Owner: "alice"}, true},
+		{Scope{Owner: "bob"}, false},
+		{Scope{Owner: "bob", Tags: []*Match{{Key: "project", Op: Prefix, Value: "cohort"}}}, true},
+		{Scope{Owner: "bob", Tags: []*Match{{Key: "project", Value: "cohort"}}}, false},
+	} {
+		q := &Query{Scope: &tt.scope}
+		if q.Match(task, "alice") != tt.match {
+			t.Errorf("expected %+v to match: %v", tt.scope, tt.match)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package secrets
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// EncryptedStore stores secrets in a JSON file, encrypted with AES-256-GCM.
+// Each secret is bound to its name, so that encrypted values can't be
+// swapped between secrets. The key is read from a separate file, e.g. a
+// mounted secret, so that the secrets file itself may be shared with the
+// workers or backed up.
+type EncryptedStore struct {
+	Path string
+	aead cipher.AEAD
+}
+
+type encryptedFile struct {
+	// Encrypted values, keyed by name: base64 of the nonce and ciphertext.
+	Secrets map[string]string `json:"secrets"`
+}
+
+// NewEncryptedStore returns the store of the secrets file at path, with the
+// base64 encoded 256-bit key in keyFile.
+func NewEncryptedStore(path, keyFile string) (*EncryptedStore, error) {
+	if path == "" || keyFile == "" {
+		return nil, errors.New("Secrets.Path and Secrets.KeyFile are required by the encrypted backend")
+	}
+	b, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading secrets key: %v", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("secrets key %s must contain 32 bytes, base64 encoded, e.g. the output of \"openssl rand -base64 32\"", keyFile)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedStore{Path: path, aead: aead}, nil
+}
+
+// Get decrypts the secret.
+func (s *EncryptedStore) Get(ctx context.Context, name string) ([]byte, error) {
+	f, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	enc, ok := f.Secrets[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	b, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil || len(b) < s.aead.NonceSize() {
+		return nil, fmt.Errorf("secret %q is corrupted", name)
+	}
+	nonce, ciphertext := b[:s.aead.NonceSize()], b[s.aead.NonceSize():]
+	value, err := s.aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting secret %q: wrong key or corrupted secret", name)
+	}
+	return value, nil
+}
+
+// Put encrypts and stores the secret, replacing any previous value.
+func (s *EncryptedStore) Put(name string, value []byte) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+	f, err := s.read()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := s.aead.Seal(nonce, nonce, value, []byte(name))
+	f.Secrets[name] = base64.StdEncoding.EncodeToString(sealed)
+	return s.write(f)
+}
+
+// Delete removes the secret.
+func (s *EncryptedStore) Delete(name string) error {
+	f, err := s.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := f.Secrets[name]; !ok {
+		return ErrNotFound
+	}
+	delete(f.Secrets, name)
+	return s.write(f)
+}
+
+// List returns the sorted names of the secrets.
+func (s *EncryptedStore) List() ([]string, error) {
+	f, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(f.Secrets))
+	for name := range f.Secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *EncryptedStore) read() (*encryptedFile, error) {
+	f := &encryptedFile{}
+	b, err := os.ReadFile(s.Path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("reading secrets file: %v", err)
+	default:
+		if err := json.Unmarshal(b, f); err != nil {
+			return nil, fmt.Errorf("parsing secrets file %s: %v", s.Path, err)
+		}
+	}
+	if f.Secrets == nil {
+		f.Secrets = map[string]string{}
+	}
+	return f, nil
+}
+
+// write replaces the secrets file atomically, so that workers never read a
+// partial file.
+func (s *EncryptedStore) write(f *encryptedFile) error {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
@@ -0,0 +1,28 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// FileStore reads secrets from a directory with one file per secret, e.g. a
+// mounted Kubernetes secret. The name of a secret is its path in the
+// directory.
+type FileStore struct {
+	Dir string
+}
+
+// Get returns the content of the secret file.
+func (s *FileStore) Get(ctx context.Context, name string) ([]byte, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(filepath.Join(s.Dir, filepath.FromSlash(name)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return b, err
+}
@@ -0,0 +1,74 @@
+// Package secrets contains the stores of the named secrets which tasks
+// reference, e.g. an executor env value "secret://db-password". Tasks only
+// contain the names of the secrets: workers resolve them when the task runs.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ohsu-comp-bio/funnel/config"
+)
+
+// Scheme is the prefix of secret references, e.g. "secret://db-password".
+const Scheme = "secret://"
+
+// The folders of the secrets of a user and of a project, e.g.
+// "user/alice/db-password" and "project/lab-a/tls-key".
+const (
+	UserFolder    = "user/"
+	ProjectFolder = "project/"
+)
+
+// RegistrySecretTag is the task tag which names the registry secret of the
+// task owner, e.g. "user-alice" or "project-lab-a": a Docker config.json file
+// in Worker.RegistrySecretsDir, or a Kubernetes image pull secret.
+const RegistrySecretTag = "_REGISTRY_SECRET"
+
+// ErrNotFound is returned by stores for unknown secrets.
+var ErrNotFound = errors.New("secret not found")
+
+// Store returns the value of a secret by name.
+type Store interface {
+	Get(ctx context.Context, name string) ([]byte, error)
+}
+
+// ParseRef returns the name of the secret referenced by s, e.g.
+// "db-password" for "secret://db-password".
+func ParseRef(s string) (name string, ok bool) {
+	return strings.CutPrefix(s, Scheme)
+}
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.@-]*(/[A-Za-z0-9_][A-Za-z0-9_.@-]*)*$`)
+
+// ValidateName returns an error if the secret name is invalid. Names contain
+// letters, digits, ".", "@", "-" and "_", and may be organized in folders
+// with "/", e.g. "lab/db-password" or "alice@example.org/api-key".
+func ValidateName(name string) error {
+	if !validName.MatchString(name) || strings.Contains(name, "..") {
+		return fmt.Errorf("invalid secret name %q", name)
+	}
+	return nil
+}
+
+// NewStore returns the secret store of the config, or nil if no backend is
+// configured.
+func NewStore(conf *config.Secrets) (Store, error) {
+	switch strings.ToLower(conf.GetBackend()) {
+	case "":
+		return nil, nil
+	case "file":
+		if conf.GetDir() == "" {
+			return nil, errors.New("Secrets.Dir is required by the file backend")
+		}
+		return &FileStore{Dir: conf.GetDir()}, nil
+	case "encrypted":
+		return NewEncryptedStore(conf.GetPath(), conf.GetKeyFile())
+	case "vault":
+		return NewVaultStore(conf.GetVault())
+	}
+	return nil, fmt.Errorf("unknown Secrets.Backend %q. Expected 'file', 'encrypted' or 'vault'", conf.GetBackend())
+}
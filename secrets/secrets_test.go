@@ -0,0 +1,204 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ohsu-comp-bio/funnel/config"
+)
+
+func TestValidateName(t *testing.T) {
+	for _, name := range []string{"db-password", "lab/tls.key", "A_1", "alice@example.org/key"} {
+		if err := ValidateName(name); err != nil {
+			t.Errorf("unexpected error for %q: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "../key", "lab/../key", "/key", "key/", ".key", "a b", "a..b", "@key"} {
+		if err := ValidateName(name); err == nil {
+			t.Errorf("expected error for %q", name)
+		}
+	}
+}
+
+func TestParseRef(t *testing.T) {
+	if name, ok := ParseRef("secret://db-password"); !ok || name != "db-password" {
+		t.Errorf("unexpected ref: %q %v", name, ok)
+	}
+	if _, ok := ParseRef("s3://bucket/key"); ok {
+		t.Error("expected s3 URL not to be a secret ref")
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "lab"), 0700)
+	os.WriteFile(filepath.Join(dir, "lab", "token"), []byte("abc"), 0600)
+	os.WriteFile(filepath.Join(t.TempDir(), "outside"), []byte("x"), 0600)
+
+	s := &FileStore{Dir: dir}
+	v, err := s.Get(ctx, "lab/token")
+	if err != nil || string(v) != "abc" {
+		t.Fatalf("unexpected value: %q %v", v, err)
+	}
+	if _, err := s.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := s.Get(ctx, "../outside"); err == nil {
+		t.Error("expected error for a name outside of the directory")
+	}
+}
+
+func newKey(t *testing.T, b byte) string {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = b
+	}
+	path := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600)
+	return path
+}
+
+func TestEncryptedStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	key := newKey(t, 1)
+
+	s, err := NewEncryptedStore(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("b", []byte("value-b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("a", []byte("value-a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("../a", []byte("x")); err == nil {
+		t.Error("expected error for an invalid name")
+	}
+
+	v, err := s.Get(ctx, "a")
+	if err != nil || string(v) != "value-a" {
+		t.Fatalf("unexpected value: %q %v", v, err)
+	}
+	names, _ := s.List()
+	if !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("unexpected names: %v", names)
+	}
+
+	b, _ := os.ReadFile(path)
+	if !json.Valid(b) {
+		t.Fatal("expected a JSON secrets file")
+	}
+	for _, plain := range []string{"value-a", "value-b"} {
+		if bytes.Contains(b, []byte(plain)) {
+			t.Errorf("secrets file contains the plain value %q", plain)
+		}
+	}
+
+	// Values are bound to their names.
+	f := &encryptedFile{}
+	json.Unmarshal(b, f)
+	f.Secrets["a"], f.Secrets["b"] = f.Secrets["b"], f.Secrets["a"]
+	if err := s.write(f); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, "a"); err == nil {
+		t.Error("expected error for a value swapped between secrets")
+	}
+
+	if err := s.Put("a", []byte("value-a")); err != nil {
+		t.Fatal(err)
+	}
+	wrong, _ := NewEncryptedStore(path, newKey(t, 2))
+	if _, err := wrong.Get(ctx, "a"); err == nil {
+		t.Error("expected error for the wrong key")
+	}
+
+	if err := s.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, "a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if err := s.Delete("a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestEncryptedStoreInvalidKey(t *testing.T) {
+	key := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(key, []byte(base64.StdEncoding.EncodeToString([]byte("short"))), 0600)
+	if _, err := NewEncryptedStore(filepath.Join(t.TempDir(), "secrets.json"), key); err == nil {
+		t.Error("expected error for a short key")
+	}
+}
+
+func TestVaultStore(t *testing.T) {
+	ctx := context.Background()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "tok" || r.Header.Get("X-Vault-Namespace") != "lab" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/kv/data/db":
+			w.Write([]byte(`{"data": {"data": {"value": "pass", "user": "funnel"}}}`))
+		case "/v1/kv/data/single":
+			w.Write([]byte(`{"data": {"data": {"key": "abc"}}}`))
+		case "/v1/kv/data/multi":
+			w.Write([]byte(`{"data": {"data": {"a": "1", "b": "2"}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	conf := &config.Vault{Address: srv.URL + "/", Mount: "kv", Namespace: "lab", Token: "tok"}
+	s, err := NewVaultStore(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.Get(ctx, "db"); err != nil || string(v) != "pass" {
+		t.Errorf("unexpected value: %q %v", v, err)
+	}
+	if v, err := s.Get(ctx, "single"); err != nil || string(v) != "abc" {
+		t.Errorf("unexpected value: %q %v", v, err)
+	}
+	if _, err := s.Get(ctx, "multi"); err == nil {
+		t.Error("expected error for a secret without a value field")
+	}
+	if _, err := s.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(tokenFile, []byte("wrong\n"), 0600)
+	conf.TokenFile = tokenFile
+	if _, err := s.Get(ctx, "db"); err == nil {
+		t.Error("expected error for the token of the token file")
+	}
+}
+
+func TestNewStore(t *testing.T) {
+	s, err := NewStore(&config.Secrets{})
+	if s != nil || err != nil {
+		t.Errorf("expected no store, got %v %v", s, err)
+	}
+	if _, err := NewStore(&config.Secrets{Backend: "file"}); err == nil {
+		t.Error("expected error for a file backend without a directory")
+	}
+	if _, err := NewStore(&config.Secrets{Backend: "other"}); err == nil {
+		t.Error("expected error for an unknown backend")
+	}
+}
@@ -0,0 +1,111 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/config"
+)
+
+// VaultStore reads secrets from a Vault-compatible KV version 2 secrets
+// engine, e.g. HashiCorp Vault, OpenBao or a local Vault agent. The name of a
+// secret is its path in the engine, and its value is the "value" field of
+// the secret, or its only field.
+type VaultStore struct {
+	conf   *config.Vault
+	client *http.Client
+}
+
+// NewVaultStore returns a Vault store. Requests use the Vault token of the
+// config, or the VAULT_TOKEN environment variable.
+func NewVaultStore(conf *config.Vault) (*VaultStore, error) {
+	if conf.GetAddress() == "" {
+		return nil, errors.New("Secrets.Vault.Address is required by the vault backend")
+	}
+	return &VaultStore{conf: conf, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+// Get reads the secret from Vault.
+func (s *VaultStore) Get(ctx context.Context, name string) ([]byte, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+	token, err := s.token()
+	if err != nil {
+		return nil, err
+	}
+	mount := strings.Trim(s.conf.GetMount(), "/")
+	if mount == "" {
+		mount = "secret"
+	}
+	url := fmt.Sprintf("%s/v1/%s/data/%s", strings.TrimSuffix(s.conf.GetAddress(), "/"), mount, name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if s.conf.GetNamespace() != "" {
+		req.Header.Set("X-Vault-Namespace", s.conf.GetNamespace())
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("reading secret %q from vault: %v", name, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, ErrNotFound
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("reading secret %q from vault: %s: %s", name, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	secret := struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}{}
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return nil, fmt.Errorf("parsing secret %q from vault: %v", name, err)
+	}
+	fields := secret.Data.Data
+	value, ok := fields["value"]
+	if !ok && len(fields) == 1 {
+		for _, v := range fields {
+			value, ok = v, true
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("vault secret %q must have a \"value\" field, or a single field", name)
+	}
+	if str, isString := value.(string); isString {
+		return []byte(str), nil
+	}
+	return json.Marshal(value)
+}
+
+// token returns the Vault token, reading TokenFile if it's set, e.g. the
+// token sink of a Vault agent which renews it.
+func (s *VaultStore) token() (string, error) {
+	if s.conf.GetTokenFile() != "" {
+		b, err := os.ReadFile(s.conf.GetTokenFile())
+		if err != nil {
+			return "", fmt.Errorf("reading vault token: %v", err)
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	if s.conf.GetToken() != "" {
+		return s.conf.GetToken(), nil
+	}
+	return os.Getenv("VAULT_TOKEN"), nil
+}
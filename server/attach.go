@@ -0,0 +1,300 @@
+package server
+
+import (
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ohsu-comp-bio/funnel/attach"
+	"github.com/ohsu-comp-bio/funnel/logger"
+	"github.com/ohsu-comp-bio/funnel/tes"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AttachService forwards the attach and exec sessions of the clients to the
+// workers running the tasks. Workers open a stream with Connect while they
+// run a task; the sessions of the task are multiplexed on that stream.
+//
+// Workers are tracked in memory, so the clients must reach the server the
+// worker is connected to.
+type AttachService struct {
+	attach.UnimplementedAttachServiceServer
+	Tasks *TaskService
+	Log   *logger.Logger
+
+	mtx     sync.Mutex
+	workers map[string]*workerStream
+	lastID  uint64
+}
+
+// workerStream is the stream of a worker running a task.
+type workerStream struct {
+	stream   attach.AttachService_ConnectServer
+	sendMtx  sync.Mutex
+	mtx      sync.Mutex
+	sessions map[string]*session
+	// Closed when the worker disconnects.
+	done chan struct{}
+}
+
+// session is an attach or exec session of a client.
+type session struct {
+	id     string
+	frames chan *attach.Frame
+	// Closed when the client leaves.
+	done chan struct{}
+}
+
+// Connect serves the stream of a worker running a task.
+func (s *AttachService) Connect(stream attach.AttachService_ConnectServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	id := first.GetTaskId()
+	if id == "" {
+		return status.Error(codes.InvalidArgument, "the first frame of a worker must hold the task ID")
+	}
+	if err := s.authorizeWorker(stream.Context(), id); err != nil {
+		return err
+	}
+
+	w := &workerStream{
+		stream:   stream,
+		sessions: map[string]*session{},
+		done:     make(chan struct{}),
+	}
+	s.mtx.Lock()
+	if s.workers == nil {
+		s.workers = map[string]*workerStream{}
+	}
+	// The sessions of a task are served by a single worker: another worker
+	// may only connect after the first one disconnected.
+	if s.workers[id] != nil {
+		s.mtx.Unlock()
+		return status.Errorf(codes.AlreadyExists, "a worker of task %s is already connected", id)
+	}
+	s.workers[id] = w
+	s.mtx.Unlock()
+	s.Log.Debug("Worker connected for attach", "taskID", id)
+
+	defer func() {
+		s.mtx.Lock()
+		if s.workers[id] == w {
+			delete(s.workers, id)
+		}
+		s.mtx.Unlock()
+		close(w.done)
+	}()
+
+	for {
+		f, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		w.mtx.Lock()
+		sess := w.sessions[f.Session]
+		w.mtx.Unlock()
+		if sess == nil {
+			continue
+		}
+		select {
+		case sess.frames <- f:
+		case <-sess.done:
+		}
+	}
+}
+
+// Attach streams the live output of an executor to the client.
+func (s *AttachService) Attach(req *attach.AttachRequest, stream attach.AttachService_AttachServer) error {
+	ctx := stream.Context()
+	if err := s.authorize(ctx, req.Id, false); err != nil {
+		return err
+	}
+	w, sess, err := s.open(req.Id, &attach.Frame{Data: &attach.Frame_Attach{Attach: req}})
+	if err != nil {
+		return err
+	}
+	defer w.close(sess)
+	return w.forward(ctx, sess, stream.Send)
+}
+
+// Exec runs a command in an executor, forwarding the stdin and terminal
+// size of the client to the worker.
+func (s *AttachService) Exec(stream attach.AttachService_ExecServer) error {
+	ctx := stream.Context()
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	req := first.GetExec()
+	if req == nil {
+		return status.Error(codes.InvalidArgument, "the first frame of an exec session must hold the ExecRequest")
+	}
+	if rec := auditRecord(ctx); rec != nil {
+		rec.TaskID = req.Id
+	}
+	if len(req.Command) == 0 {
+		return status.Error(codes.InvalidArgument, "the command is empty")
+	}
+	if err := s.authorize(ctx, req.Id, true); err != nil {
+		return err
+	}
+	w, sess, err := s.open(req.Id, first)
+	if err != nil {
+		return err
+	}
+	defer w.close(sess)
+	s.Log.Info("Exec session", "taskID", req.Id, "executor", req.Executor,
+		"user", GetUsername(ctx), "command", req.Command)
+
+	go func() {
+		for {
+			f, err := stream.Recv()
+			if err != nil {
+				// The client closed its side of the stream: close the stdin.
+				if err == io.EOF {
+					w.send(&attach.Frame{Session: sess.id, Data: &attach.Frame_CloseStdin{CloseStdin: true}})
+				}
+				return
+			}
+			switch f.Data.(type) {
+			case *attach.Frame_Stdin, *attach.Frame_CloseStdin, *attach.Frame_Resize:
+				f.Session = sess.id
+				if w.send(f) != nil {
+					return
+				}
+			}
+		}
+	}()
+	return w.forward(ctx, sess, stream.Send)
+}
+
+// authorizeWorker checks that a worker may serve the sessions of the task:
+// workers connect with the credentials of an administrator, unless the
+// server doesn't authenticate its users, and only while the task runs.
+func (s *AttachService) authorizeWorker(ctx context.Context, id string) error {
+	if u := GetUser(ctx); !u.IsPublic && !u.isAdministrator() {
+		return status.Errorf(codes.PermissionDenied, "only the workers may connect to serve the sessions of task %s", id)
+	}
+	task, err := s.Tasks.Read.GetTask(ctx, &tes.GetTaskRequest{Id: id, View: tes.View_MINIMAL.String()})
+	if err == tes.ErrNotFound {
+		return status.Errorf(codes.NotFound, "%v: taskID: %s", err.Error(), id)
+	} else if err != nil {
+		return err
+	}
+	if task.State != tes.Running {
+		return status.Errorf(codes.FailedPrecondition, "task %s is %s, not RUNNING", id, task.State)
+	}
+	return nil
+}
+
+// authorize checks that the user may attach to the task: attaching is
+// authorized like canceling the task, and running commands in it is
+// restricted to the administrators and the owner of the task.
+func (s *AttachService) authorize(ctx context.Context, id string, exec bool) error {
+	task, err := s.Tasks.Read.GetTask(ctx, &tes.GetTaskRequest{Id: id, View: tes.View_BASIC.String()})
+	if err == tes.ErrNotFound {
+		return status.Errorf(codes.NotFound, "%v: taskID: %s", err.Error(), id)
+	} else if err != nil {
+		return err
+	}
+
+	u := GetUser(ctx)
+	allowed := u.HasPermission(PermCancel, task.Tags)
+	if exec {
+		allowed = u.isAdministrator()
+	}
+	if !allowed && !s.Tasks.isOwner(ctx, id) {
+		return status.Errorf(codes.PermissionDenied, "%v: taskID: %s", tes.ErrNotPermitted, id)
+	}
+
+	if task.State != tes.Running {
+		return status.Errorf(codes.FailedPrecondition, "task %s is %s, not RUNNING", id, task.State)
+	}
+	return nil
+}
+
+// open opens a session on the stream of the worker running the task.
+func (s *AttachService) open(id string, open *attach.Frame) (*workerStream, *session, error) {
+	s.mtx.Lock()
+	w := s.workers[id]
+	s.mtx.Unlock()
+	if w == nil {
+		return nil, nil, status.Errorf(codes.Unavailable,
+			"the worker of task %s isn't connected to this server. Is Worker.DisableAttach set?", id)
+	}
+
+	sess := &session{
+		id:     strconv.FormatUint(atomic.AddUint64(&s.lastID, 1), 10),
+		frames: make(chan *attach.Frame, 64),
+		done:   make(chan struct{}),
+	}
+	w.mtx.Lock()
+	w.sessions[sess.id] = sess
+	w.mtx.Unlock()
+
+	open.Session = sess.id
+	if err := w.send(open); err != nil {
+		w.close(sess)
+		return nil, nil, status.Errorf(codes.Unavailable, "opening session: %v", err)
+	}
+	return w, sess, nil
+}
+
+// forward sends the frames of the worker to the client, until the session
+// exits.
+func (w *workerStream) forward(ctx context.Context, sess *session, send func(*attach.Frame) error) error {
+	for {
+		var f *attach.Frame
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case f = <-sess.frames:
+		case <-w.done:
+			// Frames received before the worker disconnected.
+			select {
+			case f = <-sess.frames:
+			default:
+				return status.Error(codes.Unavailable, "the worker disconnected")
+			}
+		}
+		f.Session = ""
+		if err := send(f); err != nil {
+			return err
+		}
+		if f.GetExit() != nil {
+			return nil
+		}
+	}
+}
+
+// send sends a frame to the worker.
+func (w *workerStream) send(f *attach.Frame) error {
+	w.sendMtx.Lock()
+	defer w.sendMtx.Unlock()
+	return w.stream.Send(f)
+}
+
+// close ends the session, and tells the worker to stop it.
+func (w *workerStream) close(sess *session) {
+	w.mtx.Lock()
+	_, ok := w.sessions[sess.id]
+	delete(w.sessions, sess.id)
+	w.mtx.Unlock()
+	if !ok {
+		return
+	}
+	close(sess.done)
+	select {
+	case <-w.done:
+	default:
+		w.send(&attach.Frame{Session: sess.id, Data: &attach.Frame_Exit{Exit: &attach.Exit{}}})
+	}
+}
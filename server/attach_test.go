@@ -0,0 +1,249 @@
+package server
+
+import (
+	"encoding/base64"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/attach"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/tes"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// taskReader returns the tasks by ID.
+type taskReader map[string]*tes.Task
+
+func (r taskReader) GetTask(ctx context.Context, req *tes.GetTaskRequest) (*tes.Task, error) {
+	task, ok := r[req.Id]
+	if !ok {
+		return nil, tes.ErrNotFound
+	}
+	return task, nil
+}
+
+func (r taskReader) ListTasks(ctx context.Context, req *tes.ListTasksRequest) (*tes.ListTasksResponse, error) {
+	return &tes.ListTasksResponse{}, nil
+}
+
+func (r taskReader) Close() {}
+
+// ownedTasks returns the tasks by ID, to the users who may access them.
+type ownedTasks map[string]struct {
+	task  *tes.Task
+	owner string
+}
+
+func (r ownedTasks) GetTask(ctx context.Context, req *tes.GetTaskRequest) (*tes.Task, error) {
+	t, ok := r[req.Id]
+	if !ok || !GetUser(ctx).IsAccessible(t.owner) {
+		return nil, tes.ErrNotFound
+	}
+	return t.task, nil
+}
+
+func (r ownedTasks) ListTasks(ctx context.Context, req *tes.ListTasksRequest) (*tes.ListTasksResponse, error) {
+	return &tes.ListTasksResponse{}, nil
+}
+
+func (r ownedTasks) Close() {}
+
+func newAttachClient(t *testing.T, s *AttachService, opts ...grpc.ServerOption) attach.AttachServiceClient {
+	lis := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer(opts...)
+	attach.RegisterAttachServiceServer(srv, s)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return attach.NewAttachServiceClient(conn)
+}
+
+func TestAttachService(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s := &AttachService{Tasks: &TaskService{Read: taskReader{
+		"running": {Id: "running", State: tes.Running},
+		"queued":  {Id: "queued", State: tes.Queued},
+	}}}
+	client := newAttachClient(t, s)
+
+	// No worker is connected yet.
+	_, err := recvFirst(client.Attach(ctx, &attach.AttachRequest{Id: "running", Executor: -1}))
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("expected Unavailable, got %v", err)
+	}
+
+	// The worker answers the attach sessions with some output.
+	worker, err := client.Connect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worker.Send(&attach.Frame{Data: &attach.Frame_TaskId{TaskId: "running"}})
+	go func() {
+		for {
+			f, err := worker.Recv()
+			if err != nil {
+				return
+			}
+			if f.GetAttach() == nil {
+				continue
+			}
+			worker.Send(&attach.Frame{Session: f.Session, Data: &attach.Frame_Stdout{Stdout: []byte("hello")}})
+			worker.Send(&attach.Frame{Session: f.Session, Data: &attach.Frame_Exit{Exit: &attach.Exit{ExitCode: 3}}})
+		}
+	}()
+	for {
+		s.mtx.Lock()
+		connected := s.workers["running"] != nil
+		s.mtx.Unlock()
+		if connected {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	stream, err := client.Attach(ctx, &attach.AttachRequest{Id: "running", Executor: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := stream.Recv()
+	if err != nil || string(f.GetStdout()) != "hello" || f.Session != "" {
+		t.Errorf("unexpected frame: %v %v", f, err)
+	}
+	f, err = stream.Recv()
+	if err != nil || f.GetExit().GetExitCode() != 3 {
+		t.Errorf("unexpected frame: %v %v", f, err)
+	}
+
+	_, err = recvFirst(client.Attach(ctx, &attach.AttachRequest{Id: "queued", Executor: -1}))
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected FailedPrecondition, got %v", err)
+	}
+	_, err = recvFirst(client.Attach(ctx, &attach.AttachRequest{Id: "missing", Executor: -1}))
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound, got %v", err)
+	}
+}
+
+func recvFirst(stream attach.AttachService_AttachClient, err error) (*attach.Frame, error) {
+	if err != nil {
+		return nil, err
+	}
+	return stream.Recv()
+}
+
+// basicAuth returns a context authenticating the user with the password
+// "abc".
+func basicAuth(ctx context.Context, user string) context.Context {
+	token := base64.StdEncoding.EncodeToString([]byte(user + ":abc"))
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Basic "+token)
+}
+
+func TestAttachAuthorization(t *testing.T) {
+	prevMode := accessMode
+	defer func() { accessMode = prevMode }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	a := NewAuthentication([]*config.BasicCredential{
+		{User: "alice", Password: "abc"},
+		{User: "bob", Password: "abc"},
+		{User: "admin", Password: "abc", Admin: true},
+	}, nil, AccessAll, nil, "")
+	s := &AttachService{Tasks: &TaskService{Read: ownedTasks{
+		"running": {&tes.Task{Id: "running", State: tes.Running}, "alice"},
+		"queued":  {&tes.Task{Id: "queued", State: tes.Queued}, "alice"},
+	}}}
+	client := newAttachClient(t, s, grpc.StreamInterceptor(a.StreamInterceptor))
+
+	// Only the owner and the administrators may run commands, even when all
+	// the users may view the task.
+	exec := func(user string) error {
+		stream, err := client.Exec(basicAuth(ctx, user))
+		if err != nil {
+			return err
+		}
+		stream.Send(&attach.Frame{Data: &attach.Frame_Exec{Exec: &attach.ExecRequest{Id: "running", Command: []string{"sh"}}}})
+		_, err = stream.Recv()
+		return err
+	}
+	if err := exec("bob"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied for another user, got %v", err)
+	}
+	for _, user := range []string{"alice", "admin"} {
+		// No worker is connected.
+		if err := exec(user); status.Code(err) != codes.Unavailable {
+			t.Errorf("expected %s to be authorized, got %v", user, err)
+		}
+	}
+
+	// Attaching is authorized like canceling the task.
+	attachTo := func(user string) error {
+		_, err := recvFirst(client.Attach(basicAuth(ctx, user), &attach.AttachRequest{Id: "running", Executor: -1}))
+		return err
+	}
+	if err := attachTo("bob"); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected bob to be authorized to attach, got %v", err)
+	}
+	setupRoles(t, []*config.RoleBinding{{Role: "viewer", Users: []string{"bob"}}})
+	if err := attachTo("bob"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied for a viewer, got %v", err)
+	}
+	setupRoles(t, []*config.RoleBinding{{Role: "canceller", Users: []string{"bob"}}})
+	if err := attachTo("bob"); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected a canceller to be authorized to attach, got %v", err)
+	}
+	accessMode = AccessAll
+
+	// Only the workers, with the credentials of an administrator, may
+	// connect, while the task runs.
+	connect := func(user, id string) (attach.AttachService_ConnectClient, error) {
+		stream, err := client.Connect(basicAuth(ctx, user))
+		if err != nil {
+			return nil, err
+		}
+		stream.Send(&attach.Frame{Data: &attach.Frame_TaskId{TaskId: id}})
+		return stream, nil
+	}
+	stream, _ := connect("alice", "running")
+	if _, err := stream.Recv(); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied for a user, got %v", err)
+	}
+	stream, _ = connect("admin", "queued")
+	if _, err := stream.Recv(); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected FailedPrecondition for a queued task, got %v", err)
+	}
+
+	// A second worker can't replace the first one.
+	first, _ := connect("admin", "running")
+	for {
+		s.mtx.Lock()
+		connected := s.workers["running"] != nil
+		s.mtx.Unlock()
+		if connected {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stream, _ = connect("admin", "running")
+	if _, err := stream.Recv(); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expected AlreadyExists for a second worker, got %v", err)
+	}
+	first.CloseSend()
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/ohsu-comp-bio/funnel/audit"
+	"github.com/ohsu-comp-bio/funnel/logger"
+	"github.com/ohsu-comp-bio/funnel/plugins/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Methods which are always audited. Other methods are audited only when
+// the authorization plugin made a decision about them.
+var auditedMethods = map[string]bool{
+	"/tes.TaskService/CreateTask":            true,
+	"/tes.TaskService/CancelTask":            true,
+	"/scheduler.SchedulerService/PutNode":    true,
+	"/scheduler.SchedulerService/DeleteNode": true,
+	"/attach.AttachService/Exec":             true,
+}
+
+// Default number of records returned by the audit query endpoint.
+const defaultAuditLimit = 100
+
+type auditContextKey string
+
+var auditKey = auditContextKey("audit-record")
+
+// Return a new interceptor function that writes an audit record for
+// task creation/cancelation, node changes and plugin decisions. This must
+// precede the authentication interceptor, so that rejected requests are
+// audited too.
+func newAuditInterceptor(w audit.Writer, log *logger.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+
+		rec := &audit.Record{
+			Time:     time.Now().UTC(),
+			SourceIP: sourceIP(ctx),
+			Action:   path.Base(info.FullMethod),
+		}
+		resp, err := handler(context.WithValue(ctx, auditKey, rec), req)
+		if !auditedMethods[info.FullMethod] && rec.Plugin == "" {
+			return resp, err
+		}
+
+		rec.Outcome = status.Code(err).String()
+		if err != nil {
+			rec.Error = status.Convert(err).Message()
+		}
+		id := auditID(resp, req)
+		if strings.HasPrefix(info.FullMethod, "/scheduler.") {
+			rec.NodeID = id
+		} else {
+			rec.TaskID = id
+		}
+
+		if werr := w.WriteAudit(ctx, rec); werr != nil {
+			log.Error("failed to write audit record", "action", rec.Action, "error", werr)
+		}
+		return resp, err
+	}
+}
+
+// Return a new stream interceptor function that writes an audit record for
+// the audited streaming methods, i.e. exec sessions. Handlers record the task
+// ID, read from the first message of the stream.
+func newAuditStreamInterceptor(w audit.Writer, log *logger.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+
+		if !auditedMethods[info.FullMethod] {
+			return handler(srv, ss)
+		}
+		ctx := ss.Context()
+		rec := &audit.Record{
+			Time:     time.Now().UTC(),
+			SourceIP: sourceIP(ctx),
+			Action:   path.Base(info.FullMethod),
+		}
+		wrapped := grpc_middleware.WrapServerStream(ss)
+		wrapped.WrappedContext = context.WithValue(ctx, auditKey, rec)
+		err := handler(srv, wrapped)
+
+		rec.Outcome = status.Code(err).String()
+		if err != nil {
+			rec.Error = status.Convert(err).Message()
+		}
+		if werr := w.WriteAudit(ctx, rec); werr != nil {
+			log.Error("failed to write audit record", "action", rec.Action, "error", werr)
+		}
+		return err
+	}
+}
+
+// auditRecord returns the audit record of the current request, if any.
+func auditRecord(ctx context.Context) *audit.Record {
+	rec, _ := ctx.Value(auditKey).(*audit.Record)
+	return rec
+}
+
+// auditUser records the authenticated user in the audit record.
+func auditUser(ctx context.Context) {
+	if rec := auditRecord(ctx); rec != nil {
+		rec.User = GetUsername(ctx)
+	}
+}
+
+// auditPlugin records the decision of the authorization plugin.
+func auditPlugin(ctx context.Context, t proto.Type, resp *proto.JobResponse, err error) {
+	rec := auditRecord(ctx)
+	if rec == nil {
+		return
+	}
+	if resp == nil {
+		rec.Plugin = fmt.Sprintf("%s: %v", t, err)
+		return
+	}
+	rec.Plugin = fmt.Sprintf("%s: code %d", t, resp.Code)
+	if resp.Message != "" {
+		rec.Plugin += ": " + resp.Message
+	}
+}
+
+// auditID returns the task or node ID from the response, e.g. CreateTask,
+// or the request, e.g. CancelTask.
+func auditID(msgs ...interface{}) string {
+	for _, m := range msgs {
+		if v, ok := m.(interface{ GetId() string }); ok && v.GetId() != "" {
+			return v.GetId()
+		}
+	}
+	return ""
+}
+
+// sourceIP returns the address of the client. Requests proxied by the HTTP
+// gateway arrive from the loopback interface, or the in-process listener when
+// TLS is enabled; in that case the client address is the last entry of the
+// X-Forwarded-For header added by the gateway.
+func sourceIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	addr := p.Addr.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	if ip := net.ParseIP(addr); !fromGateway(ctx) && (ip == nil || !ip.IsLoopback()) {
+		return addr
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if fwd := md.Get("x-forwarded-for"); len(fwd) > 0 {
+		hops := strings.Split(fwd[len(fwd)-1], ",")
+		return strings.TrimSpace(hops[len(hops)-1])
+	}
+	return addr
+}
+
+// auditHandler serves audit records to administrators at GET /v1/audit.
+// Records may be filtered by the "user", "task_id", "action", "since" and
+// "until" (RFC 3339) query parameters, and limited by "limit".
+func auditHandler(a *Authentication, r audit.Reader) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			http.Error(w, "Only GET method is supported.", http.StatusMethodNotAllowed)
+			return
+		}
+
+		ctx, err := a.authenticate(httpContext(req))
+		if err != nil {
+			http.Error(w, status.Convert(err).Message(), http.StatusUnauthorized)
+			return
+		}
+		if !GetUser(ctx).isAdministrator() {
+			http.Error(w, errPermissionDenied.Error(), http.StatusForbidden)
+			return
+		}
+
+		q := req.URL.Query()
+		filter := &audit.Filter{
+			User:   q.Get("user"),
+			TaskID: q.Get("task_id"),
+			Action: q.Get("action"),
+			Limit:  defaultAuditLimit,
+		}
+		for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+			if v := q.Get(name); v != "" {
+				if *t, err = time.Parse(time.RFC3339, v); err != nil {
+					http.Error(w, fmt.Sprintf("invalid %s: %v", name, err), http.StatusBadRequest)
+					return
+				}
+			}
+		}
+		if v := q.Get("limit"); v != "" {
+			if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 0 {
+				http.Error(w, "invalid limit: "+v, http.StatusBadRequest)
+				return
+			}
+		}
+
+		records, err := r.ListAudit(ctx, filter)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if records == nil {
+			records = []*audit.Record{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"records": records})
+	}
+}
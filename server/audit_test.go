@@ -0,0 +1,92 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/ohsu-comp-bio/funnel/audit"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/logger"
+	"github.com/ohsu-comp-bio/funnel/tes"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type auditRecorder []*audit.Record
+
+func (a *auditRecorder) WriteAudit(ctx context.Context, r *audit.Record) error {
+	*a = append(*a, r)
+	return nil
+}
+
+func TestAuditInterceptor(t *testing.T) {
+	prevMode := accessMode
+	defer func() { accessMode = prevMode }()
+
+	var records auditRecorder
+	auth := NewAuthentication([]*config.BasicCredential{{User: "alice", Password: "abc"}}, nil, AccessAll, nil, "")
+	chain := func(ctx context.Context, method string, req interface{}, handler grpc.UnaryHandler) error {
+		info := &grpc.UnaryServerInfo{FullMethod: method}
+		_, err := newAuditInterceptor(&records, logger.NewLogger("test", logger.DebugConfig()))(ctx, req, info,
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				return auth.Interceptor(ctx, req, info, handler)
+			})
+		return err
+	}
+
+	// Requests proxied by the HTTP gateway.
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5000}})
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(
+		"authorization", "Basic YWxpY2U6YWJj",
+		"x-forwarded-for", "10.0.0.1, 10.0.3.17",
+	))
+
+	create := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &tes.CreateTaskResponse{Id: "task-1"}, nil
+	}
+	if err := chain(ctx, "/tes.TaskService/CreateTask", &tes.Task{}, create); err != nil {
+		t.Fatal(err)
+	}
+
+	denied := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.PermissionDenied, "Permission denied")
+	}
+	if err := chain(ctx, "/tes.TaskService/CancelTask", &tes.CancelTaskRequest{Id: "task-2"}, denied); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// Not audited, unless the plugin made a decision.
+	get := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &tes.Task{Id: "task-1"}, nil
+	}
+	if err := chain(ctx, "/tes.TaskService/GetTask", &tes.GetTaskRequest{Id: "task-1"}, get); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unauthenticated requests are audited without a user.
+	anon := metadata.NewIncomingContext(context.Background(), metadata.Pairs())
+	if err := chain(anon, "/scheduler.SchedulerService/DeleteNode", &tes.GetTaskRequest{Id: "node-1"}, get); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if len(records) != 3 {
+		t.Fatalf("expected 3 audit records, got %d", len(records))
+	}
+	r := records[0]
+	if r.User != "alice" || r.SourceIP != "10.0.3.17" || r.Action != "CreateTask" ||
+		r.TaskID != "task-1" || r.Outcome != audit.OutcomeOK || r.Time.IsZero() {
+		t.Errorf("unexpected CreateTask record: %+v", r)
+	}
+	r = records[1]
+	if r.User != "alice" || r.TaskID != "task-2" || r.Outcome != "PermissionDenied" || r.Error != "Permission denied" {
+		t.Errorf("unexpected CancelTask record: %+v", r)
+	}
+	r = records[2]
+	if r.User != "" || r.NodeID != "node-1" || r.TaskID != "" || r.Outcome != "Unauthenticated" {
+		t.Errorf("unexpected DeleteNode record: %+v", r)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"os"
 	"strings"
 
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/ohsu-comp-bio/funnel/auth"
 	"github.com/ohsu-comp-bio/funnel/config"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
@@ -18,13 +20,16 @@ import (
 type Authentication struct {
 	admins map[string]bool
 	basic  map[string]string
+	groups map[string][]string
 	oidc   *OidcConfig
+	tokens auth.TokenStore
 }
 
 const (
 	AccessAll          = "All"
 	AccessOwner        = "Owner"
 	AccessOwnerOrAdmin = "OwnerOrAdmin"
+	AccessRoles        = "Roles"
 )
 
 // Extracted info about the current user, which is exposed through Context.
@@ -41,6 +46,16 @@ type UserInfo struct {
 	// In case of OIDC authentication, the provided Bearer token, which can be
 	// used when requesting task input data.
 	Token string
+	// Groups of the user, from the Basic-authentication configuration or the
+	// OIDC groups claim. Used to resolve role bindings.
+	Groups []string
+	// Roles granted directly by the identity provider (OIDC roles claim).
+	Roles []string
+	// Scopes of the API token used to authenticate, if any. Empty if the
+	// token is unrestricted.
+	Scopes []string
+	// Restricts access checks to task ownership (see withOwnerOnly).
+	ownerOnly bool
 }
 
 // Context key type for storing UserInfo.
@@ -73,9 +88,12 @@ func NewAuthentication(
 	creds []*config.BasicCredential,
 	oidc *config.OidcAuth,
 	taskAccess string,
+	bindings []*config.RoleBinding,
+	tag string,
 ) *Authentication {
 	basicCreds := make(map[string]string)
 	adminUsers := make(map[string]bool)
+	userGroups := make(map[string][]string)
 
 	for _, cred := range creds {
 		credBytes := []byte(cred.User + ":" + cred.Password)
@@ -84,21 +102,36 @@ func NewAuthentication(
 		if cred.Admin {
 			adminUsers[cred.User] = true
 		}
+		if len(cred.Groups) > 0 {
+			userGroups[cred.User] = cred.Groups
+		}
 	}
 
-	if taskAccess == AccessAll || taskAccess == AccessOwner || taskAccess == AccessOwnerOrAdmin {
+	if taskAccess == AccessAll || taskAccess == AccessOwner ||
+		taskAccess == AccessOwnerOrAdmin || taskAccess == AccessRoles {
 		accessMode = taskAccess
 	} else if taskAccess == "" {
 		accessMode = AccessAll
 	} else {
 		fmt.Printf("[ERROR] Bad configuration value for Server.TaskAccess (%s). "+
-			"Expected 'All', 'Owner', or 'OwnerOrAdmin'.\n", accessMode)
+			"Expected 'All', 'Owner', 'OwnerOrAdmin', or 'Roles'.\n", taskAccess)
 		os.Exit(1)
 	}
 
+	var err error
+	roleBindings, err = newRoleBindings(bindings)
+	if err != nil {
+		fmt.Printf("[ERROR] %s\n", err)
+		os.Exit(1)
+	}
+	if tag != "" {
+		projectTag = tag
+	}
+
 	return &Authentication{
 		admins: adminUsers,
 		basic:  basicCreds,
+		groups: userGroups,
 		oidc:   initOidcConfig(oidc),
 	}
 }
@@ -110,10 +143,53 @@ func (a *Authentication) Interceptor(
 	info *grpc.UnaryServerInfo,
 	handler grpc.UnaryHandler) (interface{}, error) {
 
+	ctx, err := a.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	auditUser(ctx)
+
+	if err := authorizeMethod(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+// StreamInterceptor authorizes streaming RPCs, like Interceptor.
+func (a *Authentication) StreamInterceptor(
+	srv interface{},
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+
+	ctx, err := a.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	auditUser(ctx)
+
+	if err := authorizeMethod(ctx, info.FullMethod); err != nil {
+		return err
+	}
+
+	wrapped := grpc_middleware.WrapServerStream(ss)
+	wrapped.WrappedContext = ctx
+	return handler(srv, wrapped)
+}
+
+// authenticate checks the credentials in the incoming metadata and returns a
+// context holding the current user.
+func (a *Authentication) authenticate(ctx context.Context) (context.Context, error) {
+	// User identified by a verified client certificate, if any.
+	certUser := a.certUser(ctx)
+
 	// Case when authentication is not required:
 	if len(a.basic) == 0 && a.oidc == nil {
-		ctx = context.WithValue(ctx, UserInfoKey, &publicUserInfo)
-		return handler(ctx, req)
+		if certUser != nil {
+			return context.WithValue(ctx, UserInfoKey, certUser), nil
+		}
+		return context.WithValue(ctx, UserInfoKey, &publicUserInfo), nil
 	}
 
 	md, ok := metadata.FromIncomingContext(ctx)
@@ -123,36 +199,43 @@ func (a *Authentication) Interceptor(
 
 	values := md["authorization"]
 	if len(values) == 0 {
+		if certUser != nil {
+			return context.WithValue(ctx, UserInfoKey, certUser), nil
+		}
 		return nil, errTokenRequired
 	}
 
-	authorized := false
-	authErr := errTokenRequired
 	authorization := values[0]
 
 	if strings.HasPrefix(authorization, "Basic ") {
-		authErr = errInvalidBasicToken
 		username := a.basic[authorization]
-		authorized = username != ""
-
-		if authorized {
-			isAdmin := a.admins[username]
-			ctx = context.WithValue(ctx, UserInfoKey,
-				&UserInfo{Username: username, IsAdmin: isAdmin})
+		if username == "" {
+			return nil, errInvalidBasicToken
+		}
+		isAdmin := a.admins[username]
+		return context.WithValue(ctx, UserInfoKey,
+			&UserInfo{Username: username, IsAdmin: isAdmin, Groups: a.groups[username]}), nil
+	} else if a.tokens != nil && strings.HasPrefix(authorization, "Bearer "+auth.TokenPrefix) {
+		userInfo, err := a.tokenUser(ctx, strings.TrimPrefix(authorization, "Bearer "))
+		if err != nil {
+			return nil, errInvalidAPIToken
 		}
+		return context.WithValue(ctx, UserInfoKey, userInfo), nil
 	} else if a.oidc != nil && strings.HasPrefix(authorization, "Bearer ") {
-		authErr = errInvalidBearerToken
-		if userInfo := a.oidc.Authorize(authorization); userInfo != nil {
-			ctx = context.WithValue(ctx, UserInfoKey, userInfo)
-			authorized = true
+		userInfo := a.oidc.Authorize(authorization)
+		if userInfo == nil {
+			return nil, errInvalidBearerToken
 		}
+		return context.WithValue(ctx, UserInfoKey, userInfo), nil
 	}
 
-	if !authorized {
-		return nil, authErr
-	}
+	return nil, errTokenRequired
+}
 
-	return handler(ctx, req)
+// isAdmin reports whether the username is configured as an administrator,
+// for Basic or OIDC authentication.
+func (a *Authentication) isAdmin(username string) bool {
+	return a.admins[username] || a.oidc != nil && a.oidc.admins[username]
 }
 
 // HTTP request handler for the /login endpoint. Initiates user authentication
@@ -204,12 +287,22 @@ func (a *Authentication) handleBasicAuth(w http.ResponseWriter, req *http.Reques
 // Evaluation depends on configuration (Server.TaskAccess), current username,
 // and the username recorded in the task. For public users and unknown task
 // owners, the username is an empty string.
+//
+// In the "Roles" mode, users holding a viewer-like role are granted access
+// here; project-scoped roles are further checked by the TaskService.
 func (u *UserInfo) IsAccessible(dataOwner string) bool {
-	if *u == systemUserInfo || accessMode == AccessAll {
+	if u == &systemUserInfo {
 		return true
 	}
 
 	isOwner := u != nil && u.Username == dataOwner
+	// Ownership checks (see withOwnerOnly) ignore the access mode.
+	if u != nil && u.ownerOnly {
+		return isOwner
+	}
+	if accessMode == AccessAll {
+		return true
+	}
 	if accessMode == AccessOwner {
 		return isOwner
 	}
@@ -218,6 +311,10 @@ func (u *UserInfo) IsAccessible(dataOwner string) bool {
 		return isOwner || u != nil && u.IsAdmin
 	}
 
+	if accessMode == AccessRoles {
+		return isOwner || u != nil && u.grants(PermView, nil, false)
+	}
+
 	return false
 }
 
@@ -225,7 +322,11 @@ func (u *UserInfo) IsAccessible(dataOwner string) bool {
 // configuration (Server.TaskAccess) and whether the user has Admin status.
 // If the result is false, data access must be verified (see: IsAccessible).
 func (u *UserInfo) CanSeeAllTasks() bool {
-	return *u == systemUserInfo ||
+	if u != nil && u.ownerOnly {
+		return false
+	}
+	return u == &systemUserInfo ||
 		accessMode == AccessAll ||
-		accessMode == AccessOwnerOrAdmin && u != nil && u.IsAdmin
+		accessMode == AccessOwnerOrAdmin && u != nil && u.IsAdmin ||
+		accessMode == AccessRoles && u != nil && u.grants(PermView, nil, false)
 }
@@ -211,20 +211,35 @@ func (c *OidcConfig) computeState(req *http.Request) string {
 
 func (c *OidcConfig) Authorize(authorization string) *UserInfo {
 	jwtString := strings.TrimPrefix(authorization, "Bearer ")
-	subject := c.ParseJwtSubject(jwtString)
-	isAdmin := c.admins[subject]
-
-	if subject == "" {
+	token := c.ParseJwt(jwtString)
+	if token == nil || token.Subject() == "" {
 		return nil
 	}
-	return &UserInfo{Username: subject, Token: jwtString, IsAdmin: isAdmin}
+
+	subject := token.Subject()
+	return &UserInfo{
+		Username: subject,
+		Token:    jwtString,
+		IsAdmin:  c.admins[subject],
+		Groups:   claimValues(token, c.local.GroupsClaim),
+		Roles:    claimValues(token, c.local.RolesClaim),
+	}
 }
 
 func (c *OidcConfig) ParseJwtSubject(jwtString string) string {
+	if token := c.ParseJwt(jwtString); token != nil {
+		return token.Subject()
+	}
+	return ""
+}
+
+// ParseJwt verifies the JWT and returns the parsed token, or nil if the
+// token is not valid or not active.
+func (c *OidcConfig) ParseJwt(jwtString string) jwt.Token {
 	keySet, err := c.jwks.Get(context.Background(), c.remote.JwksURI)
 	if err != nil {
 		fmt.Printf("[WARN] Failed to retrieve JWKS key-set: %s", err)
-		return ""
+		return nil
 	}
 
 	token, err := jwt.ParseString(
@@ -236,14 +251,53 @@ func (c *OidcConfig) ParseJwtSubject(jwtString string) string {
 
 	if err != nil {
 		fmt.Printf("[WARN] Provided JWT is not valid: %s.\n", err)
-		return ""
+		return nil
 	}
 
 	if !c.isJwtValid(&token) || !c.isJwtActive(jwtString) {
-		return ""
+		return nil
 	}
 
-	return token.Subject()
+	return token
+}
+
+// claimValues returns the values of a JWT claim holding a list of strings,
+// such as groups or roles. Space-separated string claims are also accepted.
+// Nested claims can be addressed with dots, e.g. "realm_access.roles".
+func claimValues(token jwt.Token, claim string) []string {
+	if claim == "" {
+		return nil
+	}
+	path := strings.Split(claim, ".")
+	value, found := token.Get(path[0])
+	for _, key := range path[1:] {
+		if !found {
+			break
+		}
+		nested, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value, found = nested[key]
+	}
+	if !found {
+		return nil
+	}
+
+	var out []string
+	switch v := value.(type) {
+	case string:
+		out = strings.Fields(v)
+	case []string:
+		out = v
+	case []interface{}:
+		for _, item := range v {
+			if str, ok := item.(string); ok {
+				out = append(out, str)
+			}
+		}
+	}
+	return out
 }
 
 func (c *OidcConfig) isJwtValid(token *jwt.Token) bool {
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"github.com/ohsu-comp-bio/funnel/logger"
+	"github.com/ohsu-comp-bio/funnel/logs"
+	"github.com/ohsu-comp-bio/funnel/tes"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// LogService serves the complete executor logs archived by the workers
+// (LogArchive). The workers write the chunks of the logs with WriteChunk
+// when LogArchive.Sink is "database", and directly to the storage
+// otherwise.
+type LogService struct {
+	logs.UnimplementedLogServiceServer
+	Tasks *TaskService
+	// Store of the archived logs. The log archive is disabled if nil.
+	Store logs.Store
+	Log   *logger.Logger
+}
+
+// ReadLogs reads a range of the log of an executor, for the users who may
+// view the task.
+func (s *LogService) ReadLogs(ctx context.Context, req *logs.ReadLogsRequest) (*logs.ReadLogsResponse, error) {
+	if s.Store == nil {
+		return nil, status.Error(codes.FailedPrecondition, "the log archive is disabled. See LogArchive.Sink")
+	}
+	if req.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing the task ID")
+	}
+	// GetTask checks that the user may view the task.
+	_, err := s.Tasks.GetTask(ctx, &tes.GetTaskRequest{Id: req.Id, View: tes.View_BASIC.String()})
+	if err != nil {
+		return nil, err
+	}
+	return logs.Read(ctx, s.Store, req)
+}
+
+// WriteChunk archives a chunk of the log of an executor, for the workers.
+func (s *LogService) WriteChunk(ctx context.Context, c *logs.Chunk) (*logs.WriteChunkResponse, error) {
+	if s.Store == nil {
+		return nil, status.Error(codes.FailedPrecondition, "the log archive is disabled. See LogArchive.Sink")
+	}
+	if c.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing the task ID")
+	}
+	if err := s.Store.WriteChunk(ctx, c); err != nil {
+		s.Log.Error("Failed to archive a log chunk", "taskID", c.Id, "executor", c.Executor, "error", err)
+		return nil, err
+	}
+	return &logs.WriteChunkResponse{}, nil
+}
@@ -0,0 +1,275 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ohsu-comp-bio/funnel/auth"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/query"
+	"github.com/ohsu-comp-bio/funnel/tes"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Roles which can be granted through Server.RoleBindings.
+const (
+	RoleViewer    = "viewer"
+	RoleSubmitter = "submitter"
+	RoleCanceller = "canceller"
+	RoleAdmin     = "admin"
+)
+
+// Permission describes an action a user may perform.
+type Permission int
+
+const (
+	// PermView allows getting and listing tasks.
+	PermView Permission = iota
+	// PermCreate allows creating tasks.
+	PermCreate
+	// PermCancel allows canceling tasks.
+	PermCancel
+	// PermAdmin allows node management and event writes.
+	PermAdmin
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleViewer:    {PermView},
+	RoleSubmitter: {PermCreate},
+	RoleCanceller: {PermView, PermCancel},
+	RoleAdmin:     {PermView, PermCreate, PermCancel, PermAdmin},
+}
+
+// Methods which require PermAdmin, in addition to authentication, when
+// TaskAccess is "Roles". Matched by prefix against the full gRPC method name.
+var adminMethods = []string{
+	"/scheduler.SchedulerService/",
+	"/events.EventService/WriteEvent",
+	"/attach.AttachService/Connect",
+	"/logs.LogService/WriteChunk",
+}
+
+var errPermissionDenied = status.Errorf(codes.PermissionDenied, "Permission denied")
+
+var (
+	roleBindings []*roleBinding
+	projectTag   = "project"
+)
+
+type roleBinding struct {
+	role     string
+	users    map[string]bool
+	groups   map[string]bool
+	projects []string
+}
+
+func newRoleBindings(conf []*config.RoleBinding) ([]*roleBinding, error) {
+	var out []*roleBinding
+	for _, c := range conf {
+		role := strings.ToLower(c.Role)
+		if _, ok := rolePermissions[role]; !ok {
+			return nil, fmt.Errorf("unknown role %q in Server.RoleBindings. "+
+				"Expected 'viewer', 'submitter', 'canceller', or 'admin'", c.Role)
+		}
+		b := &roleBinding{
+			role:     role,
+			users:    map[string]bool{},
+			groups:   map[string]bool{},
+			projects: c.Projects,
+		}
+		for _, u := range c.Users {
+			b.users[u] = true
+		}
+		for _, g := range c.Groups {
+			b.groups[g] = true
+		}
+		out = append(out, b)
+	}
+	return out, nil
+}
+
+// matches reports whether the binding applies to the given user.
+func (b *roleBinding) matches(u *UserInfo) bool {
+	if u.Username != "" && b.users[u.Username] {
+		return true
+	}
+	for _, g := range u.Groups {
+		if b.groups[g] {
+			return true
+		}
+	}
+	return false
+}
+
+// inScope reports whether the binding applies to a task with the given tags.
+func (b *roleBinding) inScope(tags map[string]string) bool {
+	if len(b.projects) == 0 {
+		return true
+	}
+	project, ok := tags[projectTag]
+	if !ok {
+		return false
+	}
+	for _, p := range b.projects {
+		if p == project || strings.HasSuffix(p, "*") && strings.HasPrefix(project, strings.TrimSuffix(p, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *roleBinding) grants(p Permission) bool {
+	for _, perm := range rolePermissions[b.role] {
+		if perm == p {
+			return true
+		}
+	}
+	return false
+}
+
+// grants reports whether the user holds a role which grants the
+// permission. If unscopedOnly is true, project-scoped bindings are ignored.
+// If tags is non-nil, scoped bindings must match the tags.
+func (u *UserInfo) grants(p Permission, tags map[string]string, unscopedOnly bool) bool {
+	if u.ownerOnly {
+		return false
+	}
+	if u.IsAdmin {
+		return true
+	}
+	// Roles from the identity provider (e.g. OIDC roles claim) are global.
+	for _, role := range u.Roles {
+		for _, perm := range rolePermissions[strings.ToLower(role)] {
+			if perm == p {
+				return true
+			}
+		}
+	}
+	for _, b := range roleBindings {
+		if !b.grants(p) || !b.matches(u) {
+			continue
+		}
+		if len(b.projects) == 0 {
+			return true
+		}
+		if unscopedOnly {
+			continue
+		}
+		if tags == nil || b.inScope(tags) {
+			return true
+		}
+	}
+	return false
+}
+
+// inProject reports whether the user may create tasks in the project through
+// a role binding scoped to it, not only through a global role.
+func (u *UserInfo) inProject(project string) bool {
+	if accessMode != AccessRoles || u.ownerOnly {
+		return false
+	}
+	tags := map[string]string{projectTag: project}
+	for _, b := range roleBindings {
+		if len(b.projects) > 0 && b.grants(PermCreate) && b.matches(u) && b.inScope(tags) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission reports whether the current user may perform an action on a
+// task with the given tags. Outside of the "Roles" access mode, permissions
+// are not restricted beyond the owner-based checks of IsAccessible.
+func (u *UserInfo) HasPermission(p Permission, tags map[string]string) bool {
+	if u == &systemUserInfo || accessMode != AccessRoles {
+		return true
+	}
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	return u.grants(p, tags, false)
+}
+
+// needsScopeCheck reports whether the user may view some, but not all,
+// tasks based on project-scoped role bindings. In that case, the databases
+// list the tasks in the scope returned by viewScope.
+func (u *UserInfo) needsScopeCheck() bool {
+	return accessMode == AccessRoles && u != &systemUserInfo && !u.ownerOnly &&
+		!u.grants(PermView, nil, true) && u.grants(PermView, nil, false)
+}
+
+// viewScope returns the tasks the user may view: their own tasks, and those
+// of the projects of the bindings which grant PermView.
+func (u *UserInfo) viewScope() *query.Scope {
+	scope := &query.Scope{Owner: u.Username}
+	for _, b := range roleBindings {
+		if !b.grants(PermView) || !b.matches(u) {
+			continue
+		}
+		for _, p := range b.projects {
+			m := &query.Match{Key: projectTag, Op: query.Equal, Value: p}
+			if strings.HasSuffix(p, "*") {
+				m.Op, m.Value = query.Prefix, strings.TrimSuffix(p, "*")
+			}
+			scope.Tags = append(scope.Tags, m)
+		}
+	}
+	return scope
+}
+
+// withOwnerOnly returns a context in which database access checks consider
+// only task ownership, ignoring role bindings.
+func withOwnerOnly(ctx context.Context) context.Context {
+	u := *GetUser(ctx)
+	u.ownerOnly = true
+	return context.WithValue(ctx, UserInfoKey, &u)
+}
+
+// authorizeMethod checks method-level permissions for node and event RPCs,
+// and the scopes of API tokens.
+func authorizeMethod(ctx context.Context, method string) error {
+	u := GetUser(ctx)
+	for _, m := range adminMethods {
+		if strings.HasPrefix(method, m) && !u.HasPermission(PermAdmin, nil) {
+			return errPermissionDenied
+		}
+	}
+	if !u.hasScope(methodScope(method)) {
+		return errPermissionDenied
+	}
+	return nil
+}
+
+// isAdministrator reports whether the user may administer the server, e.g.
+// query the audit trail or manage the API tokens of other users.
+func (u *UserInfo) isAdministrator() bool {
+	return u.hasScope(auth.ScopeAdmin) &&
+		(u.IsAdmin || accessMode == AccessRoles && u.HasPermission(PermAdmin, nil))
+}
+
+// canViewTask checks the project scope of a task for users with
+// project-scoped roles. Owners can always view their tasks.
+func (ts *TaskService) canViewTask(ctx context.Context, task *tes.Task) bool {
+	tags := task.GetTags()
+	if tags == nil {
+		full, err := ts.Read.GetTask(ctx, &tes.GetTaskRequest{Id: task.Id, View: tes.View_BASIC.String()})
+		if err != nil {
+			return false
+		}
+		tags = full.GetTags()
+	}
+	return ts.inViewScope(ctx, task.Id, tags)
+}
+
+// inViewScope checks the project scope of a task with the given tags.
+func (ts *TaskService) inViewScope(ctx context.Context, id string, tags map[string]string) bool {
+	return GetUser(ctx).HasPermission(PermView, tags) || ts.isOwner(ctx, id)
+}
+
+// isOwner reports whether the current user owns the task.
+func (ts *TaskService) isOwner(ctx context.Context, id string) bool {
+	_, err := ts.Read.GetTask(withOwnerOnly(ctx), &tes.GetTaskRequest{Id: id, View: tes.View_MINIMAL.String()})
+	return err == nil
+}
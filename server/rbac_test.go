@@ -0,0 +1,173 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/query"
+	"github.com/ohsu-comp-bio/funnel/tes"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func setupRoles(t *testing.T, bindings []*config.RoleBinding) {
+	prevMode, prevBindings := accessMode, roleBindings
+	t.Cleanup(func() {
+		accessMode, roleBindings = prevMode, prevBindings
+	})
+
+	var err error
+	accessMode = AccessRoles
+	roleBindings, err = newRoleBindings(bindings)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRoleBindings(t *testing.T) {
+	setupRoles(t, []*config.RoleBinding{
+		{Role: "viewer", Groups: []string{"lab-a", "lab-b"}},
+		{Role: "submitter", Groups: []string{"lab-a"}, Projects: []string{"lab-a/*"}},
+		{Role: "canceller", Users: []string{"alice"}, Projects: []string{"lab-a/rnaseq"}},
+		{Role: "admin", Users: []string{"ops"}},
+	})
+
+	alice := &UserInfo{Username: "alice", Groups: []string{"lab-a"}}
+	bob := &UserInfo{Username: "bob", Groups: []string{"lab-b"}}
+	ops := &UserInfo{Username: "ops"}
+	eve := &UserInfo{Username: "eve"}
+
+	rnaseq := map[string]string{"project": "lab-a/rnaseq"}
+	other := map[string]string{"project": "lab-b/wgs"}
+
+	// Viewers can see each other's tasks.
+	if !bob.IsAccessible("alice") || !bob.CanSeeAllTasks() {
+		t.Error("expected bob to see alice's tasks")
+	}
+	if eve.IsAccessible("alice") || eve.CanSeeAllTasks() {
+		t.Error("expected eve to see no tasks")
+	}
+	if !eve.IsAccessible("eve") {
+		t.Error("expected eve to see her own tasks")
+	}
+
+	// Viewers can't cancel.
+	if bob.HasPermission(PermCancel, rnaseq) {
+		t.Error("expected bob not to be able to cancel")
+	}
+	if !alice.HasPermission(PermCancel, rnaseq) || alice.HasPermission(PermCancel, other) {
+		t.Error("expected alice to cancel only within lab-a/rnaseq")
+	}
+
+	// Submitters are limited to their projects.
+	if !alice.HasPermission(PermCreate, rnaseq) || alice.HasPermission(PermCreate, other) {
+		t.Error("expected alice to submit only within lab-a/*")
+	}
+	if alice.HasPermission(PermCreate, nil) {
+		t.Error("expected alice not to submit tasks without a project")
+	}
+
+	// Node and event APIs require admin.
+	if alice.HasPermission(PermAdmin, nil) || !ops.HasPermission(PermAdmin, nil) {
+		t.Error("expected only ops to be admin")
+	}
+	ctx := context.WithValue(context.Background(), UserInfoKey, alice)
+	if authorizeMethod(ctx, "/scheduler.SchedulerService/PutNode") == nil {
+		t.Error("expected node API to be denied for alice")
+	}
+	if authorizeMethod(ctx, "/tes.TaskService/ListTasks") != nil {
+		t.Error("expected TES API to be allowed for alice")
+	}
+	if authorizeMethod(context.Background(), "/events.EventService/WriteEvent") != nil {
+		t.Error("expected event writes to be allowed for the system user")
+	}
+
+	// Roles from the identity provider are global.
+	carol := &UserInfo{Username: "carol", Roles: []string{"Canceller"}}
+	if !carol.HasPermission(PermCancel, other) {
+		t.Error("expected carol to cancel any task")
+	}
+
+	// Owner-only checks ignore roles.
+	owner := GetUser(withOwnerOnly(context.WithValue(context.Background(), UserInfoKey, bob)))
+	if owner.IsAccessible("alice") || !owner.IsAccessible("bob") || owner.CanSeeAllTasks() {
+		t.Error("expected owner-only check to consider ownership only")
+	}
+}
+
+func TestScopedViewers(t *testing.T) {
+	setupRoles(t, []*config.RoleBinding{
+		{Role: "viewer", Users: []string{"alice"}, Projects: []string{"lab-a"}},
+		{Role: "viewer", Users: []string{"bob"}},
+		{Role: "canceller", Users: []string{"alice"}, Projects: []string{"shared/*"}},
+		{Role: "submitter", Users: []string{"alice"}, Projects: []string{"lab-c"}},
+	})
+
+	alice := &UserInfo{Username: "alice"}
+	bob := &UserInfo{Username: "bob"}
+
+	if !alice.needsScopeCheck() || bob.needsScopeCheck() {
+		t.Error("expected only alice to need project scope checks")
+	}
+	if !alice.HasPermission(PermView, map[string]string{"project": "lab-a"}) {
+		t.Error("expected alice to view lab-a tasks")
+	}
+	if alice.HasPermission(PermView, map[string]string{"project": "lab-b"}) {
+		t.Error("expected alice not to view lab-b tasks")
+	}
+
+	// The databases list the tasks of alice, or of her projects.
+	scope := alice.viewScope()
+	if scope.Owner != "alice" || len(scope.Tags) != 2 {
+		t.Fatalf("unexpected scope: %+v", scope)
+	}
+	if m := scope.Tags[0]; m.Key != "project" || m.Op != query.Equal || m.Value != "lab-a" {
+		t.Errorf("unexpected project match: %+v", m)
+	}
+	if m := scope.Tags[1]; m.Op != query.Prefix || m.Value != "shared/" {
+		t.Errorf("unexpected project prefix match: %+v", m)
+	}
+
+	// The tasks out of the scope of alice are missing, like unknown tasks.
+	ts := &TaskService{Read: ownedTasks{
+		"task-a": {task: &tes.Task{Id: "task-a", Tags: map[string]string{"project": "lab-a"}}, owner: "carol"},
+		"task-b": {task: &tes.Task{Id: "task-b", Tags: map[string]string{"project": "lab-b"}}, owner: "carol"},
+	}}
+	ctx := context.WithValue(context.Background(), UserInfoKey, alice)
+	if _, err := ts.GetTask(ctx, &tes.GetTaskRequest{Id: "task-a"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, id := range []string{"task-b", "task-c"} {
+		if task, err := ts.GetTask(ctx, &tes.GetTaskRequest{Id: id}); task != nil || status.Code(err) != codes.NotFound {
+			t.Errorf("%s: expected NotFound, got %v", id, err)
+		}
+	}
+
+	if _, err := newRoleBindings([]*config.RoleBinding{{Role: "superuser"}}); err == nil {
+		t.Error("expected error for unknown role")
+	}
+}
+
+func TestClaimValues(t *testing.T) {
+	token := jwt.New()
+	_ = token.Set("groups", []interface{}{"lab-a", "lab-b"})
+	_ = token.Set("scope", "openid viewer")
+	_ = token.Set("realm_access", map[string]interface{}{
+		"roles": []interface{}{"admin"},
+	})
+
+	if got := claimValues(token, "groups"); len(got) != 2 || got[1] != "lab-b" {
+		t.Error("unexpected groups", got)
+	}
+	if got := claimValues(token, "scope"); len(got) != 2 || got[1] != "viewer" {
+		t.Error("unexpected scope values", got)
+	}
+	if got := claimValues(token, "realm_access.roles"); len(got) != 1 || got[0] != "admin" {
+		t.Error("unexpected nested roles", got)
+	}
+	if got := claimValues(token, "missing"); got != nil {
+		t.Error("expected no values for a missing claim", got)
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/ohsu-comp-bio/funnel/secrets"
+	"github.com/ohsu-comp-bio/funnel/tes"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// authorizeSecrets checks that the user may use the secrets of the task: the
+// secret references of the executor env values and of the inputs, e.g.
+// "secret://user/alice/db-password", and the registry secret.
+func authorizeSecrets(ctx context.Context, task *tes.Task) error {
+	u := GetUser(ctx)
+	tags := task.GetTags()
+	if name := tags[secrets.RegistrySecretTag]; name != "" && !u.canUseRegistrySecret(name, tags) {
+		return status.Errorf(codes.PermissionDenied, "%v: registry secret %q", tes.ErrNotPermitted, name)
+	}
+
+	var refs []string
+	for _, ex := range task.GetExecutors() {
+		for _, v := range ex.GetEnv() {
+			refs = append(refs, v)
+		}
+	}
+	for _, in := range task.GetInputs() {
+		refs = append(refs, in.GetUrl())
+	}
+	for _, ref := range refs {
+		name, ok := secrets.ParseRef(ref)
+		if !ok {
+			continue
+		}
+		if err := secrets.ValidateName(name); err != nil {
+			return status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		if !u.canUseSecret(name, tags) {
+			return status.Errorf(codes.PermissionDenied, "%v: secret %q", tes.ErrNotPermitted, name)
+		}
+	}
+	return nil
+}
+
+// canUseSecret reports whether the user may use the named secret in a task
+// with the given tags. Secrets are namespaced by their folder: the secrets in
+// "user/<name>/", e.g. "user/alice/db-password", belong to that user. Those in
+// "project/<name>/" belong to the users with a role scoped to the project, in
+// the tasks of the project. The administrators may use all the secrets, as
+// may all the users when the server doesn't authenticate them.
+func (u *UserInfo) canUseSecret(name string, tags map[string]string) bool {
+	if u.usesAllSecrets() {
+		return true
+	}
+	if u.Username != "" && strings.HasPrefix(name, secrets.UserFolder+u.Username+"/") {
+		return true
+	}
+	project := tags[projectTag]
+	return project != "" && strings.HasPrefix(name, secrets.ProjectFolder+project+"/") &&
+		u.inProject(project)
+}
+
+// canUseRegistrySecret is canUseSecret for the registry secrets, whose names
+// can't contain folders: "user-<name>" is the secret of a user, and
+// "project-<name>" the secret of a project.
+func (u *UserInfo) canUseRegistrySecret(name string, tags map[string]string) bool {
+	if u.usesAllSecrets() {
+		return true
+	}
+	if u.Username != "" && name == "user-"+u.Username {
+		return true
+	}
+	project := tags[projectTag]
+	return project != "" && name == "project-"+project && u.inProject(project)
+}
+
+func (u *UserInfo) usesAllSecrets() bool {
+	return u == &systemUserInfo || u.IsPublic || u.isAdministrator()
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/secrets"
+	"github.com/ohsu-comp-bio/funnel/tes"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRegistrySecretAccess(t *testing.T) {
+	setupRoles(t, []*config.RoleBinding{
+		{Role: "submitter", Users: []string{"alice", "bob"}},
+		{Role: "submitter", Users: []string{"alice"}, Projects: []string{"lab-a"}},
+		{Role: "admin", Users: []string{"ops"}},
+	})
+	alice := &UserInfo{Username: "alice"}
+	bob := &UserInfo{Username: "bob"}
+	ops := &UserInfo{Username: "ops"}
+	labA := map[string]string{"project": "lab-a"}
+
+	tests := []struct {
+		user   *UserInfo
+		secret string
+		tags   map[string]string
+		ok     bool
+	}{
+		{alice, "user/alice/db-password", nil, true},
+		{alice, "user/bob/db-password", nil, false},
+		{alice, "project/lab-a/token", labA, true},
+		// Project secrets are used in the tasks of the project only.
+		{alice, "project/lab-a/token", nil, false},
+		// A global role doesn't grant the secrets of the projects.
+		{bob, "project/lab-a/token", labA, false},
+		// Users and projects don't share a namespace.
+		{&UserInfo{Username: "lab-a"}, "project/lab-a/token", nil, false},
+		{alice, "lab-a/token", labA, false},
+		{alice, "alice/db-password", nil, false},
+		{ops, "user/bob/db-password", nil, true},
+		{&publicUserInfo, "user/bob/db-password", nil, true},
+	}
+	for _, tt := range tests {
+		if ok := tt.user.canUseSecret(tt.secret, tt.tags); ok != tt.ok {
+			t.Errorf("%s using %s with %v: expected %v, got %v", tt.user.Username, tt.secret, tt.tags, tt.ok, ok)
+		}
+	}
+
+	registryTests := []struct {
+		user   *UserInfo
+		secret string
+		tags   map[string]string
+		ok     bool
+	}{
+		{alice, "user-alice", nil, true},
+		{alice, "user-bob", nil, false},
+		{alice, "project-lab-a", labA, true},
+		{alice, "project-lab-a", nil, false},
+		{bob, "project-lab-a", labA, false},
+		{&UserInfo{Username: "lab-a"}, "project-lab-a", nil, false},
+		{alice, "alice", nil, false},
+		{ops, "user-bob", nil, true},
+	}
+	for _, tt := range registryTests {
+		if ok := tt.user.canUseRegistrySecret(tt.secret, tt.tags); ok != tt.ok {
+			t.Errorf("%s using registry secret %s with %v: expected %v, got %v", tt.user.Username, tt.secret, tt.tags, tt.ok, ok)
+		}
+	}
+
+	// The tasks using the registry secret of another user are rejected.
+	ts := &TaskService{Config: &config.Config{}}
+	ctx := context.WithValue(context.Background(), UserInfoKey, bob)
+	task := &tes.Task{
+		Executors: []*tes.Executor{{Image: "ghcr.io/lab/tool", Command: []string{"tool"}}},
+		Tags:      map[string]string{secrets.RegistrySecretTag: "user-alice"},
+	}
+	if _, err := ts.CreateTask(ctx, task); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied, got %v", err)
+	}
+}
+
+func TestSecretRefAccess(t *testing.T) {
+	setupRoles(t, []*config.RoleBinding{
+		{Role: "submitter", Users: []string{"alice", "bob"}},
+		{Role: "submitter", Users: []string{"alice"}, Projects: []string{"lab-a/*"}},
+	})
+	ts := &TaskService{Config: &config.Config{}}
+	create := func(user string, tags map[string]string, env map[string]string, inputs ...string) error {
+		task := &tes.Task{
+			Executors: []*tes.Executor{{Image: "alpine", Command: []string{"env"}, Env: env}},
+			Tags:      tags,
+		}
+		for _, url := range inputs {
+			task.Inputs = append(task.Inputs, &tes.Input{Url: url, Path: "/in/" + url[len("secret://"):]})
+		}
+		ctx := context.WithValue(context.Background(), UserInfoKey, &UserInfo{Username: user})
+		return authorizeSecrets(ctx, task)
+	}
+	rnaseq := map[string]string{"project": "lab-a/rnaseq"}
+
+	if err := create("bob", nil, map[string]string{"X": "secret://db-password"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied for a shared secret, got %v", err)
+	}
+	if err := create("bob", nil, nil, "secret://user/alice/tls-key"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied for the secret of another user, got %v", err)
+	}
+	if err := create("bob", rnaseq, map[string]string{"X": "secret://project/lab-a/rnaseq/token"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied for the secret of another project, got %v", err)
+	}
+	if err := create("bob", nil, map[string]string{"X": "secret://user/bob/../alice/key"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for an invalid name, got %v", err)
+	}
+	if err := create("alice", rnaseq, map[string]string{"X": "secret://user/alice/db", "Y": "plain"}, "secret://project/lab-a/rnaseq/token"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// The secrets are checked before the task is created.
+	ctx := context.WithValue(context.Background(), UserInfoKey, &UserInfo{Username: "bob"})
+	task := &tes.Task{Executors: []*tes.Executor{{Image: "alpine", Command: []string{"env"}, Env: map[string]string{"X": "secret://db-password"}}}}
+	if _, err := ts.CreateTask(ctx, task); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied, got %v", err)
+	}
+}
@@ -2,6 +2,7 @@
 package server
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"net"
 	"net/http"
@@ -11,6 +12,7 @@ import (
 	"time"
 
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
@@ -19,12 +21,19 @@ import (
 	"github.com/golang/gddo/httputil"
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/ohsu-comp-bio/funnel/attach"
+	"github.com/ohsu-comp-bio/funnel/audit"
+	"github.com/ohsu-comp-bio/funnel/auth"
 	"github.com/ohsu-comp-bio/funnel/compute/scheduler"
 	"github.com/ohsu-comp-bio/funnel/config"
 	"github.com/ohsu-comp-bio/funnel/events"
 	"github.com/ohsu-comp-bio/funnel/logger"
+	"github.com/ohsu-comp-bio/funnel/logs"
+	"github.com/ohsu-comp-bio/funnel/query"
 	"github.com/ohsu-comp-bio/funnel/tes"
+	"github.com/ohsu-comp-bio/funnel/util/tlsutil"
 	"github.com/ohsu-comp-bio/funnel/webdash"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"golang.org/x/net/context"
@@ -40,12 +49,26 @@ type Server struct {
 	BasicAuth        []*config.BasicCredential
 	OidcAuth         *config.OidcAuth
 	TaskAccess       string
+	RoleBindings     []*config.RoleBinding
+	ProjectTag       string
 	Tasks            tes.TaskServiceServer
 	Events           events.EventServiceServer
 	Nodes            scheduler.SchedulerServiceServer
 	DisableHTTPCache bool
 	Log              *logger.Logger
 	Plugins          *config.Plugins
+	// Attach and exec sessions of running tasks, forwarded to the workers.
+	Attach attach.AttachServiceServer
+	// Reads of the archived executor logs, and chunk writes of the workers.
+	Logs logs.LogServiceServer
+	// Audit trail of API actions. If it implements audit.Reader, the trail
+	// may be queried by administrators at /v1/audit.
+	Audit audit.Writer
+	// Store of Funnel API tokens, managed at /v1/tokens. API tokens are
+	// disabled if nil.
+	Tokens auth.TokenStore
+	// TLS for the HTTP and RPC ports, enabled if CertFile is set.
+	TLS *config.TLS
 }
 
 // Return a new interceptor function that logs all requests at the Debug level
@@ -94,6 +117,8 @@ func customErrorHandler(ctx context.Context, mux *runtime.ServeMux, marshaler ru
 		w.WriteHeader(http.StatusConflict)
 	case codes.Canceled:
 		w.WriteHeader(499)
+	case codes.Unimplemented: // 501
+		w.WriteHeader(http.StatusNotImplemented)
 	case codes.DeadlineExceeded: // 504
 		w.WriteHeader(http.StatusGatewayTimeout)
 	default:
@@ -110,6 +135,15 @@ func customErrorHandler(ctx context.Context, mux *runtime.ServeMux, marshaler ru
 	w.Write(jErrBytes)
 }
 
+// filterMetadata passes the task filter of the HTTP API, which isn't a field
+// of tes.ListTasksRequest, as gRPC metadata.
+func filterMetadata(ctx context.Context, r *http.Request) metadata.MD {
+	if filter := r.URL.Query().Get(query.Param); filter != "" {
+		return metadata.Pairs(query.MetadataKey, filter)
+	}
+	return nil
+}
+
 func healthHandler(w http.ResponseWriter, r *http.Request) {
 	// Include logging metrics in health check
 	stdoutDropped, stderrDropped, total := events.GetLogEventStats()
@@ -147,21 +181,56 @@ func (s *Server) Serve(pctx context.Context) error {
 		return err
 	}
 
-	auth := NewAuthentication(s.BasicAuth, s.OidcAuth, s.TaskAccess)
+	auth := NewAuthentication(s.BasicAuth, s.OidcAuth, s.TaskAccess, s.RoleBindings, s.ProjectTag)
+	auth.tokens = s.Tokens
+
+	var interceptors []grpc.UnaryServerInterceptor
+	var streamInterceptors []grpc.StreamServerInterceptor
+	if s.Audit != nil {
+		interceptors = append(interceptors, newAuditInterceptor(s.Audit, s.Log))
+		streamInterceptors = append(streamInterceptors, newAuditStreamInterceptor(s.Audit, s.Log))
+	}
+	interceptors = append(interceptors,
+		// API auth check.
+		auth.Interceptor,
+		newDebugInterceptor(s.Log),
+	)
+	streamInterceptors = append(streamInterceptors, auth.StreamInterceptor)
 
-	grpcServer := grpc.NewServer(
+	serverOpts := []grpc.ServerOption{
 		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
 			MinTime:             10 * time.Second, // min interval between client pings
 			PermitWithoutStream: true,             // allow pings when no active RPCs
 		}),
 		grpc.UnaryInterceptor(
-			grpc_middleware.ChainUnaryServer(
-				// API auth check.
-				auth.Interceptor,
-				newDebugInterceptor(s.Log),
-			),
+			grpc_middleware.ChainUnaryServer(interceptors...),
 		),
-	)
+		grpc.StreamInterceptor(
+			grpc_middleware.ChainStreamServer(streamInterceptors...),
+		),
+		// Spans of the calls, children of the spans of the callers.
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+	}
+
+	// TLS. The HTTP gateway then connects to the gRPC server in-process.
+	var httpTLS *tls.Config
+	var gateway *gatewayListener
+	gatewayEndpoint := s.RPCAddress
+	if tlsutil.ServerEnabled(s.TLS) {
+		rpcTLS, err := tlsutil.ServerConfig(s.TLS, "h2")
+		if err != nil {
+			return err
+		}
+		httpTLS, err = tlsutil.ServerConfig(s.TLS, "h2", "http/1.1")
+		if err != nil {
+			return err
+		}
+		serverOpts = append(serverOpts, grpc.Creds(serverCreds{credentials.NewTLS(rpcTLS)}))
+		gateway = newGatewayListener()
+		gatewayEndpoint = "passthrough:///funnel-gateway"
+	}
+
+	grpcServer := grpc.NewServer(serverOpts...)
 
 	// Retry service config: transparently retry transient gRPC stream errors
 	// (e.g. UNAVAILABLE after idle connection is closed by the server).
@@ -191,6 +260,10 @@ func (s *Server) Serve(pctx context.Context) error {
 		}),
 		// Retry on transient errors at the gRPC level before surfacing to HTTP.
 		grpc.WithDefaultServiceConfig(grpcServiceConfig),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	}
+	if gateway != nil {
+		dialOpts = append(dialOpts, gateway.dialOption())
 	}
 
 	// Set up HTTP proxy of gRPC API
@@ -200,6 +273,7 @@ func (s *Server) Serve(pctx context.Context) error {
 	grpcMux := runtime.NewServeMux(
 		runtime.WithMarshalerOption(runtime.MIMEWildcard, marsh),
 		runtime.WithErrorHandler(customErrorHandler),
+		runtime.WithMetadata(filterMetadata),
 	)
 
 	// m := protojson.MarshalOptions{
@@ -232,8 +306,20 @@ func (s *Server) Serve(pctx context.Context) error {
 	mux.HandleFunc("/login", auth.LoginHandler)
 	mux.HandleFunc("/login/token", auth.EchoTokenHandler)
 
+	// Audit trail
+	if r, ok := s.Audit.(audit.Reader); ok {
+		mux.HandleFunc("/v1/audit", auditHandler(auth, r))
+	}
+
+	// API tokens
+	if s.Tokens != nil {
+		mux.HandleFunc("/v1/tokens", tokensHandler(auth, s.Tokens))
+		mux.HandleFunc("/v1/tokens/", tokensHandler(auth, s.Tokens))
+	}
+
 	// Root
 	mux.HandleFunc("/", func(resp http.ResponseWriter, req *http.Request) {
+		forwardClientCert(req)
 
 		// Pass header to plugin if plugin is enabled
 		if s.Plugins != nil {
@@ -264,7 +350,7 @@ func (s *Server) Serve(pctx context.Context) error {
 	if s.Tasks != nil {
 		tes.RegisterTaskServiceServer(grpcServer, s.Tasks)
 		err := tes.RegisterTaskServiceHandlerFromEndpoint(
-			ctx, grpcMux, s.RPCAddress, dialOpts,
+			ctx, grpcMux, gatewayEndpoint, dialOpts,
 		)
 		if err != nil {
 			return err
@@ -276,11 +362,21 @@ func (s *Server) Serve(pctx context.Context) error {
 		events.RegisterEventServiceServer(grpcServer, s.Events)
 	}
 
+	// Register Attach service
+	if s.Attach != nil {
+		attach.RegisterAttachServiceServer(grpcServer, s.Attach)
+	}
+
+	// Register Log service
+	if s.Logs != nil {
+		logs.RegisterLogServiceServer(grpcServer, s.Logs)
+	}
+
 	// Register Scheduler RPC service
 	if s.Nodes != nil {
 		scheduler.RegisterSchedulerServiceServer(grpcServer, s.Nodes)
 		err := scheduler.RegisterSchedulerServiceHandlerFromEndpoint(
-			ctx, grpcMux, s.RPCAddress, dialOpts,
+			ctx, grpcMux, gatewayEndpoint, dialOpts,
 		)
 		if err != nil {
 			return err
@@ -288,8 +384,9 @@ func (s *Server) Serve(pctx context.Context) error {
 	}
 
 	httpServer := &http.Server{
-		Addr:    ":" + s.HTTPPort,
-		Handler: mux,
+		Addr:      ":" + s.HTTPPort,
+		Handler:   mux,
+		TLSConfig: httpTLS,
 	}
 
 	var srverr error
@@ -298,13 +395,26 @@ func (s *Server) Serve(pctx context.Context) error {
 		cancel()
 	}()
 
+	if gateway != nil {
+		go func() {
+			if err := grpcServer.Serve(gateway); err != nil {
+				srverr = err
+			}
+			cancel()
+		}()
+	}
+
 	go func() {
-		srverr = httpServer.ListenAndServe()
+		if httpTLS != nil {
+			srverr = httpServer.ListenAndServeTLS("", "")
+		} else {
+			srverr = httpServer.ListenAndServe()
+		}
 		cancel()
 	}()
 
 	s.Log.Info("Server listening",
-		"httpPort", s.HTTPPort, "rpcAddress", s.RPCAddress,
+		"httpPort", s.HTTPPort, "rpcAddress", s.RPCAddress, "tls", httpTLS != nil,
 	)
 
 	<-ctx.Done()
@@ -2,6 +2,7 @@ package server
 
 import (
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -11,7 +12,9 @@ import (
 	"github.com/ohsu-comp-bio/funnel/logger"
 	"github.com/ohsu-comp-bio/funnel/plugins/proto"
 	"github.com/ohsu-comp-bio/funnel/plugins/shared"
+	"github.com/ohsu-comp-bio/funnel/query"
 	"github.com/ohsu-comp-bio/funnel/tes"
+	"github.com/ohsu-comp-bio/funnel/tracing"
 	"github.com/ohsu-comp-bio/funnel/util/server"
 	"github.com/ohsu-comp-bio/funnel/version"
 	"golang.org/x/net/context"
@@ -71,6 +74,7 @@ func (ts *TaskService) HandleDoPluginAction(ctx context.Context, task *tes.Task,
 	gob.Register(&config.TimeoutConfig_Disabled{})
 
 	pluginResponse, err := ts.DoPluginAction(ctx, task, taskType)
+	auditPlugin(ctx, taskType, pluginResponse, err)
 	if err != nil {
 		return pluginResponse, fmt.Errorf("Error loading plugins: %v", err)
 	}
@@ -91,7 +95,7 @@ func (ts *TaskService) HandleDoPluginAction(ctx context.Context, task *tes.Task,
 
 // CreateTask provides an HTTP/gRPC endpoint for creating a task.
 // This is part of the TES implementation.
-func (ts *TaskService) CreateTask(ctx context.Context, task *tes.Task) (*tes.CreateTaskResponse, error) {
+func (ts *TaskService) CreateTask(ctx context.Context, task *tes.Task) (_ *tes.CreateTaskResponse, err error) {
 	if ts.Config.Plugins != nil {
 		pluginResponse, err := ts.HandleDoPluginAction(ctx, task, proto.Type_CREATE)
 		if err != nil {
@@ -119,11 +123,22 @@ func (ts *TaskService) CreateTask(ctx context.Context, task *tes.Task) (*tes.Cre
 		return nil, status.Errorf(codes.InvalidArgument, "%v", err.Error())
 	}
 
+	// The root span of the trace of the task.
+	ctx, span := tracing.StartTask(ctx, task.Id, "CreateTask")
+	defer func() { tracing.End(span, err) }()
+
+	if !GetUser(ctx).HasPermission(PermCreate, task.Tags) {
+		return nil, status.Errorf(codes.PermissionDenied, "%v: not permitted to create tasks in this project", tes.ErrNotPermitted)
+	}
+
+	if err := authorizeSecrets(ctx, task); err != nil {
+		return nil, err
+	}
+
 	if err := ts.Compute.CheckBackendParameterSupport(task); err != nil {
 		return nil, err
 	}
 
-	var err error
 	ctx = context.WithValue(ctx, "Config", ts.Config)
 
 	if ts.Config.Compute == "kubernetes" {
@@ -142,7 +157,8 @@ func (ts *TaskService) CreateTask(ctx context.Context, task *tes.Task) (*tes.Cre
 
 	// dispatch to compute backend
 	go func() {
-		workerCtx := context.Background()
+		workerCtx, span := tracing.Start(tracing.TaskContext(context.Background(), task.Id),
+			"SubmitTask", tracing.TaskIDKey.String(task.Id))
 
 		if pluginResponse != nil {
 			workerCtx = context.WithValue(workerCtx, "pluginResponse", pluginResponse)
@@ -152,6 +168,7 @@ func (ts *TaskService) CreateTask(ctx context.Context, task *tes.Task) (*tes.Cre
 		}
 
 		err := ts.Compute.WriteEvent(workerCtx, events.NewTaskCreated(task))
+		tracing.End(span, err)
 		ts.Log.Debug("submitted task to compute backend", "taskID", task.Id, "error", err)
 
 		if err != nil {
@@ -189,7 +206,12 @@ func (ts *TaskService) GetTask(ctx context.Context, req *tes.GetTaskRequest) (*t
 		}
 	*/
 
+	// The tasks out of the project scope of the user are missing to them, as
+	// are the tasks of other users to the databases in owner mode.
 	task, err := ts.Read.GetTask(ctx, req)
+	if err == nil && GetUser(ctx).needsScopeCheck() && !ts.canViewTask(ctx, task) {
+		task, err = nil, tes.ErrNotFound
+	}
 	if err == tes.ErrNotFound {
 		err = status.Errorf(codes.NotFound, "%v: taskID: %s", err.Error(), req.Id)
 	}
@@ -197,8 +219,70 @@ func (ts *TaskService) GetTask(ctx context.Context, req *tes.GetTaskRequest) (*t
 }
 
 // ListTasks calls ListTasks on the underlying tes.ReadOnlyServer.
+// Users with project-scoped roles only see the tasks of those projects
+// (and their own tasks), so pages may contain fewer tasks than requested.
+//
+// The filter of the "funnel-filter" metadata, or of the "filter" query
+// parameter of the HTTP API, is parsed once for the database.
 func (ts *TaskService) ListTasks(ctx context.Context, req *tes.ListTasksRequest) (*tes.ListTasksResponse, error) {
-	return ts.Read.ListTasks(ctx, req)
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if filter := md.Get(query.MetadataKey); len(filter) > 0 {
+			q, err := query.Parse(strings.Join(filter, " "))
+			if err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+			ctx = query.NewContext(ctx, q)
+		}
+	}
+
+	u := GetUser(ctx)
+	if !u.needsScopeCheck() {
+		return ts.Read.ListTasks(ctx, req)
+	}
+
+	// The database filters the tasks in the project scope of the user, so
+	// that the pages are full.
+	q := query.FromContext(ctx)
+	scoped := &query.Query{}
+	if q != nil {
+		*scoped = *q
+	}
+	scoped.Scope = u.viewScope()
+	resp, err := ts.Read.ListTasks(query.NewContext(ctx, scoped), req)
+	if !errors.Is(err, query.ErrUnsupported) || q != nil {
+		return resp, err
+	}
+
+	// Otherwise, the tasks of the page are filtered here, with the tags of
+	// the basic view.
+	basic := &tes.ListTasksRequest{
+		NamePrefix: req.NamePrefix,
+		State:      req.State,
+		TagKey:     req.TagKey,
+		TagValue:   req.TagValue,
+		PageSize:   req.PageSize,
+		PageToken:  req.PageToken,
+		View:       req.View,
+	}
+	if req.View == tes.View_MINIMAL.String() {
+		basic.View = tes.View_BASIC.String()
+	}
+	resp, err = ts.Read.ListTasks(ctx, basic)
+	if err != nil {
+		return nil, err
+	}
+	var tasks []*tes.Task
+	for _, task := range resp.Tasks {
+		if !ts.inViewScope(ctx, task.Id, task.GetTags()) {
+			continue
+		}
+		if req.View == tes.View_MINIMAL.String() {
+			task = task.GetMinimalView()
+		}
+		tasks = append(tasks, task)
+	}
+	resp.Tasks = tasks
+	return resp, nil
 }
 
 // CancelTask cancels a task
@@ -224,7 +308,8 @@ func (ts *TaskService) CancelTask(ctx context.Context, req *tes.CancelTaskReques
 
 	// Get current task state to check if it's already terminal
 	task, err := ts.Read.GetTask(ctx, &tes.GetTaskRequest{
-		Id: req.Id,
+		Id:   req.Id,
+		View: tes.View_BASIC.String(),
 	})
 	if err == tes.ErrNotFound {
 		return result, status.Errorf(codes.NotFound, "%v: taskID: %s", err.Error(), req.Id)
@@ -232,6 +317,11 @@ func (ts *TaskService) CancelTask(ctx context.Context, req *tes.CancelTaskReques
 		return result, err
 	}
 
+	// Owners can always cancel their own tasks; others need a canceller role.
+	if !GetUser(ctx).HasPermission(PermCancel, task.Tags) && !ts.isOwner(ctx, req.Id) {
+		return result, status.Errorf(codes.PermissionDenied, "%v: taskID: %s", tes.ErrNotPermitted, req.Id)
+	}
+
 	// Check if task is already in a terminal state
 	if tes.TerminalState(task.State) {
 		ts.Log.Info("Task already in terminal state, skipping cancel",
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// When TLS is enabled, the HTTP gateway connects to the gRPC server through
+// an in-process listener, without TLS. The subject of a verified HTTP client
+// certificate is forwarded to the gRPC server in this metadata, which is
+// trusted on gateway connections only.
+const (
+	clientCertMetadata = "x-funnel-client-cert"
+	clientCertHeader   = "Grpc-Metadata-" + clientCertMetadata
+)
+
+// gatewayListener is the in-process listener of the HTTP gateway.
+type gatewayListener struct {
+	*bufconn.Listener
+}
+
+func newGatewayListener() *gatewayListener {
+	return &gatewayListener{bufconn.Listen(1024 * 1024)}
+}
+
+func (l *gatewayListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return gatewayConn{conn}, nil
+}
+
+// dialOption returns the dial option used by the gateway to connect.
+func (l *gatewayListener) dialOption() grpc.DialOption {
+	return grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return l.DialContext(ctx)
+	})
+}
+
+type gatewayConn struct {
+	net.Conn
+}
+
+// gatewayAuthInfo identifies gRPC connections from the HTTP gateway.
+type gatewayAuthInfo struct {
+	credentials.CommonAuthInfo
+}
+
+func (gatewayAuthInfo) AuthType() string {
+	return "gateway"
+}
+
+// serverCreds wraps the TLS credentials of the RPC port, skipping the TLS
+// handshake for connections from the HTTP gateway.
+type serverCreds struct {
+	credentials.TransportCredentials
+}
+
+func (c serverCreds) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	if _, ok := conn.(gatewayConn); ok {
+		return conn, gatewayAuthInfo{credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity}}, nil
+	}
+	return c.TransportCredentials.ServerHandshake(conn)
+}
+
+func (c serverCreds) Clone() credentials.TransportCredentials {
+	return serverCreds{c.TransportCredentials.Clone()}
+}
+
+// fromGateway reports whether the request was proxied by the HTTP gateway
+// over the in-process listener.
+func fromGateway(ctx context.Context) bool {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return false
+	}
+	_, ok = p.AuthInfo.(gatewayAuthInfo)
+	return ok
+}
+
+// forwardClientCert replaces the client certificate header of an HTTP request
+// with the subject of the verified client certificate, if any.
+func forwardClientCert(req *http.Request) {
+	req.Header.Del(clientCertHeader)
+	if req.TLS == nil {
+		return
+	}
+	if cert := verifiedLeaf(req.TLS.VerifiedChains); cert != nil {
+		v := url.Values{"cn": {cert.Subject.CommonName}, "ou": cert.Subject.OrganizationalUnit}
+		req.Header.Set(clientCertHeader, v.Encode())
+	}
+}
+
+// httpContext returns a context for authenticating an HTTP request handled
+// outside of the gateway, with its authorization header and TLS state.
+func httpContext(req *http.Request) context.Context {
+	md := metadata.MD{}
+	if v := req.Header.Get("Authorization"); v != "" {
+		md.Set("authorization", v)
+	}
+	ctx := metadata.NewIncomingContext(req.Context(), md)
+	if req.TLS != nil {
+		ctx = peer.NewContext(ctx, &peer.Peer{AuthInfo: credentials.TLSInfo{State: *req.TLS}})
+	}
+	return ctx
+}
+
+// certUser returns the user identified by a verified client certificate:
+// the subject common name is the username, and the subject organizational
+// units are the groups of the user.
+func (a *Authentication) certUser(ctx context.Context) *UserInfo {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	var cn string
+	var ou []string
+	switch info := p.AuthInfo.(type) {
+	case credentials.TLSInfo:
+		if cert := verifiedLeaf(info.State.VerifiedChains); cert != nil {
+			cn, ou = cert.Subject.CommonName, cert.Subject.OrganizationalUnit
+		}
+	case gatewayAuthInfo:
+		md, _ := metadata.FromIncomingContext(ctx)
+		if v := md.Get(clientCertMetadata); len(v) > 0 {
+			q, _ := url.ParseQuery(v[0])
+			cn, ou = q.Get("cn"), q["ou"]
+		}
+	}
+	if cn == "" {
+		return nil
+	}
+	return &UserInfo{Username: cn, IsAdmin: a.isAdmin(cn), Groups: ou}
+}
+
+func verifiedLeaf(chains [][]*x509.Certificate) *x509.Certificate {
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return nil
+	}
+	return chains[0][0]
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ohsu-comp-bio/funnel/config"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestCertUser(t *testing.T) {
+	a := NewAuthentication([]*config.BasicCredential{
+		{User: "admin", Password: "secret", Admin: true},
+	}, nil, AccessOwnerOrAdmin, nil, "")
+
+	leaf := &x509.Certificate{Subject: pkix.Name{CommonName: "worker-1", OrganizationalUnit: []string{"nodes"}}}
+	state := tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf}}}
+
+	// Verified client certificate on the RPC port.
+	ctx := peer.NewContext(metadata.NewIncomingContext(context.Background(), metadata.MD{}),
+		&peer.Peer{AuthInfo: credentials.TLSInfo{State: state}})
+	ctx, err := a.authenticate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := GetUser(ctx); u.Username != "worker-1" || len(u.Groups) != 1 || u.Groups[0] != "nodes" || u.IsAdmin {
+		t.Errorf("unexpected user: %+v", u)
+	}
+
+	// Unverified certificates are ignored.
+	ctx = peer.NewContext(metadata.NewIncomingContext(context.Background(), metadata.MD{}),
+		&peer.Peer{AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}}})
+	if _, err := a.authenticate(ctx); err != errTokenRequired {
+		t.Errorf("expected %v, got %v", errTokenRequired, err)
+	}
+
+	// Forwarded certificate subjects are trusted from the gateway only.
+	req := httptest.NewRequest("GET", "/v1/tasks", nil)
+	req.Header.Set(clientCertHeader, "cn=admin")
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{
+		{Subject: pkix.Name{CommonName: "admin"}},
+	}}}
+	forwardClientCert(req)
+	md := metadata.Pairs(clientCertMetadata, req.Header.Get(clientCertHeader))
+
+	ctx = peer.NewContext(metadata.NewIncomingContext(context.Background(), md),
+		&peer.Peer{AuthInfo: gatewayAuthInfo{}})
+	if u := a.certUser(ctx); u == nil || u.Username != "admin" || !u.IsAdmin {
+		t.Errorf("unexpected gateway user: %+v", u)
+	}
+	ctx = peer.NewContext(metadata.NewIncomingContext(context.Background(), md), &peer.Peer{})
+	if u := a.certUser(ctx); u != nil {
+		t.Errorf("expected forwarded subject to be ignored, got %+v", u)
+	}
+
+	// Spoofed headers are removed from plain HTTP requests.
+	req = httptest.NewRequest("GET", "/v1/tasks", nil)
+	req.Header.Set(clientCertHeader, "cn=admin")
+	forwardClientCert(req)
+	if v := req.Header.Get(clientCertHeader); v != "" {
+		t.Errorf("expected spoofed header to be removed, got %q", v)
+	}
+
+	// HTTP handlers outside of the gateway see the TLS state of the request.
+	ctx, err = a.authenticate(httpContext(req))
+	if err != errTokenRequired {
+		t.Errorf("expected %v, got %v", errTokenRequired, err)
+	}
+	req.TLS = &state
+	if ctx, err = a.authenticate(httpContext(req)); err != nil || GetUsername(ctx) != "worker-1" {
+		t.Errorf("unexpected HTTP user: %v, %v", GetUsername(ctx), err)
+	}
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/auth"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errInvalidAPIToken = status.Errorf(codes.Unauthenticated, "API token not accepted")
+
+// tokenUser returns the user of a Funnel API token.
+func (a *Authentication) tokenUser(ctx context.Context, bearer string) (*UserInfo, error) {
+	id, secret, err := auth.ParseToken(bearer)
+	if err != nil {
+		return nil, err
+	}
+	t, err := a.tokens.GetToken(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Verify(secret, time.Now()); err != nil {
+		return nil, err
+	}
+	if t.Basic && !a.isBasicUser(t.User) {
+		return nil, fmt.Errorf("user %s has no Basic credentials", t.User)
+	}
+
+	var groups []string
+	groups = append(groups, a.groups[t.User]...)
+	groups = append(groups, t.Groups...)
+	return &UserInfo{
+		Username: t.User,
+		IsAdmin:  t.Admin || a.isAdmin(t.User),
+		Groups:   groups,
+		Roles:    t.Roles,
+		Scopes:   t.Scopes,
+	}, nil
+}
+
+// isBasicUser reports whether the user has Basic credentials in the config.
+func (a *Authentication) isBasicUser(username string) bool {
+	for _, u := range a.basic {
+		if u == username {
+			return true
+		}
+	}
+	return false
+}
+
+// hasScope reports whether the API token used to authenticate, if any,
+// allows the given scope.
+func (u *UserInfo) hasScope(scope string) bool {
+	if len(u.Scopes) == 0 {
+		return true
+	}
+	for _, s := range u.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// methodScope returns the API token scope required by a gRPC method.
+func methodScope(method string) string {
+	switch method {
+	case "/tes.TaskService/CreateTask", "/tes.TaskService/CancelTask",
+		"/attach.AttachService/Attach", "/attach.AttachService/Exec":
+		return auth.ScopeWrite
+	}
+	if strings.HasPrefix(method, "/tes.TaskService/") {
+		return auth.ScopeRead
+	}
+	return auth.ScopeAdmin
+}
+
+type createTokenRequest struct {
+	Name string `json:"name"`
+	// User defaults to the current user. Only administrators may create
+	// tokens for other users, i.e. service accounts.
+	User   string   `json:"user"`
+	Admin  bool     `json:"admin"`
+	Groups []string `json:"groups"`
+	Roles  []string `json:"roles"`
+	Scopes []string `json:"scopes"`
+	// Lifetime of the token, e.g. "720h". The token doesn't expire if empty.
+	ExpiresIn string `json:"expires_in"`
+}
+
+type createTokenResponse struct {
+	*auth.Token
+	// The bearer token. It is only returned once, at creation.
+	Secret string `json:"token"`
+}
+
+// tokensHandler serves the API token endpoints:
+//
+//	GET    /v1/tokens       lists tokens (all tokens for administrators)
+//	POST   /v1/tokens       creates a token, see createTokenRequest
+//	DELETE /v1/tokens/{id}  revokes a token
+//
+// Requests authenticated with an API token require the "admin" scope.
+func tokensHandler(a *Authentication, store auth.TokenStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if len(a.basic) == 0 && a.oidc == nil {
+			http.Error(w, "API tokens require Basic or OIDC authentication to be configured", http.StatusNotFound)
+			return
+		}
+
+		ctx, err := a.authenticate(httpContext(req))
+		if err != nil {
+			http.Error(w, status.Convert(err).Message(), http.StatusUnauthorized)
+			return
+		}
+		user := GetUser(ctx)
+		if !user.hasScope(auth.ScopeAdmin) {
+			http.Error(w, errPermissionDenied.Error(), http.StatusForbidden)
+			return
+		}
+
+		id := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, "/v1/tokens"), "/")
+		switch {
+		case req.Method == http.MethodGet && id == "":
+			listTokens(ctx, w, store, user)
+		case req.Method == http.MethodPost && id == "":
+			createToken(ctx, w, req, a, store, user)
+		case req.Method == http.MethodDelete && id != "":
+			revokeToken(ctx, w, store, user, id)
+		default:
+			http.Error(w, "Unsupported method.", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func listTokens(ctx context.Context, w http.ResponseWriter, store auth.TokenStore, user *UserInfo) {
+	tokens, err := store.ListTokens(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out := []*auth.Token{}
+	for _, t := range tokens {
+		if user.isAdministrator() || t.User == user.Username {
+			out = append(out, redactToken(t))
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"tokens": out})
+}
+
+func createToken(ctx context.Context, w http.ResponseWriter, req *http.Request, a *Authentication, store auth.TokenStore, user *UserInfo) {
+	body := &createTokenRequest{}
+	if err := json.NewDecoder(req.Body).Decode(body); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := auth.ValidateScopes(body.Scopes); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	serviceAccount := body.User != "" && body.User != user.Username ||
+		body.Admin || len(body.Groups) > 0 || len(body.Roles) > 0
+	if serviceAccount && !user.isAdministrator() {
+		http.Error(w, "only administrators may create tokens for other users, groups or roles", http.StatusForbidden)
+		return
+	}
+
+	t, secret, err := auth.NewToken(user.Username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	t.Name = body.Name
+	t.Scopes = body.Scopes
+	if serviceAccount {
+		if body.User != "" {
+			t.User = body.User
+		}
+		t.Admin, t.Groups, t.Roles = body.Admin, body.Groups, body.Roles
+	} else {
+		// The groups and roles of the user aren't copied to the token, so
+		// that changes in the identity provider apply to it: its
+		// permissions come from the config, e.g. the role bindings of
+		// the user.
+		t.Basic = a.isBasicUser(user.Username)
+	}
+	if t.User == "" {
+		http.Error(w, "a user is required", http.StatusBadRequest)
+		return
+	}
+	if body.ExpiresIn != "" {
+		d, err := time.ParseDuration(body.ExpiresIn)
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid expires_in: "+body.ExpiresIn, http.StatusBadRequest)
+			return
+		}
+		t.Expires = t.Created.Add(d)
+	}
+
+	if err := store.PutToken(ctx, t); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, &createTokenResponse{Token: redactToken(t), Secret: secret})
+}
+
+func revokeToken(ctx context.Context, w http.ResponseWriter, store auth.TokenStore, user *UserInfo, id string) {
+	t, err := store.GetToken(ctx, id)
+	// Tokens of other users are reported as missing to non-administrators.
+	if errors.Is(err, auth.ErrTokenNotFound) || err == nil && t.User != user.Username && !user.isAdministrator() {
+		http.Error(w, auth.ErrTokenNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	t.Revoked = true
+	if err := store.PutToken(ctx, t); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, redactToken(t))
+}
+
+// redactToken returns a copy of the token without its hash.
+func redactToken(t *auth.Token) *auth.Token {
+	c := *t
+	c.Hash = ""
+	return &c
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
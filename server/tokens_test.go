@@ -0,0 +1,149 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ohsu-comp-bio/funnel/auth"
+	"github.com/ohsu-comp-bio/funnel/config"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+)
+
+type memTokenStore map[string]*auth.Token
+
+func (m memTokenStore) PutToken(ctx context.Context, t *auth.Token) error {
+	c := *t
+	m[t.ID] = &c
+	return nil
+}
+
+func (m memTokenStore) GetToken(ctx context.Context, id string) (*auth.Token, error) {
+	t, ok := m[id]
+	if !ok {
+		return nil, auth.ErrTokenNotFound
+	}
+	c := *t
+	return &c, nil
+}
+
+func (m memTokenStore) ListTokens(ctx context.Context) ([]*auth.Token, error) {
+	var out []*auth.Token
+	for _, t := range m {
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+func TestAPITokens(t *testing.T) {
+	prevMode := accessMode
+	defer func() { accessMode = prevMode }()
+
+	store := memTokenStore{}
+	a := NewAuthentication([]*config.BasicCredential{
+		{User: "admin", Password: "secret", Admin: true},
+		{User: "alice", Password: "abc", Groups: []string{"lab-a"}},
+	}, nil, AccessOwnerOrAdmin, nil, "")
+	a.tokens = store
+	handler := tokensHandler(a, store)
+
+	call := func(method, path, authorization string, body interface{}) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(b))
+		req.Header.Set("Authorization", authorization)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+	create := func(authorization string, body *createTokenRequest) (int, *auth.Token, string) {
+		rec := call(http.MethodPost, "/v1/tokens", authorization, body)
+		resp := &createTokenResponse{}
+		json.Unmarshal(rec.Body.Bytes(), resp)
+		return rec.Code, resp.Token, resp.Secret
+	}
+	user := func(bearer string) (*UserInfo, error) {
+		ctx, err := a.authenticate(metadata.NewIncomingContext(context.Background(),
+			metadata.Pairs("authorization", "Bearer "+bearer)))
+		if err != nil {
+			return nil, err
+		}
+		return GetUser(ctx), nil
+	}
+
+	// Admins can create service account tokens.
+	code, tok, ci := create("Basic YWRtaW46c2VjcmV0", &createTokenRequest{
+		User: "ci-bot", Scopes: []string{auth.ScopeRead, auth.ScopeWrite}, ExpiresIn: "24h",
+	})
+	if code != http.StatusOK || tok.User != "ci-bot" || tok.Hash != "" || tok.Expires.IsZero() {
+		t.Fatalf("unexpected token: %d %+v", code, tok)
+	}
+	if store[tok.ID].Hash == "" {
+		t.Error("expected the token hash to be stored")
+	}
+
+	u, err := user(ci)
+	if err != nil || u.Username != "ci-bot" || u.IsAdmin {
+		t.Fatalf("unexpected user: %+v %v", u, err)
+	}
+	ctx := context.WithValue(context.Background(), UserInfoKey, u)
+	if authorizeMethod(ctx, "/tes.TaskService/CreateTask") != nil {
+		t.Error("expected the write scope to allow CreateTask")
+	}
+	if authorizeMethod(ctx, "/scheduler.SchedulerService/PutNode") == nil {
+		t.Error("expected node changes to require the admin scope")
+	}
+
+	// Users can only create tokens for themselves.
+	if code, _, _ := create("Basic YWxpY2U6YWJj", &createTokenRequest{User: "ci-bot"}); code != http.StatusForbidden {
+		t.Error("expected alice not to create tokens for other users", code)
+	}
+	code, mine, alice := create("Basic YWxpY2U6YWJj", &createTokenRequest{Name: "laptop"})
+	if code != http.StatusOK || mine.User != "alice" {
+		t.Fatal("expected alice to create her own token", code)
+	}
+
+	// The groups of the user are read from the config, not the token.
+	if stored := store[mine.ID]; len(stored.Groups) != 0 || !stored.Basic {
+		t.Errorf("unexpected stored token: %+v", stored)
+	}
+	if u, err := user(alice); err != nil || len(u.Groups) != 1 || u.Groups[0] != "lab-a" {
+		t.Errorf("unexpected user: %+v %v", u, err)
+	}
+
+	// Users can only see and revoke their own tokens.
+	rec := call(http.MethodGet, "/v1/tokens", "Bearer "+alice, nil)
+	list := map[string][]*auth.Token{}
+	json.Unmarshal(rec.Body.Bytes(), &list)
+	if len(list["tokens"]) != 1 || list["tokens"][0].ID != mine.ID {
+		t.Error("expected alice to list her own token only", rec.Body.String())
+	}
+	if rec := call(http.MethodDelete, "/v1/tokens/"+tok.ID, "Bearer "+alice, nil); rec.Code != http.StatusNotFound {
+		t.Error("expected alice not to revoke the ci-bot token", rec.Code)
+	}
+
+	// Scoped tokens can't manage tokens.
+	if rec := call(http.MethodGet, "/v1/tokens", "Bearer "+ci, nil); rec.Code != http.StatusForbidden {
+		t.Error("expected the ci-bot token to lack the admin scope", rec.Code)
+	}
+
+	// Revoked tokens are rejected.
+	if rec := call(http.MethodDelete, "/v1/tokens/"+tok.ID, "Basic YWRtaW46c2VjcmV0", nil); rec.Code != http.StatusOK {
+		t.Fatal("expected admin to revoke the ci-bot token", rec.Code)
+	}
+	if _, err := user(ci); err != errInvalidAPIToken {
+		t.Error("expected revoked token to be rejected", err)
+	}
+
+	// The tokens of a user removed from the Basic credentials are rejected.
+	for k, v := range a.basic {
+		if v == "alice" {
+			delete(a.basic, k)
+		}
+	}
+	if _, err := user(alice); err != errInvalidAPIToken {
+		t.Error("expected the token of a removed user to be rejected", err)
+	}
+}
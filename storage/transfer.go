@@ -4,7 +4,9 @@ import (
 	"context"
 
 	"github.com/gammazero/workerpool"
+	"github.com/ohsu-comp-bio/funnel/tracing"
 	"github.com/ohsu-comp-bio/funnel/util/fsutil"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Transfer defines the interface of a single storage upload or download request.
@@ -29,11 +31,13 @@ func Download(ctx context.Context, store Storage, transfers []Transfer, parallel
 		x := x
 		wp.Submit(func() {
 			x.Started()
+			ctx, span := tracing.Start(ctx, "Download", attribute.String("url.full", x.URL()))
 			var obj *Object
 			err := fsutil.EnsurePath(x.Path())
 			if err == nil {
 				obj, err = store.Get(ctx, x.URL(), x.Path())
 			}
+			tracing.End(span, err)
 			if err != nil {
 				x.Failed(err)
 			} else {
@@ -54,7 +58,9 @@ func Upload(ctx context.Context, store Storage, transfers []Transfer, parallelLi
 		x := x
 		wp.Submit(func() {
 			x.Started()
+			ctx, span := tracing.Start(ctx, "Upload", attribute.String("url.full", x.URL()))
 			obj, err := store.Put(ctx, x.URL(), x.Path())
+			tracing.End(span, err)
 			if err != nil {
 				x.Failed(err)
 			} else {
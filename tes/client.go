@@ -23,6 +23,7 @@ import (
 func NewClient(address string) (*Client, error) {
 	user := os.Getenv("FUNNEL_SERVER_USER")
 	password := os.Getenv("FUNNEL_SERVER_PASSWORD")
+	token := os.Getenv("FUNNEL_SERVER_TOKEN")
 
 	re := regexp.MustCompile("^(.+://)?(.[^/]+)(.+)?$")
 	endpoint := re.ReplaceAllString(address, "$1$2")
@@ -44,6 +45,7 @@ func NewClient(address string) (*Client, error) {
 		Marshaler: &Marshaler,
 		User:      user,
 		Password:  password,
+		Token:     token,
 	}, nil
 }
 
@@ -54,6 +56,17 @@ type Client struct {
 	Marshaler *protojson.MarshalOptions
 	User      string
 	Password  string
+	// Funnel API token, sent instead of the basic credentials if set.
+	Token string
+}
+
+// setAuth sets the authorization header of the request.
+func (c *Client) setAuth(hreq *http.Request) {
+	if c.Token != "" {
+		hreq.Header.Set("Authorization", "Bearer "+c.Token)
+		return
+	}
+	hreq.SetBasicAuth(c.User, c.Password)
 }
 
 // GetTask returns the raw bytes from GET /v1/tasks/{id}
@@ -62,7 +75,7 @@ func (c *Client) GetTask(ctx context.Context, req *GetTaskRequest) (*Task, error
 	u := c.address + "/v1/tasks/" + req.Id + "?view=" + req.View
 	hreq, _ := http.NewRequest("GET", u, nil)
 	hreq = hreq.WithContext(ctx)
-	hreq.SetBasicAuth(c.User, c.Password)
+	c.setAuth(hreq)
 	body, err := util.CheckHTTPResponse(c.client.Do(hreq))
 	if err != nil {
 		return nil, err
@@ -78,8 +91,15 @@ func (c *Client) GetTask(ctx context.Context, req *GetTaskRequest) (*Task, error
 
 // ListTasks returns the result of GET /v1/tasks
 func (c *Client) ListTasks(ctx context.Context, req *ListTasksRequest) (*ListTasksResponse, error) {
+	return c.ListTasksFilter(ctx, req, "")
+}
+
+// ListTasksFilter returns the result of GET /v1/tasks, with the Funnel task
+// filter, e.g. "state=QUEUED,RUNNING created>=2024-01-01".
+func (c *Client) ListTasksFilter(ctx context.Context, req *ListTasksRequest, filter string) (*ListTasksResponse, error) {
 	// Build url query parameters
 	v := url.Values{}
+	addString(v, "filter", filter)
 	addInt32(v, "page_size", req.GetPageSize())
 	addString(v, "page_token", req.GetPageToken())
 	addString(v, "view", req.GetView())
@@ -98,7 +118,7 @@ func (c *Client) ListTasks(ctx context.Context, req *ListTasksRequest) (*ListTas
 	u := c.address + "/v1/tasks?" + v.Encode()
 	hreq, _ := http.NewRequest("GET", u, nil)
 	hreq = hreq.WithContext(ctx)
-	hreq.SetBasicAuth(c.User, c.Password)
+	c.setAuth(hreq)
 	body, err := util.CheckHTTPResponse(c.client.Do(hreq))
 	if err != nil {
 		return nil, err
@@ -129,7 +149,7 @@ func (c *Client) CreateTask(ctx context.Context, task *Task) (*CreateTaskRespons
 	hreq, _ := http.NewRequest("POST", u, bytes.NewReader(b))
 	hreq = hreq.WithContext(ctx)
 	hreq.Header.Add("Content-Type", "application/json")
-	hreq.SetBasicAuth(c.User, c.Password)
+	c.setAuth(hreq)
 	body, err := util.CheckHTTPResponse(c.client.Do(hreq))
 	if err != nil {
 		return nil, err
@@ -165,7 +185,7 @@ func (c *Client) CancelTaskWithMessage(ctx context.Context, req *CancelTaskReque
 	hreq, _ := http.NewRequest("POST", u, nil)
 	hreq = hreq.WithContext(ctx)
 	hreq.Header.Add("Content-Type", "application/json")
-	hreq.SetBasicAuth(c.User, c.Password)
+	c.setAuth(hreq)
 
 	// Execute request and capture response
 	httpResp, err := c.client.Do(hreq)
@@ -212,7 +232,7 @@ func (c *Client) GetServiceInfo(ctx context.Context, req *GetServiceInfoRequest)
 	u := c.address + "/v1/service-info"
 	hreq, _ := http.NewRequest("GET", u, nil)
 	hreq = hreq.WithContext(ctx)
-	hreq.SetBasicAuth(c.User, c.Password)
+	c.setAuth(hreq)
 	body, err := util.CheckHTTPResponse(c.client.Do(hreq))
 	if err != nil {
 		return nil, err
@@ -61,16 +61,11 @@ func ValidateTransition(from, to State) error {
 		}
 		return &TransitionError{from, to}
 
-	case ExecutorError, SystemError:
-		// May not transition out of these terminal state, except in the case of a retry.
-		// Whether to allow retries could be made into a configuration if needed
-		if to == Queued || to == Initializing {
-			return nil
-		}
-		return &TransitionError{from, to}
-
-	case Complete, Canceled:
-		// May not transition out of these terminal states
+	case Complete, ExecutorError, SystemError, Canceled:
+		// May not transition out of the terminal states, so that a late
+		// event, e.g. of the scheduler or of a retried write, can't revert
+		// the final state of a task. A failed task isn't retried by moving
+		// it back to Queued or Initializing either: it's submitted again.
 		return &TransitionError{from, to}
 
 	}
@@ -0,0 +1,29 @@
+package tes
+
+import "testing"
+
+func TestValidateTransition(t *testing.T) {
+	for _, tc := range []struct {
+		from, to State
+		ok       bool
+	}{
+		{Unknown, Running, true},
+		{Queued, Initializing, true},
+		{Initializing, Running, true},
+		{Running, Running, true},
+		{Running, Complete, true},
+		{Running, Initializing, false},
+		{Initializing, Queued, false},
+		// The terminal states are final.
+		{Complete, Running, false},
+		{Complete, SystemError, false},
+		{ExecutorError, Queued, false},
+		{SystemError, Initializing, false},
+		{Canceled, Running, false},
+		{Complete, Complete, true},
+	} {
+		if err := ValidateTransition(tc.from, tc.to); (err == nil) != tc.ok {
+			t.Errorf("%s -> %s: unexpected result %v", tc.from, tc.to, err)
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/ohsu-comp-bio/funnel/tes"
 	"github.com/ohsu-comp-bio/funnel/tests"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
@@ -1137,6 +1138,74 @@ func TestListTaskMultipleFilters(t *testing.T) {
 	}
 }
 
+func TestListTaskQueryFilter(t *testing.T) {
+	tests.SetLogOutput(log, t)
+
+	c := tests.DefaultConfig()
+	c.Compute = "noop"
+	f := tests.NewFunnel(c)
+	f.StartServer()
+	ctx := context.Background()
+
+	var ids []string
+	for _, task := range []*tes.Task{
+		{Executors: []*tes.Executor{{Image: "alpine", Command: []string{"true"}}}, Tags: map[string]string{"project": "cohort-1"}},
+		{Executors: []*tes.Executor{{Image: "ubuntu:22.04", Command: []string{"true"}}}, Tags: map[string]string{"project": "cohort-2"}},
+		{Executors: []*tes.Executor{{Image: "ubuntu:24.04", Command: []string{"true"}}}, Tags: map[string]string{"project": "other"}},
+	} {
+		resp, err := f.RPC.CreateTask(ctx, task)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, resp.Id)
+	}
+
+	list := func(filter string) []string {
+		r, err := f.HTTP.ListTasksFilter(ctx, &tes.ListTasksRequest{View: tes.View_BASIC.String()}, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, task := range r.Tasks {
+			got = append(got, task.Id)
+		}
+		return got
+	}
+
+	cases := []struct {
+		filter   string
+		expected []string
+	}{
+		{"tag.project^=cohort-", []string{ids[1], ids[0]}},
+		{"tag.project~=[0-9]$ image^=ubuntu", []string{ids[1]}},
+		{"state=QUEUED,RUNNING sort=created", ids},
+		{"state=COMPLETE", nil},
+		{"created<2000-01-01", nil},
+		{"created>=2000-01-01 image=ubuntu:24.04", []string{ids[2]}},
+	}
+	for _, tt := range cases {
+		got := list(tt.filter)
+		if strings.Join(got, ",") != strings.Join(tt.expected, ",") {
+			t.Errorf("unexpected tasks for %q: %v, expected %v", tt.filter, got, tt.expected)
+		}
+	}
+
+	_, err := f.HTTP.ListTasksFilter(ctx, &tes.ListTasksRequest{}, "created>yesterday")
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		t.Error("expected a bad request error", err)
+	}
+
+	// gRPC clients pass the filter as metadata.
+	mctx := metadata.AppendToOutgoingContext(ctx, "funnel-filter", "image^=alpine")
+	r, err := f.RPC.ListTasks(mctx, &tes.ListTasksRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Tasks) != 1 || r.Tasks[0].Id != ids[0] {
+		t.Error("unexpected tasks", r.Tasks)
+	}
+}
+
 func TestConcurrentStateUpdate(t *testing.T) {
 	tests.SetLogOutput(log, t)
 
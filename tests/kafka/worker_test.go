@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"testing"
+	"time"
 
 	workerCmd "github.com/ohsu-comp-bio/funnel/cmd/worker"
 	"github.com/ohsu-comp-bio/funnel/config"
@@ -11,6 +12,7 @@ import (
 	"github.com/ohsu-comp-bio/funnel/logger"
 	"github.com/ohsu-comp-bio/funnel/tes"
 	"github.com/ohsu-comp-bio/funnel/tests"
+	"google.golang.org/protobuf/proto"
 )
 
 var log = logger.NewLogger("kafka-worker-test", logger.DefaultConfig())
@@ -44,17 +46,6 @@ func TestKafkaWorkerRun(t *testing.T) {
 	tests.SetLogOutput(log, t)
 
 	ctx := context.Background()
-	// Task builder collects events into a task view.
-	task := &tes.Task{}
-	b := events.TaskBuilder{Task: task}
-	l := &events.Logger{Log: log}
-	m := &events.MultiWriter{b, l}
-
-	// Read events from kafka, write into task builder.
-	_, err := events.NewKafkaReader(ctx, conf.Kafka, m)
-	if err != nil {
-		t.Fatal(err)
-	}
 
 	// this only writes the task to the DB since the 'noop'
 	// compute backend is in use
@@ -62,14 +53,59 @@ func TestKafkaWorkerRun(t *testing.T) {
     --sh 'echo hello world'
   `)
 
-	err = workerCmd.Run(ctx, conf, log, &workerCmd.Options{TaskID: id})
+	err := workerCmd.Run(ctx, conf, log, &workerCmd.Options{TaskID: id})
 	if err != nil {
 		t.Fatal("unexpected error", err)
 	}
 	fun.Wait(id)
 
+	// Task builder collects events into a task view.
+	task := &tes.Task{}
+	b := events.TaskBuilder{Task: task}
+	l := &events.Logger{Log: log}
+	m := &events.MultiWriter{b, l}
+
+	// Read events from kafka, write into task builder. A new consumer group
+	// reads the events written before it started. The events of the task,
+	// keyed by its ID, are consumed by a single goroutine, which signals the
+	// completion of the task.
+	kconf := proto.Clone(conf.Kafka).(*config.Kafka)
+	kconf.ConsumerGroup = "funnel-test-" + id
+	f := &taskFilter{id: id, Writer: m, task: task, done: make(chan struct{})}
+	r, err := events.NewKafkaReader(ctx, kconf, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
 	// Check the task (built from a stream of kafka events).
-	if task.State != tes.State_COMPLETE {
+	select {
+	case <-f.done:
+	case <-time.After(5 * time.Second):
 		t.Fatal("unexpected state")
 	}
 }
+
+// taskFilter writes the events of a task, and closes done once the task
+// is complete.
+type taskFilter struct {
+	id string
+	events.Writer
+	task *tes.Task
+	done chan struct{}
+}
+
+func (f *taskFilter) WriteEvent(ctx context.Context, ev *events.Event) error {
+	if ev.Id != f.id {
+		return nil
+	}
+	err := f.Writer.WriteEvent(ctx, ev)
+	if f.task.GetState() == tes.State_COMPLETE {
+		select {
+		case <-f.done:
+		default:
+			close(f.done)
+		}
+	}
+	return err
+}
@@ -0,0 +1,161 @@
+// Package tracing exports OpenTelemetry spans of the task lifecycle.
+//
+// The trace of a task starts with its CreateTask span, whose trace and span
+// IDs are derived from the task ID, so that the server and the workers
+// continue the same trace without storing or sending its context. The gRPC
+// metadata of the calls carry the context of their caller.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/ohsu-comp-bio/funnel/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TaskIDKey is the attribute of the task ID of a span.
+const TaskIDKey = attribute.Key("funnel.task.id")
+
+var enabled atomic.Bool
+
+// Setup exports the spans to the OTLP/HTTP endpoint of the config, and sets
+// the W3C trace context propagator of the gRPC calls. It does nothing if the
+// endpoint is empty. The returned function flushes the spans, and stops
+// exporting them.
+func Setup(ctx context.Context, conf *config.Tracing, service string) (func(context.Context) error, error) {
+	if conf.GetEndpoint() == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	if conf.ServiceName != "" {
+		service = conf.ServiceName
+	}
+
+	exp, err := otlptracehttp.New(ctx,
+		otlptracehttp.WithEndpointURL(conf.Endpoint),
+		otlptracehttp.WithHeaders(conf.Headers),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating the OTLP exporter: %v", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithIDGenerator(&idGenerator{}),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName(service),
+		)),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	enabled.Store(true)
+
+	return func(ctx context.Context) error {
+		enabled.Store(false)
+		return tp.Shutdown(ctx)
+	}, nil
+}
+
+// Enabled returns true if the spans are exported.
+func Enabled() bool {
+	return enabled.Load()
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer("github.com/ohsu-comp-bio/funnel")
+}
+
+// Start starts a span, child of the span of the context.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records the error of the span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+type rootKey struct{}
+
+// StartTask starts the root span of the trace of a task, with the IDs of
+// TaskSpanContext. It is linked to the span of the context, e.g. of the
+// client creating the task.
+func StartTask(ctx context.Context, taskID, name string) (context.Context, trace.Span) {
+	link := trace.LinkFromContext(ctx)
+	ctx = context.WithValue(ctx, rootKey{}, taskID)
+	ctx, span := tracer().Start(ctx, name,
+		trace.WithNewRoot(),
+		trace.WithLinks(link),
+		trace.WithAttributes(TaskIDKey.String(taskID)),
+	)
+	return context.WithValue(ctx, rootKey{}, ""), span
+}
+
+// TaskContext returns the context of a span of the trace of a task, child
+// of its root span.
+func TaskContext(ctx context.Context, taskID string) context.Context {
+	return trace.ContextWithRemoteSpanContext(ctx, TaskSpanContext(taskID))
+}
+
+// TaskSpanContext returns the context of the root span of the trace of a
+// task. Its IDs are derived from the task ID.
+func TaskSpanContext(taskID string) trace.SpanContext {
+	tid, sid := taskIDs(taskID)
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    tid,
+		SpanID:     sid,
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	})
+}
+
+func taskIDs(taskID string) (trace.TraceID, trace.SpanID) {
+	sum := sha256.Sum256([]byte("funnel-task:" + taskID))
+	var tid trace.TraceID
+	var sid trace.SpanID
+	copy(tid[:], sum[:16])
+	copy(sid[:], sum[16:24])
+	return tid, sid
+}
+
+// Traceparent returns the W3C traceparent of the span context.
+func Traceparent(sc trace.SpanContext) string {
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID(), sc.SpanID(), sc.TraceFlags())
+}
+
+// idGenerator generates the IDs of the root spans of the tasks, started by
+// StartTask, from their task IDs. The IDs of the other spans are random.
+type idGenerator struct{}
+
+func (g *idGenerator) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	if taskID, _ := ctx.Value(rootKey{}).(string); taskID != "" {
+		return taskIDs(taskID)
+	}
+	var tid trace.TraceID
+	rand.Read(tid[:])
+	return tid, g.NewSpanID(ctx, tid)
+}
+
+func (g *idGenerator) NewSpanID(ctx context.Context, traceID trace.TraceID) trace.SpanID {
+	var sid trace.SpanID
+	rand.Read(sid[:])
+	return sid
+}
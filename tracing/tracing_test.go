@@ -0,0 +1,134 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/ohsu-comp-bio/funnel/config"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// collector is an OTLP/HTTP collector recording the spans it receives.
+type collector struct {
+	*httptest.Server
+	mtx     sync.Mutex
+	spans   map[string]*tracepb.Span
+	service string
+}
+
+func newCollector(t *testing.T) *collector {
+	c := &collector{spans: map[string]*tracepb.Span{}}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		msg := &coltracepb.ExportTraceServiceRequest{}
+		if req.URL.Path != "/v1/traces" || proto.Unmarshal(body, msg) != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		c.mtx.Lock()
+		defer c.mtx.Unlock()
+		for _, rs := range msg.ResourceSpans {
+			for _, attr := range rs.GetResource().GetAttributes() {
+				if attr.Key == "service.name" {
+					c.service = attr.GetValue().GetStringValue()
+				}
+			}
+			for _, ss := range rs.ScopeSpans {
+				for _, s := range ss.Spans {
+					c.spans[s.Name] = s
+				}
+			}
+		}
+		rw.Header().Set("Content-Type", "application/x-protobuf")
+		b, _ := proto.Marshal(&coltracepb.ExportTraceServiceResponse{})
+		rw.Write(b)
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+func TestTaskTrace(t *testing.T) {
+	ctx := context.Background()
+	col := newCollector(t)
+
+	shutdown, err := Setup(ctx, &config.Tracing{Endpoint: col.URL + "/v1/traces"}, "funnel-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Enabled() {
+		t.Fatal("expected tracing to be enabled")
+	}
+
+	// The server creates the task, in the trace of the client.
+	clientCtx, client := Start(ctx, "client")
+	_, create := StartTask(clientCtx, "task-1", "CreateTask")
+	create.End()
+	client.End()
+
+	// A worker continues the trace, from the task ID only.
+	runCtx, run := Start(TaskContext(ctx, "task-1"), "RunTask", TaskIDKey.String("task-1"))
+	_, download := Start(runCtx, "Download")
+	End(download, errors.New("not found"))
+	run.End()
+
+	if err := shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if Enabled() {
+		t.Error("expected tracing to be disabled")
+	}
+
+	col.mtx.Lock()
+	defer col.mtx.Unlock()
+	if col.service != "funnel-test" {
+		t.Errorf("unexpected service: %s", col.service)
+	}
+	for _, name := range []string{"client", "CreateTask", "RunTask", "Download"} {
+		if col.spans[name] == nil {
+			t.Fatalf("missing span %s: %v", name, col.spans)
+		}
+	}
+
+	root := TaskSpanContext("task-1")
+	tid, sid := root.TraceID(), root.SpanID()
+	createSpan := col.spans["CreateTask"]
+	if string(createSpan.TraceId) != string(tid[:]) || string(createSpan.SpanId) != string(sid[:]) {
+		t.Errorf("expected the IDs of the task, got %x %x", createSpan.TraceId, createSpan.SpanId)
+	}
+	if len(createSpan.ParentSpanId) != 0 || len(createSpan.Links) != 1 ||
+		string(createSpan.Links[0].SpanId) != string(col.spans["client"].SpanId) {
+		t.Errorf("expected a root span linked to the client, got %v", createSpan)
+	}
+
+	runSpan := col.spans["RunTask"]
+	if string(runSpan.TraceId) != string(tid[:]) || string(runSpan.ParentSpanId) != string(sid[:]) {
+		t.Errorf("expected a child of CreateTask, got %v", runSpan)
+	}
+	downloadSpan := col.spans["Download"]
+	if string(downloadSpan.ParentSpanId) != string(runSpan.SpanId) ||
+		downloadSpan.GetStatus().GetCode() != tracepb.Status_STATUS_CODE_ERROR {
+		t.Errorf("expected a failed child of RunTask, got %v", downloadSpan)
+	}
+	// The IDs of the other spans are random.
+	if string(col.spans["client"].TraceId) == string(tid[:]) {
+		t.Error("expected another trace for the client")
+	}
+}
+
+func TestTraceparent(t *testing.T) {
+	sc := TaskSpanContext("task-1")
+	if !sc.Equal(TaskSpanContext("task-1")) || sc.Equal(TaskSpanContext("task-2")) {
+		t.Error("expected the span context to be derived from the task ID")
+	}
+	tp := Traceparent(sc)
+	if len(tp) != 55 || tp[:3] != "00-" || tp[52:] != "-01" {
+		t.Errorf("unexpected traceparent: %s", tp)
+	}
+}
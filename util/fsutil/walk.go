@@ -2,6 +2,7 @@ package fsutil
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"time"
@@ -24,7 +25,7 @@ func WalkFiles(root string) ([]Hostfile, error) {
 	var files []Hostfile
 
 	if dinfo, err := os.Stat(root); os.IsNotExist(err) || !dinfo.IsDir() {
-		return nil, fmt.Errorf("%s does not exist or is not a directory", root)
+		return nil, fmt.Errorf("%s does not exist or is not a directory: %w", root, fs.ErrNotExist)
 	}
 
 	err := filepath.Walk(root, func(p string, f os.FileInfo, err error) error {
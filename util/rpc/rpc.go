@@ -8,8 +8,11 @@ import (
 
 	grpc_retry "github.com/grpc-ecosystem/go-grpc-middleware/retry"
 	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/util/tlsutil"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
@@ -39,14 +42,46 @@ func (c *loginCreds) RequireTransportSecurity() bool {
 	return false
 }
 
+// PerRPCToken returns a new gRPC DialOption which includes a bearer token
+// header in each RPC request.
+func PerRPCToken(token string) grpc.DialOption {
+	return grpc.WithPerRPCCredentials(tokenCreds(token))
+}
+
+type tokenCreds string
+
+func (c tokenCreds) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{
+		"Authorization": "Bearer " + string(c),
+	}, nil
+}
+
+func (c tokenCreds) RequireTransportSecurity() bool {
+	return false
+}
+
 // Dial returns a new gRPC ClientConn with some default dial and call options set
 func Dial(pctx context.Context, conf *config.RPCClient, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
 	ctx, cancel := context.WithTimeout(pctx, conf.Timeout.GetDuration().AsDuration())
 	defer cancel()
 
+	creds := insecure.NewCredentials()
+	if tlsutil.ClientEnabled(conf.TLS) {
+		c, err := tlsutil.ClientConfig(conf.TLS)
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(c)
+	}
+
 	defaultOpts := []grpc.DialOption{
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		PerRPCPassword(conf.Credential.User, conf.Credential.Password),
+		grpc.WithTransportCredentials(creds),
+		// The trace context of the calls is sent in their metadata.
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	}
+	// Without a password, the client may be authenticated by its certificate.
+	if conf.Credential.GetUser() != "" {
+		defaultOpts = append(defaultOpts, PerRPCPassword(conf.Credential.User, conf.Credential.Password))
 	}
 	opts = append(opts, defaultOpts...)
 	opts = append(
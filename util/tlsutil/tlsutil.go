@@ -0,0 +1,176 @@
+// Package tlsutil builds TLS configurations from PEM certificate files,
+// which are reloaded when they change on disk.
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/config"
+)
+
+// ServerEnabled reports whether TLS is configured for a server.
+func ServerEnabled(conf *config.TLS) bool {
+	return conf.GetCertFile() != ""
+}
+
+// ClientEnabled reports whether TLS is configured for a client.
+func ClientEnabled(conf *config.TLS) bool {
+	return conf.GetCAFile() != "" || conf.GetCertFile() != "" || conf.GetServerName() != ""
+}
+
+// ServerConfig returns a TLS configuration for a server, negotiating the given
+// application protocols (e.g. "h2"). The certificate and CA files are checked
+// for changes on each handshake.
+func ServerConfig(conf *config.TLS, nextProtos ...string) (*tls.Config, error) {
+	var clientAuth tls.ClientAuthType
+	switch strings.ToLower(conf.ClientAuth) {
+	case "":
+		clientAuth = tls.NoClientCert
+	case "optional":
+		clientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		clientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unknown TLS.ClientAuth %q. Expected 'Optional' or 'Require'", conf.ClientAuth)
+	}
+	if clientAuth != tls.NoClientCert && conf.CAFile == "" {
+		return nil, fmt.Errorf("TLS.ClientAuth requires TLS.CAFile")
+	}
+
+	r, err := NewReloader(conf.CertFile, conf.KeyFile, conf.CAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	newConfig := func() *tls.Config {
+		return &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			NextProtos: nextProtos,
+			ClientAuth: clientAuth,
+		}
+	}
+	c := newConfig()
+	c.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, _, err := r.Load()
+		return cert, err
+	}
+	c.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cert, pool, err := r.Load()
+		if err != nil {
+			return nil, err
+		}
+		cc := newConfig()
+		cc.Certificates = []tls.Certificate{*cert}
+		cc.ClientCAs = pool
+		return cc, nil
+	}
+	return c, nil
+}
+
+// ClientConfig returns a TLS configuration for a client. The client
+// certificate, if any, is checked for changes on each handshake.
+func ClientConfig(conf *config.TLS) (*tls.Config, error) {
+	c := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: conf.ServerName,
+	}
+	if conf.CAFile != "" {
+		pool, err := loadCertPool(conf.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = pool
+	}
+	if conf.CertFile != "" {
+		r, err := NewReloader(conf.CertFile, conf.KeyFile, "")
+		if err != nil {
+			return nil, err
+		}
+		c.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _, err := r.Load()
+			return cert, err
+		}
+	}
+	return c, nil
+}
+
+// Reloader holds a certificate and a CA pool loaded from files, which are
+// reloaded when their modification times change. If reloading fails, e.g.
+// while the files are being replaced, the previous certificates are kept.
+type Reloader struct {
+	certFile, keyFile, caFile string
+
+	mtx     sync.Mutex
+	modTime map[string]time.Time
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+}
+
+// NewReloader loads the certificate and key, and the CA file if non-empty.
+func NewReloader(certFile, keyFile, caFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if _, _, err := r.Load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Load returns the current certificate and CA pool, reloading them if the
+// files changed.
+func (r *Reloader) Load() (*tls.Certificate, *x509.CertPool, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	modTime := map[string]time.Time{}
+	changed := false
+	for _, f := range []string{r.certFile, r.keyFile, r.caFile} {
+		if f == "" {
+			continue
+		}
+		if fi, err := os.Stat(f); err == nil {
+			modTime[f] = fi.ModTime()
+			changed = changed || !fi.ModTime().Equal(r.modTime[f])
+		}
+	}
+	if !changed && r.cert != nil {
+		return r.cert, r.pool, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return r.fallback(fmt.Errorf("loading TLS certificate: %v", err))
+	}
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		if pool, err = loadCertPool(r.caFile); err != nil {
+			return r.fallback(err)
+		}
+	}
+	r.cert, r.pool, r.modTime = &cert, pool, modTime
+	return r.cert, r.pool, nil
+}
+
+func (r *Reloader) fallback(err error) (*tls.Certificate, *x509.CertPool, error) {
+	if r.cert == nil {
+		return nil, nil, err
+	}
+	return r.cert, r.pool, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading TLS CA file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in TLS CA file %s", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,169 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/config"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert, key}
+}
+
+// issue writes a certificate and key signed by the CA to dir/name.{crt,key}.
+func (ca *testCA) issue(t *testing.T, dir, name string, serial int64, usage x509.ExtKeyUsage) (string, string) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDer)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// handshake connects a client and server over TCP, returning the server
+// certificate seen by the client, and the client certificate chains verified
+// by the server.
+func handshake(t *testing.T, client, server *tls.Config) (*x509.Certificate, [][]*x509.Certificate, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	type result struct {
+		state tls.ConnectionState
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer conn.Close()
+		tconn := tls.Server(conn, server)
+		err = tconn.Handshake()
+		done <- result{tconn.ConnectionState(), err}
+	}()
+
+	conn, err := tls.Dial("tcp", lis.Addr().String(), client)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+	res := <-done
+	if res.err != nil {
+		return nil, nil, res.err
+	}
+	return conn.ConnectionState().PeerCertificates[0], res.state.VerifiedChains, nil
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	caFile := filepath.Join(dir, "ca.crt")
+	writePEM(t, caFile, "CERTIFICATE", ca.cert.Raw)
+	serverCert, serverKey := ca.issue(t, dir, "funnel.example.org", 2, x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, dir, "worker", 3, x509.ExtKeyUsageClientAuth)
+
+	serverConf := &config.TLS{CertFile: serverCert, KeyFile: serverKey, CAFile: caFile, ClientAuth: "Require"}
+	clientConf := &config.TLS{CertFile: clientCert, KeyFile: clientKey, CAFile: caFile, ServerName: "funnel.example.org"}
+	if !ServerEnabled(serverConf) || !ClientEnabled(clientConf) || ServerEnabled(&config.TLS{}) || ClientEnabled(nil) {
+		t.Fatal("unexpected TLS enabled state")
+	}
+
+	server, err := ServerConfig(serverConf, "h2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := ClientConfig(clientConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, chains, err := handshake(t, client, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.SerialNumber.Int64() != 2 {
+		t.Error("unexpected server certificate", cert.SerialNumber)
+	}
+	if len(chains) == 0 || chains[0][0].Subject.CommonName != "worker" {
+		t.Error("expected the server to verify the worker certificate")
+	}
+
+	// Clients without a certificate are rejected.
+	anon, _ := ClientConfig(&config.TLS{CAFile: caFile, ServerName: "funnel.example.org"})
+	if _, _, err := handshake(t, anon, server); err == nil {
+		t.Error("expected a client without a certificate to be rejected")
+	}
+
+	// Certificates are reloaded when they change.
+	ca.issue(t, dir, "funnel.example.org", 4, x509.ExtKeyUsageServerAuth)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(serverCert, future, future)
+	os.Chtimes(serverKey, future, future)
+	cert, _, err = handshake(t, client, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.SerialNumber.Int64() != 4 {
+		t.Error("expected the reloaded server certificate", cert.SerialNumber)
+	}
+
+	if _, err := ServerConfig(&config.TLS{CertFile: serverCert, KeyFile: serverKey, ClientAuth: "Require"}); err == nil {
+		t.Error("expected ClientAuth without CAFile to fail")
+	}
+}
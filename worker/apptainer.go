@@ -0,0 +1,341 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/provenance"
+)
+
+// ApptainerCommand runs an executor with Apptainer (formerly Singularity),
+// e.g. on HPC nodes where Docker isn't available. Docker images are converted
+// to SIF images, which are cached in ImageDir and shared by concurrent tasks.
+type ApptainerCommand struct {
+	Name string
+	// "apptainer" or "singularity", optionally with leading arguments.
+	DriverCommand string
+	// Directory of the cached SIF images.
+	ImageDir string
+	// Don't pass the host environment to the container.
+	CleanEnv bool
+	// Extra arguments to "exec", e.g. "--nv".
+	ExtraArgs []string
+	// Time given to the executor to exit after SIGTERM, before SIGKILL.
+	// Defaults to 10 seconds.
+	StopTimeout time.Duration
+	// Pull policy and registry credentials. Cached images are used if nil.
+	Pull *ImagePull
+	// Network policy of the executor. The host network is used, unless
+	// it's "none".
+	Network string
+	Command
+	procGroup
+}
+
+// Run pulls the image, if needed, and runs the command. It blocks until the
+// command exits.
+func (a *ApptainerCommand) Run(pctx context.Context) error {
+	ctx, cancel := context.WithCancel(pctx)
+	defer cancel()
+	if err := a.begin(cancel); err != nil {
+		return err
+	}
+
+	driverCmd, err := a.driverCommand()
+	if err != nil {
+		return err
+	}
+
+	image, err := a.pullImage(ctx, driverCmd)
+	if err != nil {
+		a.Event.Error("failed to pull image", "image", a.Image, "error", err)
+		return &ImagePullError{a.Image, err}
+	}
+
+	args, err := a.execArgs(image)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(driverCmd[0], append(driverCmd[1:], args...)...)
+	cmd.Env = append(os.Environ(), a.envVars()...)
+	cmd.Stdin = a.Stdin
+	cmd.Stdout = a.Stdout
+	cmd.Stderr = a.Stderr
+
+	recordProvenance(a.Event, &provenance.Executor{
+		Runtime:        filepath.Base(driverCmd[0]),
+		RuntimeVersion: a.runtimeVersion(ctx, driverCmd),
+		Image:          a.Image,
+		ImageID:        sifDigest(image, a.imageURI() != ""),
+		CommandLine:    commandLine(cmd.Args),
+	})
+	a.Event.Info("Running command", "cmd", cmd.String())
+	return a.run(cmd)
+}
+
+// driverCommand returns the driver command and its leading arguments.
+func (a *ApptainerCommand) driverCommand() ([]string, error) {
+	driverCmd := strings.Fields(a.DriverCommand)
+	if len(driverCmd) == 0 {
+		return nil, fmt.Errorf("invalid apptainer driver command %q", a.DriverCommand)
+	}
+	return driverCmd, nil
+}
+
+// runtimeVersion returns the version of apptainer, e.g. "1.3.4", or "" if
+// it's unknown.
+func (a *ApptainerCommand) runtimeVersion(ctx context.Context, driverCmd []string) string {
+	out, err := exec.CommandContext(ctx, driverCmd[0], append(driverCmd[1:], "--version")...).Output()
+	if err != nil {
+		return ""
+	}
+	// e.g. "apptainer version 1.3.4"
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[len(fields)-1]
+}
+
+// sifDigest returns the digest of a SIF image, e.g. "sha256:...", or "" if
+// it can't be read. The digest of cached images is stored next to them, in a
+// ".sha256" file, so that large images are hashed once.
+func sifDigest(path string, cached bool) string {
+	sumFile := path + ".sha256"
+	if sum, err := os.ReadFile(sumFile); cached && err == nil && len(sum) == sha256.Size*2 {
+		if info, err := os.Stat(sumFile); err == nil && !info.ModTime().Before(modTime(path)) {
+			return "sha256:" + string(sum)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return ""
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if !cached {
+		return "sha256:" + sum
+	}
+	// Best effort: the image directory may be read-only.
+	if tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(sumFile)+".*.tmp"); err == nil {
+		_, err = tmp.WriteString(sum)
+		tmp.Close()
+		if err == nil {
+			err = os.Rename(tmp.Name(), sumFile)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}
+	return "sha256:" + sum
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// Stop sends SIGTERM to the executor processes, including those started by
+// the apptainer starter, and SIGKILL if they haven't exited after
+// StopTimeout. A pull in progress is canceled.
+func (a *ApptainerCommand) Stop() error {
+	return a.stop(a.Event, a.Name, a.StopTimeout)
+}
+
+// execArgs returns the arguments of "apptainer exec".
+func (a *ApptainerCommand) execArgs(image string) ([]string, error) {
+	args := []string{"exec"}
+	if a.CleanEnv {
+		args = append(args, "--cleanenv")
+	}
+
+	// Binds are applied in order: parent directories must be bound before
+	// the files and directories mounted inside of them.
+	vols := append([]Volume(nil), a.Volumes...)
+	sort.SliceStable(vols, func(i, j int) bool {
+		return len(filepath.Clean(vols[i].ContainerPath)) < len(filepath.Clean(vols[j].ContainerPath))
+	})
+	for _, v := range vols {
+		if strings.ContainsAny(v.HostPath+v.ContainerPath, ":,") {
+			return nil, fmt.Errorf("apptainer can't bind paths containing ':' or ',': %s", v.ContainerPath)
+		}
+		args = append(args, "--bind", formatVolumeArg(v))
+	}
+
+	if a.Workdir != "" {
+		args = append(args, "--pwd", a.Workdir)
+	}
+	// Only a loopback interface, available to unprivileged users.
+	if a.Network == config.NetworkNone {
+		args = append(args, "--net", "--network", "none")
+	}
+	args = append(args, a.ExtraArgs...)
+	args = append(args, image)
+
+	command := a.ShellCommand
+	if len(command) == 1 {
+		command = []string{"/bin/sh", "-c", command[0]}
+	}
+	return append(args, command...), nil
+}
+
+// envVars returns the task environment variables, passed with the
+// APPTAINERENV_ prefix, which is honored even with --cleanenv. Unlike the
+// --env flag, values may contain commas.
+func (a *ApptainerCommand) envVars() []string {
+	prefix := strings.TrimSuffix(a.envPrefix(), "_") + "ENV_"
+	var env []string
+	for k, v := range a.Env {
+		env = append(env, prefix+k+"="+v)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// envPrefix returns the prefix of the environment variables of the driver,
+// "APPTAINER" or "SINGULARITY".
+func (a *ApptainerCommand) envPrefix() string {
+	if driverCmd := strings.Fields(a.DriverCommand); len(driverCmd) > 0 &&
+		filepath.Base(driverCmd[len(driverCmd)-1]) == "singularity" {
+		return "SINGULARITY_"
+	}
+	return "APPTAINER_"
+}
+
+// imageURI returns the URI of the image to pull, or "" for local SIF files.
+func (a *ApptainerCommand) imageURI() string {
+	switch {
+	case strings.Contains(a.Image, "://"):
+		return a.Image
+	case filepath.IsAbs(a.Image) && strings.HasSuffix(a.Image, ".sif"):
+		return ""
+	default:
+		return "docker://" + a.Image
+	}
+}
+
+var unsafeImageChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// imagePath returns the path of the cached SIF image of the given URI.
+func (a *ApptainerCommand) imagePath(uri string) string {
+	name := unsafeImageChars.ReplaceAllString(strings.SplitN(uri, "://", 2)[1], "_")
+	sum := sha256.Sum256([]byte(uri))
+	return filepath.Join(a.ImageDir, fmt.Sprintf("%s-%x.sif", name, sum[:6]))
+}
+
+// pullImage converts the image to a SIF file in the image directory, unless
+// it's already cached and the pull policy isn't Always, and returns its path.
+// Concurrent pulls of the same image, e.g. by other workers on the node, wait
+// for the first one.
+func (a *ApptainerCommand) pullImage(ctx context.Context, driverCmd []string) (string, error) {
+	uri := a.imageURI()
+	if uri == "" {
+		return a.Image, nil
+	}
+	policy := PullIfNotPresent
+	if a.Pull != nil && a.Pull.Policy != "" {
+		policy = a.Pull.Policy
+	}
+	sif := a.imagePath(uri)
+	_, err := os.Stat(sif)
+	switch {
+	case err == nil && policy != PullAlways:
+		return sif, nil
+	case err != nil && policy == PullNever:
+		return "", fmt.Errorf("image is not cached and the pull policy is %s", PullNever)
+	}
+	cached := err == nil
+
+	if err := os.MkdirAll(a.ImageDir, 0775); err != nil {
+		return "", fmt.Errorf("creating image directory: %v", err)
+	}
+	unlock, err := lockFile(ctx, sif+".lock")
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	// Another task may have pulled the image while waiting for the lock.
+	if _, err := os.Stat(sif); err == nil && !cached {
+		return sif, nil
+	}
+
+	// Pull to a temporary file, so that an interrupted pull isn't mistaken
+	// for a cached image.
+	tmp, err := os.CreateTemp(a.ImageDir, filepath.Base(sif)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	a.Event.Info("Pulling image", "image", uri, "path", sif)
+	args := append(driverCmd[1:], "pull", "--force", tmp.Name(), uri)
+	cmd := exec.CommandContext(ctx, driverCmd[0], args...)
+	if strings.HasPrefix(uri, "docker://") {
+		username, password, err := a.Pull.credentials(imageRegistry(uri))
+		if err != nil {
+			return "", err
+		}
+		if username != "" {
+			prefix := a.envPrefix()
+			cmd.Env = append(os.Environ(), prefix+"DOCKER_USERNAME="+username, prefix+"DOCKER_PASSWORD="+password)
+		}
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	if err := os.Rename(tmp.Name(), sif); err != nil {
+		return "", err
+	}
+	return sif, nil
+}
+
+// lockFile takes an exclusive lock on the given file, waiting until it's
+// available or the context is canceled.
+func lockFile(ctx context.Context, path string) (func(), error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0664)
+	if err != nil {
+		return nil, fmt.Errorf("opening lock file: %v", err)
+	}
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return func() {
+				syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+				f.Close()
+			}, nil
+		}
+		if err != syscall.EWOULDBLOCK {
+			f.Close()
+			return nil, fmt.Errorf("locking %s: %v", path, err)
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/events"
+	"github.com/ohsu-comp-bio/funnel/logger"
+)
+
+// fakeApptainer is a driver which records pulls, and runs the command given
+// after the image on exec.
+const fakeApptainer = `#!/bin/sh
+if [ "$1" = pull ]; then
+  echo "$4" >> "$(dirname "$0")/pulls"
+  sleep 1
+  echo sif > "$3"
+  exit 0
+fi
+for arg; do
+  shift
+  case "$arg" in *.sif) break;; esac
+done
+exec "$@"
+`
+
+func newApptainerTest(t *testing.T, dir string, shellCommand ...string) *ApptainerCommand {
+	driver := filepath.Join(dir, "apptainer")
+	if _, err := os.Stat(driver); err != nil {
+		if err := os.WriteFile(driver, []byte(fakeApptainer), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &ApptainerCommand{
+		Name:          "task-0",
+		DriverCommand: driver,
+		ImageDir:      filepath.Join(dir, "images"),
+		CleanEnv:      true,
+		StopTimeout:   time.Second,
+		Command: Command{
+			Image:        "alpine:3.20",
+			ShellCommand: shellCommand,
+			Event: events.NewExecutorWriter("task", 0, 0, &events.Logger{
+				Log: logger.NewLogger("test", logger.DebugConfig()),
+			}),
+		},
+	}
+}
+
+func TestApptainerExecArgs(t *testing.T) {
+	a := newApptainerTest(t, t.TempDir(), "echo hello")
+	a.DriverCommand = "/usr/bin/singularity"
+	a.Workdir = "/data"
+	a.ExtraArgs = []string{"--nv"}
+	a.Env = map[string]string{"B": "1,2", "A": "x"}
+	a.Volumes = []Volume{
+		{HostPath: "/work/inputs/data/in.txt", ContainerPath: "/data/in.txt", Readonly: true},
+		{HostPath: "/work/data", ContainerPath: "/data"},
+	}
+
+	args, err := a.execArgs("/images/alpine.sif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"exec", "--cleanenv",
+		"--bind", "/work/data:/data:rw",
+		"--bind", "/work/inputs/data/in.txt:/data/in.txt:ro",
+		"--pwd", "/data", "--nv",
+		"/images/alpine.sif", "/bin/sh", "-c", "echo hello",
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("unexpected args:\n%v\nexpected:\n%v", args, expected)
+	}
+
+	// No network.
+	a.Network = config.NetworkNone
+	args, _ = a.execArgs("/images/alpine.sif")
+	if !reflect.DeepEqual(args[7:11], []string{"/data", "--net", "--network", "none"}) {
+		t.Errorf("expected no network, got %v", args)
+	}
+
+	env := a.envVars()
+	if !reflect.DeepEqual(env, []string{"SINGULARITYENV_A=x", "SINGULARITYENV_B=1,2"}) {
+		t.Errorf("unexpected env: %v", env)
+	}
+
+	a.Volumes = []Volume{{HostPath: "/work/a,b", ContainerPath: "/a,b"}}
+	if _, err := a.execArgs("/images/alpine.sif"); err == nil {
+		t.Error("expected an error for a volume containing a comma")
+	}
+}
+
+func TestApptainerImages(t *testing.T) {
+	a := newApptainerTest(t, t.TempDir())
+	for image, uri := range map[string]string{
+		"alpine:3.20": "docker://alpine:3.20",
+		"docker://quay.io/biocontainers/samtools:1.9": "docker://quay.io/biocontainers/samtools:1.9",
+		"oras://ghcr.io/org/tool:1":                   "oras://ghcr.io/org/tool:1",
+		"/shared/images/tool.sif":                     "",
+	} {
+		a.Image = image
+		if got := a.imageURI(); got != uri {
+			t.Errorf("imageURI(%s): expected %q, got %q", image, uri, got)
+		}
+	}
+
+	p := a.imagePath("docker://alpine:3.20")
+	if filepath.Dir(p) != a.ImageDir || !strings.HasPrefix(filepath.Base(p), "alpine_3.20-") {
+		t.Errorf("unexpected image path: %s", p)
+	}
+	if p == a.imagePath("docker://alpine/3.20") {
+		t.Error("expected distinct paths for distinct images")
+	}
+}
+
+func TestApptainerRun(t *testing.T) {
+	dir := t.TempDir()
+
+	// Concurrent tasks pull the image once.
+	var wg sync.WaitGroup
+	outs := make([]bytes.Buffer, 3)
+	for i := range outs {
+		a := newApptainerTest(t, dir, "sh", "-c", "echo $0", "hello")
+		a.Stdout = &outs[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := a.Run(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := range outs {
+		if got := outs[i].String(); got != "hello\n" {
+			t.Errorf("unexpected stdout: %q", got)
+		}
+	}
+	pulls, _ := os.ReadFile(filepath.Join(dir, "pulls"))
+	if got := string(pulls); got != "docker://alpine:3.20\n" {
+		t.Errorf("expected a single pull, got %q", got)
+	}
+
+	// A blank driver command is an error.
+	a := newApptainerTest(t, dir, "true")
+	a.DriverCommand = " "
+	if err := a.Run(context.Background()); err == nil {
+		t.Error("expected an error for a blank driver command")
+	}
+}
+
+func TestApptainerStop(t *testing.T) {
+	dir := t.TempDir()
+	started := filepath.Join(dir, "started")
+	a := newApptainerTest(t, dir, "sh", "-c", "trap '' TERM; touch "+started+"; sleep 30")
+
+	done := make(chan error, 1)
+	go func() {
+		done <- a.Run(context.Background())
+	}()
+
+	// Wait for the executor to start.
+	for i := 0; ; i++ {
+		if _, err := os.Stat(started); err == nil {
+			break
+		}
+		if i == 100 {
+			t.Fatal("executor didn't start")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	start := time.Now()
+	if err := a.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected an error from a stopped executor")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("executor wasn't killed")
+	}
+	if d := time.Since(start); d < a.StopTimeout {
+		t.Errorf("expected SIGTERM to be ignored for %s, got %s", a.StopTimeout, d)
+	}
+
+	// Stopped executors aren't restarted.
+	if err := a.Run(context.Background()); err != errExecutorStopped {
+		t.Errorf("expected %v, got %v", errExecutorStopped, err)
+	}
+}
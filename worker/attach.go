@@ -0,0 +1,593 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/attach"
+	"github.com/ohsu-comp-bio/funnel/events"
+)
+
+// Execer is implemented by the task commands which can run a command in the
+// container of a running executor, for "funnel task exec".
+type Execer interface {
+	Exec(ctx context.Context, command []string, streams ExecStreams) error
+}
+
+// Attacher is implemented by the task commands whose output isn't written
+// as it's produced, e.g. the kubernetes executor, to stream the live output
+// of the running executor for "funnel task attach".
+type Attacher interface {
+	Attach(ctx context.Context, stdout, stderr io.Writer) error
+}
+
+// ExecStreams are the standard streams of a command run by an Execer.
+type ExecStreams struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	// Nil if TTY is set: the stderr of the command is merged in its stdout.
+	Stderr io.Writer
+	// Allocates a terminal for the command.
+	TTY bool
+	// Changes of the terminal size, if TTY is set.
+	Resize <-chan TerminalSize
+}
+
+// TerminalSize is the size of the terminal of an exec session.
+type TerminalSize struct {
+	Width  uint16
+	Height uint16
+}
+
+// Time between the attempts to connect to the server.
+const attachRetryInterval = 10 * time.Second
+
+// Maximum size of the output in a frame.
+const maxFrameData = 32 * 1024
+
+// attachHub tracks the executors of a task for the attach and exec sessions
+// forwarded by the server: the command of the running executor, and the
+// clients attached to the output of the executors.
+type attachHub struct {
+	// Disables the exec sessions.
+	DisableExec bool
+	// Redacts the secrets of the task from the streamed output.
+	Redact *events.RedactWriter
+	// Logs the exec sessions in the task system logs.
+	Event *events.TaskWriter
+
+	mtx     sync.Mutex
+	count   int
+	running int
+	cmds    map[int]TaskCommand
+	// Results of the executors which ended.
+	results map[int]error
+	subs    map[int]map[*attachSub]bool
+	// Closed and replaced when an executor starts or ends.
+	changed chan struct{}
+}
+
+// attachSub is a client attached to the output of an executor.
+type attachSub struct {
+	out chan outputChunk
+	// Set when output was dropped, because the client is too slow.
+	dropped bool
+}
+
+type outputChunk struct {
+	data   []byte
+	stderr bool
+}
+
+func newAttachHub(executors int) *attachHub {
+	return &attachHub{
+		count:   executors,
+		running: -1,
+		cmds:    map[int]TaskCommand{},
+		results: map[int]error{},
+		subs:    map[int]map[*attachSub]bool{},
+		changed: make(chan struct{}),
+	}
+}
+
+// start records the command of the executor which starts.
+func (h *attachHub) start(i int, cmd TaskCommand) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.running = i
+	h.cmds[i] = cmd
+	h.notify()
+}
+
+// end records the result of the executor, and ends the attach sessions.
+func (h *attachHub) end(i int, result error) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if h.running == i {
+		h.running = -1
+	}
+	delete(h.cmds, i)
+	h.results[i] = result
+	for sub := range h.subs[i] {
+		close(sub.out)
+	}
+	delete(h.subs, i)
+	h.notify()
+}
+
+func (h *attachHub) notify() {
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// writers returns the stdout/stderr writers of the executor, which copy the
+// output to the attached clients.
+func (h *attachHub) writers(i int, stdout, stderr io.Writer) (io.Writer, io.Writer) {
+	return &hubWriter{h, i, false, stdout}, &hubWriter{h, i, true, stderr}
+}
+
+type hubWriter struct {
+	hub    *attachHub
+	index  int
+	stderr bool
+	w      io.Writer
+}
+
+func (w *hubWriter) Write(p []byte) (int, error) {
+	w.hub.publish(w.index, w.stderr, p)
+	if w.w == nil {
+		return len(p), nil
+	}
+	return w.w.Write(p)
+}
+
+// publish copies the output to the attached clients. The output is dropped
+// for the clients which are too slow, so that they never block the executor.
+func (h *attachHub) publish(i int, stderr bool, p []byte) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if len(h.subs[i]) == 0 {
+		return
+	}
+	chunk := outputChunk{data: append([]byte(nil), p...), stderr: stderr}
+	for sub := range h.subs[i] {
+		select {
+		case sub.out <- chunk:
+		default:
+			sub.dropped = true
+		}
+	}
+}
+
+// command returns the command of the executor, waiting for it to start if
+// wait is set. A negative index selects the running executor.
+func (h *attachHub) command(ctx context.Context, index int, wait bool) (int, TaskCommand, error) {
+	if index >= h.count {
+		return 0, nil, fmt.Errorf("executor %d doesn't exist: the task has %d executors", index, h.count)
+	}
+	for {
+		h.mtx.Lock()
+		i := index
+		if i < 0 {
+			i = h.running
+		}
+		cmd := h.cmds[i]
+		_, ended := h.results[index]
+		finished := len(h.results) == h.count
+		changed := h.changed
+		h.mtx.Unlock()
+
+		switch {
+		case cmd != nil:
+			return i, cmd, nil
+		case ended:
+			return 0, nil, fmt.Errorf("executor %d has ended", index)
+		case finished:
+			return 0, nil, errors.New("the executors have ended")
+		case !wait && index < 0:
+			return 0, nil, errors.New("no executor is running")
+		case !wait:
+			return 0, nil, fmt.Errorf("executor %d isn't running", index)
+		}
+		select {
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// attach streams the output of the executor until it ends, and returns its
+// exit status.
+func (h *attachHub) attach(ctx context.Context, index int, stdout, stderr io.Writer) *attach.Exit {
+	i, cmd, err := h.command(ctx, index, true)
+	if err != nil {
+		return &attach.Exit{ExitCode: -1, Error: err.Error()}
+	}
+	stdout, stderr = h.redacted(stdout), h.redacted(stderr)
+
+	if a, ok := cmd.(Attacher); ok {
+		if err := a.Attach(ctx, stdout, stderr); err != nil && ctx.Err() == nil {
+			return &attach.Exit{ExitCode: -1, Error: err.Error()}
+		}
+		return h.result(ctx, i)
+	}
+
+	sub := &attachSub{out: make(chan outputChunk, 256)}
+	h.mtx.Lock()
+	if _, ended := h.results[i]; ended {
+		h.mtx.Unlock()
+		return h.result(ctx, i)
+	}
+	if h.subs[i] == nil {
+		h.subs[i] = map[*attachSub]bool{}
+	}
+	h.subs[i][sub] = true
+	h.mtx.Unlock()
+	defer func() {
+		h.mtx.Lock()
+		delete(h.subs[i], sub)
+		h.mtx.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return &attach.Exit{ExitCode: -1, Error: ctx.Err().Error()}
+		case chunk, ok := <-sub.out:
+			if !ok {
+				return h.result(ctx, i)
+			}
+			w := stdout
+			if chunk.stderr {
+				w = stderr
+			}
+			if _, err := w.Write(chunk.data); err != nil {
+				return &attach.Exit{ExitCode: -1, Error: err.Error()}
+			}
+			h.mtx.Lock()
+			dropped := sub.dropped
+			sub.dropped = false
+			h.mtx.Unlock()
+			if dropped {
+				fmt.Fprintln(stderr, "\n[funnel: output dropped, the connection is too slow]")
+			}
+		}
+	}
+}
+
+// result waits for the end of the executor, and returns its exit status.
+func (h *attachHub) result(ctx context.Context, i int) *attach.Exit {
+	for {
+		h.mtx.Lock()
+		result, ended := h.results[i]
+		changed := h.changed
+		h.mtx.Unlock()
+		if ended {
+			return exitStatus(result)
+		}
+		select {
+		case <-ctx.Done():
+			return &attach.Exit{ExitCode: -1, Error: ctx.Err().Error()}
+		case <-changed:
+		}
+	}
+}
+
+// exec runs the command of the request in the running executor.
+func (h *attachHub) exec(ctx context.Context, req *attach.ExecRequest, streams ExecStreams) *attach.Exit {
+	if h.DisableExec {
+		return &attach.Exit{ExitCode: -1, Error: "exec is disabled on the worker by Worker.DisableExec"}
+	}
+	i, cmd, err := h.command(ctx, int(req.Executor), false)
+	if err != nil {
+		return &attach.Exit{ExitCode: -1, Error: err.Error()}
+	}
+	execer, ok := cmd.(Execer)
+	if !ok {
+		return &attach.Exit{ExitCode: -1, Error: fmt.Sprintf("the %T executor doesn't support exec", cmd)}
+	}
+	if h.Event != nil {
+		h.Event.Info("Exec session", "executor", i, "command", commandLine(req.Command), "tty", req.Tty)
+	}
+	if streams.TTY {
+		streams.Stderr = nil
+	}
+	return exitStatus(execer.Exec(ctx, req.Command, streams))
+}
+
+// runExec runs the command of an exec session, e.g. "docker exec", with
+// the streams of the session. With a terminal, the command runs in a
+// pseudo-terminal allocated by the worker.
+func runExec(ctx context.Context, cmd *exec.Cmd, streams ExecStreams) error {
+	if !streams.TTY {
+		cmd.Stdout, cmd.Stderr = streams.Stdout, streams.Stderr
+		// The command may exit before the end of the stdin: don't wait for it.
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return err
+		}
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		go func() {
+			io.Copy(stdin, streams.Stdin)
+			stdin.Close()
+		}()
+		return cmd.Wait()
+	}
+
+	master, slave, err := openPty()
+	if err != nil {
+		return err
+	}
+	defer master.Close()
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+	err = cmd.Start()
+	slave.Close()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case size := <-streams.Resize:
+				setPtySize(master, size)
+			}
+		}
+	}()
+	go func() {
+		io.Copy(master, streams.Stdin)
+		// End of the input of the terminal.
+		master.Write([]byte{4})
+	}()
+	output := make(chan struct{})
+	go func() {
+		io.Copy(streams.Stdout, master)
+		close(output)
+	}()
+
+	err = cmd.Wait()
+	select {
+	case <-output:
+	case <-time.After(time.Second):
+	}
+	return err
+}
+
+// redacted returns a writer which redacts the secrets of the task.
+func (h *attachHub) redacted(w io.Writer) io.Writer {
+	if h.Redact == nil {
+		return w
+	}
+	return redactedWriter(h.Redact, w)
+}
+
+// redactedWriter returns a writer which redacts the secrets of redact.
+func redactedWriter(redact *events.RedactWriter, w io.Writer) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		_, err := io.WriteString(w, redact.RedactString(string(p)))
+		return len(p), err
+	})
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// exitStatus returns the exit status of an executor or exec session.
+func exitStatus(err error) *attach.Exit {
+	code, cerr := getExitCode(err)
+	var coder interface{ ExitStatus() int }
+	if cerr != nil && errors.As(err, &coder) {
+		code, cerr = coder.ExitStatus(), nil
+	}
+	exit := &attach.Exit{ExitCode: int32(code)}
+	if cerr != nil {
+		exit.Error = err.Error()
+	}
+	return exit
+}
+
+// serve connects to the server and serves the attach and exec sessions of
+// the task, until ctx is canceled. The connection is retried, e.g. when the
+// server restarts.
+func (h *attachHub) serve(ctx context.Context, client attach.AttachServiceClient, taskID string) {
+	for {
+		err := h.connect(ctx, client, taskID)
+		if ctx.Err() != nil {
+			return
+		}
+		if h.Event != nil {
+			h.Event.Debug("attach connection to the server failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(attachRetryInterval):
+		}
+	}
+}
+
+// attachConn is the stream of the worker to the server.
+type attachConn struct {
+	hub      *attachHub
+	stream   attach.AttachService_ConnectClient
+	sendMtx  sync.Mutex
+	mtx      sync.Mutex
+	sessions map[string]*workerSession
+}
+
+// workerSession is an attach or exec session served by the worker.
+type workerSession struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Stdin and terminal size of exec sessions.
+	stdin       chan []byte
+	stdinClosed bool
+	resize      chan TerminalSize
+}
+
+func (h *attachHub) connect(ctx context.Context, client attach.AttachServiceClient, taskID string) error {
+	// The sessions end with the stream.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := client.Connect(ctx)
+	if err != nil {
+		return err
+	}
+	c := &attachConn{hub: h, stream: stream, sessions: map[string]*workerSession{}}
+	if err := c.send(&attach.Frame{Data: &attach.Frame_TaskId{TaskId: taskID}}); err != nil {
+		return err
+	}
+	for {
+		f, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		c.handle(ctx, f)
+	}
+}
+
+// handle handles a frame received from the server.
+func (c *attachConn) handle(ctx context.Context, f *attach.Frame) {
+	c.mtx.Lock()
+	s := c.sessions[f.Session]
+	c.mtx.Unlock()
+
+	switch d := f.Data.(type) {
+	case *attach.Frame_Attach:
+		s := c.open(ctx, f.Session)
+		go func() {
+			stdout, stderr := c.writer(f.Session, false), c.writer(f.Session, true)
+			c.exit(f.Session, c.hub.attach(s.ctx, int(d.Attach.Executor), stdout, stderr))
+		}()
+
+	case *attach.Frame_Exec:
+		s := c.open(ctx, f.Session)
+		s.stdin = make(chan []byte, 256)
+		s.resize = make(chan TerminalSize, 1)
+		streams := ExecStreams{
+			Stdin:  &chanReader{ch: s.stdin, done: s.ctx.Done()},
+			Stdout: c.writer(f.Session, false),
+			Stderr: c.writer(f.Session, true),
+			TTY:    d.Exec.Tty,
+			Resize: s.resize,
+		}
+		go func() {
+			c.exit(f.Session, c.hub.exec(s.ctx, d.Exec, streams))
+		}()
+
+	case *attach.Frame_Stdin:
+		if s != nil && s.stdin != nil && !s.stdinClosed {
+			select {
+			case s.stdin <- d.Stdin:
+			case <-s.ctx.Done():
+			}
+		}
+
+	case *attach.Frame_CloseStdin:
+		if s != nil && s.stdin != nil && !s.stdinClosed {
+			s.stdinClosed = true
+			close(s.stdin)
+		}
+
+	case *attach.Frame_Resize:
+		if s != nil && s.resize != nil {
+			size := TerminalSize{Width: uint16(d.Resize.Width), Height: uint16(d.Resize.Height)}
+			// Only the last size matters.
+			select {
+			case <-s.resize:
+			default:
+			}
+			s.resize <- size
+		}
+
+	case *attach.Frame_Exit:
+		// The client left.
+		if s != nil {
+			s.cancel()
+		}
+	}
+}
+
+func (c *attachConn) open(ctx context.Context, id string) *workerSession {
+	s := &workerSession{}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	c.mtx.Lock()
+	c.sessions[id] = s
+	c.mtx.Unlock()
+	return s
+}
+
+// exit ends the session, sending its exit status to the server.
+func (c *attachConn) exit(id string, exit *attach.Exit) {
+	c.mtx.Lock()
+	s := c.sessions[id]
+	delete(c.sessions, id)
+	c.mtx.Unlock()
+	if s != nil {
+		s.cancel()
+	}
+	c.send(&attach.Frame{Session: id, Data: &attach.Frame_Exit{Exit: exit}})
+}
+
+func (c *attachConn) send(f *attach.Frame) error {
+	c.sendMtx.Lock()
+	defer c.sendMtx.Unlock()
+	return c.stream.Send(f)
+}
+
+// writer returns a writer of the stdout or stderr of the session.
+func (c *attachConn) writer(id string, stderr bool) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		for off := 0; off < len(p); off += maxFrameData {
+			data := append([]byte(nil), p[off:min(off+maxFrameData, len(p))]...)
+			f := &attach.Frame{Session: id, Data: &attach.Frame_Stdout{Stdout: data}}
+			if stderr {
+				f.Data = &attach.Frame_Stderr{Stderr: data}
+			}
+			if err := c.send(f); err != nil {
+				return off, err
+			}
+		}
+		return len(p), nil
+	})
+}
+
+// chanReader reads the stdin of an exec session, until the session ends.
+type chanReader struct {
+	ch   <-chan []byte
+	done <-chan struct{}
+	buf  []byte
+}
+
+func (r *chanReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		select {
+		case b, ok := <-r.ch:
+			if !ok {
+				return 0, io.EOF
+			}
+			r.buf = b
+		case <-r.done:
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,173 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/attach"
+	"github.com/ohsu-comp-bio/funnel/events"
+)
+
+// fakeCommand is a TaskCommand which records the exec sessions.
+type fakeCommand struct {
+	stdout, stderr io.Writer
+	execs          [][]string
+}
+
+func (c *fakeCommand) Run(context.Context) error { return nil }
+func (c *fakeCommand) Stop() error               { return nil }
+func (c *fakeCommand) GetStdout() io.Writer      { return c.stdout }
+func (c *fakeCommand) GetStderr() io.Writer      { return c.stderr }
+func (c *fakeCommand) SetStdout(w io.Writer)     { c.stdout = w }
+func (c *fakeCommand) SetStderr(w io.Writer)     { c.stderr = w }
+func (c *fakeCommand) SetStdin(io.Reader)        {}
+
+func (c *fakeCommand) Exec(ctx context.Context, command []string, streams ExecStreams) error {
+	c.execs = append(c.execs, command)
+	_, err := io.Copy(streams.Stdout, streams.Stdin)
+	return err
+}
+
+// lockedBuffer is a bytes.Buffer safe for concurrent use.
+type lockedBuffer struct {
+	mtx sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.buf.String()
+}
+
+type exitStatusErr int
+
+func (e exitStatusErr) Error() string   { return "exit status" }
+func (e exitStatusErr) ExitStatus() int { return int(e) }
+
+func TestAttachHub(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	redact := &events.RedactWriter{}
+	redact.Redact("hunter22")
+	hub := newAttachHub(2)
+	hub.Redact = redact
+
+	var stdout, stderr lockedBuffer
+	result := make(chan *attach.Exit)
+	go func() {
+		// Waits for an executor to start.
+		result <- hub.attach(ctx, -1, &stdout, &stderr)
+	}()
+
+	cmd := &fakeCommand{}
+	out, errw := hub.writers(0, nil, nil)
+	hub.start(0, cmd)
+
+	// Wait for the client to be attached.
+	for {
+		hub.mtx.Lock()
+		n := len(hub.subs[0])
+		hub.mtx.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	io.WriteString(out, "password: hunter22\n")
+	io.WriteString(errw, "warning\n")
+	hub.end(0, exitStatusErr(3))
+
+	exit := <-result
+	if exit.Error != "" || exit.ExitCode != 3 {
+		t.Errorf("unexpected exit: %v", exit)
+	}
+	if stdout.String() != "password: [REDACTED]\n" {
+		t.Errorf("unexpected stdout: %q", stdout.String())
+	}
+	if stderr.String() != "warning\n" {
+		t.Errorf("unexpected stderr: %q", stderr.String())
+	}
+
+	// The executor ended: there is nothing to attach to.
+	exit = hub.attach(ctx, 0, io.Discard, io.Discard)
+	if !strings.Contains(exit.Error, "ended") {
+		t.Errorf("unexpected exit: %v", exit)
+	}
+}
+
+func TestAttachHubCommand(t *testing.T) {
+	ctx := context.Background()
+	hub := newAttachHub(2)
+
+	if _, _, err := hub.command(ctx, 2, true); err == nil {
+		t.Error("expected an error for a missing executor")
+	}
+	if _, _, err := hub.command(ctx, -1, false); err == nil {
+		t.Error("expected an error when no executor is running")
+	}
+
+	cmd := &fakeCommand{}
+	hub.start(1, cmd)
+	i, c, err := hub.command(ctx, -1, false)
+	if err != nil || i != 1 || c != cmd {
+		t.Errorf("unexpected command: %d %v %v", i, c, err)
+	}
+	hub.end(1, nil)
+
+	if _, _, err := hub.command(ctx, 1, true); err == nil || !strings.Contains(err.Error(), "ended") {
+		t.Errorf("expected an error for an executor which ended, got %v", err)
+	}
+}
+
+func TestAttachHubExec(t *testing.T) {
+	ctx := context.Background()
+	hub := newAttachHub(1)
+	cmd := &fakeCommand{}
+	hub.start(0, cmd)
+
+	var stdout bytes.Buffer
+	req := &attach.ExecRequest{Executor: -1, Command: []string{"cat"}}
+	exit := hub.exec(ctx, req, ExecStreams{Stdin: strings.NewReader("hello"), Stdout: &stdout})
+	if exit.Error != "" || exit.ExitCode != 0 {
+		t.Errorf("unexpected exit: %v", exit)
+	}
+	if stdout.String() != "hello" || len(cmd.execs) != 1 {
+		t.Errorf("unexpected exec: %q %v", stdout.String(), cmd.execs)
+	}
+
+	hub.DisableExec = true
+	exit = hub.exec(ctx, req, ExecStreams{Stdin: strings.NewReader(""), Stdout: io.Discard})
+	if exit.Error == "" {
+		t.Error("expected an error with DisableExec")
+	}
+}
+
+func TestRunExec(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("sh", "-c", "cat; echo done >&2; exit 2")
+	err := runExec(context.Background(), cmd, ExecStreams{
+		Stdin:  strings.NewReader("hello\n"),
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	if exit := exitStatus(err); exit.ExitCode != 2 {
+		t.Errorf("unexpected exit: %v", exit)
+	}
+	if stdout.String() != "hello\n" || stderr.String() != "done\n" {
+		t.Errorf("unexpected output: %q %q", stdout.String(), stderr.String())
+	}
+}
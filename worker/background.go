@@ -0,0 +1,75 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ohsu-comp-bio/funnel/tes"
+)
+
+// BackgroundExecutorsTag lists the executors of a task which run in the
+// background, e.g. "0" or "0,2": services used by the following executors,
+// like a database, a license server or a metrics agent. Background executors
+// start before the following executors, and are stopped when the foreground
+// executors finish.
+const BackgroundExecutorsTag = "_BACKGROUND_EXECUTORS"
+
+// backgroundExecutors returns the indexes of the background executors of the
+// task.
+func backgroundExecutors(task *tes.Task) (map[int]bool, error) {
+	v := strings.TrimSpace(task.GetTags()[BackgroundExecutorsTag])
+	if v == "" {
+		return nil, nil
+	}
+	n := len(task.GetExecutors())
+	background := map[int]bool{}
+	for _, s := range strings.Split(v, ",") {
+		i, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || i < 0 || i >= n {
+			return nil, fmt.Errorf("invalid %s tag %q: expected the indexes of executors, e.g. \"0,2\"", BackgroundExecutorsTag, v)
+		}
+		background[i] = true
+	}
+	// A background executor would be stopped as soon as it starts.
+	if background[n-1] {
+		return nil, fmt.Errorf("invalid %s tag %q: the last executor can't run in the background", BackgroundExecutorsTag, v)
+	}
+	return background, nil
+}
+
+// backgroundStep is a background executor, running until it's stopped.
+type backgroundStep struct {
+	index       int
+	ignoreError bool
+	cancel      context.CancelFunc
+	done        chan error
+}
+
+// startBackground starts a background executor.
+func startBackground(ctx context.Context, i int, s *stepWorker, ignoreError bool) *backgroundStep {
+	ctx, cancel := context.WithCancel(ctx)
+	b := &backgroundStep{
+		index:       i,
+		ignoreError: ignoreError,
+		cancel:      cancel,
+		done:        make(chan error, 1),
+	}
+	go func() {
+		b.done <- s.Run(ctx)
+	}()
+	return b
+}
+
+// stop stops the background executor, and returns its error if it failed
+// before being stopped.
+func (b *backgroundStep) stop() error {
+	b.cancel()
+	err := <-b.done
+	if errors.Is(err, context.Canceled) || b.ignoreError {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/events"
+	"github.com/ohsu-comp-bio/funnel/logger"
+	"github.com/ohsu-comp-bio/funnel/tes"
+	v1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestBackgroundExecutors(t *testing.T) {
+	task := &tes.Task{Executors: []*tes.Executor{{}, {}, {}}}
+	if bg, err := backgroundExecutors(task); err != nil || len(bg) != 0 {
+		t.Errorf("expected no background executors, got %v %v", bg, err)
+	}
+
+	task.Tags = map[string]string{BackgroundExecutorsTag: "0, 1"}
+	bg, err := backgroundExecutors(task)
+	if err != nil || !bg[0] || !bg[1] || bg[2] {
+		t.Errorf("unexpected background executors: %v %v", bg, err)
+	}
+
+	for _, v := range []string{"2", "3", "-1", "db"} {
+		task.Tags[BackgroundExecutorsTag] = v
+		if _, err := backgroundExecutors(task); err == nil {
+			t.Errorf("expected an error for %q", v)
+		}
+	}
+}
+
+func newBackgroundStep(t *testing.T, shellCommand ...string) *stepWorker {
+	return &stepWorker{
+		Conf:    &config.Worker{},
+		Event:   events.NewExecutorWriter("task", 0, 0, &events.Logger{Log: logger.NewLogger("test", logger.DebugConfig())}),
+		Command: newProcessTest(t.TempDir(), shellCommand...),
+	}
+}
+
+func TestBackgroundStep(t *testing.T) {
+	ctx := context.Background()
+
+	// Stopped while running.
+	b := startBackground(ctx, 0, newBackgroundStep(t, "sleep 30"), false)
+	time.Sleep(100 * time.Millisecond)
+	done := make(chan error, 1)
+	go func() { done <- b.stop() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("background executor wasn't stopped")
+	}
+
+	// Failed before being stopped.
+	b = startBackground(ctx, 0, newBackgroundStep(t, "exit 3"), false)
+	time.Sleep(500 * time.Millisecond)
+	if code, _ := getExitCode(b.stop()); code != 3 {
+		t.Errorf("expected exit code 3, got %d", code)
+	}
+
+	b = startBackground(ctx, 0, newBackgroundStep(t, "exit 3"), true)
+	time.Sleep(500 * time.Millisecond)
+	if err := b.stop(); err != nil {
+		t.Errorf("expected the error to be ignored, got %v", err)
+	}
+}
+
+func TestKubernetesSidecars(t *testing.T) {
+	job := &v1.Job{}
+	mounts := []corev1.VolumeMount{{Name: "funnel-storage", MountPath: "/data"}}
+	job.Spec.Template.Spec.Containers = []corev1.Container{{Name: "executor", VolumeMounts: mounts}}
+	sc := &KubernetesSidecar{
+		Name: "executor-0",
+		Command: Command{
+			Image:        "postgres:16",
+			ShellCommand: []string{"postgres -c fsync=off"},
+			Env:          map[string]string{"POSTGRES_PASSWORD": "test", "PGDATA": "/data/pg"},
+		},
+	}
+	kcmd := &KubernetesCommand{Sidecars: []*KubernetesSidecar{sc}}
+	kcmd.applySidecars(job)
+
+	spec := job.Spec.Template.Spec
+	if len(spec.InitContainers) != 1 {
+		t.Fatalf("expected a sidecar, got %v", spec.InitContainers)
+	}
+	c := spec.InitContainers[0]
+	if c.Name != "executor-0" || c.Image != "postgres:16" || c.RestartPolicy == nil || *c.RestartPolicy != corev1.ContainerRestartPolicyAlways {
+		t.Errorf("unexpected sidecar: %v", c)
+	}
+	if len(c.Command) != 2 || c.Args[0] != "postgres -c fsync=off" {
+		t.Errorf("unexpected command: %v %v", c.Command, c.Args)
+	}
+	if len(c.Env) != 2 || c.Env[0].Name != "PGDATA" {
+		t.Errorf("unexpected env: %v", c.Env)
+	}
+	if len(c.VolumeMounts) != 1 || c.VolumeMounts[0].MountPath != "/data" {
+		t.Errorf("expected the volumes of the executor, got %v", c.VolumeMounts)
+	}
+
+	// The sidecar runs until it's stopped.
+	done := make(chan error, 1)
+	go func() { done <- sc.Run(context.Background()) }()
+	sc.Stop()
+	sc.Stop()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sidecar wasn't stopped")
+	}
+}
@@ -0,0 +1,169 @@
+package worker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ohsu-comp-bio/funnel/tes"
+	"google.golang.org/protobuf/proto"
+)
+
+// checkpoint records the progress of a task on the node: the downloaded
+// inputs and the exit codes of the completed executors. A new attempt of the
+// task, e.g. after a restart of the node, skips them if the work directory
+// of the task still exists. A nil checkpoint records nothing.
+type checkpoint struct {
+	path string
+	mtx  sync.Mutex
+	// Hash of the task, which must not change between attempts.
+	Fingerprint string `json:"fingerprint"`
+	// Size of the downloaded inputs, by host path.
+	Inputs map[string]int64 `json:"inputs"`
+	// Exit codes of the completed executors, by index.
+	Executors map[int]int `json:"executors"`
+}
+
+// checkpointPath returns the path of the checkpoint of a task, next to its
+// work directory, where executors can't change it.
+func checkpointPath(workDir string) string {
+	return filepath.Clean(workDir) + ".checkpoint.json"
+}
+
+// taskFingerprint returns a hash of the inputs, outputs, volumes and
+// executors of a task.
+func taskFingerprint(task *tes.Task) (string, error) {
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(&tes.Task{
+		Inputs:    task.GetInputs(),
+		Outputs:   task.GetOutputs(),
+		Volumes:   task.GetVolumes(),
+		Executors: task.GetExecutors(),
+	})
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// loadCheckpoint returns the checkpoint of the task at path. The progress of
+// the previous attempt is only kept if the work directory of the task still
+// exists, and the task didn't change.
+func loadCheckpoint(path, workDir, fingerprint string) *checkpoint {
+	cp := &checkpoint{
+		path:        path,
+		Fingerprint: fingerprint,
+		Inputs:      map[string]int64{},
+		Executors:   map[int]int{},
+	}
+	if _, err := os.Stat(workDir); err != nil {
+		return cp
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return cp
+	}
+	prev := &checkpoint{}
+	if json.Unmarshal(b, prev) != nil || prev.Fingerprint != fingerprint {
+		return cp
+	}
+	if prev.Inputs != nil {
+		cp.Inputs = prev.Inputs
+	}
+	if prev.Executors != nil {
+		cp.Executors = prev.Executors
+	}
+	return cp
+}
+
+// resumed returns whether a previous attempt of the task made progress.
+func (cp *checkpoint) resumed() bool {
+	if cp == nil {
+		return false
+	}
+	cp.mtx.Lock()
+	defer cp.mtx.Unlock()
+	return len(cp.Inputs) > 0 || len(cp.Executors) > 0
+}
+
+// downloaded returns whether the input at the host path was downloaded by a
+// previous attempt, and wasn't changed since.
+func (cp *checkpoint) downloaded(path string) bool {
+	if cp == nil {
+		return false
+	}
+	cp.mtx.Lock()
+	size, ok := cp.Inputs[path]
+	cp.mtx.Unlock()
+	if !ok {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular() && info.Size() == size
+}
+
+// addInput records a downloaded input.
+func (cp *checkpoint) addInput(path string) error {
+	if cp == nil {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	cp.mtx.Lock()
+	defer cp.mtx.Unlock()
+	cp.Inputs[path] = info.Size()
+	return cp.save()
+}
+
+// completed returns the exit code of an executor completed by a previous
+// attempt.
+func (cp *checkpoint) completed(i int) (int, bool) {
+	if cp == nil {
+		return 0, false
+	}
+	cp.mtx.Lock()
+	defer cp.mtx.Unlock()
+	code, ok := cp.Executors[i]
+	return code, ok
+}
+
+// addExecutor records the exit code of a completed executor.
+func (cp *checkpoint) addExecutor(i, code int) error {
+	if cp == nil {
+		return nil
+	}
+	cp.mtx.Lock()
+	defer cp.mtx.Unlock()
+	cp.Executors[i] = code
+	return cp.save()
+}
+
+// save writes the checkpoint, atomically: the worker may be killed at any
+// time.
+func (cp *checkpoint) save() error {
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := cp.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return fmt.Errorf("writing checkpoint: %v", err)
+	}
+	if err := os.Rename(tmp, cp.path); err != nil {
+		return fmt.Errorf("writing checkpoint: %v", err)
+	}
+	return nil
+}
+
+// remove removes the checkpoint.
+func (cp *checkpoint) remove() {
+	if cp != nil {
+		os.Remove(cp.path)
+	}
+}
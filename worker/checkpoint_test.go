@@ -0,0 +1,186 @@
+package worker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/events"
+	"github.com/ohsu-comp-bio/funnel/logger"
+	"github.com/ohsu-comp-bio/funnel/storage"
+	"github.com/ohsu-comp-bio/funnel/tes"
+)
+
+func TestCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	workDir := filepath.Join(dir, "task")
+	path := checkpointPath(workDir)
+
+	cp := loadCheckpoint(path, workDir, "abc")
+	if cp.resumed() {
+		t.Fatal("unexpected progress")
+	}
+
+	os.MkdirAll(workDir, 0755)
+	input := filepath.Join(workDir, "in.txt")
+	os.WriteFile(input, []byte("hello"), 0644)
+	if err := cp.addInput(input); err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.addExecutor(1, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	// The progress of the previous attempt.
+	cp = loadCheckpoint(path, workDir, "abc")
+	if !cp.resumed() || !cp.downloaded(input) {
+		t.Errorf("expected the downloaded input, got %v", cp.Inputs)
+	}
+	if code, ok := cp.completed(1); !ok || code != 3 {
+		t.Errorf("expected exit code 3, got %d %v", code, ok)
+	}
+	if _, ok := cp.completed(0); ok {
+		t.Error("unexpected completed executor")
+	}
+
+	// A changed input is downloaded again.
+	os.WriteFile(input, []byte("hello world"), 0644)
+	if cp.downloaded(input) {
+		t.Error("expected the changed input to be downloaded again")
+	}
+
+	// The task changed.
+	if loadCheckpoint(path, workDir, "def").resumed() {
+		t.Error("unexpected progress of another task")
+	}
+
+	// The work directory was removed.
+	os.RemoveAll(workDir)
+	if loadCheckpoint(path, workDir, "abc").resumed() {
+		t.Error("unexpected progress without the work directory")
+	}
+
+	// Nothing is recorded without checkpoint.
+	var none *checkpoint
+	if none.addExecutor(0, 0) != nil || none.downloaded(input) || none.resumed() {
+		t.Error("unexpected nil checkpoint behavior")
+	}
+}
+
+func TestWorkerResume(t *testing.T) {
+	dir := t.TempDir()
+	task := &tes.Task{
+		Id: "resume",
+		Executors: []*tes.Executor{
+			{Command: []string{"sh", "-c", "echo 0 >> /data/out.txt"}},
+			{Command: []string{"sh", "-c", "echo 1 >> /data/out.txt; test -f /data/ok"}},
+		},
+		Volumes: []string{"/data"},
+	}
+	out := filepath.Join(dir, "work", "resume", "data", "out.txt")
+	conf := config.DefaultConfig().Worker
+	conf.WorkDir = filepath.Join(dir, "work")
+	conf.LeaveWorkDir = true
+
+	run := func() error {
+		w := &DefaultWorker{
+			Executor:    Executor{Backend: "process"},
+			Conf:        conf,
+			Store:       &storage.Mux{},
+			TaskReader:  &Base64TaskReader{task: task},
+			EventWriter: &events.Logger{Log: logger.NewLogger("test", logger.DebugConfig())},
+		}
+		return w.Run(context.Background())
+	}
+
+	// The second executor fails, and is run again by the next attempt, but
+	// not the first one.
+	if err := run(); err == nil {
+		t.Fatal("expected an executor error")
+	}
+	os.WriteFile(filepath.Join(dir, "work", "resume", "data", "ok"), nil, 0644)
+	if err := run(); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(out)
+	if string(b) != "0\n1\n1\n" {
+		t.Errorf("unexpected executor runs: %q", b)
+	}
+
+	// Without checkpoints, all the executors run again.
+	conf.DisableCheckpoints = true
+	if err := run(); err != nil {
+		t.Fatal(err)
+	}
+	b, _ = os.ReadFile(out)
+	if string(b) != "0\n1\n1\n0\n1\n" {
+		t.Errorf("unexpected executor runs: %q", b)
+	}
+}
+
+// Tests that a task interrupted by the shutdown of its node, e.g. to
+// restart, keeps its work directory and resumes from its checkpoint.
+func TestWorkerInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	task := &tes.Task{
+		Id: "interrupted",
+		Executors: []*tes.Executor{
+			// The first executor fails if it runs again.
+			{Command: []string{"sh", "-c", "test ! -f /data/ok && echo 0 >> /data/out.txt"}},
+			{Command: []string{"sh", "-c", "echo 1 >> /data/out.txt; test -f /data/ok || sleep 60"}},
+		},
+		Volumes: []string{"/data"},
+	}
+	workDir := filepath.Join(dir, "work", "interrupted")
+	out := filepath.Join(workDir, "data", "out.txt")
+	conf := config.DefaultConfig().Worker
+	conf.WorkDir = filepath.Join(dir, "work")
+
+	run := func(ctx context.Context, w events.Writer) error {
+		r := &DefaultWorker{
+			Executor:    Executor{Backend: "process"},
+			Conf:        conf,
+			Store:       &storage.Mux{},
+			TaskReader:  &Base64TaskReader{task: task},
+			EventWriter: w,
+			Resumable:   true,
+		}
+		return r.Run(ctx)
+	}
+
+	// The node stops while the second executor runs.
+	ctx, stop := context.WithCancel(context.Background())
+	go func() {
+		for {
+			if b, _ := os.ReadFile(out); string(b) == "0\n1\n" {
+				stop()
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	w := &captureWriter{}
+	if err := run(ctx, w); err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, ev := range w.events {
+		if ev.Type == events.Type_TASK_STATE && tes.TerminalState(ev.GetState()) {
+			t.Errorf("unexpected final state: %s", ev.GetState())
+		}
+	}
+	if _, err := os.Stat(checkpointPath(workDir)); err != nil {
+		t.Fatal("expected the checkpoint to be kept", err)
+	}
+
+	// The task resumes when the node restarts.
+	os.WriteFile(filepath.Join(workDir, "data", "ok"), nil, 0644)
+	if err := run(context.Background(), &captureWriter{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(workDir); !os.IsNotExist(err) {
+		t.Error("expected the work directory to be removed", err)
+	}
+}
@@ -16,6 +16,9 @@ type Command struct {
 	Stdout       io.Writer
 	Stderr       io.Writer
 	Event        *events.ExecutorWriter
+	// Keys of Env whose values are secrets, which are never written on a
+	// command line or in a Kubernetes spec.
+	SecretEnv map[string]bool
 	TaskCommand
 }
 
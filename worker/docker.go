@@ -9,12 +9,16 @@ import (
 	"math"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"text/template"
 	"time"
 
 	"github.com/google/shlex"
 	"github.com/ohsu-comp-bio/funnel/events"
+	"github.com/ohsu-comp-bio/funnel/provenance"
 	tes "github.com/ohsu-comp-bio/funnel/tes"
 )
 
@@ -33,19 +37,40 @@ type DockerCommand struct {
 	EnableTags      bool
 	Tags            map[string]string
 	Resources       *tes.Resources
+	EnforceLimits   bool
+	// Pull policy and registry credentials. Images are always pulled if nil.
+	Pull *ImagePull
+	// Network of the container, from the network policy of the task, and
+	// its alias in the network of the task, if it has background executors.
+	Network      string
+	NetworkAlias string
 	Command
 }
 
 // MemoryMB returns the task's requested RAM as an integer megabyte value
 // suitable for passing to nerdctl/docker --memory flag (e.g. "2048m").
-// Returns 0 if no memory limit is set.
+// Returns 0 if no memory limit is set, or if limits are not enforced.
 func (docker DockerCommand) MemoryMB() int64 {
-	if docker.Resources == nil || docker.Resources.RamGb <= 0 {
+	if !docker.EnforceLimits || docker.Resources == nil || docker.Resources.RamGb <= 0 {
 		return 0
 	}
 	return int64(math.Round(docker.Resources.RamGb * 1024))
 }
 
+// CpuLimit returns the task's requested CPU cores suitable for passing to
+// the nerdctl/docker --cpus flag, capped at the number of CPUs on the host
+// since docker refuses limits larger than that.
+// Returns 0 if no CPU limit is set, or if limits are not enforced.
+func (docker DockerCommand) CpuLimit() int32 {
+	if !docker.EnforceLimits || docker.Resources == nil || docker.Resources.CpuCores <= 0 {
+		return 0
+	}
+	if n := int32(runtime.NumCPU()); docker.Resources.CpuCores > n {
+		return n
+	}
+	return docker.Resources.CpuCores
+}
+
 type DockerVersion struct {
 	Client string
 	Server string
@@ -59,12 +84,23 @@ func (docker DockerCommand) Run(ctx context.Context) error {
 		docker.Event.Error("failed to sync docker client API version", err)
 	}
 
-	err = docker.executeCommand(ctx, docker.PullCommand, false)
+	err = docker.pullImage(ctx)
 	if err != nil {
-		docker.Event.Error("failed to pull docker image using command", err, docker.PullCommand)
+		docker.Event.Error("failed to pull docker image", "image", docker.Image, "error", err)
+		return err
 	}
 
-	err = docker.executeCommand(ctx, docker.RunCommand, true)
+	cmd, err := docker.command(ctx, docker.RunCommand, true)
+	if err == nil {
+		recordProvenance(docker.Event, &provenance.Executor{
+			Runtime:        filepath.Base(strings.Fields(docker.DriverCommand)[0]),
+			RuntimeVersion: docker.runtimeVersion(ctx),
+			ImageID:        docker.inspectImage(ctx, "{{.Id}}"),
+			CommandLine:    commandLine(cmd.Args),
+			Resources:      docker.effectiveResources(),
+		})
+		err = cmd.Run()
+	}
 	if err != nil {
 		docker.Event.Error("failed to run docker container", err)
 	}
@@ -72,6 +108,78 @@ func (docker DockerCommand) Run(ctx context.Context) error {
 	return err
 }
 
+// effectiveResources returns the resources of the task, and the limits of
+// the container if they're enforced.
+func (docker DockerCommand) effectiveResources() map[string]string {
+	res := requestedResources(docker.Resources)
+	if cpus := docker.CpuLimit(); cpus > 0 {
+		res["cpu_limit"] = strconv.Itoa(int(cpus))
+	}
+	if mb := docker.MemoryMB(); mb > 0 {
+		res["memory_limit"] = fmt.Sprintf("%dm", mb)
+	}
+	return res
+}
+
+// runtimeVersion returns the version of the container engine, or "" if it's
+// unknown.
+func (docker DockerCommand) runtimeVersion(ctx context.Context) string {
+	driverCmd := strings.Fields(docker.DriverCommand)
+	for _, format := range []string{"{{.Server.Version}}", "{{.Client.Version}}"} {
+		args := append(driverCmd[1:], "version", "--format", format)
+		out, err := exec.CommandContext(ctx, driverCmd[0], args...).Output()
+		if v := strings.TrimSpace(string(out)); err == nil && v != "" && v != "<no value>" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Exec runs a command in the running container, with "docker exec".
+func (docker DockerCommand) Exec(ctx context.Context, command []string, streams ExecStreams) error {
+	driverCmd := strings.Fields(docker.DriverCommand)
+	args := append(driverCmd[1:], "exec", "-i")
+	if streams.TTY {
+		args = append(args, "-t")
+	}
+	args = append(args, docker.Name)
+	args = append(args, command...)
+	return runExec(ctx, exec.CommandContext(ctx, driverCmd[0], args...), streams)
+}
+
+// createDockerNetwork creates the network shared by the executors of a task.
+// An internal network has no external connectivity.
+func createDockerNetwork(ctx context.Context, driverCommand, name string, internal bool) error {
+	driverCmd := strings.Fields(driverCommand)
+	args := append(driverCmd[1:], "network", "create")
+	if internal {
+		args = append(args, "--internal")
+	}
+	args = append(args, name)
+	out, err := exec.CommandContext(ctx, driverCmd[0], args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("creating docker network %s: %v: %s", name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// removeDockerContainers removes containers, if they exist, e.g. those left
+// running by a previous attempt of a task when the worker was killed.
+func removeDockerContainers(driverCommand string, names ...string) {
+	driverCmd := strings.Fields(driverCommand)
+	for _, name := range names {
+		args := append(driverCmd[1:], "rm", "-f", name)
+		exec.Command(driverCmd[0], args...).Run()
+	}
+}
+
+// removeDockerNetwork removes the network of a task.
+func removeDockerNetwork(driverCommand, name string) error {
+	driverCmd := strings.Fields(driverCommand)
+	args := append(driverCmd[1:], "network", "rm", name)
+	return exec.Command(driverCmd[0], args...).Run()
+}
+
 // Stop stops the container.
 func (docker DockerCommand) Stop() error {
 	docker.Event.Info("Stopping container", "container", docker.Name)
@@ -83,7 +191,88 @@ func (docker DockerCommand) Stop() error {
 	return nil
 }
 
+// pullImage pulls the image according to the pull policy, with the registry
+// credentials, and pins the image to its digest. Failures are returned as
+// an ImagePullError.
+func (docker *DockerCommand) pullImage(ctx context.Context) error {
+	policy := PullAlways
+	if docker.Pull != nil && docker.Pull.Policy != "" {
+		policy = docker.Pull.Policy
+	}
+
+	present := docker.inspectImage(ctx, "{{.Id}}") != ""
+	switch {
+	case policy == PullNever && !present:
+		return &ImagePullError{docker.Image, fmt.Errorf("image is not present and the pull policy is %s", PullNever)}
+	case policy == PullAlways || !present:
+		cmd, err := docker.command(ctx, docker.PullCommand, false)
+		if err != nil {
+			return &ImagePullError{docker.Image, err}
+		}
+		configDir, err := docker.Pull.dockerConfig()
+		if err != nil {
+			return &ImagePullError{docker.Image, err}
+		}
+		if configDir != "" {
+			defer os.RemoveAll(configDir)
+			cmd.Env = append(os.Environ(),
+				"DOCKER_CONFIG="+configDir,
+				"REGISTRY_AUTH_FILE="+filepath.Join(configDir, "config.json"),
+			)
+		}
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return &ImagePullError{docker.Image, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))}
+		}
+	}
+
+	// Run the image by digest, so that the executor runs the image which was
+	// pulled, even if the tag is updated meanwhile.
+	if digest := docker.imageDigest(ctx); digest != "" {
+		recordProvenance(docker.Event, &provenance.Executor{Image: docker.Image, ImageDigest: digest})
+		if !strings.Contains(docker.Image, "@") {
+			docker.Image = imageRepository(docker.Image) + "@" + digest
+		}
+	}
+	return nil
+}
+
+// imageDigest returns the registry digest of the image, e.g. "sha256:...",
+// or "" for images which weren't pulled from a registry.
+func (docker *DockerCommand) imageDigest(ctx context.Context) string {
+	if _, digest, ok := strings.Cut(docker.Image, "@"); ok {
+		return digest
+	}
+	repo := imageRepository(docker.Image)
+	for _, d := range strings.Fields(docker.inspectImage(ctx, `{{join .RepoDigests " "}}`)) {
+		if name, digest, ok := strings.Cut(d, "@"); ok && imageRepository(name) == repo {
+			return digest
+		}
+	}
+	return ""
+}
+
+// inspectImage returns the formatted output of "image inspect", or "" if the
+// image isn't present.
+func (docker *DockerCommand) inspectImage(ctx context.Context, format string) string {
+	driverCmd := strings.Fields(docker.DriverCommand)
+	args := append(driverCmd[1:], "image", "inspect", "--format", format, docker.Image)
+	out, err := exec.CommandContext(ctx, driverCmd[0], args...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
 func (docker DockerCommand) executeCommand(ctx context.Context, commandTemplate string, enableIO bool) error {
+	cmd, err := docker.command(ctx, commandTemplate, enableIO)
+	if err != nil {
+		return err
+	}
+	return cmd.Run()
+}
+
+// command returns the driver command of the given template.
+func (docker DockerCommand) command(ctx context.Context, commandTemplate string, enableIO bool) (*exec.Cmd, error) {
 	var usingCommand bool = false
 	if strings.Contains(commandTemplate, "{{.Command}}") {
 		usingCommand = true
@@ -92,18 +281,18 @@ func (docker DockerCommand) executeCommand(ctx context.Context, commandTemplate
 
 	tmpl, err := template.New("command").Parse(commandTemplate)
 	if err != nil {
-		return fmt.Errorf("failed to parse template for command: %w", err)
+		return nil, fmt.Errorf("failed to parse template for command: %w", err)
 	}
 
 	var cmdBuffer bytes.Buffer
 	err = tmpl.Execute(&cmdBuffer, docker)
 	if err != nil {
-		return fmt.Errorf("failed to execute template for command: %w", err)
+		return nil, fmt.Errorf("failed to execute template for command: %w", err)
 	}
 
 	cmdParts, err := shlex.Split(cmdBuffer.String())
 	if err != nil {
-		return fmt.Errorf("failed to parse command: %w", err)
+		return nil, fmt.Errorf("failed to parse command: %w", err)
 	}
 
 	if usingCommand {
@@ -127,6 +316,14 @@ func (docker DockerCommand) executeCommand(ctx context.Context, commandTemplate
 		cmd = exec.CommandContext(ctx, driverCmd[0], cmdParts...)
 	}
 
+	// The secret env values of the --env options (see formatEnvVars).
+	if len(docker.SecretEnv) > 0 {
+		cmd.Env = os.Environ()
+		for k := range docker.SecretEnv {
+			cmd.Env = append(cmd.Env, k+"="+docker.Env[k])
+		}
+	}
+
 	if enableIO {
 		if docker.Stdin != nil {
 			cmd.Stdin = docker.Stdin
@@ -142,7 +339,7 @@ func (docker DockerCommand) executeCommand(ctx context.Context, commandTemplate
 	if usingCommand {
 		docker.Event.Info("Running command", "cmd", cmd.String())
 	}
-	return cmd.Run()
+	return cmd, nil
 }
 
 func formatVolumeArg(v Volume) string {
@@ -154,6 +351,9 @@ func formatVolumeArg(v Volume) string {
 	return fmt.Sprintf("%s:%s:%s", v.HostPath, v.ContainerPath, mode)
 }
 
+// escapedQuote is a single quote in a single-quoted shell string.
+const escapedQuote = `'"'"'`
+
 // shellEscape safely escapes a string for use in a POSIX shell context.
 // It wraps the value in single quotes and correctly handles embedded single quotes
 // by closing the quote, inserting an escaped single quote, and reopening the quote.
@@ -166,7 +366,7 @@ func shellEscape(s string) string {
 	b.WriteByte('\'')
 	for _, r := range s {
 		if r == '\'' {
-			b.WriteString("'\"'\"'")
+			b.WriteString(escapedQuote)
 		} else {
 			b.WriteRune(r)
 		}
@@ -175,9 +375,16 @@ func shellEscape(s string) string {
 	return b.String()
 }
 
-func formatEnvVars(env map[string]string) []string {
+// formatEnvVars returns the --env options of the env. The secret values
+// aren't written on the command line, where any local user could read them:
+// the driver passes them from its own environment (see command).
+func formatEnvVars(env map[string]string, secret map[string]bool) []string {
 	var result []string
 	for k, v := range env {
+		if secret[k] {
+			result = append(result, fmt.Sprintf("--env %s", k))
+			continue
+		}
 		escapedValue := shellEscape(v)
 		result = append(result, fmt.Sprintf("--env %s=%s", k, escapedValue))
 	}
@@ -185,7 +392,7 @@ func formatEnvVars(env map[string]string) []string {
 }
 
 func (docker DockerCommand) GetEnvArgs() string {
-	return strings.Join(formatEnvVars(docker.Env), " ")
+	return strings.Join(formatEnvVars(docker.Env, docker.SecretEnv), " ")
 }
 
 func (docker DockerCommand) GetImage() string {
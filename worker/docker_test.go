@@ -2,6 +2,7 @@ package worker
 
 import (
 	"context"
+	"runtime"
 	"testing"
 	"time"
 
@@ -9,6 +10,7 @@ import (
 
 	"github.com/ohsu-comp-bio/funnel/events"
 	"github.com/ohsu-comp-bio/funnel/logger"
+	"github.com/ohsu-comp-bio/funnel/tes"
 )
 
 var command = Command{
@@ -87,6 +89,29 @@ func TestDockerGetImage(t *testing.T) {
 		t.Errorf("Expected %s, but got %s", expected, result)
 	}
 }
+
+func TestDockerResourceLimits(t *testing.T) {
+	d := DockerCommand{
+		Resources: &tes.Resources{CpuCores: 1, RamGb: 1.5},
+	}
+	if d.CpuLimit() != 0 || d.MemoryMB() != 0 {
+		t.Error("expected no limits when EnforceLimits is false")
+	}
+
+	d.EnforceLimits = true
+	if d.CpuLimit() != 1 {
+		t.Errorf("Expected 1 cpu, but got %d", d.CpuLimit())
+	}
+	if d.MemoryMB() != 1536 {
+		t.Errorf("Expected 1536 MB, but got %d", d.MemoryMB())
+	}
+
+	d.Resources.CpuCores = int32(runtime.NumCPU()) + 1
+	if d.CpuLimit() != int32(runtime.NumCPU()) {
+		t.Errorf("Expected cpu limit capped at %d, but got %d", runtime.NumCPU(), d.CpuLimit())
+	}
+}
+
 func TestDockerInspectContainer(t *testing.T) {
 	config := docker.InspectContainer(context.Background())
 	if config.Id == "" {
@@ -27,6 +27,7 @@ type FileMapper struct {
 	Outputs      []*tes.Output
 	WorkDir      string
 	ScratchDir   string
+	SecretFiles  []string // host paths of the secret files, see AddSecretFile
 }
 
 // Volume represents a volume mounted into a docker container.
@@ -0,0 +1,141 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ohsu-comp-bio/funnel/tes"
+	"google.golang.org/protobuf/proto"
+)
+
+// ExecutorGroupsTag sets the group of each executor of a task, e.g.
+// "0,1,1,2": the executors of a group run in parallel, sharing the inputs
+// and volumes of the task, and a group starts when the previous one
+// finished.
+const ExecutorGroupsTag = "_EXECUTOR_GROUPS"
+
+// executorGroups returns the group of each executor of the task, or nil if
+// the executors run in sequence. A group can't have more foreground executors
+// than the CPU cores requested by the task, which they share.
+func executorGroups(task *tes.Task, background map[int]bool) ([]int, error) {
+	v := strings.TrimSpace(task.GetTags()[ExecutorGroupsTag])
+	if v == "" {
+		return nil, nil
+	}
+	parts := strings.Split(v, ",")
+	if len(parts) != len(task.GetExecutors()) {
+		return nil, fmt.Errorf("invalid %s tag %q: expected a group for each of the %d executors, e.g. \"0,1,1,2\"",
+			ExecutorGroupsTag, v, len(task.GetExecutors()))
+	}
+	groups := make([]int, len(parts))
+	for i, s := range parts {
+		g, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || g < 0 {
+			return nil, fmt.Errorf("invalid %s tag %q: groups are non-negative integers", ExecutorGroupsTag, v)
+		}
+		// The groups run in order.
+		if i > 0 && g < groups[i-1] {
+			return nil, fmt.Errorf("invalid %s tag %q: the groups of the executors must be in order", ExecutorGroupsTag, v)
+		}
+		groups[i] = g
+	}
+	if cpus := task.GetResources().GetCpuCores(); cpus > 0 {
+		for i := range groups {
+			if n := groupSize(groups, background, i); n > int(cpus) {
+				return nil, fmt.Errorf("invalid %s tag %q: the %d executors of group %d need at least %d CPU cores, the task requests %d",
+					ExecutorGroupsTag, v, n, groups[i], n, cpus)
+			}
+		}
+	}
+	return groups, nil
+}
+
+// groupSize returns the number of foreground executors in the group of
+// executor i, which run in parallel. Background executors run on their own.
+func groupSize(groups []int, background map[int]bool, i int) int {
+	if groups == nil || background[i] {
+		return 1
+	}
+	n := 0
+	for j, g := range groups {
+		if g == groups[i] && !background[j] {
+			n++
+		}
+	}
+	return n
+}
+
+// lastOfGroup returns whether executor i is the last executor of its group.
+func lastOfGroup(groups []int, i int) bool {
+	return groups == nil || i == len(groups)-1 || groups[i+1] != groups[i]
+}
+
+// groupResources returns the share of the task resources of each of the n
+// executors running in parallel, so that they don't use more than the task
+// requested altogether. Each executor gets at least one CPU core: the
+// resources must have at least n cores, if any.
+func groupResources(res *tes.Resources, n int) (*tes.Resources, error) {
+	if n <= 1 || res == nil {
+		return res, nil
+	}
+	if res.CpuCores > 0 && int(res.CpuCores) < n {
+		return nil, fmt.Errorf("the %d executors of a group need at least %d CPU cores, the task has %d", n, n, res.CpuCores)
+	}
+	res = proto.Clone(res).(*tes.Resources)
+	res.CpuCores /= int32(n)
+	res.RamGb /= float64(n)
+	return res, nil
+}
+
+// stepGroup runs the executors of a group in parallel.
+type stepGroup struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	mtx    sync.Mutex
+	errs   []error
+	failed bool
+	// Set if all the executors of the group ignore their errors.
+	ignoreError bool
+}
+
+func newStepGroup(ctx context.Context) *stepGroup {
+	ctx, cancel := context.WithCancel(ctx)
+	return &stepGroup{ctx: ctx, cancel: cancel, ignoreError: true}
+}
+
+// start starts an executor of the group. The other executors are stopped
+// if it fails, unless it ignores its errors.
+func (g *stepGroup) start(s *stepWorker, ignoreError bool) {
+	g.ignoreError = g.ignoreError && ignoreError
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		err := s.Run(g.ctx)
+		if err == nil {
+			return
+		}
+		g.mtx.Lock()
+		defer g.mtx.Unlock()
+		// Stopped because another executor failed.
+		if g.failed && errors.Is(err, context.Canceled) {
+			return
+		}
+		g.errs = append(g.errs, err)
+		if !ignoreError {
+			g.failed = true
+			g.cancel()
+		}
+	}()
+}
+
+// wait waits for the executors of the group, and returns their errors.
+func (g *stepGroup) wait() []error {
+	g.wg.Wait()
+	g.cancel()
+	return g.errs
+}
@@ -0,0 +1,113 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/tes"
+)
+
+func TestExecutorGroups(t *testing.T) {
+	task := &tes.Task{Executors: []*tes.Executor{{}, {}, {}, {}}}
+	if groups, err := executorGroups(task, nil); err != nil || groups != nil {
+		t.Errorf("expected no groups, got %v %v", groups, err)
+	}
+
+	task.Tags = map[string]string{ExecutorGroupsTag: "0, 1,1,2"}
+	groups, err := executorGroups(task, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range []int{1, 2, 2, 1} {
+		if n := groupSize(groups, nil, i); n != expected {
+			t.Errorf("executor %d: expected a group of %d, got %d", i, expected, n)
+		}
+	}
+	// Background executors aren't part of the groups.
+	background := map[int]bool{1: true}
+	for i, expected := range []int{1, 1, 1, 1} {
+		if n := groupSize(groups, background, i); n != expected {
+			t.Errorf("executor %d: expected a group of %d with background executors, got %d", i, expected, n)
+		}
+	}
+
+	// A group can't have more executors than the CPU cores of the task.
+	task.Resources = &tes.Resources{CpuCores: 1}
+	if _, err := executorGroups(task, nil); err == nil {
+		t.Error("expected an error for a group larger than the CPU cores")
+	}
+	if _, err := executorGroups(task, background); err != nil {
+		t.Error(err)
+	}
+	task.Resources = nil
+	for i, expected := range []bool{true, false, true, true} {
+		if last := lastOfGroup(groups, i); last != expected {
+			t.Errorf("executor %d: expected lastOfGroup %v", i, expected)
+		}
+	}
+
+	for _, v := range []string{"0,1,1", "0,1,1,x", "0,-1,1,2", "0,2,1,3"} {
+		task.Tags[ExecutorGroupsTag] = v
+		if _, err := executorGroups(task, nil); err == nil {
+			t.Errorf("expected an error for %q", v)
+		}
+	}
+}
+
+func TestGroupResources(t *testing.T) {
+	res := &tes.Resources{CpuCores: 4, RamGb: 8, DiskGb: 100}
+	share, err := groupResources(res, 3)
+	if err != nil || share.CpuCores != 1 || share.RamGb != 8.0/3 || share.DiskGb != 100 {
+		t.Errorf("unexpected share: %v %v", share, err)
+	}
+	if res.CpuCores != 4 {
+		t.Error("expected the task resources to be unchanged")
+	}
+	if share, _ := groupResources(res, 1); share != res {
+		t.Error("expected the resources of a single executor to be unchanged")
+	}
+	// The executors don't share CPU cores.
+	if _, err := groupResources(res, 5); err == nil {
+		t.Error("expected an error for a group larger than the CPU cores")
+	}
+}
+
+func TestStepGroup(t *testing.T) {
+	ctx := context.Background()
+
+	// The executors run in parallel.
+	start := time.Now()
+	g := newStepGroup(ctx)
+	g.start(newBackgroundStep(t, "sleep 1"), false)
+	g.start(newBackgroundStep(t, "sleep 1"), false)
+	if errs := g.wait(); len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	if d := time.Since(start); d > 1900*time.Millisecond {
+		t.Errorf("expected the executors to run in parallel, took %s", d)
+	}
+
+	// A failure stops the other executors.
+	start = time.Now()
+	g = newStepGroup(ctx)
+	g.start(newBackgroundStep(t, "sleep 30"), false)
+	g.start(newBackgroundStep(t, "exit 3"), false)
+	errs := g.wait()
+	if len(errs) != 1 || g.ignoreError {
+		t.Errorf("unexpected errors: %v", errs)
+	} else if code, _ := getExitCode(errs[0]); code != 3 {
+		t.Errorf("expected exit code 3, got %d", code)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("expected the other executor to be stopped, took %s", d)
+	}
+
+	// Unless the error is ignored.
+	g = newStepGroup(ctx)
+	g.start(newBackgroundStep(t, "sleep 1; exit 0"), true)
+	g.start(newBackgroundStep(t, "exit 3"), true)
+	if errs := g.wait(); len(errs) != 1 || !g.ignoreError {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
@@ -3,16 +3,22 @@ package worker
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"slices"
+	"sort"
 	"strings"
 	"text/template"
 	"time"
 
+	"github.com/ohsu-comp-bio/funnel/config"
 	"github.com/ohsu-comp-bio/funnel/logger"
+	"github.com/ohsu-comp-bio/funnel/provenance"
 	"github.com/ohsu-comp-bio/funnel/tes"
 	v1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
@@ -38,6 +44,13 @@ type KubernetesCommand struct {
 	ServiceAccount string
 	NeedsPVC       bool
 	Clientset      kubernetes.Interface
+	// Pull policy and image pull secrets of the executor containers.
+	Pull *ImagePull
+	// Background executors of the task, run as sidecars in the job.
+	Sidecars []*KubernetesSidecar
+	// Network policy of the task. The server creates the NetworkPolicy
+	// selecting the executor pods.
+	Network *config.Network
 	Command
 }
 
@@ -125,7 +138,7 @@ func (kcmd KubernetesCommand) Run(ctx context.Context) error {
 		"UseShell":           useShell,
 		"Workdir":            kcmd.Workdir,
 		"Volumes":            kcmd.Volumes,
-		"Env":                kcmd.Env,
+		"Env":                kcmd.plainEnv(),
 		"Cpus":               kcmd.Resources.CpuCores,
 		"RamGb":              kcmd.Resources.RamGb,
 		"DiskGb":             kcmd.Resources.DiskGb,
@@ -150,7 +163,7 @@ func (kcmd KubernetesCommand) Run(ctx context.Context) error {
 		}
 	}
 
-	logger.Debug("Decoding job template", "template", buf.String())
+	logger.Debug("Decoding job template")
 	decode := scheme.Codecs.UniversalDeserializer().Decode
 	obj, _, err := decode(buf.Bytes(), nil, nil)
 	if err != nil {
@@ -170,6 +183,11 @@ func (kcmd KubernetesCommand) Run(ctx context.Context) error {
 		}
 	}
 
+	kcmd.applyImagePull(job)
+	kcmd.applySidecars(job)
+	kcmd.applyNetwork(job)
+	secret := kcmd.applySecretEnv(job)
+
 	logger.Debug("Creating Kubernetes clientset", "clientset", kcmd.Clientset)
 	clientset := kcmd.Clientset
 	if clientset == nil {
@@ -188,7 +206,7 @@ func (kcmd KubernetesCommand) Run(ctx context.Context) error {
 
 	logger.Debug("Creating Kubernetes job", "jobName", job.Name, "namespace", kcmd.JobsNamespace)
 	var client = clientset.BatchV1().Jobs(kcmd.JobsNamespace)
-	_, err = client.Create(ctx, job, metav1.CreateOptions{})
+	created, err := client.Create(ctx, job, metav1.CreateOptions{})
 	if err != nil {
 		// If the executor job already exists, delete and recreate it. This allows us to restart the
 		// whole task in case of worker job error, even if the executor job is not configured to
@@ -196,7 +214,7 @@ func (kcmd KubernetesCommand) Run(ctx context.Context) error {
 		if err.Error() == "jobs.batch \""+job.Name+"\" already exists" {
 			logger.Debug("Executor job already exists: recreating it", "jobName", job.Name)
 			deleteJob(ctx, clientset, client, job.Name, kcmd.JobsNamespace)
-			_, err = client.Create(ctx, job, metav1.CreateOptions{})
+			created, err = client.Create(ctx, job, metav1.CreateOptions{})
 			if err != nil {
 				return &K8sSystemErr{
 					Reason:  "JobCreationFailed",
@@ -213,6 +231,19 @@ func (kcmd KubernetesCommand) Run(ctx context.Context) error {
 		}
 	}
 
+	// The pod starts once the Secret of its env exists.
+	if secret != nil {
+		err = createSecret(ctx, clientset, secret, created)
+		if err != nil {
+			deleteJob(ctx, clientset, client, job.Name, kcmd.JobsNamespace)
+			return &K8sSystemErr{
+				Reason:  "SecretCreationFailed",
+				Message: "Failed to create the Secret of the executor env",
+				Err:     err,
+			}
+		}
+	}
+
 	logger.Debug("Job created successfully, waiting for pod to finish", "jobName", job.Name)
 	podWatcher, err := clientset.CoreV1().Pods(kcmd.JobsNamespace).Watch(ctx, metav1.ListOptions{
 		LabelSelector: fmt.Sprintf("job-name=%s-%d", taskId, kcmd.JobId),
@@ -226,6 +257,15 @@ func (kcmd KubernetesCommand) Run(ctx context.Context) error {
 	}
 	defer podWatcher.Stop()
 	pod, err := waitForPodFinish(ctx, podWatcher)
+	var pullErr *ImagePullError
+	if errors.As(err, &pullErr) {
+		pullErr.Image = kcmd.Image
+		return &K8sSystemErr{
+			Reason:  "ImagePullFailed",
+			Message: fmt.Sprintf("Failed to pull image %s", kcmd.Image),
+			Err:     err,
+		}
+	}
 	if err != nil {
 		return &K8sSystemErr{
 			Reason:  "PodWaitFailed",
@@ -235,7 +275,7 @@ func (kcmd KubernetesCommand) Run(ctx context.Context) error {
 	}
 
 	logger.Debug("Streaming pod logs", "podName", pod.Name)
-	err = streamPodLogs(ctx, kcmd.JobsNamespace, pod.Name, kcmd.Stdout, kcmd.Stderr)
+	err = streamPodLogs(ctx, kcmd.JobsNamespace, pod.Name, pod.Spec.Containers[0].Name, kcmd.Stdout, kcmd.Stderr)
 	if err != nil {
 		return &K8sSystemErr{
 			Reason:  "LogStreamingFailed",
@@ -243,6 +283,7 @@ func (kcmd KubernetesCommand) Run(ctx context.Context) error {
 			Err:     err,
 		}
 	}
+	kcmd.streamSidecarLogs(ctx, pod.Name)
 
 	if len(pod.Status.ContainerStatuses) == 0 {
 		return &K8sSystemErr{
@@ -254,6 +295,16 @@ func (kcmd KubernetesCommand) Run(ctx context.Context) error {
 
 	// TODO: Review effects (e.g. does this cover all Executors?)
 	cStatus := pod.Status.ContainerStatuses[0]
+	_, digest, _ := strings.Cut(cStatus.ImageID, "@")
+	recordProvenance(kcmd.Event, &provenance.Executor{
+		Runtime:        "kubernetes",
+		RuntimeVersion: serverVersion(clientset),
+		Image:          kcmd.Image,
+		ImageDigest:    digest,
+		ImageID:        cStatus.ImageID,
+		CommandLine:    containerCommandLine(job),
+		Resources:      kcmd.effectiveResources(job),
+	})
 	if cStatus.State.Terminated == nil {
 		return &K8sSystemErr{
 			Reason:  "ContainerNotTerminated",
@@ -285,9 +336,44 @@ func (kcmd KubernetesCommand) Run(ctx context.Context) error {
 	return nil
 }
 
+// serverVersion returns the version of the Kubernetes API server, or "" if
+// it's unknown.
+func serverVersion(clientset kubernetes.Interface) string {
+	v, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		return ""
+	}
+	return v.GitVersion
+}
+
+// containerCommandLine returns the command line of the executor container.
+func containerCommandLine(job *v1.Job) string {
+	if len(job.Spec.Template.Spec.Containers) == 0 {
+		return ""
+	}
+	c := job.Spec.Template.Spec.Containers[0]
+	return commandLine(append(append([]string{}, c.Command...), c.Args...))
+}
+
+// effectiveResources returns the resources of the task, after the limits of
+// the config are applied, and the limits of the executor container.
+func (kcmd *KubernetesCommand) effectiveResources(job *v1.Job) map[string]string {
+	res := requestedResources(kcmd.Resources)
+	if len(job.Spec.Template.Spec.Containers) > 0 {
+		limits := job.Spec.Template.Spec.Containers[0].Resources.Limits
+		if cpu, ok := limits[corev1.ResourceCPU]; ok {
+			res["cpu_limit"] = cpu.String()
+		}
+		if mem, ok := limits[corev1.ResourceMemory]; ok {
+			res["memory_limit"] = mem.String()
+		}
+	}
+	return res
+}
+
 // streamPodLogs streams logs from a pod regardless of its state
 // This works for Running, Succeeded, and Failed pods (as long as they haven't been deleted)
-func streamPodLogs(ctx context.Context, namespace string, podName string, stdout io.Writer, stderr io.Writer) error {
+func streamPodLogs(ctx context.Context, namespace string, podName string, container string, stdout io.Writer, stderr io.Writer) error {
 	clientset, err := getKubernetesClientset()
 	if err != nil {
 		return fmt.Errorf("getting kubernetes clientset: %v", err)
@@ -297,7 +383,8 @@ func streamPodLogs(ctx context.Context, namespace string, podName string, stdout
 	// Follow=true ensures we stream logs until the pod completely finishes (closes the stream),
 	// catching the final error logs that might be missed due to race conditions.
 	req := clientset.CoreV1().Pods(namespace).GetLogs(podName, &corev1.PodLogOptions{
-		Follow: true,
+		Container: container,
+		Follow:    true,
 	})
 
 	podLogs, err := req.Stream(ctx)
@@ -382,6 +469,9 @@ func waitForPodFinish(ctx context.Context, watcher watch.Interface) (*corev1.Pod
 					logger.Debug("Container has terminated")
 					return pod, nil
 				}
+				if err := imagePullFailure(cStatus.State.Waiting); err != nil {
+					return pod, err
+				}
 			}
 
 			// Handle pod deletion
@@ -400,6 +490,105 @@ func waitForPodFinish(ctx context.Context, watcher watch.Interface) (*corev1.Pod
 	}
 }
 
+// imagePullFailure returns an ImagePullError if the container is waiting
+// because its image can't be pulled. ErrImagePull is retried by the kubelet,
+// with ImagePullBackOff in between, so it's considered a failure only once
+// the kubelet backs off.
+func imagePullFailure(w *corev1.ContainerStateWaiting) error {
+	if w == nil {
+		return nil
+	}
+	switch w.Reason {
+	case "ImagePullBackOff", "ErrImageNeverPull", "InvalidImageName":
+		return &ImagePullError{Err: fmt.Errorf("%s: %s", w.Reason, w.Message)}
+	}
+	return nil
+}
+
+// applyImagePull sets the pull policy and image pull secrets of the job.
+func (kcmd *KubernetesCommand) applyImagePull(job *v1.Job) {
+	if kcmd.Pull == nil {
+		return
+	}
+	spec := &job.Spec.Template.Spec
+	if kcmd.Pull.Policy != "" {
+		for i := range spec.Containers {
+			spec.Containers[i].ImagePullPolicy = corev1.PullPolicy(kcmd.Pull.Policy)
+		}
+	}
+	for _, name := range kcmd.Pull.Secrets {
+		spec.ImagePullSecrets = append(spec.ImagePullSecrets, corev1.LocalObjectReference{Name: name})
+	}
+}
+
+// applySecretEnv references the secret env values of the executor and its
+// sidecars from a Secret, so that they aren't stored in the job. It returns
+// the Secret, to create with the job, or nil if there are no secret values.
+func (kcmd *KubernetesCommand) applySecretEnv(job *v1.Job) *corev1.Secret {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      job.Name + "-env",
+			Namespace: kcmd.JobsNamespace,
+			Labels:    map[string]string{"app": "funnel-executor", "taskId": kcmd.TaskId},
+		},
+		Data: map[string][]byte{},
+	}
+	add := func(c *corev1.Container, cmd *Command) {
+		var keys []string
+		for k := range cmd.SecretEnv {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			key := c.Name + "." + k
+			secret.Data[key] = []byte(cmd.Env[k])
+			env := corev1.EnvVar{Name: k, ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: secret.Name},
+					Key:                  key,
+				},
+			}}
+			c.Env = append(slices.DeleteFunc(c.Env, func(e corev1.EnvVar) bool { return e.Name == k }), env)
+		}
+	}
+
+	spec := &job.Spec.Template.Spec
+	if len(spec.Containers) > 0 {
+		add(&spec.Containers[0], &kcmd.Command)
+	}
+	for _, sc := range kcmd.Sidecars {
+		for i := range spec.InitContainers {
+			if spec.InitContainers[i].Name == sc.Name {
+				add(&spec.InitContainers[i], &sc.Command)
+			}
+		}
+	}
+	if len(secret.Data) == 0 {
+		return nil
+	}
+	return secret
+}
+
+// createSecret creates the Secret of the env of the job, owned by the job so
+// that it's deleted with it. A Secret left by a previous attempt is replaced.
+func createSecret(ctx context.Context, clientset kubernetes.Interface, secret *corev1.Secret, job *v1.Job) error {
+	secret.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: "batch/v1",
+		Kind:       "Job",
+		Name:       job.Name,
+		UID:        job.UID,
+	}}
+	client := clientset.CoreV1().Secrets(secret.Namespace)
+	_, err := client.Create(ctx, secret, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		if err := client.Delete(ctx, secret.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		_, err = client.Create(ctx, secret, metav1.CreateOptions{})
+	}
+	return err
+}
+
 // Deletes a job and waits for it to be deleted
 func deleteJob(ctx context.Context, clientset kubernetes.Interface, client batchv1.JobInterface, jobName, namespace string) error {
 	// delete the job
@@ -0,0 +1,136 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// Attach streams the live logs of the executor pod, from now on. The logs of
+// the pod are otherwise only copied to the task logs when it ends.
+func (kcmd KubernetesCommand) Attach(ctx context.Context, stdout, stderr io.Writer) error {
+	clientset := kcmd.Clientset
+	if clientset == nil {
+		cs, err := getKubernetesClientset()
+		if err != nil {
+			return fmt.Errorf("getting kubernetes clientset: %v", err)
+		}
+		clientset = cs
+	}
+
+	// The pod may still be pending, e.g. pulling the image.
+	var pod *corev1.Pod
+	for {
+		var err error
+		pod, err = kcmd.runningPod(ctx, clientset)
+		if err == nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+
+	tail := int64(0)
+	req := clientset.CoreV1().Pods(kcmd.JobsNamespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container: pod.Spec.Containers[0].Name,
+		Follow:    true,
+		TailLines: &tail,
+	})
+	logs, err := req.Stream(ctx)
+	if err != nil {
+		return fmt.Errorf("streaming logs from pod %s: %v", pod.Name, err)
+	}
+	defer logs.Close()
+	_, err = io.Copy(stdout, logs)
+	return err
+}
+
+// Exec runs a command in the executor container, like "kubectl exec". The
+// worker service account must be allowed to create "pods/exec".
+func (kcmd KubernetesCommand) Exec(ctx context.Context, command []string, streams ExecStreams) error {
+	conf, err := rest.InClusterConfig()
+	if err != nil {
+		return fmt.Errorf("getting kubernetes config: %v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(conf)
+	if err != nil {
+		return err
+	}
+	pod, err := kcmd.runningPod(ctx, clientset)
+	if err != nil {
+		return err
+	}
+
+	req := clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(kcmd.JobsNamespace).
+		Name(pod.Name).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: pod.Spec.Containers[0].Name,
+			Command:   command,
+			Stdin:     true,
+			Stdout:    true,
+			Stderr:    !streams.TTY,
+			TTY:       streams.TTY,
+		}, scheme.ParameterCodec)
+	exec, err := remotecommand.NewSPDYExecutor(conf, "POST", req.URL())
+	if err != nil {
+		return fmt.Errorf("exec in pod %s: %v", pod.Name, err)
+	}
+
+	opts := remotecommand.StreamOptions{
+		Stdin:  streams.Stdin,
+		Stdout: streams.Stdout,
+		Stderr: streams.Stderr,
+		Tty:    streams.TTY,
+	}
+	if streams.TTY {
+		opts.TerminalSizeQueue = &terminalSizeQueue{ctx: ctx, sizes: streams.Resize}
+	}
+	return exec.StreamWithContext(ctx, opts)
+}
+
+// runningPod returns the running pod of the executor job.
+func (kcmd KubernetesCommand) runningPod(ctx context.Context, clientset kubernetes.Interface) (*corev1.Pod, error) {
+	jobName := fmt.Sprintf("%s-%d", kcmd.TaskId, kcmd.JobId)
+	pods, err := clientset.CoreV1().Pods(kcmd.JobsNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "job-name=" + jobName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing pods of executor job %s: %v", jobName, err)
+	}
+	for i := range pods.Items {
+		if pods.Items[i].Status.Phase == corev1.PodRunning && len(pods.Items[i].Spec.Containers) > 0 {
+			return &pods.Items[i], nil
+		}
+	}
+	return nil, fmt.Errorf("the pod of executor job %s isn't running", jobName)
+}
+
+// terminalSizeQueue passes the terminal size changes of an exec session to
+// the kubernetes API.
+type terminalSizeQueue struct {
+	ctx   context.Context
+	sizes <-chan TerminalSize
+}
+
+func (q *terminalSizeQueue) Next() *remotecommand.TerminalSize {
+	select {
+	case <-q.ctx.Done():
+		return nil
+	case size := <-q.sizes:
+		return &remotecommand.TerminalSize{Width: size.Width, Height: size.Height}
+	}
+}
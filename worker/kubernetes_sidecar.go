@@ -0,0 +1,133 @@
+package worker
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+
+	v1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// KubernetesSidecar is a background executor of a task, which runs as a
+// sidecar container in the jobs of the following executors: it starts
+// before, and is stopped after, the executor container of each job.
+type KubernetesSidecar struct {
+	// Name of the container.
+	Name string
+	Command
+
+	mtx       sync.Mutex
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// Run waits until the background executor is stopped: the sidecar
+// containers run in the jobs of the following executors.
+func (sc *KubernetesSidecar) Run(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+	case <-sc.stopCh():
+	}
+	return nil
+}
+
+// Stop stops the background executor. The sidecar containers stop with the
+// jobs.
+func (sc *KubernetesSidecar) Stop() error {
+	ch := sc.stopCh()
+	sc.closeOnce.Do(func() { close(ch) })
+	return nil
+}
+
+func (sc *KubernetesSidecar) stopCh() chan struct{} {
+	sc.mtx.Lock()
+	defer sc.mtx.Unlock()
+	if sc.stopped == nil {
+		sc.stopped = make(chan struct{})
+	}
+	return sc.stopped
+}
+
+// GetStdout returns the stdout of the background executor. The writers are
+// guarded, as the logs of the sidecar are written by the jobs of the
+// following executors.
+func (sc *KubernetesSidecar) GetStdout() io.Writer {
+	sc.mtx.Lock()
+	defer sc.mtx.Unlock()
+	return sc.Stdout
+}
+
+func (sc *KubernetesSidecar) GetStderr() io.Writer {
+	sc.mtx.Lock()
+	defer sc.mtx.Unlock()
+	return sc.Stderr
+}
+
+func (sc *KubernetesSidecar) SetStdout(w io.Writer) {
+	sc.mtx.Lock()
+	defer sc.mtx.Unlock()
+	sc.Stdout = w
+}
+
+func (sc *KubernetesSidecar) SetStderr(w io.Writer) {
+	sc.mtx.Lock()
+	defer sc.mtx.Unlock()
+	sc.Stderr = w
+}
+
+// container returns the sidecar container, sharing the volumes of the
+// executor container.
+func (sc *KubernetesSidecar) container(executor *corev1.Container) corev1.Container {
+	always := corev1.ContainerRestartPolicyAlways
+	c := corev1.Container{
+		Name:            sc.Name,
+		Image:           sc.Image,
+		WorkingDir:      sc.Workdir,
+		VolumeMounts:    executor.VolumeMounts,
+		ImagePullPolicy: executor.ImagePullPolicy,
+		RestartPolicy:   &always,
+	}
+	if len(sc.ShellCommand) == 1 {
+		c.Command = []string{"/bin/sh", "-c"}
+		c.Args = sc.ShellCommand
+	} else {
+		c.Command = sc.ShellCommand
+	}
+	// The secret values are referenced by applySecretEnv.
+	for k, v := range sc.plainEnv() {
+		c.Env = append(c.Env, corev1.EnvVar{Name: k, Value: v})
+	}
+	sort.Slice(c.Env, func(i, j int) bool {
+		return c.Env[i].Name < c.Env[j].Name
+	})
+	return c
+}
+
+// applySidecars adds the background executors to the job, as native
+// sidecars: init containers which keep running.
+func (kcmd *KubernetesCommand) applySidecars(job *v1.Job) {
+	spec := &job.Spec.Template.Spec
+	if len(kcmd.Sidecars) == 0 || len(spec.Containers) == 0 {
+		return
+	}
+	for _, sc := range kcmd.Sidecars {
+		spec.InitContainers = append(spec.InitContainers, sc.container(&spec.Containers[0]))
+	}
+}
+
+// streamSidecarLogs copies the logs of the sidecars of the pod to the logs
+// of their background executors.
+func (kcmd *KubernetesCommand) streamSidecarLogs(ctx context.Context, podName string) {
+	for _, sc := range kcmd.Sidecars {
+		stdout := sc.GetStdout()
+		if stdout == nil {
+			stdout = io.Discard
+		}
+		err := streamPodLogs(ctx, kcmd.JobsNamespace, podName, sc.Name, stdout, sc.GetStderr())
+		if err != nil && kcmd.Event != nil {
+			kcmd.Event.Error("failed to stream sidecar logs", "container", sc.Name, "error", err)
+		}
+	}
+}
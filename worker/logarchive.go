@@ -0,0 +1,40 @@
+package worker
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/events"
+	"github.com/ohsu-comp-bio/funnel/logs"
+)
+
+// LogArchive archives the complete stdout and stderr of the executors, in
+// chunks. The task logs only keep the tail of the logs.
+type LogArchive struct {
+	Writer        logs.ChunkWriter
+	ChunkSize     int64
+	FlushInterval time.Duration
+}
+
+// writers returns the stdout and stderr of an executor, which also write to
+// the archive, and the writers of the archive, to close when the executor
+// stops. The secrets of the task are redacted from the archive.
+func (a *LogArchive) writers(ctx context.Context, taskID string, index int, redact *events.RedactWriter, stdout, stderr io.Writer) (io.Writer, io.Writer, []*logs.Writer) {
+	newWriter := func(stream logs.Stream) *logs.Writer {
+		key := logs.Key{ID: taskID, Executor: uint32(index), Stream: stream}
+		return logs.NewWriter(ctx, a.Writer, key, a.ChunkSize, a.FlushInterval)
+	}
+	out := newWriter(logs.Stream_STDOUT)
+	errw := newWriter(logs.Stream_STDERR)
+	tee := func(w io.Writer, archive io.Writer) io.Writer {
+		if redact != nil {
+			archive = redactedWriter(redact, archive)
+		}
+		if w == nil {
+			return archive
+		}
+		return io.MultiWriter(w, archive)
+	}
+	return tee(stdout, out), tee(stderr, errw), []*logs.Writer{out, errw}
+}
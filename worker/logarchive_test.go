@@ -0,0 +1,48 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/ohsu-comp-bio/funnel/events"
+	"github.com/ohsu-comp-bio/funnel/logs"
+)
+
+// chunkRecorder records the chunks of the archived logs.
+type chunkRecorder struct {
+	mtx  sync.Mutex
+	logs map[logs.Stream]string
+}
+
+func (r *chunkRecorder) WriteChunk(ctx context.Context, c *logs.Chunk) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if c.Id != "task" || c.Executor != 0 || int(c.Offset) != len(r.logs[c.Stream]) {
+		return fmt.Errorf("unexpected chunk: %v", c)
+	}
+	r.logs[c.Stream] += string(c.Data)
+	return nil
+}
+
+func TestStepLogArchive(t *testing.T) {
+	rec := &chunkRecorder{logs: map[logs.Stream]string{}}
+	s := newBackgroundStep(t, "echo hello; echo s3cr3t >&2; echo world")
+	s.TaskID = "task"
+	s.LogArchive = &LogArchive{Writer: rec, ChunkSize: 4}
+	s.Redact = &events.RedactWriter{Writer: &events.Logger{}}
+	s.Redact.Redact("s3cr3t")
+
+	if err := s.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	rec.mtx.Lock()
+	defer rec.mtx.Unlock()
+	if out := rec.logs[logs.Stream_STDOUT]; out != "hello\nworld\n" {
+		t.Errorf("unexpected stdout: %q", out)
+	}
+	if out := rec.logs[logs.Stream_STDERR]; out != events.Redacted+"\n" {
+		t.Errorf("unexpected stderr: %q", out)
+	}
+}
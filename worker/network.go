@@ -0,0 +1,95 @@
+package worker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/tes"
+	v1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// dockerNetwork describes the docker network of the executors of a task.
+type dockerNetwork struct {
+	// Network of the executors, or "" for the default network.
+	Name string
+	// Whether the network is created by the worker for the task, and its
+	// executors reachable at "executor-<index>".
+	Create bool
+	// The network created for the task has no external connectivity.
+	Internal bool
+}
+
+// newDockerNetwork returns the docker network of the executors of a task.
+// Background executors are reachable by the following executors in a
+// network of the task, unless it uses the host network or the allowlist
+// network shared by tasks.
+func newDockerNetwork(conf *config.Worker, network *config.Network, taskID string, background bool) (*dockerNetwork, error) {
+	n := &dockerNetwork{}
+	switch network.GetPolicy() {
+	case config.NetworkNone:
+		n.Name = "none"
+		if background {
+			n = &dockerNetwork{Name: "funnel-" + taskID, Create: true, Internal: true}
+		}
+	case config.NetworkAllowlist:
+		if network.GetAllowlistNetwork() == "" {
+			return nil, fmt.Errorf("the %q network policy requires Worker.Network.AllowlistNetwork with docker", config.NetworkAllowlist)
+		}
+		n.Name = network.GetAllowlistNetwork()
+	case config.NetworkHost:
+		n.Name = "host"
+	default:
+		if background {
+			n = &dockerNetwork{Name: "funnel-" + taskID, Create: true}
+		}
+	}
+
+	// A RunCommand ignoring the network would give the executors full
+	// network access.
+	if n.Name != "" && !strings.Contains(conf.GetContainer().GetRunCommand(), ".Network") {
+		return nil, fmt.Errorf("the network policy %q requires {{.Network}} in Worker.Container.RunCommand", network.GetPolicy())
+	}
+	return n, nil
+}
+
+// checkNetwork returns an error if the executor backend can't enforce the
+// network policy of the task. With docker, the allowlist network is shared
+// by the tasks, which can't restrict its allowed hosts.
+func checkNetwork(backend string, network *config.Network, task *tes.Task) error {
+	policy := network.GetPolicy()
+	switch backend {
+	case "process":
+		if policy == config.NetworkNone || policy == config.NetworkAllowlist {
+			return fmt.Errorf("the process executor can't enforce the %q network policy", policy)
+		}
+	case "apptainer":
+		if policy == config.NetworkAllowlist {
+			return fmt.Errorf("the apptainer executor can't enforce the %q network policy", policy)
+		}
+	case "kubernetes":
+	default:
+		hosts := strings.TrimSpace(task.GetTags()[config.NetworkAllowedHostsTag])
+		if policy == config.NetworkAllowlist && hosts != "" {
+			return fmt.Errorf("the docker executor can't enforce the %s tag", config.NetworkAllowedHostsTag)
+		}
+	}
+	return nil
+}
+
+// applyNetwork labels the executor pods, which are selected by the network
+// policy of the task, and sets the host network.
+func (kcmd *KubernetesCommand) applyNetwork(job *v1.Job) {
+	meta := &job.Spec.Template.ObjectMeta
+	if meta.Labels == nil {
+		meta.Labels = map[string]string{}
+	}
+	meta.Labels["app"] = "funnel-executor"
+	meta.Labels["taskId"] = kcmd.TaskId
+
+	if kcmd.Network.GetPolicy() == config.NetworkHost {
+		job.Spec.Template.Spec.HostNetwork = true
+		job.Spec.Template.Spec.DNSPolicy = corev1.DNSClusterFirstWithHostNet
+	}
+}
@@ -0,0 +1,97 @@
+package worker
+
+import (
+	"testing"
+
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/tes"
+	v1 "k8s.io/api/batch/v1"
+)
+
+func TestDockerNetwork(t *testing.T) {
+	conf := config.DefaultConfig().Worker
+	tests := []struct {
+		policy     string
+		background bool
+		expected   dockerNetwork
+	}{
+		{config.NetworkBridge, false, dockerNetwork{}},
+		{config.NetworkBridge, true, dockerNetwork{Name: "funnel-task", Create: true}},
+		{config.NetworkNone, false, dockerNetwork{Name: "none"}},
+		{config.NetworkNone, true, dockerNetwork{Name: "funnel-task", Create: true, Internal: true}},
+		{config.NetworkAllowlist, true, dockerNetwork{Name: "egress"}},
+		{config.NetworkHost, true, dockerNetwork{Name: "host"}},
+	}
+	for _, test := range tests {
+		network := &config.Network{Policy: test.policy, AllowlistNetwork: "egress"}
+		n, err := newDockerNetwork(conf, network, "task", test.background)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *n != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.policy, test.expected, *n)
+		}
+	}
+
+	if _, err := newDockerNetwork(conf, &config.Network{Policy: config.NetworkAllowlist}, "task", false); err == nil {
+		t.Error("expected an error without an allowlist network")
+	}
+
+	// A RunCommand ignoring the network can't enforce the policy.
+	conf.Container.RunCommand = "run -i --rm {{.Image}} {{.Command}}"
+	if _, err := newDockerNetwork(conf, &config.Network{Policy: config.NetworkNone}, "task", false); err == nil {
+		t.Error("expected an error for a RunCommand without the network")
+	}
+	if _, err := newDockerNetwork(conf, &config.Network{Policy: config.NetworkBridge}, "task", false); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCheckNetwork(t *testing.T) {
+	none := &config.Network{Policy: config.NetworkNone}
+	allowlist := &config.Network{Policy: config.NetworkAllowlist}
+	task := &tes.Task{}
+	if err := checkNetwork("process", none, task); err == nil {
+		t.Error("expected an error for the process executor")
+	}
+	if err := checkNetwork("apptainer", allowlist, task); err == nil {
+		t.Error("expected an error for the apptainer executor")
+	}
+	for _, backend := range []string{"docker", "kubernetes", "apptainer"} {
+		if err := checkNetwork(backend, none, task); err != nil {
+			t.Errorf("%s: unexpected error: %v", backend, err)
+		}
+	}
+
+	// The allowlist network of docker is shared by the tasks, which can't
+	// restrict its hosts.
+	hosts := &tes.Task{Tags: map[string]string{config.NetworkAllowedHostsTag: "pypi.org"}}
+	if err := checkNetwork("docker", allowlist, task); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := checkNetwork("docker", allowlist, hosts); err == nil {
+		t.Error("expected an error for the allowed hosts of a task with docker")
+	}
+	if err := checkNetwork("kubernetes", allowlist, hosts); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestKubernetesNetwork(t *testing.T) {
+	job := &v1.Job{}
+	kcmd := &KubernetesCommand{TaskId: "task", Network: &config.Network{Policy: config.NetworkNone}}
+	kcmd.applyNetwork(job)
+	labels := job.Spec.Template.Labels
+	if labels["app"] != "funnel-executor" || labels["taskId"] != "task" {
+		t.Errorf("unexpected pod labels: %v", labels)
+	}
+	if job.Spec.Template.Spec.HostNetwork {
+		t.Error("unexpected host network")
+	}
+
+	kcmd.Network.Policy = config.NetworkHost
+	kcmd.applyNetwork(job)
+	if !job.Spec.Template.Spec.HostNetwork {
+		t.Error("expected the host network")
+	}
+}
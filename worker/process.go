@@ -0,0 +1,251 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/events"
+	"github.com/ohsu-comp-bio/funnel/provenance"
+)
+
+// ProcessCommand runs an executor command directly as a subprocess of the
+// worker, without a container, e.g. when the worker already runs in a
+// container or VM where nested containers aren't possible. The executor must
+// be trusted: it runs as the worker user, with access to the host.
+//
+// Container paths of the task volumes, inputs and outputs are rewritten to
+// their host paths in the command, workdir and environment.
+type ProcessCommand struct {
+	Name string
+	// Working directory of commands without a Workdir, e.g. the task directory.
+	DefaultDir string
+	// Time given to the executor to exit after SIGTERM, before SIGKILL.
+	// Defaults to 10 seconds.
+	StopTimeout time.Duration
+	Command
+	procGroup
+}
+
+// Run runs the command and blocks until it exits.
+func (p *ProcessCommand) Run(ctx context.Context) error {
+	if err := p.begin(nil); err != nil {
+		return err
+	}
+
+	command := p.ShellCommand
+	if len(command) == 1 {
+		command = []string{"/bin/sh", "-c", command[0]}
+	}
+	args := make([]string, len(command))
+	for i, arg := range command {
+		args[i] = p.hostPaths(arg)
+	}
+	if len(args) == 0 {
+		return errors.New("empty executor command")
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = p.DefaultDir
+	if p.Workdir != "" {
+		cmd.Dir = p.hostPaths(p.Workdir)
+		// Like "docker run --workdir", create the working directory, if it's
+		// in a task volume.
+		if cmd.Dir != p.Workdir {
+			if err := os.MkdirAll(cmd.Dir, 0775); err != nil {
+				return err
+			}
+		}
+	}
+	cmd.Env = os.Environ()
+	keys := make([]string, 0, len(p.Env))
+	for k := range p.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		cmd.Env = append(cmd.Env, k+"="+p.hostPaths(p.Env[k]))
+	}
+	cmd.Stdin = p.Stdin
+	cmd.Stdout = p.Stdout
+	cmd.Stderr = p.Stderr
+
+	recordProvenance(p.Event, &provenance.Executor{
+		Runtime:     "process",
+		CommandLine: commandLine(cmd.Args),
+	})
+	p.Event.Info("Running command", "cmd", cmd.String(), "dir", cmd.Dir)
+	return p.run(cmd)
+}
+
+// Stop sends SIGTERM to the executor processes, and SIGKILL if they haven't
+// exited after StopTimeout.
+func (p *ProcessCommand) Stop() error {
+	return p.stop(p.Event, p.Name, p.StopTimeout)
+}
+
+// hostPaths rewrites the container paths of the volumes in s to their host
+// paths. Paths are matched as whole path components, and the most specific
+// volume wins, e.g. an input file mounted in an output directory.
+func (p *ProcessCommand) hostPaths(s string) string {
+	vols := make([]Volume, 0, len(p.Volumes))
+	for _, v := range p.Volumes {
+		if v.ContainerPath = filepath.Clean(v.ContainerPath); v.ContainerPath != "/" {
+			vols = append(vols, v)
+		}
+	}
+	sort.SliceStable(vols, func(i, j int) bool {
+		return len(vols[i].ContainerPath) > len(vols[j].ContainerPath)
+	})
+
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] == '/' && (i == 0 || !isPathChar(s[i-1]) && s[i-1] != '/') {
+			if v, ok := matchVolume(s[i:], vols); ok {
+				b.WriteString(v.HostPath)
+				i += len(v.ContainerPath)
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+		i++
+	}
+	return b.String()
+}
+
+// matchVolume returns the volume whose container path prefixes s.
+func matchVolume(s string, vols []Volume) (Volume, bool) {
+	for _, v := range vols {
+		cp := v.ContainerPath
+		if strings.HasPrefix(s, cp) && (len(s) == len(cp) || s[len(cp)] == '/' || !isPathChar(s[len(cp)])) {
+			return v, true
+		}
+	}
+	return Volume{}, false
+}
+
+// isPathChar reports whether c may be part of a file name, as opposed to
+// separators in a shell command such as spaces, quotes, "=" or ":".
+func isPathChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("._-+@~%", c) >= 0
+}
+
+var errExecutorStopped = errors.New("executor was stopped")
+
+// procGroup runs a command in its own process group, so that stopping it
+// signals all the processes it started.
+type procGroup struct {
+	mtx     sync.Mutex
+	stopped bool
+	cancel  context.CancelFunc
+	proc    *os.Process
+	done    chan struct{}
+}
+
+// begin returns errExecutorStopped if the command was already stopped.
+// The cancel function, if any, is called on stop, e.g. to cancel an image
+// pull before the command starts.
+func (g *procGroup) begin(cancel context.CancelFunc) error {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	if g.stopped {
+		return errExecutorStopped
+	}
+	g.cancel = cancel
+	return nil
+}
+
+// run starts the command, unless it was stopped, and waits for it to exit.
+func (g *procGroup) run(cmd *exec.Cmd) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	g.mtx.Lock()
+	if g.stopped {
+		g.mtx.Unlock()
+		return errExecutorStopped
+	}
+	if err := cmd.Start(); err != nil {
+		g.mtx.Unlock()
+		return err
+	}
+	g.proc = cmd.Process
+	g.done = make(chan struct{})
+	g.mtx.Unlock()
+
+	err := cmd.Wait()
+
+	// Like a container, the executor ends with its main process: processes
+	// left in the background are killed, unless stop is giving them time to
+	// exit.
+	g.mtx.Lock()
+	stopped := g.stopped
+	g.mtx.Unlock()
+	if !stopped {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	close(g.done)
+	return err
+}
+
+// defaultStopTimeout is the time given to the executors to exit after
+// SIGTERM, if the config doesn't set it.
+const defaultStopTimeout = 10 * time.Second
+
+// stop sends SIGTERM to the process group, then SIGKILL after the timeout, or
+// defaultStopTimeout if it's zero.
+func (g *procGroup) stop(event *events.ExecutorWriter, name string, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = defaultStopTimeout
+	}
+
+	g.mtx.Lock()
+	g.stopped = true
+	proc, done, cancel := g.proc, g.done, g.cancel
+	g.mtx.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	if proc == nil {
+		return nil
+	}
+
+	event.Info("Stopping executor", "name", name)
+	if err := syscall.Kill(-proc.Pid, syscall.SIGTERM); err != nil && err != syscall.ESRCH {
+		event.Error("failed to stop executor", "error", err)
+		return err
+	}
+
+	// Wait for all the processes of the group to exit, not only the main one.
+	deadline := time.After(timeout)
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	for exited := false; ; {
+		select {
+		case <-done:
+			exited = true
+			done = nil
+		case <-tick.C:
+			if exited && syscall.Kill(-proc.Pid, 0) == syscall.ESRCH {
+				return nil
+			}
+		case <-deadline:
+			event.Info("Killing executor", "name", name)
+			if err := syscall.Kill(-proc.Pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+				return err
+			}
+			return nil
+		}
+	}
+}
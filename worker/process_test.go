@@ -0,0 +1,129 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ohsu-comp-bio/funnel/events"
+	"github.com/ohsu-comp-bio/funnel/logger"
+)
+
+func newProcessTest(dir string, shellCommand ...string) *ProcessCommand {
+	return &ProcessCommand{
+		Name:        "task-0",
+		DefaultDir:  dir,
+		StopTimeout: time.Second,
+		Command: Command{
+			ShellCommand: shellCommand,
+			Volumes: []Volume{
+				{HostPath: dir + "/data", ContainerPath: "/data"},
+				{HostPath: dir + "/inputs/data/in.txt", ContainerPath: "/data/in.txt", Readonly: true},
+				{HostPath: dir + "/ref", ContainerPath: "/ref/"},
+			},
+			Event: events.NewExecutorWriter("task", 0, 0, &events.Logger{
+				Log: logger.NewLogger("test", logger.DebugConfig()),
+			}),
+		},
+	}
+}
+
+func TestProcessHostPaths(t *testing.T) {
+	p := newProcessTest("/work")
+	tests := map[string]string{
+		"/data":                              "/work/data",
+		"/data/in.txt":                       "/work/inputs/data/in.txt",
+		"/data/in.txt.bai":                   "/work/data/in.txt.bai",
+		"cat /data/in.txt > /data/out.txt":   "cat /work/inputs/data/in.txt > /work/data/out.txt",
+		"--ref=/ref/hg38.fa,/ref/hg19.fa":    "--ref=/work/ref/hg38.fa,/work/ref/hg19.fa",
+		"'/data/a b' \"/ref\"":               "'/work/data/a b' \"/work/ref\"",
+		"/database /usr/data /data2 ./data/": "/database /usr/data /data2 ./data/",
+	}
+	for in, expected := range tests {
+		if got := p.hostPaths(in); got != expected {
+			t.Errorf("hostPaths(%q): expected %q, got %q", in, expected, got)
+		}
+	}
+}
+
+func TestProcessRun(t *testing.T) {
+	dir := t.TempDir()
+	p := newProcessTest(dir, "sh", "-c", `read line; echo "$line $(pwd) $OUT"; echo err >&2; echo out > $OUT`)
+	p.Workdir = "/data/sub"
+	p.Env = map[string]string{"OUT": "/data/out.txt"}
+	var stdout, stderr bytes.Buffer
+	p.Stdin = strings.NewReader("hello\n")
+	p.Stdout = &stdout
+	p.Stderr = &stderr
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "hello " + dir + "/data/sub " + dir + "/data/out.txt\n"; stdout.String() != expected {
+		t.Errorf("expected stdout %q, got %q", expected, stdout.String())
+	}
+	if stderr.String() != "err\n" {
+		t.Errorf("unexpected stderr: %q", stderr.String())
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "data", "out.txt")); err != nil || string(b) != "out\n" {
+		t.Errorf("unexpected output file: %q, %v", b, err)
+	}
+
+	p = newProcessTest(dir, "exit 3")
+	if code, _ := getExitCode(p.Run(context.Background())); code != 3 {
+		t.Errorf("expected exit code 3, got %d", code)
+	}
+}
+
+func TestProcessStop(t *testing.T) {
+	dir := t.TempDir()
+	pidFile := filepath.Join(dir, "pid")
+	// The child process ignores SIGTERM, and is killed with its process group.
+	p := newProcessTest(dir, "sh", "-c", "sh -c 'trap \"\" TERM; echo $$ > "+pidFile+"; exec sleep 30' & wait")
+
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Run(context.Background())
+	}()
+
+	var pid []byte
+	for i := 0; len(pid) == 0; i++ {
+		if i == 100 {
+			t.Fatal("executor didn't start")
+		}
+		time.Sleep(50 * time.Millisecond)
+		pid, _ = os.ReadFile(pidFile)
+	}
+
+	if err := p.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("executor wasn't stopped")
+	}
+	if !processExited(strings.TrimSpace(string(pid))) {
+		t.Error("expected the child process to be killed")
+	}
+
+	if err := p.Run(context.Background()); err != errExecutorStopped {
+		t.Errorf("expected %v, got %v", errExecutorStopped, err)
+	}
+}
+
+// processExited reports whether the process is gone, or a zombie, on Linux.
+func processExited(pid string) bool {
+	for i := 0; i < 20; i++ {
+		stat, err := os.ReadFile(filepath.Join("/proc", pid, "stat"))
+		if err != nil || strings.Contains(string(stat), ") Z ") {
+			return true
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package worker
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/ohsu-comp-bio/funnel/events"
+	"github.com/ohsu-comp-bio/funnel/provenance"
+	"github.com/ohsu-comp-bio/funnel/tes"
+)
+
+// recordProvenance records how the executor ran in the task metadata.
+// Failures are logged: they don't fail the executor.
+func recordProvenance(event *events.ExecutorWriter, p *provenance.Executor) {
+	if event == nil {
+		return
+	}
+	if err := event.Metadata(p.Metadata()); err != nil {
+		event.Error("failed to record executor provenance", "error", err)
+	}
+}
+
+// requestedResources returns the resources requested by the task, for the
+// executor provenance.
+func requestedResources(r *tes.Resources) map[string]string {
+	res := map[string]string{}
+	if r.GetCpuCores() > 0 {
+		res["cpu_cores"] = strconv.Itoa(int(r.GetCpuCores()))
+	}
+	if r.GetRamGb() > 0 {
+		res["ram_gb"] = strconv.FormatFloat(r.GetRamGb(), 'f', -1, 64)
+	}
+	if r.GetDiskGb() > 0 {
+		res["disk_gb"] = strconv.FormatFloat(r.GetDiskGb(), 'f', -1, 64)
+	}
+	if r.GetPreemptible() {
+		res["preemptible"] = "true"
+	}
+	return res
+}
+
+// commandLine joins the arguments of a command, quoting them as needed for a
+// POSIX shell.
+func commandLine(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = arg
+		if arg == "" || strings.IndexFunc(arg, needsQuote) >= 0 {
+			quoted[i] = shellEscape(arg)
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+func needsQuote(r rune) bool {
+	return !isPathChar(byte(r)) && !strings.ContainsRune("/=:,", r) || r > 127
+}
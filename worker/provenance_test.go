@@ -0,0 +1,54 @@
+package worker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCommandLine(t *testing.T) {
+	args := []string{"docker", "run", "--env", "A=it's", "-v", "/a:/b:ro", "ubuntu", "sh", "-c", "echo $HOME", ""}
+	expected := `docker run --env 'A=it'"'"'s' -v /a:/b:ro ubuntu sh -c 'echo $HOME' ''`
+	if got := commandLine(args); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
+
+func TestSIFDigest(t *testing.T) {
+	dir := t.TempDir()
+	sif := filepath.Join(dir, "image.sif")
+	os.WriteFile(sif, []byte("v1"), 0644)
+	digest := func(b string) string {
+		sum := sha256.Sum256([]byte(b))
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+
+	if got := sifDigest(sif, false); got != digest("v1") {
+		t.Errorf("expected %s, got %s", digest("v1"), got)
+	}
+	if _, err := os.Stat(sif + ".sha256"); err == nil {
+		t.Error("expected the digest of an image which isn't cached not to be stored")
+	}
+
+	if got := sifDigest(sif, true); got != digest("v1") {
+		t.Errorf("expected %s, got %s", digest("v1"), got)
+	}
+	// The stored digest is used, until the image is pulled again.
+	os.WriteFile(sif+".sha256", []byte(digest("stored")[7:]), 0644)
+	if got := sifDigest(sif, true); got != digest("stored") {
+		t.Errorf("expected the stored digest, got %s", got)
+	}
+	later := time.Now().Add(time.Minute)
+	os.WriteFile(sif, []byte("v2"), 0644)
+	os.Chtimes(sif, later, later)
+	if got := sifDigest(sif, true); got != digest("v2") {
+		t.Errorf("expected %s, got %s", digest("v2"), got)
+	}
+
+	if got := sifDigest(filepath.Join(dir, "missing.sif"), true); got != "" {
+		t.Errorf("expected no digest, got %s", got)
+	}
+}
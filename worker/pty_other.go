@@ -0,0 +1,18 @@
+//go:build !linux
+
+package worker
+
+import (
+	"errors"
+	"os"
+)
+
+var errNoPty = errors.New("exec sessions with a terminal are only supported by Linux workers")
+
+func openPty() (master, slave *os.File, err error) {
+	return nil, nil, errNoPty
+}
+
+func setPtySize(pty *os.File, size TerminalSize) error {
+	return errNoPty
+}
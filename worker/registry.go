@@ -0,0 +1,224 @@
+package worker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/secrets"
+	"github.com/ohsu-comp-bio/funnel/tes"
+)
+
+// ImagePullPolicyTag overrides Worker.ImagePullPolicy for a task. The
+// registry secret of the task is named by secrets.RegistrySecretTag.
+const ImagePullPolicyTag = "_IMAGE_PULL_POLICY"
+
+// Image pull policies.
+const (
+	PullAlways       = "Always"
+	PullIfNotPresent = "IfNotPresent"
+	PullNever        = "Never"
+)
+
+// ImagePullError describes a failure to pull an executor image. It's a
+// system error: the executor didn't run.
+type ImagePullError struct {
+	Image string
+	Err   error
+}
+
+func (e *ImagePullError) Error() string {
+	return fmt.Sprintf("failed to pull image %s: %v", e.Image, e.Err)
+}
+
+func (e *ImagePullError) Unwrap() error {
+	return e.Err
+}
+
+// ParsePullPolicy returns the canonical name of an image pull policy,
+// or "" for the default policy of the executor.
+func ParsePullPolicy(s string) (string, error) {
+	for _, p := range []string{"", PullAlways, PullIfNotPresent, PullNever} {
+		if strings.EqualFold(s, p) {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("unknown image pull policy %q. Expected 'Always', 'IfNotPresent' or 'Never'", s)
+}
+
+// ImagePull describes how the executor images of a task are pulled.
+type ImagePull struct {
+	// "Always", "IfNotPresent", "Never", or "" for the executor default.
+	Policy string
+	// Registry credentials from the config.
+	Credentials []*config.RegistryCredential
+	// Docker config.json file of the task owner, if any.
+	SecretFile string
+	// Kubernetes image pull secrets.
+	Secrets []string
+}
+
+// NewImagePull returns the image pull settings of a task, from the worker
+// config and the task tags.
+func NewImagePull(conf *config.Worker, k8sSecrets []string, task *tes.Task) (*ImagePull, error) {
+	policy := conf.GetImagePullPolicy()
+	if v, ok := task.GetTags()[ImagePullPolicyTag]; ok {
+		policy = v
+	}
+	policy, err := ParsePullPolicy(policy)
+	if err != nil {
+		return nil, err
+	}
+	p := &ImagePull{
+		Policy:      policy,
+		Credentials: conf.GetRegistryCredentials(),
+		Secrets:     k8sSecrets,
+	}
+
+	secret := task.GetTags()[secrets.RegistrySecretTag]
+	if secret == "" {
+		return p, nil
+	}
+	p.Secrets = append(append([]string(nil), k8sSecrets...), secret)
+	if conf.GetRegistrySecretsDir() != "" {
+		if secret != filepath.Base(secret) || strings.HasPrefix(secret, ".") {
+			return nil, fmt.Errorf("invalid %s tag: %q", secrets.RegistrySecretTag, secret)
+		}
+		p.SecretFile = filepath.Join(conf.GetRegistrySecretsDir(), secret+".json")
+		if _, err := os.Stat(p.SecretFile); err != nil {
+			return nil, fmt.Errorf("registry secret %q: %v", secret, err)
+		}
+	}
+	return p, nil
+}
+
+// registryAuth is an entry of the "auths" of a Docker config.json file.
+type registryAuth struct {
+	Auth     string `json:"auth,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// dockerHubAuthKey is the key of Docker Hub credentials in config.json.
+const dockerHubAuthKey = "https://index.docker.io/v1/"
+
+// auths returns the registry credentials, keyed by registry host as in
+// Docker config.json files. The secret file of the task owner takes
+// precedence over the config.
+func (p *ImagePull) auths() (map[string]registryAuth, error) {
+	auths := map[string]registryAuth{}
+	if p == nil {
+		return auths, nil
+	}
+	for _, c := range p.Credentials {
+		password := c.Password
+		if c.PasswordFile != "" {
+			b, err := os.ReadFile(c.PasswordFile)
+			if err != nil {
+				return nil, fmt.Errorf("reading password of registry %s: %v", c.Registry, err)
+			}
+			password = strings.TrimSpace(string(b))
+		}
+		auths[authKey(c.Registry)] = registryAuth{
+			Auth: base64.StdEncoding.EncodeToString([]byte(c.Username + ":" + password)),
+		}
+	}
+	if p.SecretFile != "" {
+		b, err := os.ReadFile(p.SecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading registry secret: %v", err)
+		}
+		secret := struct {
+			Auths map[string]registryAuth `json:"auths"`
+		}{}
+		if err := json.Unmarshal(b, &secret); err != nil {
+			return nil, fmt.Errorf("parsing registry secret %s: %v", p.SecretFile, err)
+		}
+		for k, v := range secret.Auths {
+			auths[authKey(k)] = v
+		}
+	}
+	return auths, nil
+}
+
+// credentials returns the username and password for the registry host.
+func (p *ImagePull) credentials(host string) (username, password string, err error) {
+	auths, err := p.auths()
+	if err != nil {
+		return "", "", err
+	}
+	a, ok := auths[authKey(host)]
+	if !ok {
+		return "", "", nil
+	}
+	if a.Auth == "" {
+		return a.Username, a.Password, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(a.Auth)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid credentials of registry %s: %v", host, err)
+	}
+	username, password, _ = strings.Cut(string(b), ":")
+	return username, password, nil
+}
+
+// dockerConfig writes a Docker config.json file with the registry
+// credentials to a new temporary directory, for DOCKER_CONFIG. It returns ""
+// if there are no credentials.
+func (p *ImagePull) dockerConfig() (dir string, err error) {
+	auths, err := p.auths()
+	if err != nil || len(auths) == 0 {
+		return "", err
+	}
+	dir, err = os.MkdirTemp("", "funnel-docker-config-")
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(map[string]interface{}{"auths": auths})
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, "config.json"), b, 0600)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// authKey normalizes a registry host or URL to a config.json auths key.
+func authKey(registry string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(registry, "https://"), "http://")
+	host = strings.SplitN(host, "/", 2)[0]
+	switch host {
+	case "docker.io", "index.docker.io", "registry-1.docker.io", "":
+		return dockerHubAuthKey
+	}
+	return host
+}
+
+// imageRegistry returns the registry host of an image reference,
+// e.g. "ghcr.io" for "ghcr.io/org/tool:1.0", or "docker.io" for "ubuntu".
+func imageRegistry(image string) string {
+	image = strings.TrimPrefix(image, "docker://")
+	first, _, ok := strings.Cut(image, "/")
+	if ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return first
+	}
+	return "docker.io"
+}
+
+// imageRepository returns an image reference without its tag or digest,
+// and without the implicit Docker Hub prefixes, e.g. "ghcr.io/org/tool" for
+// "ghcr.io/org/tool:1.0", or "ubuntu" for "docker.io/library/ubuntu:24.04".
+func imageRepository(image string) string {
+	image, _, _ = strings.Cut(strings.TrimPrefix(image, "docker://"), "@")
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	image = strings.TrimPrefix(image, "docker.io/")
+	return strings.TrimPrefix(image, "library/")
+}
@@ -0,0 +1,236 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ohsu-comp-bio/funnel/config"
+	"github.com/ohsu-comp-bio/funnel/events"
+	"github.com/ohsu-comp-bio/funnel/secrets"
+	"github.com/ohsu-comp-bio/funnel/tes"
+	v1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestNewImagePull(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "user-alice.json"), []byte(`{"auths": {}}`), 0600)
+	conf := &config.Worker{ImagePullPolicy: "ifnotpresent", RegistrySecretsDir: dir}
+
+	p, err := NewImagePull(conf, []string{"shared"}, &tes.Task{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Policy != PullIfNotPresent || p.SecretFile != "" || len(p.Secrets) != 1 {
+		t.Errorf("unexpected image pull: %+v", p)
+	}
+
+	p, err = NewImagePull(conf, []string{"shared"}, &tes.Task{Tags: map[string]string{
+		ImagePullPolicyTag:        "Never",
+		secrets.RegistrySecretTag: "user-alice",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Policy != PullNever || p.SecretFile != filepath.Join(dir, "user-alice.json") ||
+		strings.Join(p.Secrets, ",") != "shared,user-alice" {
+		t.Errorf("unexpected image pull: %+v", p)
+	}
+
+	for _, tags := range []map[string]string{
+		{ImagePullPolicyTag: "Sometimes"},
+		{secrets.RegistrySecretTag: "../user-alice"},
+		{secrets.RegistrySecretTag: "user-bob"},
+	} {
+		if _, err := NewImagePull(conf, nil, &tes.Task{Tags: tags}); err == nil {
+			t.Errorf("expected an error for tags %v", tags)
+		}
+	}
+}
+
+func TestRegistryCredentials(t *testing.T) {
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "token")
+	os.WriteFile(passwordFile, []byte("ghcr-token\n"), 0600)
+	secretFile := filepath.Join(dir, "user-alice.json")
+	os.WriteFile(secretFile, []byte(`{"auths": {
+		"https://index.docker.io/v1/": {"auth": "YWxpY2U6aHVi"},
+		"quay.io": {"username": "alice", "password": "quay"}
+	}}`), 0600)
+
+	p := &ImagePull{
+		Credentials: []*config.RegistryCredential{
+			{Registry: "ghcr.io", Username: "bot", PasswordFile: passwordFile},
+			{Registry: "docker.io", Username: "bot", Password: "hub"},
+		},
+		SecretFile: secretFile,
+	}
+	for image, expected := range map[string]string{
+		"ghcr.io/org/tool:1.0":      "bot:ghcr-token",
+		"ubuntu":                    "alice:hub",
+		"docker://quay.io/org/tool": "alice:quay",
+		"localhost:5000/tool":       ":",
+	} {
+		u, pw, err := p.credentials(imageRegistry(image))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u+":"+pw != expected {
+			t.Errorf("credentials of %s: expected %q, got %q", image, expected, u+":"+pw)
+		}
+	}
+
+	configDir, err := p.dockerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(configDir)
+	b, _ := os.ReadFile(filepath.Join(configDir, "config.json"))
+	conf := struct{ Auths map[string]registryAuth }{}
+	if err := json.Unmarshal(b, &conf); err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.Auths) != 3 || conf.Auths[dockerHubAuthKey].Auth != "YWxpY2U6aHVi" {
+		t.Errorf("unexpected docker config: %s", b)
+	}
+
+	if dir, err := (&ImagePull{}).dockerConfig(); dir != "" || err != nil {
+		t.Errorf("expected no docker config without credentials, got %q, %v", dir, err)
+	}
+}
+
+func TestImageRepository(t *testing.T) {
+	for image, expected := range map[string]string{
+		"ubuntu":                           "ubuntu",
+		"ubuntu:24.04":                     "ubuntu",
+		"docker.io/library/ubuntu:24.04":   "ubuntu",
+		"localhost:5000/tool:1.0":          "localhost:5000/tool",
+		"ghcr.io/org/tool@sha256:abcd":     "ghcr.io/org/tool",
+		"docker://quay.io/org/tool:latest": "quay.io/org/tool",
+	} {
+		if got := imageRepository(image); got != expected {
+			t.Errorf("imageRepository(%s): expected %q, got %q", image, expected, got)
+		}
+	}
+}
+
+// fakeDocker is a docker driver which knows the images listed in the
+// "images" file, records pulls, and runs nothing.
+const fakeDocker = `#!/bin/sh
+dir=$(dirname "$0")
+case "$1 $2" in
+"image inspect")
+  grep -q "^$5\$" "$dir/images" 2>/dev/null || exit 1
+  case "$4" in
+  *RepoDigests*) echo "other/tool@sha256:0000 $(echo "$5" | cut -d: -f1)@sha256:1234" ;;
+  *) echo sha256:abcd ;;
+  esac ;;
+"pull "*)
+  echo "$2 $DOCKER_CONFIG" >> "$dir/pulls"
+  [ "$2" = missing ] && { echo "manifest unknown" >&2; exit 1; }
+  echo "$2" >> "$dir/images" ;;
+esac
+`
+
+func TestDockerPullPolicy(t *testing.T) {
+	dir := t.TempDir()
+	driver := filepath.Join(dir, "docker")
+	os.WriteFile(driver, []byte(fakeDocker), 0755)
+	md := metadataWriter{}
+
+	newDocker := func(image, policy string) *DockerCommand {
+		return &DockerCommand{
+			DriverCommand: driver,
+			PullCommand:   "pull {{.Image}}",
+			Event:         events.NewExecutorWriter("task", 0, 0, md),
+			Pull: &ImagePull{
+				Policy:      policy,
+				Credentials: []*config.RegistryCredential{{Registry: "docker.io", Username: "u", Password: "p"}},
+			},
+			Command: Command{Image: image},
+		}
+	}
+	pulls := func() string {
+		b, _ := os.ReadFile(filepath.Join(dir, "pulls"))
+		return string(b)
+	}
+
+	// Never: missing images are system errors.
+	var pullErr *ImagePullError
+	if err := newDocker("tool:1.0", PullNever).pullImage(context.Background()); !errors.As(err, &pullErr) {
+		t.Errorf("expected an ImagePullError, got %v", err)
+	}
+
+	// IfNotPresent: the image is pulled once, with credentials, and pinned.
+	for i := 0; i < 2; i++ {
+		d := newDocker("tool:1.0", PullIfNotPresent)
+		if err := d.pullImage(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if d.Image != "tool@sha256:1234" {
+			t.Errorf("expected the image to be pinned, got %s", d.Image)
+		}
+	}
+	if p := pulls(); strings.Count(p, "tool:1.0") != 1 || !strings.Contains(p, "funnel-docker-config-") {
+		t.Errorf("expected a single pull with credentials, got %q", p)
+	}
+	if md["executor.0.image_digest"] != "sha256:1234" {
+		t.Errorf("expected the image digest in the task metadata, got %v", md)
+	}
+
+	// Always (default): the image is pulled again.
+	d := newDocker("tool:1.0", "")
+	if err := d.pullImage(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if p := pulls(); strings.Count(p, "tool:1.0") != 2 {
+		t.Errorf("expected the image to be pulled again, got %q", p)
+	}
+
+	// Pull failures are system errors, with the output of the driver.
+	err := newDocker("missing", PullAlways).pullImage(context.Background())
+	if !errors.As(err, &pullErr) || !strings.Contains(err.Error(), "manifest unknown") {
+		t.Errorf("expected an ImagePullError, got %v", err)
+	}
+}
+
+// metadataWriter records the task metadata events.
+type metadataWriter map[string]string
+
+func (w metadataWriter) WriteEvent(ctx context.Context, ev *events.Event) error {
+	for k, v := range ev.GetMetadata().GetValue() {
+		w[k] = v
+	}
+	return nil
+}
+
+func (w metadataWriter) Close() {}
+
+func TestKubernetesImagePull(t *testing.T) {
+	job := &v1.Job{}
+	job.Spec.Template.Spec.Containers = []corev1.Container{{Name: "executor"}}
+	kcmd := &KubernetesCommand{Pull: &ImagePull{Policy: PullIfNotPresent, Secrets: []string{"shared", "alice"}}}
+	kcmd.applyImagePull(job)
+
+	spec := job.Spec.Template.Spec
+	if spec.Containers[0].ImagePullPolicy != corev1.PullIfNotPresent {
+		t.Errorf("unexpected pull policy: %s", spec.Containers[0].ImagePullPolicy)
+	}
+	if len(spec.ImagePullSecrets) != 2 || spec.ImagePullSecrets[1].Name != "alice" {
+		t.Errorf("unexpected image pull secrets: %v", spec.ImagePullSecrets)
+	}
+
+	if err := imagePullFailure(&corev1.ContainerStateWaiting{Reason: "ErrImagePull"}); err != nil {
+		t.Errorf("expected ErrImagePull to be retried, got %v", err)
+	}
+	err := imagePullFailure(&corev1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: "not found"})
+	var pullErr *ImagePullError
+	if !errors.As(err, &pullErr) {
+		t.Errorf("expected an ImagePullError, got %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package worker
 import (
 	"github.com/ohsu-comp-bio/funnel/config"
 	"github.com/ohsu-comp-bio/funnel/tes"
+	"github.com/ohsu-comp-bio/funnel/tracing"
 	util "github.com/ohsu-comp-bio/funnel/util/rpc"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
@@ -25,8 +26,10 @@ func NewRPCTaskReader(ctx context.Context, conf *config.RPCClient, taskID string
 	return &RPCTaskReader{cli, conn, taskID}, nil
 }
 
-// Task returns the task descriptor.
+// Task returns the task descriptor. The call is in the trace of the task,
+// whose context is sent in its metadata.
 func (r *RPCTaskReader) Task(ctx context.Context) (*tes.Task, error) {
+	ctx = tracing.TaskContext(ctx, r.taskID)
 	return r.client.GetTask(ctx, &tes.GetTaskRequest{
 		Id:   r.taskID,
 		View: tes.View_FULL.String(),
@@ -0,0 +1,200 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ohsu-comp-bio/funnel/events"
+	"github.com/ohsu-comp-bio/funnel/secrets"
+	"github.com/ohsu-comp-bio/funnel/tes"
+	"google.golang.org/protobuf/proto"
+)
+
+// secretFile is a secret input, written to the task directory and mounted
+// read-only in the executors.
+type secretFile struct {
+	Path  string
+	Value []byte
+}
+
+// resolveSecrets returns a copy of the task in which the secret references
+// of the executor env values, e.g. "secret://db-password", are replaced with
+// the secret values, and the secret inputs, e.g. an input with the URL
+// "secret://tls-key", are removed and returned as secret files. The values
+// are added to the redacted values of the event writer.
+//
+// The task itself is unchanged, so that the secrets are never written to the
+// database. The server checked that the owner of the task may use its
+// secrets when the task was created.
+func resolveSecrets(ctx context.Context, store secrets.Store, task *tes.Task, redact *events.RedactWriter) (*tes.Task, []secretFile, error) {
+	if !hasSecrets(task) {
+		return task, nil, nil
+	}
+	if store == nil {
+		return nil, nil, errors.New("the task references secrets, but no Secrets.Backend is configured")
+	}
+
+	values := map[string][]byte{}
+	get := func(name string) ([]byte, error) {
+		if v, ok := values[name]; ok {
+			return v, nil
+		}
+		if err := secrets.ValidateName(name); err != nil {
+			return nil, err
+		}
+		v, err := store.Get(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("secret %q: %w", name, err)
+		}
+		values[name] = v
+		redact.Redact(redactedValues(v)...)
+		return v, nil
+	}
+
+	resolved := proto.Clone(task).(*tes.Task)
+	for _, ex := range resolved.GetExecutors() {
+		for k, v := range ex.Env {
+			name, ok := secrets.ParseRef(v)
+			if !ok {
+				continue
+			}
+			value, err := get(name)
+			if err != nil {
+				return nil, nil, err
+			}
+			ex.Env[k] = envValue(value)
+		}
+	}
+
+	var files []secretFile
+	var inputs []*tes.Input
+	for _, in := range resolved.GetInputs() {
+		name, ok := secrets.ParseRef(in.GetUrl())
+		if !ok {
+			inputs = append(inputs, in)
+			continue
+		}
+		if in.GetType() == tes.Directory {
+			return nil, nil, fmt.Errorf("secret input %s must be a file", in.GetPath())
+		}
+		value, err := get(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, secretFile{Path: in.GetPath(), Value: value})
+	}
+	resolved.Inputs = inputs
+	return resolved, files, nil
+}
+
+// secretEnvKeys returns, for each executor of the task, the keys of the env
+// values which reference secrets.
+func secretEnvKeys(task *tes.Task) []map[string]bool {
+	keys := make([]map[string]bool, len(task.GetExecutors()))
+	for i, ex := range task.GetExecutors() {
+		for k, v := range ex.GetEnv() {
+			if _, ok := secrets.ParseRef(v); ok {
+				if keys[i] == nil {
+					keys[i] = map[string]bool{}
+				}
+				keys[i][k] = true
+			}
+		}
+	}
+	return keys
+}
+
+// plainEnv returns the env of the command without the secret values.
+func (c *Command) plainEnv() map[string]string {
+	if len(c.SecretEnv) == 0 {
+		return c.Env
+	}
+	env := map[string]string{}
+	for k, v := range c.Env {
+		if !c.SecretEnv[k] {
+			env[k] = v
+		}
+	}
+	return env
+}
+
+// hasSecrets reports whether the task references secrets.
+func hasSecrets(task *tes.Task) bool {
+	for _, ex := range task.GetExecutors() {
+		for _, v := range ex.GetEnv() {
+			if _, ok := secrets.ParseRef(v); ok {
+				return true
+			}
+		}
+	}
+	for _, in := range task.GetInputs() {
+		if _, ok := secrets.ParseRef(in.GetUrl()); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// envValue returns the value of a secret as an env value, without the
+// trailing newline of files written by e.g. "echo".
+func envValue(v []byte) string {
+	return strings.TrimSuffix(strings.TrimSuffix(string(v), "\n"), "\r")
+}
+
+// redactedValues returns the strings to redact for a secret value: the value,
+// and the lines of multi-line values, e.g. a PEM key printed line by line.
+// The values containing single quotes are also redacted as they're quoted in
+// the command lines of the executors (see commandLine).
+func redactedValues(v []byte) []string {
+	values := []string{envValue(v)}
+	if lines := strings.Split(envValue(v), "\n"); len(lines) > 1 {
+		for _, line := range lines {
+			// Short lines, e.g. "-----END KEY-----", aren't secret.
+			if line = strings.TrimSpace(line); len(line) >= 8 {
+				values = append(values, line)
+			}
+		}
+	}
+	for _, value := range values {
+		if strings.Contains(value, "'") {
+			values = append(values, strings.ReplaceAll(value, "'", escapedQuote))
+		}
+	}
+	return values
+}
+
+// AddSecretFile writes a secret file to the task directory, or the scratch
+// directory, and mounts it read-only at the given container path. The file
+// is removed by RemoveSecretFiles, even if the working directory is kept.
+func (mapper *FileMapper) AddSecretFile(path string, value []byte) error {
+	hostPath, err := mapper.HostPath(path)
+	if mapper.ScratchDir != "" {
+		hostPath, err = mapper.HostScratchPath(path)
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(hostPath), 0775); err != nil {
+		return err
+	}
+	// The file may be left read-only by a previous attempt.
+	os.Remove(hostPath)
+	mapper.SecretFiles = append(mapper.SecretFiles, hostPath)
+	if err := os.WriteFile(hostPath, value, 0444); err != nil {
+		return fmt.Errorf("writing secret file %s: %v", path, err)
+	}
+	_, err = mapper.AddVolume(hostPath, path, true)
+	return err
+}
+
+// RemoveSecretFiles removes the secret files of the task.
+func (mapper *FileMapper) RemoveSecretFiles() {
+	for _, p := range mapper.SecretFiles {
+		os.Remove(p)
+	}
+	mapper.SecretFiles = nil
+}
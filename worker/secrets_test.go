@@ -0,0 +1,228 @@
+package worker
+
+import (
+	"context"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ohsu-comp-bio/funnel/events"
+	"github.com/ohsu-comp-bio/funnel/secrets"
+	"github.com/ohsu-comp-bio/funnel/tes"
+	v1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// secretStore is an in-memory secrets.Store.
+type secretStore map[string]string
+
+func (s secretStore) Get(ctx context.Context, name string) ([]byte, error) {
+	v, ok := s[name]
+	if !ok {
+		return nil, secrets.ErrNotFound
+	}
+	return []byte(v), nil
+}
+
+// captureWriter records the events written.
+type captureWriter struct {
+	events []*events.Event
+}
+
+func (w *captureWriter) WriteEvent(ctx context.Context, ev *events.Event) error {
+	w.events = append(w.events, ev)
+	return nil
+}
+
+func (w *captureWriter) Close() {}
+
+func TestResolveSecrets(t *testing.T) {
+	ctx := context.Background()
+	store := secretStore{"db-password": "hunter22\n", "tls-key": "-----BEGIN KEY-----\nMIIEvQIBADANBg\n-----END KEY-----\n"}
+	task := &tes.Task{
+		Inputs: []*tes.Input{
+			{Url: "secret://tls-key", Path: "/etc/tls/key.pem"},
+			{Url: "s3://bucket/data.txt", Path: "/data/data.txt"},
+		},
+		Executors: []*tes.Executor{{
+			Image: "alpine",
+			Env:   map[string]string{"DB_PASSWORD": "secret://db-password", "MODE": "test"},
+		}},
+	}
+	redact := &events.RedactWriter{Writer: &captureWriter{}}
+
+	resolved, files, err := resolveSecrets(ctx, store, task, redact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := resolved.Executors[0].Env["DB_PASSWORD"]; v != "hunter22" {
+		t.Errorf("unexpected env value: %q", v)
+	}
+	if v := resolved.Executors[0].Env["MODE"]; v != "test" {
+		t.Errorf("unexpected env value: %q", v)
+	}
+	if len(resolved.Inputs) != 1 || resolved.Inputs[0].Path != "/data/data.txt" {
+		t.Errorf("unexpected inputs: %v", resolved.Inputs)
+	}
+	if len(files) != 1 || files[0].Path != "/etc/tls/key.pem" || string(files[0].Value) != store["tls-key"] {
+		t.Errorf("unexpected secret files: %v", files)
+	}
+
+	// The task itself only references the secrets.
+	if task.Executors[0].Env["DB_PASSWORD"] != "secret://db-password" || len(task.Inputs) != 2 {
+		t.Errorf("task was modified: %v", task)
+	}
+
+	if _, _, err := resolveSecrets(ctx, nil, task, redact); err == nil {
+		t.Error("expected error without a secrets store")
+	}
+	if _, _, err := resolveSecrets(ctx, secretStore{}, task, redact); err == nil {
+		t.Error("expected error for a missing secret")
+	}
+	noSecrets := &tes.Task{Executors: []*tes.Executor{{Image: "alpine"}}}
+	if r, _, err := resolveSecrets(ctx, nil, noSecrets, redact); err != nil || r != noSecrets {
+		t.Errorf("unexpected result for a task without secrets: %v %v", r, err)
+	}
+}
+
+func TestSecretEnv(t *testing.T) {
+	task := &tes.Task{Executors: []*tes.Executor{
+		{Env: map[string]string{"TOKEN": "secret://token", "MODE": "test"}},
+		{Env: map[string]string{"MODE": "test"}},
+	}}
+	keys := secretEnvKeys(task)
+	if len(keys) != 2 || !keys[0]["TOKEN"] || keys[0]["MODE"] || keys[1] != nil {
+		t.Fatalf("unexpected secret env keys: %v", keys)
+	}
+	cmd := Command{
+		Image:     "alpine",
+		Env:       map[string]string{"TOKEN": "hunter22", "MODE": "test"},
+		SecretEnv: keys[0],
+	}
+
+	// Docker passes the secret values in the environment of the driver.
+	d := DockerCommand{DriverCommand: "docker", RunCommand: "run {{.GetEnvArgs}} {{.Image}}", Command: cmd}
+	c, err := d.command(context.Background(), d.RunCommand, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := strings.Join(c.Args, " ")
+	if strings.Contains(args, "hunter22") || !strings.Contains(args, "--env TOKEN ") || !strings.Contains(args, "--env MODE=test") {
+		t.Errorf("unexpected docker command line: %s", args)
+	}
+	if !slices.Contains(c.Env, "TOKEN=hunter22") {
+		t.Error("expected the secret in the environment of the driver")
+	}
+
+	// Kubernetes references the secret values from a Secret owned by the job.
+	job := &v1.Job{ObjectMeta: metav1.ObjectMeta{Name: "task-0", UID: "uid"}}
+	job.Spec.Template.Spec.Containers = []corev1.Container{{Name: "executor"}}
+	kcmd := &KubernetesCommand{
+		TaskId:        "task",
+		JobsNamespace: "jobs",
+		Command:       cmd,
+		Sidecars:      []*KubernetesSidecar{{Name: "executor-1", Command: cmd}},
+	}
+	kcmd.applySidecars(job)
+	secret := kcmd.applySecretEnv(job)
+	if secret == nil || secret.Name != "task-0-env" || string(secret.Data["executor.TOKEN"]) != "hunter22" ||
+		string(secret.Data["executor-1.TOKEN"]) != "hunter22" {
+		t.Fatalf("unexpected secret: %v", secret)
+	}
+	for _, c := range append(job.Spec.Template.Spec.Containers, job.Spec.Template.Spec.InitContainers...) {
+		for _, env := range c.Env {
+			if env.Value == "hunter22" {
+				t.Errorf("container %s has a secret value", c.Name)
+			}
+			if env.Name == "TOKEN" && (env.ValueFrom == nil || env.ValueFrom.SecretKeyRef.Name != "task-0-env") {
+				t.Errorf("unexpected secret env of container %s: %v", c.Name, env)
+			}
+		}
+	}
+
+	clientset := fake.NewSimpleClientset()
+	for i := 0; i < 2; i++ {
+		if err := createSecret(context.Background(), clientset, secret, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	created, err := clientset.CoreV1().Secrets("jobs").Get(context.Background(), "task-0-env", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created.OwnerReferences) != 1 || created.OwnerReferences[0].UID != "uid" {
+		t.Errorf("expected the secret to be owned by the job, got %v", created.OwnerReferences)
+	}
+
+	noSecrets := &KubernetesCommand{Command: Command{Env: map[string]string{"MODE": "test"}}}
+	if noSecrets.applySecretEnv(job) != nil {
+		t.Error("expected no secret without secret env values")
+	}
+}
+
+func TestRedactWriter(t *testing.T) {
+	w := &captureWriter{}
+	redact := &events.RedactWriter{Writer: w}
+	redact.Redact(redactedValues([]byte("hunter22\n"))...)
+	redact.Redact(redactedValues([]byte("-----BEGIN KEY-----\nMIIEvQIBADANBg\n-----END KEY-----"))...)
+	redact.Redact(redactedValues([]byte("it's a secret"))...)
+
+	ex := events.NewExecutorWriter("task", 0, 0, redact)
+	ex.Stdout("password: hunter22\n")
+	ex.Stderr("MIIEvQIBADANBg")
+	ex.Metadata(map[string]string{"commandLine": "login --password hunter22"})
+	// The quoted secrets of command lines are redacted.
+	ex.Metadata(map[string]string{"commandLine": commandLine([]string{"login", "--password=it's a secret"})})
+	tw := events.NewTaskWriter("task", 0, redact)
+	tw.Info("connected", "password", "hunter22")
+
+	for _, ev := range w.events {
+		s := ev.String()
+		if strings.Contains(s, "hunter22") || strings.Contains(s, "MIIEvQIBADANBg") || strings.Contains(s, "secret") {
+			t.Errorf("event contains a secret: %s", s)
+		}
+		if !strings.Contains(s, events.Redacted) {
+			t.Errorf("event wasn't redacted: %s", s)
+		}
+	}
+	if len(w.events) != 5 {
+		t.Errorf("expected 5 events, got %d", len(w.events))
+	}
+}
+
+func TestAddSecretFile(t *testing.T) {
+	mapper := NewFileMapper(t.TempDir())
+	if err := mapper.AddSecretFile("/etc/tls/key.pem", []byte("key")); err != nil {
+		t.Fatal(err)
+	}
+	// Secret files are replaced on retries.
+	if err := mapper.AddSecretFile("/etc/tls/key.pem", []byte("key")); err != nil {
+		t.Fatal(err)
+	}
+
+	hostPath, _ := mapper.HostPath("/etc/tls/key.pem")
+	info, err := os.Stat(hostPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0444 {
+		t.Errorf("unexpected mode: %v", info.Mode())
+	}
+	found := false
+	for _, v := range mapper.Volumes {
+		if v.HostPath == hostPath && v.ContainerPath == "/etc/tls/key.pem" && v.Readonly {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected a read-only volume, got %v", mapper.Volumes)
+	}
+
+	mapper.RemoveSecretFiles()
+	if _, err := os.Stat(hostPath); !os.IsNotExist(err) {
+		t.Errorf("expected the secret file to be removed, got %v", err)
+	}
+}
@@ -9,6 +9,9 @@ import (
 
 	"github.com/ohsu-comp-bio/funnel/config"
 	"github.com/ohsu-comp-bio/funnel/events"
+	"github.com/ohsu-comp-bio/funnel/logs"
+	"github.com/ohsu-comp-bio/funnel/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type stepWorker struct {
@@ -16,9 +19,22 @@ type stepWorker struct {
 	Command TaskCommand
 	Event   *events.ExecutorWriter
 	IP      string
+	// Serves the attach and exec sessions of the executor, if not nil.
+	Attach *attachHub
+	// Archives the complete stdout and stderr of the executor, if not nil.
+	LogArchive *LogArchive
+	// Redacts the secrets of the task from the archived logs, if not nil.
+	Redact *events.RedactWriter
+	TaskID string
+	Index  int
+	// Records the exit code of the executor, if not nil.
+	Checkpoint *checkpoint
 }
 
-func (s *stepWorker) Run(ctx context.Context) error {
+func (s *stepWorker) Run(ctx context.Context) (runerr error) {
+	ctx, span := tracing.Start(ctx, "RunExecutor", attribute.Int("funnel.executor.index", s.Index))
+	defer func() { tracing.End(span, runerr) }()
+
 	// WaitGroup to block closing the worker until the last event is written from events/executor.go:StreamLogTail()
 	var wg sync.WaitGroup
 	defer wg.Wait()
@@ -54,6 +70,18 @@ func (s *stepWorker) Run(ctx context.Context) error {
 		s.Command.SetStderr(stderr)
 	}
 
+	// The archive writes from a context which outlives the cancelation of
+	// the task, so that the logs of canceled executors are archived too.
+	var archive []*logs.Writer
+	if s.LogArchive != nil {
+		stdout, stderr, archive = s.LogArchive.writers(subctx, s.TaskID, s.Index, s.Redact, stdout, stderr)
+	}
+
+	if s.Attach != nil {
+		stdout, stderr = s.Attach.writers(s.Index, stdout, stderr)
+		s.Attach.start(s.Index, s.Command)
+	}
+
 	s.Command.SetStdout(stdout)
 	s.Command.SetStderr(stderr)
 
@@ -67,18 +95,39 @@ func (s *stepWorker) Run(ctx context.Context) error {
 			// Likely the task was canceled.
 			s.Command.Stop()
 			<-done
+			s.closeLogArchive(archive)
 			s.Event.EndTime(time.Now())
+			if s.Attach != nil {
+				s.Attach.end(s.Index, ctx.Err())
+			}
 			return ctx.Err()
 
 		case result := <-done:
+			s.closeLogArchive(archive)
 			s.Event.EndTime(time.Now())
+			if s.Attach != nil {
+				s.Attach.end(s.Index, result)
+			}
 			exitcode, err := getExitCode(result)
 			if err != nil {
 				s.Event.Error(err.Error())
 			} else {
 				s.Event.ExitCode(exitcode)
+				if err := s.Checkpoint.addExecutor(s.Index, exitcode); err != nil {
+					s.Event.Error("failed to record the exit code in the checkpoint", "error", err)
+				}
 			}
 			return result
 		}
 	}
 }
+
+// closeLogArchive writes the last chunks of the archived logs. The executor
+// doesn't fail when its logs can't be archived.
+func (s *stepWorker) closeLogArchive(archive []*logs.Writer) {
+	for _, w := range archive {
+		if err := w.Close(); err != nil {
+			s.Event.Error("failed to archive the executor logs", "error", err)
+		}
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/ohsu-comp-bio/funnel/events"
 	"github.com/ohsu-comp-bio/funnel/storage"
 	"github.com/ohsu-comp-bio/funnel/tes"
+	"github.com/ohsu-comp-bio/funnel/tracing"
 	"github.com/ohsu-comp-bio/funnel/util"
 	"github.com/ohsu-comp-bio/funnel/util/fsutil"
 	"google.golang.org/protobuf/proto"
@@ -51,6 +52,15 @@ func FlattenInputs(ctx context.Context, inputs []*tes.Input, store storage.Stora
 
 // DownloadInputs downloads the given inputs.
 func DownloadInputs(pctx context.Context, inputs []*tes.Input, store storage.Storage, ev *events.TaskWriter, parallelLimit int) error {
+	return downloadInputs(pctx, inputs, store, ev, parallelLimit, nil)
+}
+
+// downloadInputs downloads the given inputs, except those downloaded by a
+// previous attempt of the task, and records them in the checkpoint.
+func downloadInputs(pctx context.Context, inputs []*tes.Input, store storage.Storage, ev *events.TaskWriter, parallelLimit int, cp *checkpoint) (err error) {
+
+	pctx, span := tracing.Start(pctx, "DownloadInputs")
+	defer func() { tracing.End(span, err) }()
 
 	ctx, cancel := context.WithCancel(pctx)
 	defer cancel()
@@ -62,10 +72,15 @@ func DownloadInputs(pctx context.Context, inputs []*tes.Input, store storage.Sto
 
 	var downloads []storage.Transfer
 	for _, input := range flat {
+		if cp.downloaded(input.Path) {
+			ev.Info("download skipped, finished by a previous attempt", "url", input.Url)
+			continue
+		}
 		downloads = append(downloads, storage.Transfer(&download{
 			ev:     ev,
 			in:     input,
 			cancel: cancel,
+			cp:     cp,
 		}))
 	}
 
@@ -119,7 +134,10 @@ func FlattenOutputs(ctx context.Context, outputs []*tes.Output, store storage.St
 }
 
 // UploadOutputs uploads the outputs.
-func UploadOutputs(ctx context.Context, outputs []*tes.Output, store storage.Storage, ev *events.TaskWriter, parallelLimit int) ([]*tes.OutputFileLog, error) {
+func UploadOutputs(ctx context.Context, outputs []*tes.Output, store storage.Storage, ev *events.TaskWriter, parallelLimit int) (_ []*tes.OutputFileLog, err error) {
+
+	ctx, span := tracing.Start(ctx, "UploadOutputs")
+	defer func() { tracing.End(span, err) }()
 
 	flat, err := FlattenOutputs(ctx, outputs, store, ev)
 	if err != nil {
@@ -154,6 +172,7 @@ type download struct {
 	in     *tes.Input
 	err    error
 	cancel context.CancelFunc
+	cp     *checkpoint
 }
 
 func (d *download) URL() string {
@@ -167,6 +186,9 @@ func (d *download) Started() {
 }
 func (d *download) Finished(obj *storage.Object) {
 	d.ev.Info("download finished", "url", d.in.Url, "size", obj.Size, "etag", obj.ETag)
+	if err := d.cp.addInput(d.in.Path); err != nil {
+		d.ev.Warn("failed to record the download in the checkpoint", "url", d.in.Url, "error", err)
+	}
 }
 func (d *download) Failed(err error) {
 	d.ev.Error("download failed", "url", d.in.Url, "error", err)
@@ -2,6 +2,7 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os/exec"
 	"runtime/debug"
@@ -62,3 +63,27 @@ func (h *helper) ok() bool {
 	}
 	return h.syserr == nil && h.execerr == nil
 }
+
+// stepError records the error of an executor, as a system error if the
+// executor couldn't run, or an executor error otherwise.
+func (h *helper) stepError(err error) {
+	// TODO: Change this to check the exit code
+	var k8sSystemErr *K8sSystemErr
+	var execErr *K8sExecutorErr
+	var pullErr *ImagePullError
+
+	switch {
+	// K8s System error
+	case errors.As(err, &k8sSystemErr):
+		h.syserr = err
+	// The executor image couldn't be pulled
+	case errors.As(err, &pullErr):
+		h.syserr = err
+	// K8s Executor error
+	case errors.As(err, &execErr):
+		h.execerr = err
+	// Local (Docker) Executor error
+	default:
+		h.execerr = err
+	}
+}
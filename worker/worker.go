@@ -3,17 +3,20 @@ package worker
 
 import (
 	"context"
-	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
 	"time"
 
+	"github.com/ohsu-comp-bio/funnel/attach"
 	"github.com/ohsu-comp-bio/funnel/config"
 	"github.com/ohsu-comp-bio/funnel/events"
+	"github.com/ohsu-comp-bio/funnel/secrets"
 	"github.com/ohsu-comp-bio/funnel/storage"
 	"github.com/ohsu-comp-bio/funnel/tes"
+	"github.com/ohsu-comp-bio/funnel/tracing"
 	"github.com/ohsu-comp-bio/funnel/version"
 )
 
@@ -26,12 +29,24 @@ type DefaultWorker struct {
 	Store       storage.Storage
 	TaskReader  TaskReader
 	EventWriter events.Writer
+	// Store of the secrets referenced by tasks, if any.
+	Secrets secrets.Store
+	// Connects to the server while running a task, to serve the attach and
+	// exec sessions of the clients. Disabled if nil.
+	Attach attach.AttachServiceClient
+	// Archives the complete stdout and stderr of the executors. Disabled if
+	// nil.
+	LogArchive *LogArchive
+	// The task resumes when the worker runs again, e.g. on a node, which
+	// restarts with the tasks assigned to it: canceling the context of Run
+	// interrupts the task, without ending it or removing its work directory.
+	Resumable bool
 	Command
 }
 
 // Configuration of the task executor.
 type Executor struct {
-	// "docker" or "kubernetes"
+	// "docker", "apptainer", "process" or "kubernetes"
 	Backend string
 	// Kubernetes executor template
 	Template string
@@ -57,6 +72,8 @@ type Executor struct {
 	Tolerations []map[string]interface{}
 	// Resources specifies the default resource requirements for Kubernetes jobs.
 	Resources *config.KubernetesResources
+	// Kubernetes image pull secrets of the executor jobs
+	ImagePullSecrets []string
 }
 
 // Run runs the Worker.
@@ -77,6 +94,7 @@ func (r *DefaultWorker) Run(pctx context.Context) (runerr error) {
 	var mapper *FileMapper
 	var run helper
 	var task *tes.Task
+	var cp *checkpoint
 
 	task, run.syserr = r.TaskReader.Task(pctx)
 	// TODO if we failed to retrieve the task, we can't do anything useful.
@@ -91,7 +109,9 @@ func (r *DefaultWorker) Run(pctx context.Context) (runerr error) {
 	}
 
 	// set up task specific utilities
-	event = events.NewTaskWriter(task.GetId(), 0, r.EventWriter)
+	// The values of the task secrets are redacted from all the task events.
+	redact := &events.RedactWriter{Writer: r.EventWriter}
+	event = events.NewTaskWriter(task.GetId(), 0, redact)
 	mapper = NewFileMapper(filepath.Join(r.Conf.WorkDir, task.GetId()))
 	if r.Conf.ScratchPath != "" {
 		scratchAbsDir, err := filepath.Abs(r.Conf.ScratchPath)
@@ -109,6 +129,10 @@ func (r *DefaultWorker) Run(pctx context.Context) (runerr error) {
 		event.Metadata(map[string]string{"hostname": name})
 	}
 
+	// The span of the run, in the trace of the task started by the server.
+	pctx, span := tracing.Start(tracing.TaskContext(pctx, task.GetId()), "RunTask",
+		tracing.TaskIDKey.String(task.GetId()))
+
 	// TODO: Increment taskgroup waitgroup, defer done on waitgroup
 	// Decrement taskgroup waitgroup on exit to make sure that the final logging step is called
 	// before the worker is closed (and the backend database connection is closed).
@@ -116,8 +140,16 @@ func (r *DefaultWorker) Run(pctx context.Context) (runerr error) {
 	// Run the final logging/state steps in a deferred function
 	// to ensure they always run, even if there's a missed error.
 	defer func() {
-		event.EndTime(time.Now())
+		// The node is shutting down, e.g. to restart: the task isn't
+		// finished, and resumes from its checkpoint when the node restarts.
+		interrupted := r.Resumable && !run.taskCanceled && pctx.Err() != nil
+		if !interrupted {
+			event.EndTime(time.Now())
+		}
 		switch {
+		case interrupted:
+			event.Info("Interrupted by the shutdown of the node")
+			runerr = fmt.Errorf("task interrupted: %w", pctx.Err())
 		case run.taskCanceled:
 			// The task was canceled.
 			event.Info("Canceled")
@@ -136,10 +168,13 @@ func (r *DefaultWorker) Run(pctx context.Context) (runerr error) {
 		default:
 			event.State(tes.State_COMPLETE)
 		}
+		tracing.End(span, runerr)
 
 		// cleanup workdir
-		if !r.Conf.LeaveWorkDir {
+		mapper.RemoveSecretFiles()
+		if !r.Conf.LeaveWorkDir && !interrupted {
 			mapper.Cleanup()
+			cp.remove()
 		}
 	}()
 
@@ -152,6 +187,36 @@ func (r *DefaultWorker) Run(pctx context.Context) (runerr error) {
 	ctx := r.pollForCancel(pctx, func() { run.taskCanceled = true })
 	run.ctx = ctx
 
+	// Serve the attach and exec sessions of the executors.
+	hub := newAttachHub(len(task.GetExecutors()))
+	hub.DisableExec = r.Conf.DisableExec
+	hub.Redact = redact
+	hub.Event = event
+
+	// Progress of a previous attempt of the task on this node, e.g. before a
+	// restart of the node, if its work directory still exists.
+	if run.ok() && !r.Conf.DisableCheckpoints {
+		var fingerprint string
+		fingerprint, run.syserr = taskFingerprint(task)
+		if run.ok() {
+			cp = loadCheckpoint(checkpointPath(mapper.WorkDir), mapper.WorkDir, fingerprint)
+			if cp.resumed() {
+				event.Info("Resuming the progress of a previous attempt", "inputs", len(cp.Inputs), "executors", len(cp.Executors))
+			}
+		}
+	}
+
+	// Resolve the secrets referenced by the task, in a copy of the task.
+	var secretFiles []secretFile
+	secretEnv := secretEnvKeys(task)
+	if run.ok() {
+		var resolved *tes.Task
+		resolved, secretFiles, run.syserr = resolveSecrets(ctx, r.Secrets, task, redact)
+		if run.ok() {
+			task = resolved
+		}
+	}
+
 	// Prepare file mapper, which maps task file URLs to host filesystem paths
 	if run.ok() {
 		run.syserr = mapper.MapTask(task)
@@ -163,13 +228,20 @@ func (r *DefaultWorker) Run(pctx context.Context) (runerr error) {
 
 	// Download inputs
 	if run.ok() {
-		run.syserr = DownloadInputs(ctx, mapper.Inputs, r.Store, event, int(r.Conf.MaxParallelTransfers))
+		run.syserr = downloadInputs(ctx, mapper.Inputs, r.Store, event, int(r.Conf.MaxParallelTransfers), cp)
 	}
 
 	if run.ok() {
 		event.State(tes.State_RUNNING)
 	}
 
+	// The server accepts the worker of a task once the task runs.
+	if run.ok() && r.Attach != nil {
+		attachCtx, stopAttach := context.WithCancel(ctx)
+		defer stopAttach()
+		go hub.serve(attachCtx, r.Attach, task.GetId())
+	}
+
 	// Create symlinks between the working directory and the Scratch Directory
 	if run.ok() && r.Conf.ScratchPath != "" {
 		err := mapper.CopyInputsToScratch(r.Conf.ScratchPath)
@@ -189,6 +261,70 @@ func (r *DefaultWorker) Run(pctx context.Context) (runerr error) {
 
 	}
 
+	// Write the secret files, mounted read-only in the executors.
+	for _, f := range secretFiles {
+		if run.ok() {
+			run.syserr = mapper.AddSecretFile(f.Path, f.Value)
+		}
+	}
+
+	// Image pull policy and registry credentials of the task.
+	var pull *ImagePull
+	if run.ok() {
+		pull, run.syserr = NewImagePull(r.Conf, r.Executor.ImagePullSecrets, task)
+	}
+
+	// Executors running in the background, alongside the following ones.
+	var background map[int]bool
+	if run.ok() {
+		background, run.syserr = backgroundExecutors(task)
+	}
+
+	// Groups of executors running in parallel.
+	var groups []int
+	if run.ok() {
+		groups, run.syserr = executorGroups(task, background)
+	}
+
+	// Network policy of the executors.
+	var network *config.Network
+	if run.ok() {
+		network, run.syserr = config.TaskNetwork(r.Conf, task)
+	}
+	if run.ok() {
+		run.syserr = checkNetwork(r.Executor.Backend, network, task)
+	}
+
+	// Docker executors (the default backend) share a network of the task
+	// with the background executors, which are reachable at
+	// "executor-<index>".
+	var dockerNet *dockerNetwork
+	switch r.Executor.Backend {
+	case "kubernetes", "apptainer", "process":
+	default:
+		if run.ok() {
+			dockerNet, run.syserr = newDockerNetwork(r.Conf, network, task.GetId(), len(background) > 0)
+		}
+	}
+	// Containers and network left by a previous attempt, if the worker was
+	// killed.
+	if run.ok() && dockerNet != nil && cp.resumed() {
+		var names []string
+		for i := range task.GetExecutors() {
+			names = append(names, fmt.Sprintf("%s-%d", task.Id, i))
+		}
+		removeDockerContainers(r.Conf.Container.DriverCommand, names...)
+		if dockerNet.Create {
+			removeDockerNetwork(r.Conf.Container.DriverCommand, dockerNet.Name)
+		}
+	}
+	if run.ok() && dockerNet != nil && dockerNet.Create {
+		run.syserr = createDockerNetwork(ctx, r.Conf.Container.DriverCommand, dockerNet.Name, dockerNet.Internal)
+		if run.syserr == nil {
+			defer removeDockerNetwork(r.Conf.Container.DriverCommand, dockerNet.Name)
+		}
+	}
+
 	// Run steps
 	if run.ok() {
 		var resources = task.GetResources()
@@ -197,19 +333,36 @@ func (r *DefaultWorker) Run(pctx context.Context) (runerr error) {
 		}
 
 		ignoreError := false
+		var backgroundSteps []*backgroundStep
+		var group *stepGroup
+		// Background executors run as sidecars in the following kubernetes
+		// executor jobs.
+		var sidecars []*KubernetesSidecar
 		for i, d := range task.GetExecutors() {
+			// The executors of a group share the task resources.
+			parallel := groupSize(groups, background, i) > 1
+
 			var command = Command{
 				Image:        d.Image,
 				ShellCommand: d.Command,
 				Volumes:      mapper.Volumes,
 				Workdir:      d.Workdir,
 				Env:          d.Env,
+				SecretEnv:    secretEnv[i],
 				Event:        event.NewExecutorWriter(uint32(i)),
 			}
 
 			var taskCommand TaskCommand
 
-			if r.Executor.Backend == "kubernetes" {
+			if r.Executor.Backend == "kubernetes" && background[i] {
+				sidecar := &KubernetesSidecar{
+					Name:    fmt.Sprintf("executor-%d", i),
+					Command: command,
+				}
+				sidecars = append(sidecars, sidecar)
+				taskCommand = sidecar
+
+			} else if r.Executor.Backend == "kubernetes" {
 				resources, err := config.ValidateResources(resources, r.Executor.Resources)
 				if err != nil {
 					return err
@@ -220,6 +373,13 @@ func (r *DefaultWorker) Run(pctx context.Context) (runerr error) {
 
 				resourceLimits := config.GetResourceLimits(r.Executor.Resources)
 
+				// The default resources may have fewer CPU cores than the group.
+				share, err := groupResources(resources, groupSize(groups, background, i))
+				if err != nil {
+					run.syserr = err
+					break
+				}
+
 				err = r.EventWriter.WriteEvent(pctx, events.NewResources(task.Id, task.Resources))
 				if err != nil {
 					// TODO: Handle this error properly...
@@ -235,13 +395,16 @@ func (r *DefaultWorker) Run(pctx context.Context) (runerr error) {
 					TaskTemplate:   r.Executor.Template,
 					Namespace:      r.Executor.Namespace,
 					JobsNamespace:  r.Executor.JobsNamespace,
-					Resources:      resources,
+					Resources:      share,
 					ResourceLimits: resourceLimits,
 					Command:        command,
 					NeedsPVC:       len(task.GetInputs()) > 0 || len(task.GetOutputs()) > 0 || len(task.GetVolumes()) > 0,
 					NodeSelector:   r.Executor.NodeSelector,
 					Tolerations:    r.Executor.Tolerations,
 					ServiceAccount: fmt.Sprintf("funnel-worker-sa-%s-%s", r.Executor.JobsNamespace, task.Id),
+					Pull:           pull,
+					Sidecars:       sidecars,
+					Network:        network,
 				}
 
 				// Override ServiceAccountName if provided in Task Tags
@@ -249,7 +412,23 @@ func (r *DefaultWorker) Run(pctx context.Context) (runerr error) {
 					taskCommand.(*KubernetesCommand).ServiceAccount = saName
 				}
 
+			} else if r.Executor.Backend == "apptainer" {
+				apptainer := r.apptainerCommand(fmt.Sprintf("%s-%d", task.Id, i), command)
+				apptainer.Pull = pull
+				apptainer.Network = network.GetPolicy()
+				taskCommand = apptainer
+
+			} else if r.Executor.Backend == "process" {
+				taskCommand = &ProcessCommand{
+					Name:        fmt.Sprintf("%s-%d", task.Id, i),
+					DefaultDir:  mapper.WorkDir,
+					StopTimeout: r.Conf.GetProcess().GetStopTimeout().AsDuration(),
+					Command:     command,
+				}
+
 			} else {
+				// The task has enough CPU cores for its groups (see executorGroups).
+				share, _ := groupResources(resources, groupSize(groups, background, i))
 				taskCommand = &DockerCommand{
 					Volumes: mapper.Volumes,
 					Workdir: d.Workdir,
@@ -261,9 +440,17 @@ func (r *DefaultWorker) Run(pctx context.Context) (runerr error) {
 					RunCommand:      r.Conf.Container.RunCommand,
 					PullCommand:     r.Conf.Container.PullCommand,
 					StopCommand:     r.Conf.Container.StopCommand,
-					Resources:       resources,
+					Resources:       share,
+					EnforceLimits:   r.Conf.Container.EnforceLimits,
+					Pull:            pull,
 					Command:         command,
 				}
+				if dockerNet != nil {
+					taskCommand.(*DockerCommand).Network = dockerNet.Name
+					if dockerNet.Create {
+						taskCommand.(*DockerCommand).NetworkAlias = fmt.Sprintf("executor-%d", i)
+					}
+				}
 
 				// TODO: Hide this behind explicit flag/option in configuration
 				// if r.Conf.Container.EnableTags {
@@ -275,9 +462,23 @@ func (r *DefaultWorker) Run(pctx context.Context) (runerr error) {
 			}
 
 			s := &stepWorker{
-				Conf:    r.Conf,
-				Event:   event.NewExecutorWriter(uint32(i)),
-				Command: taskCommand,
+				Conf:       r.Conf,
+				Event:      event.NewExecutorWriter(uint32(i)),
+				Command:    taskCommand,
+				Attach:     hub,
+				LogArchive: r.LogArchive,
+				Redact:     redact,
+				TaskID:     task.Id,
+				Index:      i,
+			}
+
+			// Executors completed by a previous attempt don't run again. The
+			// exit code of background executors, which are stopped, isn't
+			// recorded.
+			code, completed := cp.completed(i)
+			skip := completed && code == 0 && !background[i]
+			if !background[i] {
+				s.Checkpoint = cp
 			}
 
 			// Opens stdin/out/err files and updates those fields on "cmd".
@@ -285,34 +486,57 @@ func (r *DefaultWorker) Run(pctx context.Context) (runerr error) {
 			// stdout/stderr directly via its PVC mount. Creating host files here
 			// would poison the Mountpoint inode, making the file unreadable by
 			// the worker's mount instance (EPERM).
-			if (run.ok() || ignoreError) && r.Executor.Backend != "kubernetes" {
+			if (run.ok() || ignoreError) && !skip && r.Executor.Backend != "kubernetes" {
 				run.syserr = r.openStepLogs(mapper, s, d)
 			}
 
 			if run.ok() || ignoreError {
-				err := s.Run(ctx)
+				switch {
+				case skip:
+					s.Event.Info("Skipped, completed by a previous attempt")
+					s.Event.ExitCode(code)
+
+				// Background executors run until the foreground executors
+				// finish; the following executors start meanwhile.
+				case background[i]:
+					backgroundSteps = append(backgroundSteps, startBackground(ctx, i, s, d.GetIgnoreError()))
+
+				case parallel:
+					if group == nil {
+						group = newStepGroup(ctx)
+					}
+					group.start(s, d.GetIgnoreError())
 
-				if err != nil {
-					// Check if it's a Kubernetes system error
-					// TODO: Change this to check the exit code
-					var k8sSystemErr *K8sSystemErr
-					var execErr *K8sExecutorErr
-
-					switch {
-					// K8s System error
-					case errors.As(err, &k8sSystemErr):
-						run.syserr = err
-					// K8s Executor error
-					case errors.As(err, &execErr):
-						run.execerr = err
-					// Local (Docker) Executor error
-					default:
-						run.execerr = err
+				default:
+					err := s.Run(ctx)
+
+					if err != nil {
+						run.stepError(err)
 					}
 				}
 			}
 
-			ignoreError = d.GetIgnoreError()
+			// The executors of a group finish before the next group starts.
+			if lastOfGroup(groups, i) && group != nil {
+				for _, err := range group.wait() {
+					run.stepError(err)
+				}
+				ignoreError = group.ignoreError
+				group = nil
+			}
+
+			// The error of a background executor is only known when it
+			// stops, and those of a group when the group finishes.
+			if !background[i] && !parallel {
+				ignoreError = d.GetIgnoreError()
+			}
+		}
+
+		// Stop the background executors.
+		for _, b := range backgroundSteps {
+			if err := b.stop(); err != nil && run.ok() {
+				run.stepError(err)
+			}
 		}
 	}
 
@@ -352,9 +576,39 @@ func (r *DefaultWorker) Run(pctx context.Context) (runerr error) {
 	return
 }
 
+// apptainerCommand returns the Apptainer executor command, configured by
+// Worker.Apptainer.
+func (r *DefaultWorker) apptainerCommand(name string, command Command) *ApptainerCommand {
+	conf := r.Conf.GetApptainer()
+	a := &ApptainerCommand{
+		Name:          name,
+		DriverCommand: conf.GetDriverCommand(),
+		ImageDir:      conf.GetImageDir(),
+		CleanEnv:      conf.GetCleanEnv(),
+		ExtraArgs:     conf.GetExtraArgs(),
+		StopTimeout:   conf.GetStopTimeout().AsDuration(),
+		Command:       command,
+	}
+	if a.DriverCommand == "" {
+		a.DriverCommand = "apptainer"
+	}
+	if a.ImageDir == "" {
+		a.ImageDir = filepath.Join(r.Conf.WorkDir, "apptainer-images")
+	}
+	return a
+}
+
 func (r *DefaultWorker) Close() {
 	r.TaskReader.Close()
 	r.EventWriter.Close()
+	if c, ok := r.Attach.(io.Closer); ok {
+		c.Close()
+	}
+	if r.LogArchive != nil {
+		if c, ok := r.LogArchive.Writer.(io.Closer); ok {
+			c.Close()
+		}
+	}
 }
 
 // openLogs opens/creates the logs files for a step and updates those fields.